@@ -0,0 +1,149 @@
+package main
+
+import (
+	_ "embed"
+	"fmt"
+	"html/template"
+	"math"
+	"math/bits"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+
+	"go-localization-large-backend/pkg/allocation"
+	"go-localization-large-backend/pkg/store"
+)
+
+// adminRefreshSeconds is how often the admin page reloads itself, so its
+// distribution stats stay live without any script.
+const adminRefreshSeconds = 5
+
+//go:embed admin.html
+var adminTemplateSource string
+
+var adminTemplate = template.Must(template.New("admin").Funcs(template.FuncMap{
+	"bytes":   store.FormatBytes,
+	"percent": func(f float64) string { return fmt.Sprintf("%.1f%%", f) },
+	"short":   func(s string) string { return s[:min(len(s), 12)] },
+	"ms":      func(f float64) string { return fmt.Sprintf("%.2f ms", f) },
+}).Parse(adminTemplateSource))
+
+// adminPage is what the admin page shows: the experiment's configuration and
+// variants, with the same stats and metrics /experiment/:id/stats and /metrics
+// serve as JSON.
+type adminPage struct {
+	ExperimentID    string
+	ConfigHash      string
+	Algorithm       string
+	Reduction       string
+	Exposure        float64
+	ControlPayload  string
+	Prerequisite    string
+	AppVersions     string
+	FallbackPayload string
+	AdaptiveBalance bool
+	Variants        []adminVariant
+	Stats           experimentStatsResponse
+	Metrics         metricsResponse
+	BasePath        string
+	Refresh         int
+	Rendered        string
+}
+
+// adminVariant is one loaded payload in the order users are bucketed into
+// them, with its share of the hash space and of the responses served.
+type adminVariant struct {
+	Bucket        int
+	Name          string
+	Weight        float64 // percent of bucketed users
+	HashRange     string
+	Size          int64
+	ContentType   string
+	SchemaVersion int
+	SHA256        string
+	RolledBackTo  string // the fallback while the payload is rolled back
+	Responses     int64
+	Share         float64 // percent of responses in the stats window
+	Bytes         int64
+}
+
+// Admin UI handler: a read-only HTML view of the experiment for people who
+// would rather not curl the JSON endpoints
+func adminUIHandler(c *fiber.Ctx) error {
+	snapshot := serverMetrics.Snapshot()
+	page := adminPage{
+		ExperimentID:    experimentID,
+		ConfigHash:      experimentConfigHash(),
+		Algorithm:       hashAlgorithm,
+		Reduction:       allocation.ReductionFormula(hashAlgorithm),
+		Exposure:        exposurePercent,
+		ControlPayload:  controlPayload,
+		FallbackPayload: fallbackPayload,
+		AdaptiveBalance: balancer != nil,
+		Stats:           newExperimentStatsResponse(snapshot),
+		Metrics:         newMetricsResponse(snapshot),
+		BasePath:        basePath,
+		Refresh:         adminRefreshSeconds,
+		Rendered:        time.Now().UTC().Format(time.RFC3339),
+	}
+	if prerequisites != nil {
+		page.Prerequisite = prerequisites.Requires.String()
+	}
+	if appVersionRange != nil {
+		page.AppVersions = appVersionRange.String()
+	}
+
+	disabled := *rollbacks.Load()
+	payloads := payloadStore.Payloads()
+	for i, p := range payloads {
+		served := page.Stats.Variants[p.Name]
+		v := adminVariant{
+			Bucket:        i,
+			Name:          p.Name,
+			Weight:        100 / float64(len(payloads)),
+			HashRange:     bucketHashRange(hashAlgorithm, i, len(payloads)),
+			Size:          int64(len(p.Content)),
+			ContentType:   p.ContentType,
+			SchemaVersion: p.SchemaVersion,
+			SHA256:        p.SHA256,
+			RolledBackTo:  disabled[p.Name],
+			Responses:     served.Responses,
+			Bytes:         served.Bytes,
+		}
+		if page.Stats.Total.Responses > 0 {
+			v.Share = 100 * float64(served.Responses) / float64(page.Stats.Total.Responses)
+		}
+		page.Variants = append(page.Variants, v)
+	}
+
+	var sb strings.Builder
+	if err := adminTemplate.Execute(&sb, page); err != nil {
+		return err
+	}
+	c.Set(fiber.HeaderCacheControl, "no-store")
+	c.Type("html", "utf-8")
+	return c.SendString(sb.String())
+}
+
+// bucketHashRange describes the hashes that land in bucket i of n. The default
+// algorithm takes the hash mod n, so a bucket is every hash with one
+// remainder; the others split the 64-bit hash space into n contiguous ranges.
+func bucketHashRange(algorithm string, i, n int) string {
+	if algorithm == allocation.DefaultHashAlgorithm {
+		return fmt.Sprintf("hash mod %d = %d", n, i)
+	}
+	// Bucket i starts at the first hash h with h×n ≥ i×2^64
+	start := func(i int) uint64 {
+		q, r := bits.Div64(uint64(i), 0, uint64(n))
+		if r > 0 {
+			q++
+		}
+		return q
+	}
+	end := uint64(math.MaxUint64)
+	if i+1 < n {
+		end = start(i+1) - 1
+	}
+	return fmt.Sprintf("%016x–%016x", start(i), end)
+}
@@ -0,0 +1,311 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"text/tabwriter"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+
+	"go-localization-large-backend/pkg/allocation"
+	"go-localization-large-backend/pkg/middleware"
+	"go-localization-large-backend/pkg/store"
+)
+
+// defaultServingSizes are the payload sizes in KB bench-serving compares at
+// when -payload-sizes isn't set, from well under to well over the size where
+// buffering a response per connection starts to cost real memory.
+const defaultServingSizes = "16,256,1024,4096"
+
+// servingSampleInterval is how often the serving benchmark samples the heap
+// for its peak. Each sample briefly stops the world, so it is kept coarse.
+const servingSampleInterval = 10 * time.Millisecond
+
+// servingWinMargin and servingWinMinBytes are how far below the buffered peak
+// heap the streamed one must be, relatively and absolutely, for streaming to
+// count as winning, so GC noise between two nearly equal peaks at small sizes
+// isn't reported as a crossover.
+const (
+	servingWinMargin   = 0.9
+	servingWinMinBytes = 1 << 20
+)
+
+// servingMode is one way /experiment can send a payload, chosen by the
+// request's Accept header.
+type servingMode struct {
+	name   string
+	accept string
+}
+
+// servingModes are the modes the serving benchmark compares: the whole
+// response built in memory and sent with c.JSON, and the JSON Lines stream
+// written entry by entry while the body goes out.
+var servingModes = []servingMode{
+	{name: "buffered", accept: fiber.MIMEApplicationJSON},
+	{name: "streamed", accept: mimeNDJSON},
+}
+
+// servingResult is what one mode measured at one payload size and
+// concurrency.
+type servingResult struct {
+	requests    int64
+	failures    int64
+	bytes       int64
+	elapsed     time.Duration
+	peakHeap    uint64 // peak HeapInuse above the idle heap before the run
+	bytesPerOp  uint64
+	allocsPerOp uint64
+}
+
+// runBenchServing implements `main bench-serving`: it parses the flags and
+// runs runServingBench. The allocation and handler benchmarks, which don't
+// need a listener, are Go benchmarks run with `go test -bench`. It returns
+// the process exit code.
+func runBenchServing(args []string) int {
+	fs := flag.NewFlagSet("bench-serving", flag.ExitOnError)
+	sizes := fs.String("payload-sizes", defaultServingSizes, "Comma-separated payload sizes in KB to compare serving at")
+	count := fs.Int("payload-count", 5, "Synthetic payloads generated at each size")
+	seed := fs.Int64("seed", 1, "Seed for synthetic payload content")
+	concurrency := fs.String("concurrency", "1,8,32", "Comma-separated client counts")
+	duration := fs.Duration("duration", 2*time.Second, "How long each run lasts")
+	clientBPS := fs.Int64("client-bps", 0, "Cap each client's read rate in bytes/sec to model slow clients (0 = as fast as possible)")
+	fs.Parse(args)
+
+	var clientCounts []int
+	for _, c := range strings.Split(*concurrency, ",") {
+		n, err := strconv.Atoi(strings.TrimSpace(c))
+		if err != nil || n < 1 {
+			fmt.Printf("❌ Invalid -concurrency %q: want positive integers\n", c)
+			return 2
+		}
+		clientCounts = append(clientCounts, n)
+	}
+	if *duration <= 0 || *clientBPS < 0 {
+		fmt.Println("❌ -duration must be positive and -client-bps can't be negative")
+		return 2
+	}
+
+	var specs []store.GenerateSpec
+	for _, size := range strings.Split(*sizes, ",") {
+		spec, err := store.ParseGenerateSpec(fmt.Sprintf("%s,%d", size, *count))
+		if err != nil {
+			fmt.Printf("❌ Invalid -payload-sizes or -payload-count: %v\n", err)
+			return 2
+		}
+		spec.Seed = *seed
+		specs = append(specs, spec)
+	}
+	return runServingBench(specs, clientCounts, *duration, *clientBPS)
+}
+
+// runServingBench implements bench-serving: for each payload size it
+// serves synthetic payloads from a real listener on loopback and, at each
+// concurrency, loads it with that many clients in each servingMode for
+// duration. Unlike the handler benchmarks the body is written to a socket,
+// so a buffered response holds its encoded body until the client has read
+// it, and the heap grows with concurrency times payload size. clientBPS > 0
+// caps how fast each client reads, to model slow clients holding responses
+// open. It returns the process exit code.
+func runServingBench(specs []store.GenerateSpec, concurrency []int, duration time.Duration, clientBPS int64) int {
+	// The crossover is read off in order of size
+	sort.Slice(specs, func(i, j int) bool { return specs[i].SizeBytes < specs[j].SizeBytes })
+	// The handler reads the rollbacks main would set up; benchmark with none
+	rollbacks.Store(&allocation.Rollbacks{})
+
+	fmt.Printf("Serving benchmark: %s per run, concurrency %s", duration, joinInts(concurrency))
+	if clientBPS > 0 {
+		fmt.Printf(", clients reading %d bytes/sec", clientBPS)
+	}
+	fmt.Println()
+	fmt.Println()
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(tw, "Benchmark\tRequests\treq/s\tMB/s\tpeak heap MB\tB/op\tallocs/op\t")
+
+	// results[i][j][m] is spec i at concurrency j in servingModes[m]
+	results := make([][][]servingResult, len(specs))
+	failed := false
+	for i, spec := range specs {
+		payloadStore = store.NewPayloadStore(payloadDir, store.Limits{})
+		// The streamed mode needs entries, as the server splits them with -ndjson
+		payloadStore.SetEntries(true)
+		if err := payloadStore.LoadGenerated(spec); err != nil {
+			fmt.Printf("❌ Failed to generate payloads: %v\n", err)
+			return 1
+		}
+
+		app := fiber.New(fiber.Config{DisableStartupMessage: true})
+		app.Post("/experiment", experiment)
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			fmt.Printf("❌ Failed to listen: %v\n", err)
+			return 1
+		}
+		go app.Listener(ln)
+		url := "http://" + ln.Addr().String() + "/experiment"
+
+		results[i] = make([][]servingResult, len(concurrency))
+		for j, clients := range concurrency {
+			results[i][j] = make([]servingResult, len(servingModes))
+			for m, mode := range servingModes {
+				r := runServingLoad(url, mode.accept, clients, duration, clientBPS)
+				results[i][j][m] = r
+				failed = failed || r.failures > 0
+				printServingRow(tw, fmt.Sprintf("Serving/%dKB/concurrency=%d/%s", spec.SizeBytes/1024, clients, mode.name), r)
+			}
+		}
+		app.Shutdown()
+	}
+	tw.Flush()
+
+	fmt.Println()
+	fmt.Printf("Memory crossover (smallest payload size from which streamed peak heap stays %.0f%% and %d MB below buffered):\n",
+		(1-servingWinMargin)*100, servingWinMinBytes>>20)
+	for j, clients := range concurrency {
+		crossover := -1
+		for i := len(specs) - 1; i >= 0; i-- {
+			buffered, streamed := results[i][j][0], results[i][j][1]
+			if float64(streamed.peakHeap) >= float64(buffered.peakHeap)*servingWinMargin ||
+				streamed.peakHeap+servingWinMinBytes > buffered.peakHeap {
+				break
+			}
+			crossover = i
+		}
+		if crossover < 0 {
+			fmt.Printf("  concurrency=%d: not reached up to %dKB\n", clients, specs[len(specs)-1].SizeBytes/1024)
+			continue
+		}
+		buffered, streamed := results[crossover][j][0], results[crossover][j][1]
+		fmt.Printf("  concurrency=%d: from %dKB (peak heap %.1f MB streamed vs %.1f MB buffered, at %.0f%% of the buffered req/s)\n",
+			clients, specs[crossover].SizeBytes/1024, float64(streamed.peakHeap)/(1<<20), float64(buffered.peakHeap)/(1<<20),
+			100*float64(streamed.requests)/float64(max(buffered.requests, 1)))
+	}
+
+	fmt.Println()
+	fmt.Println("Each run serves generated payloads over loopback HTTP, without middleware. Clients share the process, so")
+	fmt.Println("B/op and allocs/op include the client's share, which is the same for both modes. peak heap MB is the")
+	fmt.Println("highest HeapInuse sampled during the run above the idle heap before it, so loaded payloads aren't counted.")
+	if failed {
+		fmt.Println("❌ Some requests failed; their rows count successful requests only.")
+		return 1
+	}
+	return 0
+}
+
+// runServingLoad sends requests for distinct users with the given Accept header
+// from clients concurrent clients for duration, reading and discarding each
+// body, and measures throughput and the heap while it runs.
+func runServingLoad(url, accept string, clients int, duration time.Duration, clientBPS int64) servingResult {
+	transport := &http.Transport{MaxIdleConnsPerHost: clients, DisableCompression: true}
+	defer transport.CloseIdleConnections()
+	client := &http.Client{Transport: transport}
+
+	var before runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&before)
+
+	var peak atomic.Uint64
+	stopSampling := make(chan struct{})
+	sampled := make(chan struct{})
+	go func() {
+		defer close(sampled)
+		ticker := time.NewTicker(servingSampleInterval)
+		defer ticker.Stop()
+		var ms runtime.MemStats
+		for {
+			select {
+			case <-stopSampling:
+				return
+			case <-ticker.C:
+				runtime.ReadMemStats(&ms)
+				if ms.HeapInuse > peak.Load() {
+					peak.Store(ms.HeapInuse)
+				}
+			}
+		}
+	}()
+
+	var requests, failures, bytes atomic.Int64
+	start := time.Now()
+	deadline := start.Add(duration)
+	var wg sync.WaitGroup
+	for w := 0; w < clients; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			var sink io.Writer = io.Discard
+			if clientBPS > 0 {
+				sink = middleware.NewThrottledWriter(io.Discard, clientBPS)
+			}
+			for i := 0; time.Now().Before(deadline); i++ {
+				body := fmt.Sprintf(`{"userId":"serving-bench-%d-%d"}`, w, i)
+				req, _ := http.NewRequest(http.MethodPost, url, strings.NewReader(body))
+				req.Header.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
+				req.Header.Set(fiber.HeaderAccept, accept)
+				resp, err := client.Do(req)
+				if err != nil {
+					failures.Add(1)
+					continue
+				}
+				n, err := io.Copy(sink, resp.Body)
+				resp.Body.Close()
+				if err != nil || resp.StatusCode != http.StatusOK {
+					failures.Add(1)
+					continue
+				}
+				requests.Add(1)
+				bytes.Add(n)
+			}
+		}(w)
+	}
+	wg.Wait()
+	elapsed := time.Since(start)
+	close(stopSampling)
+	<-sampled
+
+	var after runtime.MemStats
+	runtime.ReadMemStats(&after)
+	if after.HeapInuse > peak.Load() {
+		peak.Store(after.HeapInuse)
+	}
+
+	r := servingResult{
+		requests: requests.Load(),
+		failures: failures.Load(),
+		bytes:    bytes.Load(),
+		elapsed:  elapsed,
+	}
+	if p := peak.Load(); p > before.HeapInuse {
+		r.peakHeap = p - before.HeapInuse
+	}
+	if r.requests > 0 {
+		r.bytesPerOp = (after.TotalAlloc - before.TotalAlloc) / uint64(r.requests)
+		r.allocsPerOp = (after.Mallocs - before.Mallocs) / uint64(r.requests)
+	}
+	return r
+}
+
+func printServingRow(w *tabwriter.Writer, name string, r servingResult) {
+	secs := r.elapsed.Seconds()
+	fmt.Fprintf(w, "%s\t%d\t%.0f\t%.1f\t%.1f\t%d\t%d\t\n", name, r.requests,
+		float64(r.requests)/secs, float64(r.bytes)/secs/(1<<20), float64(r.peakHeap)/(1<<20), r.bytesPerOp, r.allocsPerOp)
+}
+
+func joinInts(values []int) string {
+	parts := make([]string, len(values))
+	for i, v := range values {
+		parts[i] = fmt.Sprint(v)
+	}
+	return strings.Join(parts, ",")
+}
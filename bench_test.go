@@ -0,0 +1,135 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"github.com/valyala/fasthttp"
+
+	"go-localization-large-backend/pkg/model"
+	"go-localization-large-backend/pkg/store"
+)
+
+// benchUsers is the number of distinct userIds each benchmark cycles through,
+// so results reflect a mix of buckets rather than one hot key.
+const benchUsers = 1024
+
+// benchUserIDs returns benchUsers random userIds.
+func benchUserIDs() []string {
+	ids := make([]string, benchUsers)
+	for i := range ids {
+		ids[i] = uuid.NewString()
+	}
+	return ids
+}
+
+// useGenerated serves count synthetic payloads of sizeKB each until the
+// benchmark ends.
+func useGenerated(b *testing.B, sizeKB, count int) {
+	b.Helper()
+	s := store.NewPayloadStore(payloadDir, store.Limits{})
+	if err := s.LoadGenerated(store.GenerateSpec{SizeBytes: sizeKB * 1024, Count: count, Seed: 1}); err != nil {
+		b.Fatal(err)
+	}
+	useStore(b, s)
+}
+
+// useDir serves the checked-in payloads directory until the benchmark ends.
+func useDir(b *testing.B) {
+	b.Helper()
+	s := store.NewPayloadStore(payloadDir, store.Limits{})
+	if err := s.Load(); err != nil {
+		b.Fatal(err)
+	}
+	useStore(b, s)
+}
+
+// BenchmarkAssign measures choosing a user's payload: the gates, bucketing
+// and rollbacks the handler runs per request, without parsing or encoding.
+// Its cost doesn't depend on payload size, only on the number of variants.
+func BenchmarkAssign(b *testing.B) {
+	userIDs := benchUserIDs()
+	for _, variants := range []int{2, 5, 20} {
+		b.Run(fmt.Sprintf("variants=%d", variants), func(b *testing.B) {
+			useGenerated(b, 1, variants)
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				assignPayloadForUser(model.Request{UserID: userIDs[i%benchUsers]})
+			}
+		})
+	}
+}
+
+// BenchmarkExperimentHandler drives the /experiment handler directly with a
+// reused fasthttp request context, so the numbers cover routing, body parsing,
+// allocation and JSON encoding but not socket I/O or middleware. It runs
+// against the checked-in payloads and synthetic ones of growing size.
+func BenchmarkExperimentHandler(b *testing.B) {
+	userIDs := benchUserIDs()
+	bodies := make([][]byte, len(userIDs))
+	for i, id := range userIDs {
+		bodies[i] = []byte(`{"userId":"` + id + `"}`)
+	}
+
+	run := func(b *testing.B) {
+		handler := experimentApp().Handler()
+		var ctx fasthttp.RequestCtx
+		ctx.Request.Header.SetMethod(fiber.MethodPost)
+		ctx.Request.SetRequestURI("/experiment")
+		ctx.Request.Header.SetContentType(fiber.MIMEApplicationJSON)
+
+		b.ReportAllocs()
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			ctx.Request.SetBody(bodies[i%len(bodies)])
+			ctx.Response.Reset()
+			handler(&ctx)
+			if ctx.Response.StatusCode() != fiber.StatusOK {
+				b.Fatalf("unexpected status %d: %s", ctx.Response.StatusCode(), ctx.Response.Body())
+			}
+		}
+	}
+
+	b.Run("payloads=dir", func(b *testing.B) {
+		useDir(b)
+		run(b)
+	})
+	for _, sizeKB := range []int{1, 64, 1024} {
+		b.Run(fmt.Sprintf("payloads=5x%dKB", sizeKB), func(b *testing.B) {
+			useGenerated(b, sizeKB, 5)
+			run(b)
+		})
+	}
+}
+
+// BenchmarkExperimentHandlerSameVariant is BenchmarkExperimentHandler from
+// one goroutine per proc, every request for the same user and so the same
+// payload. Serving a payload takes no locks, so with `-cpu 1,2,4,8` ns/op,
+// the wall time per request, should fall in proportion to procs up to the
+// core count.
+func BenchmarkExperimentHandlerSameVariant(b *testing.B) {
+	useDir(b)
+	handler := experimentApp().Handler()
+	body := []byte(`{"userId":"` + uuid.NewString() + `"}`)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		var ctx fasthttp.RequestCtx
+		ctx.Request.Header.SetMethod(fiber.MethodPost)
+		ctx.Request.SetRequestURI("/experiment")
+		ctx.Request.Header.SetContentType(fiber.MIMEApplicationJSON)
+		for pb.Next() {
+			ctx.Request.SetBody(body)
+			ctx.Response.Reset()
+			handler(&ctx)
+			if ctx.Response.StatusCode() != fiber.StatusOK {
+				b.Errorf("unexpected status %d: %s", ctx.Response.StatusCode(), ctx.Response.Body())
+				return
+			}
+		}
+	})
+}
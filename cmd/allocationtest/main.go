@@ -1,20 +1,33 @@
 package main
 
 import (
+	"bufio"
 	"bytes"
+	"crypto/sha256"
+	"encoding/csv"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"io"
+	"math"
+	"math/rand"
 	"net/http"
 	"os"
+	"path/filepath"
+	"slices"
 	"sort"
+	"strconv"
 	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
 
 	"github.com/google/uuid"
+
+	"go-localization-large-backend/pkg/allocation"
+	"go-localization-large-backend/pkg/health"
 )
 
 type Request struct {
@@ -25,16 +38,20 @@ type Response struct {
 	ExperimentID        string          `json:"experimentId"`
 	SelectedPayloadName string          `json:"selectedPayloadName"`
 	Payload             json.RawMessage `json:"payload"`
+	Exposed             *bool           `json:"exposed,omitempty"`
 }
 
 type UserAllocation struct {
 	UserID       string
+	Locale       string // empty unless -locales is set
 	PayloadName  string
 	RequestCount int
 	Consistent   bool // true if all requests returned the same payload
 }
 
 type TestResults struct {
+	TestDate              time.Time
+	ConfigHash            string // server's X-Config-Hash; comma-separated if it changed mid-run
 	TotalUsers            int
 	TotalRequests         int
 	SuccessfulRequests    int
@@ -47,76 +64,806 @@ type TestResults struct {
 	TestDuration          time.Duration
 	RequestsPerSecond     float64
 	AllocationConsistency float64
+	Drift                 *DriftReport    // set when compared against a baseline run
+	Temporal              *TemporalReport // set when re-checking an earlier run's users
+	Truth                 *TruthReport    // set when checking users against -truth-file
+	Exposure              *ExposureStats  // set when the server gates users with -exposure
+	Sequential            bool            // requests were sent one at a time in userId order
+
+	// Set when users are assigned locales with -locales
+	Locales            []string                  // in -locales order
+	LocaleDistribution map[string]map[string]int // locale -> payload -> users
+	LocaleIndependence *allocation.IndependenceReport
+
+	// Set with -adversarial, in adversarialPopulations order
+	Populations []PopulationCheck
+
+	// Buckets holds how the server bucketed each user, from the first
+	// response that said; users it sent no X-Bucket-Hash for are missing
+	Buckets map[string]BucketInfo
+	Proofs  []ReproducibilityProof // set unless -proof-samples is 0
+
+	// PayloadDigests maps each payload served to the SHA-256 of its content
+	PayloadDigests map[string]string
+	AA             *AATest // set with -aa
+}
+
+// ExposureStats counts users inside and outside the server's exposure gate.
+type ExposureStats struct {
+	Exposed   int `json:"exposed"`
+	Unexposed int `json:"unexposed"`
+}
+
+// LocaleWeight is one locale's share of the synthetic population.
+type LocaleWeight struct {
+	Locale string
+	Weight float64
+}
+
+// resultsSchemaVersion is the version of ResultsExport written by this tool.
+// Bump it when a field changes meaning; adding optional fields doesn't need a
+// bump. Version 0 is the older distribution-only export, which still loads.
+const resultsSchemaVersion = 1
+
+// ResultsExport is the stable, machine-readable form of a run's results,
+// written next to the Markdown report so a later run can load it with
+// LoadResults and compare against it with -baseline.
+type ResultsExport struct {
+	SchemaVersion int       `json:"schemaVersion"`
+	TestDate      time.Time `json:"testDate"`
+	// ConfigHash is the server's experiment config hash (X-Config-Hash).
+	// Distributions are only comparable between runs with the same hash.
+	ConfigHash string `json:"configHash,omitempty"`
+
+	TotalUsers            int     `json:"totalUsers"`
+	TotalRequests         int     `json:"totalRequests"`
+	SuccessfulRequests    int     `json:"successfulRequests"`
+	FailedRequests        int     `json:"failedRequests"`
+	ConsistentUsers       int     `json:"consistentUsers"`
+	InconsistentUsers     int     `json:"inconsistentUsers"`
+	AllocationConsistency float64 `json:"allocationConsistency"` // percent
+
+	PayloadDistribution map[string]int `json:"payloadDistribution"`
+	Exposure            *ExposureStats `json:"exposure,omitempty"`
+
+	Locales            []string                  `json:"locales,omitempty"`
+	LocaleDistribution map[string]map[string]int `json:"localeDistribution,omitempty"`
+	LocaleIndependence *IndependenceExport       `json:"localeIndependence,omitempty"`
+
+	// Assignments maps each user to the payload they were served (the most
+	// common one if inconsistent), for -verify-temporal
+	Assignments map[string]string `json:"assignments,omitempty"`
+	// Sequential is set for -sequential runs, so comparing one with a
+	// concurrent run can tell an ordering bug from the passage of time
+	Sequential bool `json:"sequential,omitempty"`
+}
+
+// IndependenceExport is the locale x payload chi-square test in ResultsExport.
+type IndependenceExport struct {
+	Samples          int     `json:"samples"`
+	Columns          int     `json:"columns"`
+	ChiSquare        float64 `json:"chiSquare"`
+	DegreesOfFreedom int     `json:"degreesOfFreedom"`
+	PValue           float64 `json:"pValue"`
+	MinExpected      float64 `json:"minExpected"`
+}
+
+// DriftEntry is one payload's share of users in the baseline and current runs,
+// in percent.
+type DriftEntry struct {
+	Payload     string
+	BaselinePct float64
+	CurrentPct  float64
+	Delta       float64 // percentage points, current - baseline
+	Drifted     bool
+}
+
+// DriftReport compares the current distribution against a baseline run.
+type DriftReport struct {
+	BaselineFile string
+	BaselineDate time.Time
+	Threshold    float64      // percentage points
+	Entries      []DriftEntry // sorted by absolute delta, largest first
+	DriftedCount int
+
+	// ConfigMismatch is set when the runs were against different experiment
+	// configs, so drift is expected and says nothing about allocation.
+	// BaselineConfigHash is empty for baselines that predate the hash.
+	BaselineConfigHash string
+	ConfigMismatch     bool
+}
+
+// adversarialPopulations are the non-random userId shapes -adversarial tests,
+// in report order.
+var adversarialPopulations = []string{"sequential", "prefix", "timestamp"}
+
+// PopulationCheck compares the payload split of a non-random userId pattern
+// against the split of the main run's users. Allocation should look the same
+// for both; a significant difference means the hash clusters on the pattern.
+type PopulationCheck struct {
+	Population   string
+	Example      string // first userId, to show the pattern
+	Users        int
+	Independence allocation.IndependenceReport
+}
+
+// populationAlpha is the significance level for each population check. The
+// 1% budget is split across the populations (Bonferroni), so running all of
+// them with -adversarial doesn't triple the false alarm rate.
+var populationAlpha = 0.01 / float64(len(adversarialPopulations))
+
+// Skewed reports whether the pattern's split differs significantly from the
+// reference. Unreliable tests (too few users per cell) never count as skewed.
+func (p PopulationCheck) Skewed() bool {
+	return p.Independence.Reliable() && p.Independence.Detectable(populationAlpha)
+}
+
+// TemporalChange is a user whose payload differs from an earlier run.
+type TemporalChange struct {
+	UserID string
+	Before string
+	After  string
+}
+
+// TemporalReport compares users' assignments against an earlier run of the
+// same users, to catch assignments that depend on wall-clock time.
+type TemporalReport struct {
+	PreviousFile string
+	PreviousDate time.Time
+	Elapsed      time.Duration
+	Checked      int              // users assigned in both runs
+	Missing      int              // users with no successful request in this run
+	Changes      []TemporalChange // sorted by userId
+
+	// ConfigMismatch is set when the runs were against different experiment
+	// configs, so reassignments are expected
+	PreviousConfigHash string
+	ConfigMismatch     bool
+
+	// OrderingDiffers is set when one run was -sequential and the other
+	// concurrent. Ordering must not change anyone's payload, so changes then
+	// point at allocation depending on request order or timing.
+	PreviousSequential bool
+	OrderingDiffers    bool
+}
+
+// BucketInfo is how the server computed a user's bucket, from the X-Bucket,
+// X-Bucket-Hash, X-Bucket-Count and X-Bucket-Nudged-From response headers.
+type BucketInfo struct {
+	Algorithm  string
+	Hash       uint64
+	Buckets    int
+	Bucket     int
+	NudgedFrom int // the hash bucket -adaptive-balance moved the user from, or -1
+}
+
+// ReproducibilityProof is one user's assignment worked through step by step:
+// the hash of their userId, the bucket it reduces to, and the variant served.
+// Recomputed is whether this tool got the same hash and bucket on its own.
+type ReproducibilityProof struct {
+	UserID   string
+	Variant  string
+	Requests int
+	BucketInfo
+	Recomputed bool
+}
+
+// AATest checks the framework against a known null: with every variant
+// serving the same content, users should split evenly across buckets and a
+// chi-square test should find no difference. A failure means hashing,
+// bucketing or this report is broken, not that a variant won.
+type AATest struct {
+	Alpha      float64
+	Variants   int // distinct variants served to bucketed users
+	Contents   int // distinct payload contents among them: 1 for an A/A config
+	Uniformity allocation.BiasReport
+}
+
+// Bucketed reports whether the server reported a bucket for any user. Without
+// X-Bucket-Hash there is nothing to test.
+func (a AATest) Bucketed() bool {
+	return a.Uniformity.Samples > 0
+}
+
+// Identical reports whether every variant served the same content, as an A/A
+// configuration must.
+func (a AATest) Identical() bool {
+	return a.Contents == 1
+}
+
+// Reliable reports whether every bucket expects at least 5 users, the usual
+// rule of thumb for the chi-square approximation to hold.
+func (a AATest) Reliable() bool {
+	return a.Uniformity.Buckets > 1 && a.Uniformity.Expected >= 5
+}
+
+// Passed reports whether the run was a reliable A/A test that found no
+// difference between the variants.
+func (a AATest) Passed() bool {
+	return a.Bucketed() && a.Identical() && a.Reliable() && !a.Uniformity.Detectable(a.Alpha)
+}
+
+// truthReportRows caps the mismatched users listed in the Markdown report, so
+// checking a large truth file that disagrees everywhere stays readable.
+const truthReportRows = 100
+
+// TruthMismatch is a user the server assigned a different variant from the
+// one the truth file expects.
+type TruthMismatch struct {
+	UserID   string
+	Expected string
+	Actual   string
+}
+
+// TruthReport compares users' assignments against the variants an external
+// system, such as analytics, recorded for them.
+type TruthReport struct {
+	TruthFile  string
+	Checked    int             // users with an expected variant and a successful request
+	Missing    int             // users with no successful request in this run
+	Mismatches []TruthMismatch // sorted by userId
 }
 
 func main() {
-	serverURL := flag.String("url", "http://localhost:3000", "Server URL")
+	serverURL := flag.String("url", "http://localhost:3000", "Server URL, including the server's -base-path if it has one, e.g. http://localhost:3000/loc/v1")
 	numUsers := flag.Int("users", 100, "Number of unique users to test")
 	requestsPerUser := flag.Int("requests", 5, "Number of requests per user")
 	concurrency := flag.Int("concurrency", 10, "Number of concurrent workers")
+	sequential := flag.Bool("sequential", false, "Send every request from one worker, users in sorted userId order, so a failing run reproduces exactly (overrides -concurrency)")
 	outputFile := flag.String("output", "allocation_test_results.md", "Output file for results")
+	authToken := flag.String("auth-token", "", "Bearer token to send if the server requires auth")
+	userIDsFile := flag.String("userids-file", "", "File of userIds to test, one per line (default: generate random UUIDs)")
+	failOnInconsistency := flag.Bool("fail-on-inconsistency", false, "Exit non-zero when consistency is below -min-consistency (for CI)")
+	minConsistency := flag.Float64("min-consistency", 100, "Minimum allocation consistency percentage required to pass")
+	jsonOutput := flag.String("json-output", "", "File for the JSON results export, usable as a -baseline later (default: -output with a .json extension)")
+	baselineFile := flag.String("baseline", "", "Compare the distribution against a previous run's JSON results export")
+	driftThreshold := flag.Float64("drift-threshold", 1.0, "Flag payloads whose share moved more than this many percentage points from the baseline")
+	failOnDrift := flag.Bool("fail-on-drift", false, "Exit non-zero when any payload drifts beyond -drift-threshold or the baseline used a different server config")
+	localesSpec := flag.String("locales", "", "Assign users locales by weight, e.g. en-US:50,fr-FR:30,de-DE:20 (sent as Accept-Language)")
+	localeSeed := flag.Int64("locale-seed", 1, "Seed for assigning locales to users")
+	population := flag.String("population", "uuid", "Shape of generated userIds: 'uuid', 'sequential' (1, 2, ...), 'prefix' (a long shared prefix and a counter) or 'timestamp' (millisecond timestamps)")
+	adversarial := flag.Bool("adversarial", false, "After the main run, test sequential, prefix and timestamp userId populations of the same size and flag any whose payload split differs from the main run's")
+	verifyTemporal := flag.String("verify-temporal", "", "Re-test the users recorded in an earlier run's JSON results export and report any whose payload changed since")
+	sampleSize := flag.Int("sample-size", 20, "Number of users listed in the report's sample allocations table")
+	order := flag.String("order", "grouped", "Order of the requests sent: 'grouped' (each user's requests back to back) or 'interleaved' (one request per user per round, spreading each user's requests over the run)")
+	truthFile := flag.String("truth-file", "", "CSV of userId,expectedVariant pairs from an external source of truth: test those users and report any the server assigns a different variant")
+	sampleMode := flag.String("sample", "representative", "How the report picks sample users: 'representative' (inconsistent users, then one per payload, then evenly spaced) or 'first' (lowest userIds)")
+	healthAttempts := flag.Int("health-attempts", health.DefaultAttempts, "Times to try the server's /health before giving up, to wait out a server that is still starting (1 = no retries)")
+	healthInterval := flag.Duration("health-interval", health.DefaultInterval, "Pause between -health-attempts")
+	aa := flag.Bool("aa", false, "Run as an A/A test: check every variant serves the same content and that users split evenly across buckets")
+	aaAlpha := flag.Float64("aa-alpha", 0.01, "With -aa, the significance level below which the split counts as uneven")
+	proofSamples := flag.Int("proof-samples", 5, "Number of bucketed users whose hash, bucket and variant the report works through so a reader can recompute them (0 = no reproducibility proof)")
 	flag.Parse()
+	// Paths are appended to the URL, so a base path may end in a slash
+	*serverURL = strings.TrimRight(*serverURL, "/")
+
+	if *sampleMode != "representative" && *sampleMode != "first" {
+		fmt.Printf("❌ -sample must be 'representative' or 'first', got %q\n", *sampleMode)
+		os.Exit(2)
+	}
+	if *order != "grouped" && *order != "interleaved" {
+		fmt.Printf("❌ -order must be 'grouped' or 'interleaved', got %q\n", *order)
+		os.Exit(2)
+	}
+	if *sampleSize < 0 {
+		fmt.Printf("❌ -sample-size must not be negative, got %d\n", *sampleSize)
+		os.Exit(2)
+	}
+	if *healthAttempts < 1 || *healthInterval < 0 {
+		fmt.Println("❌ -health-attempts must be at least 1 and -health-interval can't be negative")
+		os.Exit(2)
+	}
+	if *proofSamples < 0 {
+		fmt.Printf("❌ -proof-samples must not be negative, got %d\n", *proofSamples)
+		os.Exit(2)
+	}
+	if *aaAlpha <= 0 || *aaAlpha >= 1 {
+		fmt.Printf("❌ -aa-alpha must be between 0 and 1, got %g\n", *aaAlpha)
+		os.Exit(2)
+	}
+
+	var localeWeights []LocaleWeight
+	if *localesSpec != "" {
+		var err error
+		localeWeights, err = parseLocales(*localesSpec)
+		if err != nil {
+			fmt.Printf("❌ %v\n", err)
+			os.Exit(2)
+		}
+	}
+
+	// Load user IDs up front so a bad file fails before anything is printed
+	var userIDs []string
+	var previous TestResults
+	var expected map[string]string
+	if *truthFile != "" {
+		if *userIDsFile != "" || *verifyTemporal != "" {
+			fmt.Println("❌ -truth-file tests the file's users; don't combine it with -userids-file or -verify-temporal")
+			os.Exit(2)
+		}
+		var err error
+		userIDs, expected, err = loadTruth(*truthFile)
+		if err != nil {
+			fmt.Printf("❌ Failed to read truth file: %v\n", err)
+			os.Exit(1)
+		}
+		if len(userIDs) == 0 {
+			fmt.Printf("❌ No userIds found in %s\n", *truthFile)
+			os.Exit(1)
+		}
+	} else if *verifyTemporal != "" {
+		if *userIDsFile != "" {
+			fmt.Println("❌ -verify-temporal tests the earlier run's users; don't combine it with -userids-file")
+			os.Exit(2)
+		}
+		var err error
+		previous, err = LoadResults(*verifyTemporal)
+		if err != nil {
+			fmt.Printf("❌ Failed to load earlier run: %v\n", err)
+			os.Exit(1)
+		}
+		if len(previous.UserAllocations) == 0 {
+			fmt.Printf("❌ %s has no per-user assignments; it was written by an older version of this tool\n", *verifyTemporal)
+			os.Exit(1)
+		}
+		for _, alloc := range previous.UserAllocations {
+			userIDs = append(userIDs, alloc.UserID)
+		}
+	} else if *userIDsFile != "" {
+		ids, linesRead, err := loadUserIDs(*userIDsFile)
+		if err != nil {
+			fmt.Printf("❌ Failed to read userIds: %v\n", err)
+			os.Exit(1)
+		}
+		if len(ids) == 0 {
+			fmt.Printf("❌ No userIds found in %s\n", *userIDsFile)
+			os.Exit(1)
+		}
+		userIDs = ids
+		fmt.Printf("Loaded %d unique userIds from %s (%d lines read)\n", len(ids), *userIDsFile, linesRead)
+	} else {
+		var err error
+		userIDs, err = generatePopulation(*population, *numUsers)
+		if err != nil {
+			fmt.Printf("❌ %v\n", err)
+			os.Exit(2)
+		}
+	}
 
 	fmt.Println("━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━")
 	fmt.Println("🧪 A/B Allocation Verification Test")
 	fmt.Println("━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━")
 	fmt.Printf("Server URL: %s\n", *serverURL)
-	fmt.Printf("Users: %d\n", *numUsers)
+	if *truthFile != "" {
+		fmt.Printf("Users: %d (expected variants from %s)\n", len(userIDs), *truthFile)
+	} else if *verifyTemporal != "" {
+		fmt.Printf("Users: %d (from %s, %s ago)\n", len(userIDs), *verifyTemporal, time.Since(previous.TestDate).Round(time.Second))
+	} else if *userIDsFile != "" {
+		fmt.Printf("Users: %d (from %s)\n", len(userIDs), *userIDsFile)
+	} else if *population == "uuid" {
+		fmt.Printf("Users: %d (random UUIDs)\n", len(userIDs))
+	} else {
+		fmt.Printf("Users: %d (%s, e.g. %s)\n", len(userIDs), *population, userIDs[0])
+	}
+	if *aa {
+		fmt.Printf("A/A test: variants must be identical, split tested at alpha %g\n", *aaAlpha)
+	}
+	if *adversarial {
+		fmt.Printf("Adversarial populations: %s (%d users each)\n", strings.Join(adversarialPopulations, ", "), len(userIDs))
+	}
+	var userLocales map[string]string
+	if len(localeWeights) > 0 {
+		userLocales = assignLocales(userIDs, localeWeights, *localeSeed)
+		fmt.Printf("Locales: %s\n", *localesSpec)
+	}
 	fmt.Printf("Requests per user: %d\n", *requestsPerUser)
-	fmt.Printf("Concurrency: %d\n", *concurrency)
-	fmt.Printf("Output file: %s\n", *outputFile)
+	if *sequential {
+		*concurrency = 1
+		fmt.Println("Concurrency: 1 (sequential, users in sorted userId order)")
+	} else {
+		fmt.Printf("Concurrency: %d\n", *concurrency)
+	}
+	fmt.Printf("Request order: %s\n", *order)
+	if *jsonOutput == "" {
+		*jsonOutput = strings.TrimSuffix(*outputFile, filepath.Ext(*outputFile)) + ".json"
+	}
+	fmt.Printf("Output files: %s, %s\n", *outputFile, *jsonOutput)
 	fmt.Println("━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━")
 	fmt.Println()
 
 	// Check server health
-	if !checkHealth(*serverURL) {
-		fmt.Println("❌ Server health check failed. Is the server running?")
+	if !checkHealth(&http.Client{Timeout: 10 * time.Second}, *serverURL, *healthAttempts, *healthInterval) {
 		os.Exit(1)
 	}
 	fmt.Println("✅ Server health check passed")
 	fmt.Println()
 
-	// Generate user IDs
-	userIDs := make([]string, *numUsers)
-	for i := 0; i < *numUsers; i++ {
-		userIDs[i] = uuid.New().String()
+	// Run the allocation test
+	results := runAllocationTest(*serverURL, *authToken, requestOrder(userIDs, *sequential), userLocales, *requestsPerUser, *concurrency, *order == "interleaved")
+	results.Sequential = *sequential
+	if len(localeWeights) > 0 {
+		addLocaleBreakdown(&results, localeWeights)
 	}
 
-	// Run the allocation test
-	results := runAllocationTest(*serverURL, userIDs, *requestsPerUser, *concurrency)
+	// Compare against the baseline run, if any
+	if *baselineFile != "" {
+		baseline, err := LoadResults(*baselineFile)
+		if err != nil {
+			fmt.Printf("❌ Failed to load baseline: %v\n", err)
+			os.Exit(1)
+		}
+		results.Drift = compareDistributions(*baselineFile, baseline, results, *driftThreshold)
+	}
+	if *verifyTemporal != "" {
+		results.Temporal = compareAssignments(*verifyTemporal, previous, results)
+	}
+	if *truthFile != "" {
+		results.Truth = compareTruth(*truthFile, userIDs, expected, results)
+	}
+	if *proofSamples > 0 {
+		results.Proofs = proveAllocations(results, *proofSamples, *sampleMode == "representative")
+	}
+	if *aa {
+		results.AA = checkAA(results, *aaAlpha)
+	}
+	if *adversarial {
+		for _, kind := range adversarialPopulations {
+			fmt.Printf("\nPopulation: %s\n", kind)
+			ids, _ := generatePopulation(kind, len(userIDs))
+			run := runAllocationTest(*serverURL, *authToken, requestOrder(ids, *sequential), nil, *requestsPerUser, *concurrency, *order == "interleaved")
+			results.Populations = append(results.Populations, comparePopulation(kind, ids[0], results, run))
+		}
+	}
 
 	// Print summary to console
 	printSummary(results)
 
 	// Write detailed results to file
-	if err := writeResults(*outputFile, results); err != nil {
+	samples := sampleAllocations(results.UserAllocations, *sampleSize, *sampleMode == "representative")
+	if err := writeResults(*outputFile, *jsonOutput, results, samples, *sampleMode); err != nil {
 		fmt.Printf("❌ Failed to write results: %v\n", err)
 		os.Exit(1)
 	}
-	fmt.Printf("\n✅ Detailed results written to %s\n", *outputFile)
+	fmt.Printf("\n✅ Detailed results written to %s (JSON export: %s)\n", *outputFile, *jsonOutput)
+
+	// Final machine-parseable line for CI pipelines
+	passed := results.TotalUsers > 0 && results.AllocationConsistency >= *minConsistency
+	verdict := "PASS"
+	if !passed {
+		verdict = "FAIL"
+	}
+	driftResult := ""
+	drifted := results.Drift != nil && (results.Drift.DriftedCount > 0 || results.Drift.ConfigMismatch)
+	if results.Drift != nil {
+		driftResult = fmt.Sprintf(" drifted=%d config_mismatch=%t", results.Drift.DriftedCount, results.Drift.ConfigMismatch)
+		if *failOnDrift && drifted {
+			verdict = "FAIL"
+		}
+	}
+	temporalResult := ""
+	reassigned := results.Temporal != nil && len(results.Temporal.Changes) > 0 && !results.Temporal.ConfigMismatch
+	if results.Temporal != nil {
+		temporalResult = fmt.Sprintf(" temporal_changed=%d", len(results.Temporal.Changes))
+		if reassigned {
+			verdict = "FAIL"
+		}
+	}
+	truthResult := ""
+	mismatched := results.Truth != nil && len(results.Truth.Mismatches) > 0
+	if results.Truth != nil {
+		truthResult = fmt.Sprintf(" truth_mismatches=%d", len(results.Truth.Mismatches))
+		if mismatched {
+			verdict = "FAIL"
+		}
+	}
+	proofResult := ""
+	unreproduced := 0
+	for _, p := range results.Proofs {
+		if !p.Recomputed {
+			unreproduced++
+		}
+	}
+	if len(results.Proofs) > 0 {
+		proofResult = fmt.Sprintf(" proof_failures=%d", unreproduced)
+		if unreproduced > 0 {
+			verdict = "FAIL"
+		}
+	}
+	populationResult := ""
+	skewed := 0
+	for _, p := range results.Populations {
+		if p.Skewed() {
+			skewed++
+		}
+	}
+	if *adversarial {
+		populationResult = fmt.Sprintf(" skewed_populations=%d", skewed)
+		if skewed > 0 {
+			verdict = "FAIL"
+		}
+	}
+	aaResult := ""
+	aaFailed := results.AA != nil && !results.AA.Passed()
+	if results.AA != nil {
+		aaResult = fmt.Sprintf(" aa_p=%.4f aa_passed=%t", results.AA.Uniformity.PValue, results.AA.Passed())
+		if aaFailed {
+			verdict = "FAIL"
+		}
+	}
+	fmt.Printf("RESULT consistency=%.2f min=%.2f users=%d failed_requests=%d%s%s%s%s%s%s %s\n",
+		results.AllocationConsistency, *minConsistency, results.TotalUsers, results.FailedRequests, driftResult, temporalResult, truthResult, proofResult, populationResult, aaResult, verdict)
+
+	if *failOnInconsistency && !passed {
+		os.Exit(1)
+	}
+	if *failOnDrift && drifted {
+		os.Exit(1)
+	}
+	if reassigned || mismatched || unreproduced > 0 || skewed > 0 || aaFailed {
+		os.Exit(1)
+	}
+}
+
+// generatePopulation returns n distinct userIds of the given shape. Apart from
+// uuid, the shapes mimic real ids that share most of their bytes, which is
+// where a weak hash would cluster users into the same payloads.
+func generatePopulation(kind string, n int) ([]string, error) {
+	ids := make([]string, n)
+	switch kind {
+	case "uuid":
+		for i := range ids {
+			ids[i] = uuid.New().String()
+		}
+	case "sequential":
+		for i := range ids {
+			ids[i] = strconv.Itoa(i + 1)
+		}
+	case "prefix":
+		for i := range ids {
+			ids[i] = fmt.Sprintf("tenant-acme-production-account-%08d", i)
+		}
+	case "timestamp":
+		// Ids minted a few milliseconds apart, as by a time-based generator
+		base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC).UnixMilli()
+		for i := range ids {
+			ids[i] = strconv.FormatInt(base+int64(i)*7, 10)
+		}
+	default:
+		return nil, fmt.Errorf("unknown population %q (want uuid, sequential, prefix or timestamp)", kind)
+	}
+	return ids, nil
+}
+
+// comparePopulation tests whether run's payload split, over the userIds of
+// one population, is independent of which population the users came from
+// compared with the reference run.
+func comparePopulation(kind, example string, reference, run TestResults) PopulationCheck {
+	names := make(map[string]int)
+	for name, count := range reference.PayloadDistribution {
+		names[name] += count
+	}
+	for name, count := range run.PayloadDistribution {
+		names[name] += count
+	}
+	payloadNames := sortedPayloadNames(names)
+	table := [][]int{make([]int, len(payloadNames)), make([]int, len(payloadNames))}
+	for j, name := range payloadNames {
+		table[0][j] = reference.PayloadDistribution[name]
+		table[1][j] = run.PayloadDistribution[name]
+	}
+	return PopulationCheck{
+		Population:   kind,
+		Example:      example,
+		Users:        run.TotalUsers,
+		Independence: allocation.MeasureIndependence(table),
+	}
+}
+
+// loadUserIDs reads one userId per line, skipping blank lines and lines
+// starting with '#'. Duplicates are dropped, keeping first-seen order, so each
+// user is tested exactly requestsPerUser times. It also returns the number of
+// lines read so callers can report how many duplicates were removed.
+func loadUserIDs(path string) ([]string, int, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer f.Close()
+
+	var ids []string
+	seen := make(map[string]bool)
+	linesRead := 0
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		linesRead++
+		id := strings.TrimSpace(scanner.Text())
+		if id == "" || strings.HasPrefix(id, "#") || seen[id] {
+			continue
+		}
+		seen[id] = true
+		ids = append(ids, id)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, 0, err
+	}
+
+	return ids, linesRead, nil
 }
 
-func checkHealth(serverURL string) bool {
-	resp, err := http.Get(serverURL + "/health")
+// loadTruth reads a CSV of userId,expectedVariant pairs, as exported by an
+// analytics system, and returns the userIds in file order with each one's
+// expected variant. Blank lines, lines starting with '#', a leading header
+// row and columns after the second are skipped, so /admin/allocate-batch CSV
+// output can be read as is. A user listed twice must expect the same variant
+// both times.
+func loadTruth(path string) ([]string, map[string]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer f.Close()
+
+	r := csv.NewReader(f)
+	r.Comment = '#'
+	r.FieldsPerRecord = -1
+	r.TrimLeadingSpace = true
+
+	var ids []string
+	expected := make(map[string]string)
+	for first := true; ; first = false {
+		record, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		line, _ := r.FieldPos(0)
+		if len(record) < 2 {
+			return nil, nil, fmt.Errorf("line %d: want userId,expectedVariant", line)
+		}
+		userID, variant := strings.TrimSpace(record[0]), strings.TrimSpace(record[1])
+		if first && strings.EqualFold(userID, "userId") {
+			continue
+		}
+		if userID == "" || variant == "" {
+			return nil, nil, fmt.Errorf("line %d: userId and expectedVariant must not be empty", line)
+		}
+		if prev, ok := expected[userID]; ok {
+			if prev != variant {
+				return nil, nil, fmt.Errorf("line %d: user %s expects both %s and %s", line, userID, prev, variant)
+			}
+			continue
+		}
+		expected[userID] = variant
+		ids = append(ids, userID)
+	}
+	return ids, expected, nil
+}
+
+// parseLocales parses a comma-separated list of locale:weight pairs. Weights
+// are relative and need not sum to 100.
+func parseLocales(spec string) ([]LocaleWeight, error) {
+	var weights []LocaleWeight
+	seen := make(map[string]bool)
+	for _, part := range strings.Split(spec, ",") {
+		locale, weightStr, ok := strings.Cut(strings.TrimSpace(part), ":")
+		if !ok || locale == "" {
+			return nil, fmt.Errorf("invalid -locales entry %q (want locale:weight)", part)
+		}
+		weight, err := strconv.ParseFloat(weightStr, 64)
+		if err != nil || weight <= 0 {
+			return nil, fmt.Errorf("invalid weight %q for locale %s (want a positive number)", weightStr, locale)
+		}
+		if seen[locale] {
+			return nil, fmt.Errorf("locale %s listed twice in -locales", locale)
+		}
+		seen[locale] = true
+		weights = append(weights, LocaleWeight{Locale: locale, Weight: weight})
+	}
+	return weights, nil
+}
+
+// assignLocales gives each user a locale drawn from weights. The draw is seeded
+// and follows userIDs order, so the same inputs always produce the same
+// assignment.
+func assignLocales(userIDs []string, weights []LocaleWeight, seed int64) map[string]string {
+	total := 0.0
+	for _, w := range weights {
+		total += w.Weight
+	}
+
+	rng := rand.New(rand.NewSource(seed))
+	locales := make(map[string]string, len(userIDs))
+	for _, id := range userIDs {
+		r := rng.Float64() * total
+		locale := weights[len(weights)-1].Locale
+		for _, w := range weights {
+			if r < w.Weight {
+				locale = w.Locale
+				break
+			}
+			r -= w.Weight
+		}
+		locales[id] = locale
+	}
+	return locales
+}
+
+// addLocaleBreakdown splits the payload distribution by locale and tests
+// whether locale and payload are independent. They should be: allocation only
+// looks at the userId, so any association means locale leaked into bucketing.
+func addLocaleBreakdown(results *TestResults, weights []LocaleWeight) {
+	results.LocaleDistribution = make(map[string]map[string]int)
+	for _, w := range weights {
+		results.Locales = append(results.Locales, w.Locale)
+		results.LocaleDistribution[w.Locale] = make(map[string]int)
+	}
+	for _, alloc := range results.UserAllocations {
+		results.LocaleDistribution[alloc.Locale][alloc.PayloadName]++
+	}
+
+	payloadNames := sortedPayloadNames(results.PayloadDistribution)
+	table := make([][]int, len(results.Locales))
+	for i, locale := range results.Locales {
+		table[i] = make([]int, len(payloadNames))
+		for j, name := range payloadNames {
+			table[i][j] = results.LocaleDistribution[locale][name]
+		}
+	}
+	report := allocation.MeasureIndependence(table)
+	results.LocaleIndependence = &report
+}
+
+func sortedPayloadNames(distribution map[string]int) []string {
+	names := make([]string, 0, len(distribution))
+	for name := range distribution {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// checkHealth retries the server's /health until it answers 200 OK or the
+// attempts run out, printing how long a slow-starting server took.
+func checkHealth(client *http.Client, serverURL string, attempts int, interval time.Duration) bool {
+	waited, err := health.Wait(client, serverURL+"/health", attempts, interval, func(attempt int, err error) {
+		fmt.Printf("⏳ Server not ready (%v); retry %d/%d in %s\n", err, attempt, attempts, interval)
+	})
 	if err != nil {
+		fmt.Printf("❌ Server health check failed: %v. Is the server running?\n", err)
 		return false
 	}
-	defer resp.Body.Close()
-	return resp.StatusCode == http.StatusOK
+	if waited > 0 {
+		fmt.Printf("✅ Server became ready after %s\n", waited.Round(time.Millisecond))
+	}
+	return true
 }
 
-func runAllocationTest(serverURL string, userIDs []string, requestsPerUser, concurrency int) TestResults {
+// runAllocationTest sends requestsPerUser requests for every user from
+// concurrency workers. By default each user's requests are queued back to
+// back, so concurrent workers often send them at once; interleave queues one
+// request per user per round instead, so a user's requests are a whole round
+// of the other users apart. Only interleaved runs see what a server-side
+// cache or its expiry does to a user's later requests.
+func runAllocationTest(serverURL, authToken string, userIDs []string, userLocales map[string]string, requestsPerUser, concurrency int, interleave bool) TestResults {
 	fmt.Println("Running allocation test...")
 
 	startTime := time.Now()
 
 	// Track allocations per user
 	userPayloads := make(map[string]map[string]int) // userID -> payloadName -> count
+	userExposed := make(map[string]bool)            // only filled if the server reports exposure
+	configHashes := make(map[string]bool)           // X-Config-Hash values seen
+	userBuckets := make(map[string]BucketInfo)      // only filled if the server sends X-Bucket-Hash
+	payloadDigests := make(map[string]string)       // payload -> SHA-256 of its content
 	var mu sync.Mutex
 
 	var totalRequests atomic.Int64
 	var successRequests atomic.Int64
 	var failedRequests atomic.Int64
+	var reportMismatch sync.Once // header/body mismatches fail requests; show the first
 
 	// Create work channel
 	type work struct {
@@ -125,9 +872,17 @@ func runAllocationTest(serverURL string, userIDs []string, requestsPerUser, conc
 	workChan := make(chan work, len(userIDs)*requestsPerUser)
 
 	// Fill work channel
-	for _, userID := range userIDs {
+	if interleave {
 		for i := 0; i < requestsPerUser; i++ {
-			workChan <- work{userID: userID}
+			for _, userID := range userIDs {
+				workChan <- work{userID: userID}
+			}
+		}
+	} else {
+		for _, userID := range userIDs {
+			for i := 0; i < requestsPerUser; i++ {
+				workChan <- work{userID: userID}
+			}
 		}
 	}
 	close(workChan)
@@ -143,9 +898,12 @@ func runAllocationTest(serverURL string, userIDs []string, requestsPerUser, conc
 			for w := range workChan {
 				totalRequests.Add(1)
 
-				payload, err := makeRequest(client, serverURL+"/experiment", w.userID)
+				result, err := makeRequest(client, serverURL+"/experiment", authToken, w.userID, userLocales[w.userID])
 				if err != nil {
 					failedRequests.Add(1)
+					if errors.Is(err, errHeaderMismatch) {
+						reportMismatch.Do(func() { fmt.Printf("\n❌ %s: %v\n", w.userID, err) })
+					}
 					continue
 				}
 
@@ -155,7 +913,17 @@ func runAllocationTest(serverURL string, userIDs []string, requestsPerUser, conc
 				if userPayloads[w.userID] == nil {
 					userPayloads[w.userID] = make(map[string]int)
 				}
-				userPayloads[w.userID][payload]++
+				userPayloads[w.userID][result.PayloadName]++
+				if result.Exposed != nil {
+					userExposed[w.userID] = *result.Exposed
+				}
+				if result.ConfigHash != "" {
+					configHashes[result.ConfigHash] = true
+				}
+				if _, seen := userBuckets[w.userID]; !seen && result.Bucket != nil {
+					userBuckets[w.userID] = *result.Bucket
+				}
+				payloadDigests[result.PayloadName] = result.PayloadDigest
 				mu.Unlock()
 			}
 		}()
@@ -187,48 +955,179 @@ func runAllocationTest(serverURL string, userIDs []string, requestsPerUser, conc
 	duration := endTime.Sub(startTime)
 
 	// Analyze results
-	results := analyzeResults(userPayloads, requestsPerUser, duration,
+	results := analyzeResults(userPayloads, userLocales, requestsPerUser, duration,
 		int(totalRequests.Load()), int(successRequests.Load()), int(failedRequests.Load()))
+	results.TestDate = startTime.UTC()
+	if len(userBuckets) > 0 {
+		results.Buckets = userBuckets
+	}
+	results.PayloadDigests = payloadDigests
+
+	// A reload mid-run changes the hash; keep every value so the run never
+	// looks comparable to a baseline taken under just one of them
+	hashes := make([]string, 0, len(configHashes))
+	for hash := range configHashes {
+		hashes = append(hashes, hash)
+	}
+	sort.Strings(hashes)
+	results.ConfigHash = strings.Join(hashes, ",")
+	if len(hashes) > 1 {
+		fmt.Printf("⚠️  Server config changed during the run (%s)\n", results.ConfigHash)
+	}
+
+	if len(userExposed) > 0 {
+		results.Exposure = &ExposureStats{}
+		for _, exposed := range userExposed {
+			if exposed {
+				results.Exposure.Exposed++
+			} else {
+				results.Exposure.Unexposed++
+			}
+		}
+	}
 
 	return results
 }
 
-func makeRequest(client *http.Client, url, userID string) (string, error) {
+// errHeaderMismatch marks a response whose X-Variant or X-Experiment-Id
+// headers disagree with its body
+var errHeaderMismatch = errors.New("variant headers disagree with the body")
+
+// RequestResult is what one /experiment response says about a user's
+// assignment.
+type RequestResult struct {
+	PayloadName string
+	Exposed     *bool       // set when the server gates the experiment with -exposure
+	ConfigHash  string      // X-Config-Hash; empty for servers that don't send it
+	Bucket      *BucketInfo // set when the server sends X-Bucket-Hash: bucketed users only
+	// PayloadDigest is the SHA-256 of the payload served: X-Payload-SHA256,
+	// or computed from the body for servers that don't send it
+	PayloadDigest string
+}
+
+// makeRequest returns the payload the server selected for userID and, when the
+// server gates the experiment with -exposure, whether the user was exposed.
+func makeRequest(client *http.Client, url, authToken, userID, locale string) (RequestResult, error) {
 	reqBody := Request{UserID: userID}
 	jsonData, _ := json.Marshal(reqBody)
 
-	resp, err := client.Post(url, "application/json", bytes.NewBuffer(jsonData))
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewBuffer(jsonData))
 	if err != nil {
-		return "", err
+		return RequestResult{}, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if authToken != "" {
+		req.Header.Set("Authorization", "Bearer "+authToken)
+	}
+	if locale != "" {
+		req.Header.Set("Accept-Language", locale)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return RequestResult{}, err
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		return "", fmt.Errorf("unexpected status: %d", resp.StatusCode)
+		return RequestResult{}, fmt.Errorf("unexpected status: %d", resp.StatusCode)
 	}
 
 	body, err := io.ReadAll(resp.Body)
 	if err != nil {
-		return "", err
+		return RequestResult{}, err
 	}
 
 	var response Response
 	if err := json.Unmarshal(body, &response); err != nil {
-		return "", err
+		return RequestResult{}, err
 	}
 
 	// Validate that the payload is valid JSON (not an escaped string)
 	if len(response.Payload) > 0 {
 		var payloadCheck interface{}
 		if err := json.Unmarshal(response.Payload, &payloadCheck); err != nil {
-			return "", fmt.Errorf("payload is not valid JSON: %v", err)
+			return RequestResult{}, fmt.Errorf("payload is not valid JSON: %v", err)
+		}
+	}
+
+	// The variant headers are for CDNs that don't parse the body, so they
+	// must say what the body says
+	if variant := resp.Header.Get("X-Variant"); variant != "" {
+		if variant != response.SelectedPayloadName || resp.Header.Get("X-Experiment-Id") != response.ExperimentID {
+			return RequestResult{}, fmt.Errorf("%w: X-Variant %q, X-Experiment-Id %q but body has %q, %q", errHeaderMismatch,
+				variant, resp.Header.Get("X-Experiment-Id"), response.SelectedPayloadName, response.ExperimentID)
+		}
+		if response.Exposed != nil && !*response.Exposed {
+			return RequestResult{}, fmt.Errorf("%w: X-Variant %q sent for an unexposed user", errHeaderMismatch, variant)
 		}
 	}
 
-	return response.SelectedPayloadName, nil
+	bucket, err := parseBucketHeaders(resp.Header)
+	if err != nil {
+		return RequestResult{}, err
+	}
+
+	digest := resp.Header.Get("X-Payload-SHA256")
+	if digest == "" {
+		sum := sha256.Sum256(response.Payload)
+		digest = hex.EncodeToString(sum[:])
+	}
+
+	return RequestResult{
+		PayloadName:   response.SelectedPayloadName,
+		Exposed:       response.Exposed,
+		ConfigHash:    resp.Header.Get("X-Config-Hash"),
+		Bucket:        bucket,
+		PayloadDigest: digest,
+	}, nil
 }
 
-func analyzeResults(userPayloads map[string]map[string]int, requestsPerUser int, duration time.Duration,
+// parseBucketHeaders returns how the server bucketed the user, or nil if it
+// didn't say: a gated user, or a server older than X-Bucket-Hash.
+func parseBucketHeaders(header http.Header) (*BucketInfo, error) {
+	hashHeader := header.Get("X-Bucket-Hash")
+	if hashHeader == "" {
+		return nil, nil
+	}
+	algorithm, hashText, ok := strings.Cut(hashHeader, ":")
+	hash, err := strconv.ParseUint(hashText, 10, 64)
+	if !ok || err != nil {
+		return nil, fmt.Errorf("malformed X-Bucket-Hash %q: want <algorithm>:<hash>", hashHeader)
+	}
+	buckets, err := strconv.Atoi(header.Get("X-Bucket-Count"))
+	if err != nil || buckets < 1 {
+		return nil, fmt.Errorf("malformed X-Bucket-Count %q", header.Get("X-Bucket-Count"))
+	}
+	bucket, err := strconv.Atoi(header.Get("X-Bucket"))
+	if err != nil || bucket < 0 || bucket >= buckets {
+		return nil, fmt.Errorf("malformed X-Bucket %q for %d buckets", header.Get("X-Bucket"), buckets)
+	}
+	nudgedFrom := -1
+	if from := header.Get("X-Bucket-Nudged-From"); from != "" {
+		if nudgedFrom, err = strconv.Atoi(from); err != nil || nudgedFrom < 0 || nudgedFrom >= buckets {
+			return nil, fmt.Errorf("malformed X-Bucket-Nudged-From %q for %d buckets", from, buckets)
+		}
+	}
+	return &BucketInfo{Algorithm: algorithm, Hash: hash, Buckets: buckets, Bucket: bucket, NudgedFrom: nudgedFrom}, nil
+}
+
+// primaryPayloadOf returns the payload a user received most often, which is
+// the one they are counted under when they were served more than one. Ties go
+// to the lowest name, so the same responses always give the same report.
+func primaryPayloadOf(payloads map[string]int) string {
+	var primary string
+	maxCount := 0
+	for payload, count := range payloads {
+		if count > maxCount || (count == maxCount && payload < primary) {
+			maxCount = count
+			primary = payload
+		}
+	}
+	return primary
+}
+
+func analyzeResults(userPayloads map[string]map[string]int, userLocales map[string]string, requestsPerUser int, duration time.Duration,
 	totalReqs, successReqs, failedReqs int) TestResults {
 
 	results := TestResults{
@@ -254,21 +1153,14 @@ func analyzeResults(userPayloads map[string]map[string]int, requestsPerUser int,
 		// Check consistency - user should have only one payload
 		consistent := len(payloads) == 1
 
-		// Get the primary payload (most common if inconsistent)
-		var primaryPayload string
-		maxCount := 0
-		for payload, count := range payloads {
-			if count > maxCount {
-				maxCount = count
-				primaryPayload = payload
-			}
-		}
+		primaryPayload := primaryPayloadOf(payloads)
 
 		// Update distribution
 		results.PayloadDistribution[primaryPayload]++
 
 		allocation := UserAllocation{
 			UserID:       userID,
+			Locale:       userLocales[userID],
 			PayloadName:  primaryPayload,
 			RequestCount: totalForUser,
 			Consistent:   consistent,
@@ -281,8 +1173,8 @@ func analyzeResults(userPayloads map[string]map[string]int, requestsPerUser int,
 			results.InconsistentUsers++
 			// Record inconsistency details
 			var payloadList []string
-			for payload, count := range payloads {
-				payloadList = append(payloadList, fmt.Sprintf("%s(%d)", payload, count))
+			for _, payload := range sortedPayloadNames(payloads) {
+				payloadList = append(payloadList, fmt.Sprintf("%s(%d)", payload, payloads[payload]))
 			}
 			results.InconsistentDetails = append(results.InconsistentDetails,
 				fmt.Sprintf("User %s received multiple payloads: %s", userID, strings.Join(payloadList, ", ")))
@@ -358,14 +1250,199 @@ func printSummary(results TestResults) {
 	if nestedCount > 0 {
 		fmt.Printf("  - Including %d items from nested_large.json array\n", nestedCount)
 	}
+
+	if results.Exposure != nil {
+		fmt.Println()
+		fmt.Println("Exposure:")
+		fmt.Printf("  Exposed: %d users (%.1f%%)\n", results.Exposure.Exposed, percentOf(results.Exposure.Exposed, results.TotalUsers))
+		fmt.Printf("  Unexposed (control): %d users (%.1f%%)\n", results.Exposure.Unexposed, percentOf(results.Exposure.Unexposed, results.TotalUsers))
+	}
+
+	if results.LocaleDistribution != nil {
+		fmt.Println()
+		fmt.Println("Distribution by Locale:")
+		for _, locale := range results.Locales {
+			users, payloads := localeTotals(results.LocaleDistribution[locale])
+			fmt.Printf("  %s: %d users (%.1f%%) across %d payloads\n",
+				locale, users, percentOf(users, results.TotalUsers), payloads)
+		}
+		printIndependence(results.LocaleIndependence)
+	}
+
+	if len(results.Populations) > 0 {
+		fmt.Println()
+		fmt.Println("Adversarial Populations (payload split vs the main run's users):")
+		for _, p := range results.Populations {
+			r := p.Independence
+			status := "✅ balanced"
+			switch {
+			case !r.Reliable():
+				status = fmt.Sprintf("⚠️  too few users per cell (min expected %.2f, want 5) - raise -users", r.MinExpected)
+			case p.Skewed():
+				status = "❌ skewed"
+			}
+			fmt.Printf("  %-10s chi-square %.2f (df=%d), p-value %.4f  %s (e.g. %s)\n",
+				p.Population, r.ChiSquare, r.DegreesOfFreedom, r.PValue, status, p.Example)
+		}
+	}
+
+	if results.Drift != nil {
+		fmt.Println()
+		fmt.Printf("Distribution Drift (vs %s, threshold %.2f pp):\n", results.Drift.BaselineFile, results.Drift.Threshold)
+		if results.Drift.ConfigMismatch {
+			fmt.Printf("  ⚠️  Config mismatch: baseline ran against server config %s, this run against %s\n",
+				results.Drift.BaselineConfigHash, results.ConfigHash)
+		}
+		if results.Drift.DriftedCount == 0 {
+			fmt.Println("  ✅ No payload drifted beyond the threshold")
+		} else {
+			fmt.Printf("  ❌ %d payloads drifted beyond the threshold\n", results.Drift.DriftedCount)
+		}
+		// Show the largest movements (entries are sorted by absolute delta)
+		for i, e := range results.Drift.Entries {
+			if !e.Drifted {
+				break
+			}
+			if i >= 10 {
+				fmt.Printf("  ... and %d more payloads\n", results.Drift.DriftedCount-i)
+				break
+			}
+			fmt.Printf("  %s: %.2f%% -> %.2f%% (%+.2f pp)\n", e.Payload, e.BaselinePct, e.CurrentPct, e.Delta)
+		}
+	}
+	if t := results.Temporal; t != nil {
+		fmt.Println()
+		fmt.Printf("Consistency Over Time (vs %s, %s earlier):\n", t.PreviousFile, t.Elapsed.Round(time.Second))
+		if t.ConfigMismatch {
+			fmt.Printf("  ⚠️  Config mismatch: the earlier run was against server config %s, this run against %s\n",
+				t.PreviousConfigHash, results.ConfigHash)
+		}
+		if t.OrderingDiffers {
+			fmt.Printf("  Ordering: the earlier run was %s, this run %s\n", orderingName(t.PreviousSequential), orderingName(results.Sequential))
+			if len(t.Changes) > 0 && !t.ConfigMismatch {
+				fmt.Println("  ❌ Request ordering changed assignments: allocation depends on the order or timing of requests")
+			}
+		}
+		if len(t.Changes) == 0 {
+			fmt.Printf("  ✅ All %d users kept their payload\n", t.Checked)
+		} else {
+			fmt.Printf("  ❌ %d of %d users changed payload\n", len(t.Changes), t.Checked)
+		}
+		if t.Missing > 0 {
+			fmt.Printf("  ⚠️  %d users had no successful request and were not compared\n", t.Missing)
+		}
+		for i, c := range t.Changes {
+			if i >= 10 {
+				fmt.Printf("  ... and %d more users\n", len(t.Changes)-i)
+				break
+			}
+			fmt.Printf("  %s: %s -> %s\n", c.UserID, c.Before, c.After)
+		}
+	}
+	if t := results.Truth; t != nil {
+		fmt.Println()
+		fmt.Printf("Source of Truth (%s):\n", t.TruthFile)
+		if len(t.Mismatches) == 0 {
+			fmt.Printf("  ✅ All %d users got their expected variant\n", t.Checked)
+		} else {
+			fmt.Printf("  ❌ %d of %d users got a different variant than expected\n", len(t.Mismatches), t.Checked)
+		}
+		if t.Missing > 0 {
+			fmt.Printf("  ⚠️  %d users had no successful request and were not compared\n", t.Missing)
+		}
+		for i, m := range t.Mismatches {
+			if i >= 10 {
+				fmt.Printf("  ... and %d more users\n", len(t.Mismatches)-i)
+				break
+			}
+			fmt.Printf("  %s: expected %s, got %s\n", m.UserID, m.Expected, m.Actual)
+		}
+	}
+	if a := results.AA; a != nil {
+		fmt.Println()
+		fmt.Println("A/A Test:")
+		switch {
+		case !a.Bucketed():
+			fmt.Println("  ❌ No bucketed users: the server sent no X-Bucket-Hash, so there is no split to test")
+		case !a.Identical():
+			fmt.Printf("  ❌ Not an A/A configuration: %d variants serve %d different contents\n", a.Variants, a.Contents)
+		case !a.Reliable():
+			fmt.Printf("  ❌ Inconclusive: %d users over %d buckets is fewer than 5 per bucket; rerun with more users\n",
+				a.Uniformity.Samples, a.Uniformity.Buckets)
+		case a.Passed():
+			fmt.Printf("  ✅ %d users split evenly over %d identical variants: chi-square %.2f (df=%d), p-value %.4f\n",
+				a.Uniformity.Samples, a.Uniformity.Buckets, a.Uniformity.ChiSquare, a.Uniformity.DegreesOfFreedom, a.Uniformity.PValue)
+		default:
+			fmt.Printf("  ❌ Uneven split over identical variants: chi-square %.2f (df=%d), p-value %.4f < %g\n",
+				a.Uniformity.ChiSquare, a.Uniformity.DegreesOfFreedom, a.Uniformity.PValue, a.Alpha)
+			fmt.Println("     The variants are identical, so this is a framework bug, not a real effect")
+		}
+	}
+	if len(results.Proofs) > 0 {
+		fmt.Println()
+		fmt.Println("Reproducibility Proof:")
+		for _, p := range results.Proofs {
+			status := "✅"
+			if !p.Recomputed {
+				status = "❌ not reproduced"
+			}
+			bucket := strconv.Itoa(p.Bucket)
+			if p.NudgedFrom >= 0 {
+				bucket = fmt.Sprintf("%d, nudged to %d", p.NudgedFrom, p.Bucket)
+			}
+			fmt.Printf("  %s: %s hash %d, %s with n=%d -> bucket %s -> %s  %s\n",
+				p.UserID, p.Algorithm, p.Hash, allocation.ReductionFormula(p.Algorithm), p.Buckets, bucket, p.Variant, status)
+		}
+	}
 	fmt.Println("━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━")
 }
 
-func writeResults(filename string, results TestResults) error {
+// localeTotals returns the number of users in a locale and how many distinct
+// payloads they were assigned.
+func localeTotals(distribution map[string]int) (users, payloads int) {
+	for _, count := range distribution {
+		users += count
+		payloads++
+	}
+	return users, payloads
+}
+
+func percentOf(count, total int) float64 {
+	if total == 0 {
+		return 0
+	}
+	return float64(count) / float64(total) * 100
+}
+
+func printIndependence(r *allocation.IndependenceReport) {
+	fmt.Printf("  Locale x payload independence: chi-square %.2f (df=%d, %d payload groups), p-value %.4f\n",
+		r.ChiSquare, r.DegreesOfFreedom, r.Columns, r.PValue)
+	switch {
+	case !r.Reliable():
+		fmt.Printf("  ⚠️  Too few users per cell for a reliable test (min expected %.2f, want 5) - raise -users\n", r.MinExpected)
+	case r.Detectable(0.01):
+		fmt.Println("  ❌ Payload assignment depends on locale")
+	default:
+		fmt.Println("  ✅ No association between locale and payload")
+	}
+}
+
+// writeResults writes the Markdown report to filename and the JSON results
+// export (see ResultsExport) to jsonFilename. samples are the users listed in
+// the sample allocations table, chosen with sampleMode (see
+// sampleAllocations).
+func writeResults(filename, jsonFilename string, results TestResults, samples []UserAllocation, sampleMode string) error {
+	if err := writeResultsExport(jsonFilename, results); err != nil {
+		return err
+	}
+
 	var sb strings.Builder
 
 	sb.WriteString("# A/B Allocation Test Results\n\n")
-	sb.WriteString(fmt.Sprintf("**Test Date:** %s\n\n", time.Now().Format(time.RFC3339)))
+	sb.WriteString(fmt.Sprintf("**Test Date:** %s\n\n", results.TestDate.Format(time.RFC3339)))
+	if results.ConfigHash != "" {
+		sb.WriteString(fmt.Sprintf("**Server Config Hash:** `%s`\n\n", results.ConfigHash))
+	}
 
 	sb.WriteString("## Test Configuration\n\n")
 	sb.WriteString(fmt.Sprintf("- **Total Users:** %d\n", results.TotalUsers))
@@ -423,23 +1500,138 @@ func writeResults(filename string, results TestResults) error {
 	}
 	sb.WriteString("\n")
 
+	if results.Exposure != nil {
+		sb.WriteString("## Exposure\n\n")
+		sb.WriteString("The server buckets only a fraction of users into the experiment; the rest get the control payload. Unexposed users are included in the payload distribution above under the control payload.\n\n")
+		sb.WriteString("| Group | Users | Percentage |\n")
+		sb.WriteString("|-------|-------|------------|\n")
+		sb.WriteString(fmt.Sprintf("| Exposed | %d | %.1f%% |\n", results.Exposure.Exposed, percentOf(results.Exposure.Exposed, results.TotalUsers)))
+		sb.WriteString(fmt.Sprintf("| Unexposed (control) | %d | %.1f%% |\n\n", results.Exposure.Unexposed, percentOf(results.Exposure.Unexposed, results.TotalUsers)))
+	}
+
+	if results.LocaleDistribution != nil {
+		writeLocaleBreakdown(&sb, results)
+	}
+
+	if results.Drift != nil {
+		sb.WriteString("## Distribution Drift\n\n")
+		sb.WriteString(fmt.Sprintf("Compared against `%s` from %s. Payloads whose share moved more than **%.2f percentage points** are flagged.\n\n",
+			results.Drift.BaselineFile, results.Drift.BaselineDate.Format(time.RFC3339), results.Drift.Threshold))
+		if results.Drift.ConfigMismatch {
+			sb.WriteString(fmt.Sprintf("### ⚠️ Config mismatch\n\nThe baseline ran against server config `%s`, this run against `%s`. Drift between different configs is expected and says nothing about allocation.\n\n",
+				results.Drift.BaselineConfigHash, results.ConfigHash))
+		}
+		if results.Drift.DriftedCount == 0 {
+			sb.WriteString("### ✅ No drift detected\n\n")
+		} else {
+			sb.WriteString(fmt.Sprintf("### ❌ %d payloads drifted\n\n", results.Drift.DriftedCount))
+			sb.WriteString("| Payload | Baseline | Current | Delta |\n")
+			sb.WriteString("|---------|----------|---------|-------|\n")
+			for _, e := range results.Drift.Entries {
+				if e.Drifted {
+					sb.WriteString(fmt.Sprintf("| %s | %.2f%% | %.2f%% | %+.2f pp |\n", e.Payload, e.BaselinePct, e.CurrentPct, e.Delta))
+				}
+			}
+			sb.WriteString("\n")
+		}
+	}
+
+	if len(results.Populations) > 0 {
+		sb.WriteString("## Adversarial Populations\n\n")
+		sb.WriteString(fmt.Sprintf("Each population of non-random userIds was run against the server and its payload split compared with the main run's users by a chi-square test of independence (payloads pooled so each cell expects at least 5 users). A p-value below %.4f (1%% split across the %d populations) means the hash splits that pattern differently.\n\n", populationAlpha, len(adversarialPopulations)))
+		sb.WriteString("| Population | Example | Users | Chi-square | df | p-value | Result |\n")
+		sb.WriteString("|------------|---------|-------|------------|----|---------|--------|\n")
+		for _, p := range results.Populations {
+			r := p.Independence
+			result := "✅ Balanced"
+			switch {
+			case !r.Reliable():
+				result = "⚠️ Too few users"
+			case p.Skewed():
+				result = "❌ Skewed"
+			}
+			sb.WriteString(fmt.Sprintf("| %s | `%s` | %d | %.2f | %d | %.4f | %s |\n",
+				p.Population, p.Example, p.Users, r.ChiSquare, r.DegreesOfFreedom, r.PValue, result))
+		}
+		sb.WriteString("\n")
+	}
+
+	if t := results.Temporal; t != nil {
+		sb.WriteString("## Consistency Over Time\n\n")
+		sb.WriteString(fmt.Sprintf("Re-tested the users from `%s` (%s), **%s** later. Each user should get the same payload as before.\n\n",
+			t.PreviousFile, t.PreviousDate.Format(time.RFC3339), t.Elapsed.Round(time.Second)))
+		if t.ConfigMismatch {
+			sb.WriteString(fmt.Sprintf("### ⚠️ Config mismatch\n\nThe earlier run was against server config `%s`, this run against `%s`. Reassignments between different configs are expected.\n\n",
+				t.PreviousConfigHash, results.ConfigHash))
+		}
+		if t.OrderingDiffers {
+			sb.WriteString(fmt.Sprintf("The earlier run was **%s**, this run **%s**. Deterministic allocation gives every user the same payload in any order",
+				orderingName(t.PreviousSequential), orderingName(results.Sequential)))
+			if len(t.Changes) > 0 && !t.ConfigMismatch {
+				sb.WriteString(", so the changes below are a bug: allocation depends on the order or timing of requests")
+			}
+			sb.WriteString(".\n\n")
+		}
+		if len(t.Changes) == 0 {
+			sb.WriteString(fmt.Sprintf("### ✅ All %d users kept their payload\n\n", t.Checked))
+		} else {
+			sb.WriteString(fmt.Sprintf("### ❌ %d of %d users changed payload\n\n", len(t.Changes), t.Checked))
+			sb.WriteString("| User ID | Before | After |\n")
+			sb.WriteString("|---------|--------|-------|\n")
+			for _, c := range t.Changes {
+				sb.WriteString(fmt.Sprintf("| %s | %s | %s |\n", c.UserID, c.Before, c.After))
+			}
+			sb.WriteString("\n")
+		}
+		if t.Missing > 0 {
+			sb.WriteString(fmt.Sprintf("%d users had no successful request in this run and were not compared.\n\n", t.Missing))
+		}
+	}
+
+	if t := results.Truth; t != nil {
+		sb.WriteString("## Source of Truth\n\n")
+		sb.WriteString(fmt.Sprintf("Checked each user against the variant `%s` expects for them.\n\n", t.TruthFile))
+		if len(t.Mismatches) == 0 {
+			sb.WriteString(fmt.Sprintf("### ✅ All %d users got their expected variant\n\n", t.Checked))
+		} else {
+			sb.WriteString(fmt.Sprintf("### ❌ %d of %d users got a different variant than expected\n\n", len(t.Mismatches), t.Checked))
+			if len(t.Mismatches) > truthReportRows {
+				sb.WriteString(fmt.Sprintf("First %d by userId:\n\n", truthReportRows))
+			}
+			sb.WriteString("| User ID | Expected | Actual |\n")
+			sb.WriteString("|---------|----------|--------|\n")
+			for i, m := range t.Mismatches {
+				if i >= truthReportRows {
+					break
+				}
+				sb.WriteString(fmt.Sprintf("| %s | %s | %s |\n", m.UserID, m.Expected, m.Actual))
+			}
+			sb.WriteString("\n")
+		}
+		if t.Missing > 0 {
+			sb.WriteString(fmt.Sprintf("%d users had no successful request in this run and were not compared.\n\n", t.Missing))
+		}
+	}
+
+	if results.AA != nil {
+		writeAA(&sb, *results.AA)
+	}
+	if len(results.Proofs) > 0 {
+		writeProofs(&sb, results.Proofs)
+	}
+
 	// Add sample user allocations
 	sb.WriteString("## Sample User Allocations\n\n")
-	sb.WriteString("First 20 users and their assigned payloads:\n\n")
+	if sampleMode == "first" {
+		sb.WriteString(fmt.Sprintf("First %d users by userId and their assigned payloads:\n\n", len(samples)))
+	} else {
+		sb.WriteString(fmt.Sprintf("%d of %d users and their assigned payloads: inconsistent users first, then one user per payload when they all fit, then users spread evenly across the sorted userIds.\n\n",
+			len(samples), len(results.UserAllocations)))
+	}
 	sb.WriteString("| User ID | Payload | Requests | Consistent |\n")
 	sb.WriteString("|---------|---------|----------|------------|\n")
 
-	// Sort by user ID for consistent output
-	sort.Slice(results.UserAllocations, func(i, j int) bool {
-		return results.UserAllocations[i].UserID < results.UserAllocations[j].UserID
-	})
-
-	maxSamples := 20
-	if len(results.UserAllocations) < maxSamples {
-		maxSamples = len(results.UserAllocations)
-	}
-	for i := 0; i < maxSamples; i++ {
-		alloc := results.UserAllocations[i]
+	for _, alloc := range samples {
 		consistentStr := "✅"
 		if !alloc.Consistent {
 			consistentStr = "❌"
@@ -450,3 +1642,498 @@ func writeResults(filename string, results TestResults) error {
 
 	return os.WriteFile(filename, []byte(sb.String()), 0644)
 }
+
+// writeAA writes the A/A test verdict and the per-bucket counts it was
+// computed from.
+func writeAA(sb *strings.Builder, a AATest) {
+	u := a.Uniformity
+	sb.WriteString("## A/A Test\n\n")
+	sb.WriteString("Every variant serves the same content, so any difference between them is noise: users should split evenly across buckets. ")
+	sb.WriteString("A failing A/A test means the framework is broken (hashing, bucketing or this report), not that a variant had an effect.\n\n")
+	switch {
+	case !a.Bucketed():
+		sb.WriteString("### ❌ No Bucketed Users\n\nThe server sent no X-Bucket-Hash, so there is no split to test.\n\n")
+		return
+	case !a.Identical():
+		sb.WriteString(fmt.Sprintf("### ❌ Not an A/A Configuration\n\n%d variants served %d different contents. Load identical copies of one payload and rerun.\n\n", a.Variants, a.Contents))
+		return
+	case !a.Reliable():
+		sb.WriteString(fmt.Sprintf("### ❌ Inconclusive\n\n%d users over %d buckets is fewer than 5 expected per bucket, too few for the chi-square test. Rerun with more users.\n\n", u.Samples, u.Buckets))
+		return
+	case a.Passed():
+		sb.WriteString("### ✅ Passed\n\n")
+	default:
+		sb.WriteString("### ❌ Failed\n\n")
+	}
+	sb.WriteString(fmt.Sprintf("- **Users:** %d over %d identical variants (%.1f expected per bucket)\n", u.Samples, u.Buckets, u.Expected))
+	sb.WriteString(fmt.Sprintf("- **Chi-square:** %.2f (df=%d)\n", u.ChiSquare, u.DegreesOfFreedom))
+	sb.WriteString(fmt.Sprintf("- **p-value:** %.4f (alpha %g)\n", u.PValue, a.Alpha))
+	sb.WriteString(fmt.Sprintf("- **Largest deviation:** %.1f%%\n\n", u.MaxDeviation*100))
+	if len(u.Counts) <= truthReportRows {
+		sb.WriteString("| Bucket | Users |\n")
+		sb.WriteString("|--------|-------|\n")
+		for i, c := range u.Counts {
+			sb.WriteString(fmt.Sprintf("| %d | %d |\n", i, c))
+		}
+		sb.WriteString("\n")
+	}
+}
+
+// writeProofs writes the reproducibility proof: each sampled user's
+// assignment worked through so a reader can redo it without the server.
+func writeProofs(sb *strings.Builder, proofs []ReproducibilityProof) {
+	sb.WriteString("## Reproducibility Proof\n\n")
+	sb.WriteString("Assignment is a pure function of the userId: hash the userId, reduce the hash to a bucket among the n loaded payloads, and serve the payload at that index in the payloads sorted by file name. ")
+	sb.WriteString("The server reported each hash and bucket below, and this tool recomputed both from the userId alone.\n\n")
+	// One algorithm unless the server was restarted with another mid-run
+	var algorithms []string
+	for _, p := range proofs {
+		if !slices.Contains(algorithms, p.Algorithm) {
+			algorithms = append(algorithms, p.Algorithm)
+		}
+	}
+	for _, name := range algorithms {
+		switch name {
+		case allocation.DefaultHashAlgorithm:
+			sb.WriteString(fmt.Sprintf("- `%s`: hash is the 32-bit FNV-1a of the userId's UTF-8 bytes; bucket = %s.\n", name, allocation.ReductionFormula(name)))
+		default:
+			sb.WriteString(fmt.Sprintf("- `%s`: hash is the %s hash of the userId as 64 bits, a shorter hash in the high bits; bucket = %s.\n", name, name, allocation.ReductionFormula(name)))
+		}
+	}
+	sb.WriteString("\nA user can be served a different variant than the payload at their bucket while a rollback redirects it.")
+	if slices.ContainsFunc(proofs, func(p ReproducibilityProof) bool { return p.NudgedFrom >= 0 }) {
+		sb.WriteString(" The server's `-adaptive-balance` nudged some users from their hash bucket into a neighbouring one; their rows show both buckets, and the hash bucket is the one recomputed.")
+	}
+	sb.WriteString("\n\n")
+	sb.WriteString("| User ID | Hash | n | Bucket | Variant | Requests | Recomputed |\n")
+	sb.WriteString("|---------|------|---|--------|---------|----------|------------|\n")
+	for _, p := range proofs {
+		recomputed := "✅"
+		if !p.Recomputed {
+			recomputed = "❌"
+		}
+		bucket := strconv.Itoa(p.Bucket)
+		if p.NudgedFrom >= 0 {
+			bucket = fmt.Sprintf("%d → %d (nudged)", p.NudgedFrom, p.Bucket)
+		}
+		sb.WriteString(fmt.Sprintf("| %s | %s:%d | %d | %s | %s | %d | %s |\n",
+			p.UserID, p.Algorithm, p.Hash, p.Buckets, bucket, p.Variant, p.Requests, recomputed))
+	}
+	sb.WriteString("\n")
+}
+
+// sampleAllocations picks up to size users for the report's sample table. The
+// choice depends only on the results, so reruns with the same users show the
+// same sample. Without representative it returns the lowest userIds. With
+// representative it fills the sample in priority order: inconsistent users,
+// since those are the ones worth inspecting; then the lowest userId of each
+// payload not yet shown, so every variant appears (when they all fit); then
+// users evenly spaced across the sorted userIds rather than one lexical
+// prefix. Inconsistent users are listed first, the rest by userId.
+func sampleAllocations(allocations []UserAllocation, size int, representative bool) []UserAllocation {
+	sorted := make([]UserAllocation, len(allocations))
+	copy(sorted, allocations)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].UserID < sorted[j].UserID })
+	if size >= len(sorted) {
+		size = len(sorted)
+	}
+	if !representative {
+		return sorted[:size]
+	}
+
+	chosen := make([]bool, len(sorted))
+	var picked []int
+	pick := func(i int) {
+		if len(picked) < size && !chosen[i] {
+			chosen[i] = true
+			picked = append(picked, i)
+		}
+	}
+
+	for i, alloc := range sorted {
+		if !alloc.Consistent {
+			pick(i)
+		}
+	}
+	shown := make(map[string]bool)
+	for _, i := range picked {
+		shown[sorted[i].PayloadName] = true
+	}
+	var firstOfPayload []int
+	for i, alloc := range sorted {
+		if !shown[alloc.PayloadName] {
+			shown[alloc.PayloadName] = true
+			firstOfPayload = append(firstOfPayload, i)
+		}
+	}
+	// With more payloads than room, one per payload would just be the lowest
+	// userIds again, so leave the sample to even spacing
+	if len(firstOfPayload) <= size-len(picked) {
+		for _, i := range firstOfPayload {
+			pick(i)
+		}
+	}
+	// Stepping over the whole set can land on users already picked, so keep
+	// halving the stride until the sample is full
+	for stride := len(sorted) / max(size-len(picked), 1); len(picked) < size; stride = max(stride/2, 1) {
+		for i := 0; i < len(sorted) && len(picked) < size; i += stride {
+			pick(i)
+		}
+	}
+
+	sort.SliceStable(picked, func(a, b int) bool {
+		x, y := sorted[picked[a]], sorted[picked[b]]
+		if x.Consistent != y.Consistent {
+			return !x.Consistent
+		}
+		return x.UserID < y.UserID
+	})
+	samples := make([]UserAllocation, len(picked))
+	for i, index := range picked {
+		samples[i] = sorted[index]
+	}
+	return samples
+}
+
+// writeLocaleBreakdown writes the per-locale distribution and the locale x
+// payload cross-tab.
+func writeLocaleBreakdown(sb *strings.Builder, results TestResults) {
+	sb.WriteString("## Distribution by Locale\n\n")
+	sb.WriteString("Users were assigned locales (sent as `Accept-Language`). Allocation should ignore locale, so every locale should split across payloads the same way.\n\n")
+	sb.WriteString("| Locale | Users | Percentage | Payloads |\n")
+	sb.WriteString("|--------|-------|------------|----------|\n")
+	for _, locale := range results.Locales {
+		users, payloads := localeTotals(results.LocaleDistribution[locale])
+		sb.WriteString(fmt.Sprintf("| %s | %d | %.1f%% | %d |\n", locale, users, percentOf(users, results.TotalUsers), payloads))
+	}
+	sb.WriteString("\n")
+
+	r := results.LocaleIndependence
+	sb.WriteString(fmt.Sprintf("**Independence test:** chi-square %.2f (df=%d), p-value %.4f, min expected cell %.2f. Payloads are pooled into %d groups in name order so every cell expects at least 5 users.\n\n",
+		r.ChiSquare, r.DegreesOfFreedom, r.PValue, r.MinExpected, r.Columns))
+	switch {
+	case !r.Reliable():
+		sb.WriteString("### ⚠️ Inconclusive\n\nToo few users per locale and payload for a reliable chi-square test. Rerun with more users.\n\n")
+	case r.Detectable(0.01):
+		sb.WriteString("### ❌ Locale and payload are associated\n\nSome locales are over- or under-represented in some payloads.\n\n")
+	default:
+		sb.WriteString("### ✅ Locale and payload are independent\n\n")
+	}
+
+	sb.WriteString("### Locale × Payload\n\n")
+	sb.WriteString("Users per payload in each locale (percentage of that locale's users):\n\n")
+	sb.WriteString("| Payload |")
+	divider := "|---------|"
+	for _, locale := range results.Locales {
+		sb.WriteString(fmt.Sprintf(" %s |", locale))
+		divider += strings.Repeat("-", len(locale)+2) + "|"
+	}
+	sb.WriteString("\n" + divider + "\n")
+
+	localeUsers := make(map[string]int)
+	for _, locale := range results.Locales {
+		localeUsers[locale], _ = localeTotals(results.LocaleDistribution[locale])
+	}
+	for _, name := range sortedPayloadNames(results.PayloadDistribution) {
+		sb.WriteString(fmt.Sprintf("| %s |", name))
+		for _, locale := range results.Locales {
+			count := results.LocaleDistribution[locale][name]
+			sb.WriteString(fmt.Sprintf(" %d (%.1f%%) |", count, percentOf(count, localeUsers[locale])))
+		}
+		sb.WriteString("\n")
+	}
+	sb.WriteString("\n")
+}
+
+// newResultsExport converts results to the stable export schema.
+func newResultsExport(results TestResults) ResultsExport {
+	export := ResultsExport{
+		SchemaVersion:         resultsSchemaVersion,
+		TestDate:              results.TestDate,
+		ConfigHash:            results.ConfigHash,
+		TotalUsers:            results.TotalUsers,
+		TotalRequests:         results.TotalRequests,
+		SuccessfulRequests:    results.SuccessfulRequests,
+		FailedRequests:        results.FailedRequests,
+		ConsistentUsers:       results.ConsistentUsers,
+		InconsistentUsers:     results.InconsistentUsers,
+		AllocationConsistency: results.AllocationConsistency,
+		PayloadDistribution:   results.PayloadDistribution,
+		Exposure:              results.Exposure,
+		Locales:               results.Locales,
+		LocaleDistribution:    results.LocaleDistribution,
+		Assignments:           make(map[string]string, len(results.UserAllocations)),
+		Sequential:            results.Sequential,
+	}
+	for _, alloc := range results.UserAllocations {
+		export.Assignments[alloc.UserID] = alloc.PayloadName
+	}
+	if r := results.LocaleIndependence; r != nil {
+		export.LocaleIndependence = &IndependenceExport{
+			Samples:          r.Samples,
+			Columns:          r.Columns,
+			ChiSquare:        r.ChiSquare,
+			DegreesOfFreedom: r.DegreesOfFreedom,
+			PValue:           r.PValue,
+			MinExpected:      r.MinExpected,
+		}
+	}
+	return export
+}
+
+// writeResultsExport writes results as a ResultsExport JSON file.
+func writeResultsExport(filename string, results TestResults) error {
+	data, err := json.MarshalIndent(newResultsExport(results), "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filename, append(data, '\n'), 0644)
+}
+
+// LoadResults reads a JSON results export written by an earlier run. Exports
+// from before the schema was versioned (distribution only) load with just
+// their date, user count and distributions set. Per-user allocations, timings
+// and drift are not exported, so they are always empty.
+func LoadResults(filename string) (TestResults, error) {
+	var export ResultsExport
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return TestResults{}, err
+	}
+	if err := json.Unmarshal(data, &export); err != nil {
+		return TestResults{}, fmt.Errorf("%s is not a results export: %w", filename, err)
+	}
+	if export.SchemaVersion > resultsSchemaVersion {
+		return TestResults{}, fmt.Errorf("%s uses results schema %d, this tool reads up to %d",
+			filename, export.SchemaVersion, resultsSchemaVersion)
+	}
+	if export.TotalUsers == 0 {
+		return TestResults{}, fmt.Errorf("%s has no users", filename)
+	}
+
+	results := TestResults{
+		TestDate:              export.TestDate,
+		ConfigHash:            export.ConfigHash,
+		TotalUsers:            export.TotalUsers,
+		TotalRequests:         export.TotalRequests,
+		SuccessfulRequests:    export.SuccessfulRequests,
+		FailedRequests:        export.FailedRequests,
+		ConsistentUsers:       export.ConsistentUsers,
+		InconsistentUsers:     export.InconsistentUsers,
+		AllocationConsistency: export.AllocationConsistency,
+		PayloadDistribution:   export.PayloadDistribution,
+		Exposure:              export.Exposure,
+		Locales:               export.Locales,
+		LocaleDistribution:    export.LocaleDistribution,
+		Sequential:            export.Sequential,
+	}
+	for userID, payload := range export.Assignments {
+		results.UserAllocations = append(results.UserAllocations, UserAllocation{UserID: userID, PayloadName: payload})
+	}
+	sort.Slice(results.UserAllocations, func(i, j int) bool {
+		return results.UserAllocations[i].UserID < results.UserAllocations[j].UserID
+	})
+	if r := export.LocaleIndependence; r != nil {
+		results.LocaleIndependence = &allocation.IndependenceReport{
+			Samples:          r.Samples,
+			Columns:          r.Columns,
+			ChiSquare:        r.ChiSquare,
+			DegreesOfFreedom: r.DegreesOfFreedom,
+			PValue:           r.PValue,
+			MinExpected:      r.MinExpected,
+		}
+	}
+	return results, nil
+}
+
+// compareDistributions computes each payload's change in share of users
+// between the baseline and the current run. Payloads present in only one run
+// count as 0% in the other. Shares are compared rather than counts so runs
+// with different user totals remain comparable; for the comparison to reflect
+// config changes rather than sampling noise, both runs should test the same
+// users (-userids-file). Runs against different server configs (by config
+// hash) are flagged as a mismatch, since their drift is expected.
+func compareDistributions(baselineFile string, baseline TestResults, results TestResults, threshold float64) *DriftReport {
+	report := &DriftReport{
+		BaselineFile:       baselineFile,
+		BaselineDate:       baseline.TestDate,
+		Threshold:          threshold,
+		BaselineConfigHash: baseline.ConfigHash,
+		ConfigMismatch:     baseline.ConfigHash != "" && results.ConfigHash != "" && baseline.ConfigHash != results.ConfigHash,
+	}
+
+	payloads := make(map[string]bool)
+	for name := range baseline.PayloadDistribution {
+		payloads[name] = true
+	}
+	for name := range results.PayloadDistribution {
+		payloads[name] = true
+	}
+
+	for name := range payloads {
+		var currentPct float64
+		if results.TotalUsers > 0 {
+			currentPct = float64(results.PayloadDistribution[name]) / float64(results.TotalUsers) * 100
+		}
+		baselinePct := float64(baseline.PayloadDistribution[name]) / float64(baseline.TotalUsers) * 100
+		delta := currentPct - baselinePct
+		entry := DriftEntry{
+			Payload:     name,
+			BaselinePct: baselinePct,
+			CurrentPct:  currentPct,
+			Delta:       delta,
+			Drifted:     math.Abs(delta) > threshold,
+		}
+		if entry.Drifted {
+			report.DriftedCount++
+		}
+		report.Entries = append(report.Entries, entry)
+	}
+
+	sort.Slice(report.Entries, func(i, j int) bool {
+		di, dj := math.Abs(report.Entries[i].Delta), math.Abs(report.Entries[j].Delta)
+		if di != dj {
+			return di > dj
+		}
+		return report.Entries[i].Payload < report.Entries[j].Payload
+	})
+
+	return report
+}
+
+// compareTruth reports the users whose payload in results differs from the
+// variant expected for them in truthFile.
+// proveAllocations works through the assignment of up to n users the server
+// bucketed, picked as the sample table picks its users, recomputing each hash
+// and bucket with pkg/allocation.
+func proveAllocations(results TestResults, n int, representative bool) []ReproducibilityProof {
+	var bucketed []UserAllocation
+	for _, alloc := range results.UserAllocations {
+		if _, ok := results.Buckets[alloc.UserID]; ok {
+			bucketed = append(bucketed, alloc)
+		}
+	}
+	var proofs []ReproducibilityProof
+	for _, alloc := range sampleAllocations(bucketed, n, representative) {
+		info := results.Buckets[alloc.UserID]
+		hash, err := allocation.BucketHash(info.Algorithm, alloc.UserID)
+		recomputed := err == nil && hash == info.Hash
+		if info.NudgedFrom >= 0 {
+			// Adaptive balancing only ever moves a user one bucket over
+			recomputed = recomputed && allocation.ReduceHash(info.Algorithm, hash, info.Buckets) == info.NudgedFrom &&
+				(info.Bucket == (info.NudgedFrom+1)%info.Buckets || info.NudgedFrom == (info.Bucket+1)%info.Buckets)
+		} else {
+			recomputed = recomputed && allocation.ReduceHash(info.Algorithm, hash, info.Buckets) == info.Bucket
+		}
+		proofs = append(proofs, ReproducibilityProof{
+			UserID:     alloc.UserID,
+			Variant:    alloc.PayloadName,
+			Requests:   alloc.RequestCount,
+			BucketInfo: info,
+			Recomputed: recomputed,
+		})
+	}
+	return proofs
+}
+
+// checkAA runs the A/A test on the users the server bucketed: it checks that
+// every variant they were served has the same content, then tests their split
+// across buckets for uniformity. Counting buckets rather than variant names
+// keeps a broken report from hiding a broken split, and the reverse.
+func checkAA(results TestResults, alpha float64) *AATest {
+	a := &AATest{Alpha: alpha}
+	var counts []int
+	variants := make(map[string]bool)
+	contents := make(map[string]bool)
+	for _, alloc := range results.UserAllocations {
+		info, ok := results.Buckets[alloc.UserID]
+		if !ok || info.Bucket < 0 || info.Bucket >= info.Buckets {
+			continue
+		}
+		if counts == nil {
+			counts = make([]int, info.Buckets)
+		}
+		// A bucket count that changed mid-run can't be tested as one split
+		if len(counts) != info.Buckets {
+			continue
+		}
+		counts[info.Bucket]++
+		variants[alloc.PayloadName] = true
+		contents[results.PayloadDigests[alloc.PayloadName]] = true
+	}
+	a.Variants, a.Contents = len(variants), len(contents)
+	if counts != nil {
+		a.Uniformity = allocation.MeasureUniformity(counts)
+	}
+	return a
+}
+
+func compareTruth(truthFile string, userIDs []string, expected map[string]string, results TestResults) *TruthReport {
+	report := &TruthReport{TruthFile: truthFile}
+	actual := make(map[string]string, len(results.UserAllocations))
+	for _, alloc := range results.UserAllocations {
+		actual[alloc.UserID] = alloc.PayloadName
+	}
+	for _, userID := range userIDs {
+		payload, ok := actual[userID]
+		if !ok {
+			report.Missing++
+			continue
+		}
+		report.Checked++
+		if payload != expected[userID] {
+			report.Mismatches = append(report.Mismatches, TruthMismatch{UserID: userID, Expected: expected[userID], Actual: payload})
+		}
+	}
+	sort.Slice(report.Mismatches, func(i, j int) bool { return report.Mismatches[i].UserID < report.Mismatches[j].UserID })
+	return report
+}
+
+// requestOrder returns the userIds in the order their requests are queued:
+// as given, or sorted for a -sequential run so it sends the same requests in
+// the same order every time.
+func requestOrder(userIDs []string, sequential bool) []string {
+	if !sequential {
+		return userIDs
+	}
+	return slices.Sorted(slices.Values(userIDs))
+}
+
+// orderingName describes how a run sent its requests, for reports.
+func orderingName(sequential bool) string {
+	if sequential {
+		return "sequential"
+	}
+	return "concurrent"
+}
+
+// compareAssignments reports the users whose payload in results differs from
+// their payload in the earlier run loaded from previousFile.
+func compareAssignments(previousFile string, previous TestResults, results TestResults) *TemporalReport {
+	report := &TemporalReport{
+		PreviousFile:       previousFile,
+		PreviousDate:       previous.TestDate,
+		Elapsed:            results.TestDate.Sub(previous.TestDate),
+		PreviousConfigHash: previous.ConfigHash,
+		ConfigMismatch:     previous.ConfigHash != "" && results.ConfigHash != "" && previous.ConfigHash != results.ConfigHash,
+		PreviousSequential: previous.Sequential,
+		OrderingDiffers:    previous.Sequential != results.Sequential,
+	}
+
+	current := make(map[string]string, len(results.UserAllocations))
+	for _, alloc := range results.UserAllocations {
+		current[alloc.UserID] = alloc.PayloadName
+	}
+	for _, alloc := range previous.UserAllocations {
+		payload, ok := current[alloc.UserID]
+		if !ok {
+			report.Missing++
+			continue
+		}
+		report.Checked++
+		if payload != alloc.PayloadName {
+			report.Changes = append(report.Changes, TemporalChange{UserID: alloc.UserID, Before: alloc.PayloadName, After: payload})
+		}
+	}
+	return report
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestPrimaryPayloadOf(t *testing.T) {
+	tests := []struct {
+		name     string
+		payloads map[string]int
+		want     string
+	}{
+		{name: "consistent", payloads: map[string]int{"b.json": 5}, want: "b.json"},
+		{name: "clear majority", payloads: map[string]int{"a.json": 1, "c.json": 4}, want: "c.json"},
+		{name: "1-1 tie", payloads: map[string]int{"c.json": 1, "b.json": 1}, want: "b.json"},
+		{name: "three-way tie", payloads: map[string]int{"c.json": 2, "a.json": 2, "b.json": 2}, want: "a.json"},
+		{name: "tie below the majority", payloads: map[string]int{"a.json": 1, "b.json": 1, "c.json": 3}, want: "c.json"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Map iteration order varies from call to call, so a tie broken
+			// by it would show up within a few runs
+			for run := 0; run < 100; run++ {
+				if got := primaryPayloadOf(tt.payloads); got != tt.want {
+					t.Fatalf("run %d: primaryPayloadOf(%v) = %s, want %s", run, tt.payloads, got, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestAnalyzeResultsDeterministic(t *testing.T) {
+	userPayloads := map[string]map[string]int{
+		"u1": {"a.json": 2},
+		"u2": {"b.json": 1, "c.json": 1},
+		"u3": {"c.json": 1, "a.json": 1},
+	}
+	want := analyzeResults(userPayloads, nil, 2, time.Second, 6, 6, 0)
+	if got := want.PayloadDistribution; !reflect.DeepEqual(got, map[string]int{"a.json": 2, "b.json": 1}) {
+		t.Fatalf("PayloadDistribution = %v, want a.json:2 b.json:1", got)
+	}
+	for run := 0; run < 100; run++ {
+		got := analyzeResults(userPayloads, nil, 2, time.Second, 6, 6, 0)
+		if !reflect.DeepEqual(got.PayloadDistribution, want.PayloadDistribution) {
+			t.Fatalf("run %d: PayloadDistribution = %v, want %v", run, got.PayloadDistribution, want.PayloadDistribution)
+		}
+		details := map[string]bool{}
+		for _, d := range got.InconsistentDetails {
+			details[d] = true
+		}
+		for _, d := range want.InconsistentDetails {
+			if !details[d] {
+				t.Fatalf("run %d: InconsistentDetails = %v, want %v", run, got.InconsistentDetails, want.InconsistentDetails)
+			}
+		}
+	}
+}
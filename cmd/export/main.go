@@ -0,0 +1,204 @@
+// Command export streams the payload every user is assigned, for auditing a
+// whole user base offline. It reads userIds from stdin, one per line, and
+// writes one JSON line per user to stdout, applying the same gates as the
+// server through pkg/allocation. Memory use doesn't grow with the input, so it
+// handles populations of tens of millions:
+//
+//	go run ./cmd/export -exposure 20 -control-payload small_payload.json < users.txt > assignments.jsonl
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"go-localization-large-backend/pkg/allocation"
+	"go-localization-large-backend/pkg/store"
+)
+
+// maxUserIDLength bounds a single input line, so a malformed file can't make
+// the scanner buffer grow without limit.
+const maxUserIDLength = 64 * 1024
+
+// experimentID is the server's experiment, which -prerequisites are for.
+const experimentID = "exp-localization-v1"
+
+// progressInterval is how often the progress line on stderr is updated.
+const progressInterval = 2 * time.Second
+
+// Assignment is one output line: the payload the server serves a user. Bucket
+// is -1 when a gate applied, as in the audit log.
+type Assignment struct {
+	UserID  string `json:"userId"`
+	Variant string `json:"variant"`
+	Bucket  int    `json:"bucket"`
+}
+
+func main() {
+	dir := flag.String("dir", "payloads", "Payloads directory the server loads")
+	generatePayloads := flag.String("generate-payloads", "", "Server -generate-payloads: <sizeKB>,<count> (replaces -dir)")
+	generateSeed := flag.Int64("generate-seed", 1, "Server -generate-seed")
+	appVersion := flag.String("app-version", "", "Client app version every user's request sends (empty = not sent)")
+	exposure := flag.Float64("exposure", 100, "Server -exposure: percentage of users bucketed into the experiment")
+	controlPayload := flag.String("control-payload", "", "Server -control-payload: payload served to users outside -exposure")
+	appVersions := flag.String("app-version-range", "", "Server -app-version-range: semver range of app versions that can render the payloads")
+	fallbackPayload := flag.String("fallback-payload", "", "Server -fallback-payload: payload served to clients outside -app-version-range")
+	hashAlgorithm := flag.String("hash-algorithm", allocation.DefaultHashAlgorithm, "Server -hash-algorithm: hash that buckets users")
+	prerequisitesFile := flag.String("prerequisites", "", "Server -prerequisites: JSON file limiting the experiment to users in a variant of another experiment")
+	payloadTypes := flag.String("payload-types", "", "Server -payload-types: non-JSON extensions loaded as payloads, e.g. .html,.bin")
+	rollbackSpec := flag.String("rollbacks", "", "Server rollbacks (-rollbacks or GET /admin/rollbacks): <disabled>=<fallback>,...")
+	progress := flag.Bool("progress", true, "Report progress on stderr")
+	flag.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: export [flags] < userids.txt > assignments.jsonl")
+		fmt.Fprintln(os.Stderr)
+		fmt.Fprintln(os.Stderr, "Pass the same gating flags the server runs with.")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	// stdout carries the export, so every message goes to stderr, and the
+	// store's per-file load logging is dropped
+	log.SetOutput(io.Discard)
+	fail := func(code int, format string, args ...interface{}) {
+		fmt.Fprintf(os.Stderr, "❌ "+format+"\n", args...)
+		os.Exit(code)
+	}
+
+	payloads := store.NewPayloadStore(*dir, store.Limits{})
+	types, err := store.ParseContentTypes(*payloadTypes)
+	if err != nil {
+		fail(2, "Invalid -payload-types: %v", err)
+	}
+	payloads.SetContentTypes(types)
+	if *generatePayloads != "" {
+		spec, err := store.ParseGenerateSpec(*generatePayloads)
+		if err != nil {
+			fail(2, "Invalid -generate-payloads: %v", err)
+		}
+		spec.Seed = *generateSeed
+		if err := payloads.LoadGenerated(spec); err != nil {
+			fail(1, "Failed to generate payloads: %v", err)
+		}
+	} else if err := payloads.Load(); err != nil {
+		fail(1, "Failed to load payloads: %v", err)
+	}
+
+	if *exposure < 0 || *exposure > 100 {
+		fail(2, "-exposure must be between 0 and 100, got %g", *exposure)
+	}
+	mapper, err := allocation.MapperFor(*hashAlgorithm)
+	if err != nil {
+		fail(2, "Invalid -hash-algorithm: %v", err)
+	}
+	rules := allocation.Rules{ExposurePercent: *exposure, Mapper: mapper}
+	if *exposure < 100 {
+		if _, ok := payloads.Lookup(*controlPayload); !ok {
+			fail(2, "-exposure below 100 needs -control-payload naming a loaded payload, got %q", *controlPayload)
+		}
+	}
+	if *appVersions != "" {
+		r, err := allocation.ParseVersionRange(*appVersions)
+		if err != nil {
+			fail(2, "Invalid -app-version-range: %v", err)
+		}
+		if _, ok := payloads.Lookup(*fallbackPayload); !ok {
+			fail(2, "-app-version-range needs -fallback-payload naming a loaded payload, got %q", *fallbackPayload)
+		}
+		rules.AppVersions = &r
+	}
+	if *prerequisitesFile != "" {
+		data, err := os.ReadFile(*prerequisitesFile)
+		if err != nil {
+			fail(1, "Failed to read -prerequisites: %v", err)
+		}
+		if rules.Prerequisites, err = allocation.ParsePrerequisites(data, experimentID); err != nil {
+			fail(2, "Invalid -prerequisites %s: %v", *prerequisitesFile, err)
+		}
+		if _, ok := payloads.Lookup(*controlPayload); !ok {
+			fail(2, "-prerequisites needs -control-payload naming a loaded payload, got %q", *controlPayload)
+		}
+	}
+	rollbacks, err := allocation.ParseRollbacks(*rollbackSpec)
+	if err != nil {
+		fail(2, "Invalid -rollbacks: %v", err)
+	}
+
+	var exported atomic.Int64
+	start := time.Now()
+	stopProgress, progressDone := make(chan struct{}), make(chan struct{})
+	if *progress {
+		go reportProgress(&exported, start, stopProgress, progressDone)
+	} else {
+		close(progressDone)
+	}
+
+	names := make([]string, 0, len(payloads.Payloads()))
+	for _, p := range payloads.Payloads() {
+		names = append(names, p.Name)
+	}
+	assign := func(userID string) Assignment {
+		d := rules.Decide(userID, *appVersion, len(names))
+		switch {
+		case !d.VersionSupported:
+			return Assignment{UserID: userID, Variant: *fallbackPayload, Bucket: -1}
+		case !d.Eligible, !d.Exposed:
+			return Assignment{UserID: userID, Variant: *controlPayload, Bucket: -1}
+		}
+		served, _ := rollbacks.Serve(names[d.Bucket])
+		return Assignment{UserID: userID, Variant: served, Bucket: d.Bucket}
+	}
+
+	in := bufio.NewScanner(os.Stdin)
+	in.Buffer(make([]byte, 0, 4096), maxUserIDLength)
+	out := bufio.NewWriterSize(os.Stdout, 64*1024)
+	enc := json.NewEncoder(out)
+	enc.SetEscapeHTML(false)
+	for in.Scan() {
+		userID := strings.TrimSpace(in.Text())
+		if userID == "" {
+			continue
+		}
+		if err := enc.Encode(assign(userID)); err != nil {
+			fail(1, "Failed to write: %v", err)
+		}
+		exported.Add(1)
+	}
+	if err := in.Err(); err != nil {
+		fail(1, "Failed to read userIds after %d users: %v", exported.Load(), err)
+	}
+	if err := out.Flush(); err != nil {
+		fail(1, "Failed to write: %v", err)
+	}
+
+	close(stopProgress)
+	<-progressDone
+	if *progress {
+		elapsed := time.Since(start)
+		fmt.Fprintf(os.Stderr, "\r✅ Exported %d users in %s (%.0f users/s)\n",
+			exported.Load(), elapsed.Round(time.Millisecond), float64(exported.Load())/elapsed.Seconds())
+	}
+}
+
+// reportProgress rewrites one stderr line with the users exported so far and
+// the rate, every progressInterval until stop is closed, then closes done.
+func reportProgress(exported *atomic.Int64, start time.Time, stop, done chan struct{}) {
+	defer close(done)
+	ticker := time.NewTicker(progressInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			n := exported.Load()
+			fmt.Fprintf(os.Stderr, "\r⏳ Exported %d users (%.0f users/s)", n, float64(n)/time.Since(start).Seconds())
+		}
+	}
+}
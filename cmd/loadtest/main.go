@@ -1,17 +1,30 @@
 package main
 
 import (
+	"bufio"
 	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"io"
+	"math"
 	"math/rand"
+	"net"
 	"net/http"
+	"os"
 	"sort"
+	"strconv"
+	"strings"
 	"sync"
 	"sync/atomic"
+	"syscall"
 	"time"
+
+	"go-localization-large-backend/pkg/health"
 )
 
 type TestConfig struct {
@@ -21,18 +34,377 @@ type TestConfig struct {
 	RequestsPerClient int
 	SlowDownloadSpeed int // bytes per second for slow clients
 	TestDuration      time.Duration
-	ConnectionHogTest bool // Special mode to demonstrate connection hogging
+	ConnectionHogTest bool       // Special mode to demonstrate connection hogging
+	AuthToken         string     // Bearer token sent on /experiment requests (optional)
+	ThinkTime         *ThinkTime // Pause between requests per client (nil = fixed defaults)
+	ReplayRecords     []ReplayRecord
+	ReplaySpeed       float64 // Timeline multiplier for replay (2 = twice as fast)
+	Seed              int64   // Base seed for SlowReader jitter/stalls (0 = random per request)
+	Protocol          string  // "h1" or "h2c" (cleartext HTTP/2)
+	FastPool          PoolConfig
+	SlowPool          PoolConfig
+	Profiles          []ProfileShare // Client behavior mix of the fast clients (nil = all plain fast clients)
+	VerifyPayload     bool           // Slow clients check each payload against X-Payload-SHA256
+
+	// Built from the pool settings once the client counts are final
+	fastTransport *http.Transport
+	slowTransport *http.Transport
+}
+
+// PoolConfig sizes the connection pool shared by one class of clients. Fast
+// and slow clients get separate pools, so slow downloads never tie up the
+// connections fast clients reuse.
+type PoolConfig struct {
+	MaxIdleConnsPerHost int // idle connections kept for reuse (0 = one per client)
+	MaxConnsPerHost     int // connections open at once, busy or idle (0 = unlimited)
+}
+
+// newTransport builds the transport for a class of clients. With h2c it speaks
+// cleartext HTTP/2 with prior knowledge, multiplexing the class's requests as
+// streams over its pooled connections.
+func newTransport(protocol string, pool PoolConfig, clients int) *http.Transport {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.MaxIdleConnsPerHost = pool.MaxIdleConnsPerHost
+	if transport.MaxIdleConnsPerHost == 0 {
+		transport.MaxIdleConnsPerHost = max(clients, 1)
+	}
+	transport.MaxIdleConns = 0 // no cap across hosts; MaxIdleConnsPerHost applies
+	transport.MaxConnsPerHost = pool.MaxConnsPerHost
+	if protocol == "h2c" {
+		var protocols http.Protocols
+		protocols.SetUnencryptedHTTP2(true)
+		transport.Protocols = &protocols
+	}
+	return transport
+}
+
+// poolWarnings describes how a class's pool would throttle its clients, so
+// client-side queueing isn't mistaken for server-side hogging. Over HTTP/1.1
+// each in-flight request needs its own connection: a MaxConnsPerHost below the
+// client count queues requests inside the client, and a MaxIdleConnsPerHost
+// below it closes connections after use, so requests pay for new ones. HTTP/2
+// multiplexes requests over a connection, so neither applies to h2c.
+func poolWarnings(class, protocol string, pool PoolConfig, clients int) []string {
+	if protocol == "h2c" || clients == 0 {
+		return nil
+	}
+	var warnings []string
+	if pool.MaxConnsPerHost > 0 && pool.MaxConnsPerHost < clients {
+		warnings = append(warnings, fmt.Sprintf("%d %s clients share %d connections (-%s-max-conns): at most %d requests run at once and the rest queue in the client",
+			clients, class, pool.MaxConnsPerHost, class, pool.MaxConnsPerHost))
+	}
+	if pool.MaxIdleConnsPerHost > 0 && pool.MaxIdleConnsPerHost < clients {
+		warnings = append(warnings, fmt.Sprintf("%d %s clients keep only %d idle connections (-%s-idle-conns): the rest reconnect for each request",
+			clients, class, pool.MaxIdleConnsPerHost, class))
+	}
+	return warnings
+}
+
+// httpClient returns a client with the given timeout over transport.
+func httpClient(transport *http.Transport, timeout time.Duration) *http.Client {
+	return &http.Client{Transport: transport, Timeout: timeout}
+}
+
+// ReplayRecord is one recorded production request: when it arrived and for
+// which user. Replay files are JSON Lines of these records.
+type ReplayRecord struct {
+	Timestamp time.Time `json:"timestamp"`
+	UserID    string    `json:"userId"`
+}
+
+// loadReplayFile reads JSON Lines replay records, sorted by timestamp.
+func loadReplayFile(path string) ([]ReplayRecord, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var records []ReplayRecord
+	scanner := bufio.NewScanner(f)
+	lineNum := 0
+	for scanner.Scan() {
+		lineNum++
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		var record ReplayRecord
+		if err := json.Unmarshal(line, &record); err != nil {
+			return nil, fmt.Errorf("line %d: %w", lineNum, err)
+		}
+		if record.UserID == "" || record.Timestamp.IsZero() {
+			return nil, fmt.Errorf("line %d: timestamp and userId are required", lineNum)
+		}
+		records = append(records, record)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(records) == 0 {
+		return nil, fmt.Errorf("%s contains no records", path)
+	}
+
+	sort.SliceStable(records, func(i, j int) bool {
+		return records[i].Timestamp.Before(records[j].Timestamp)
+	})
+	return records, nil
+}
+
+// ThinkTime models the pause a client takes between requests. Exponential
+// think time gives Poisson-like arrivals, which produce more realistic queueing
+// at the server than fixed sleeps.
+type ThinkTime struct {
+	Distribution string        // "constant", "uniform" or "exponential"
+	Min          time.Duration // constant value, or uniform lower bound
+	Max          time.Duration // uniform upper bound
+	Mean         time.Duration // exponential mean
+}
+
+// noThinkTime makes clients send requests back-to-back, to find the server's
+// throughput ceiling rather than a realistic load.
+var noThinkTime = &ThinkTime{Distribution: "constant"}
+
+// parseThinkTime parses a think-time spec of the form "constant:50ms",
+// "uniform:20ms-200ms" or "exponential:100ms" (mean). "0" disables think time.
+func parseThinkTime(spec string) (*ThinkTime, error) {
+	if spec == "0" {
+		return noThinkTime, nil
+	}
+	kind, params, ok := strings.Cut(spec, ":")
+	if !ok {
+		return nil, fmt.Errorf("invalid think time %q: expected <distribution>:<params>", spec)
+	}
+
+	switch kind {
+	case "constant":
+		d, err := time.ParseDuration(params)
+		if err != nil || d < 0 {
+			return nil, fmt.Errorf("invalid constant think time %q", params)
+		}
+		return &ThinkTime{Distribution: kind, Min: d}, nil
+	case "uniform":
+		lo, hi, ok := strings.Cut(params, "-")
+		if !ok {
+			return nil, fmt.Errorf("invalid uniform think time %q: expected <min>-<max>", params)
+		}
+		minD, err1 := time.ParseDuration(lo)
+		maxD, err2 := time.ParseDuration(hi)
+		if err1 != nil || err2 != nil || minD < 0 || maxD < minD {
+			return nil, fmt.Errorf("invalid uniform think time %q", params)
+		}
+		return &ThinkTime{Distribution: kind, Min: minD, Max: maxD}, nil
+	case "exponential":
+		mean, err := time.ParseDuration(params)
+		if err != nil || mean <= 0 {
+			return nil, fmt.Errorf("invalid exponential think time mean %q", params)
+		}
+		return &ThinkTime{Distribution: kind, Mean: mean}, nil
+	default:
+		return nil, fmt.Errorf("unknown think time distribution %q (want constant, uniform or exponential)", kind)
+	}
+}
+
+// Sample draws the next pause from the distribution.
+func (t *ThinkTime) Sample() time.Duration {
+	switch t.Distribution {
+	case "uniform":
+		return t.Min + time.Duration(rand.Int63n(int64(t.Max-t.Min)+1))
+	case "exponential":
+		return time.Duration(rand.ExpFloat64() * float64(t.Mean))
+	default:
+		return t.Min
+	}
+}
+
+func (t *ThinkTime) String() string {
+	switch t.Distribution {
+	case "uniform":
+		return fmt.Sprintf("uniform %s-%s", t.Min, t.Max)
+	case "exponential":
+		return fmt.Sprintf("exponential (mean %s)", t.Mean)
+	default:
+		if t.Min == 0 {
+			return "none (back-to-back requests)"
+		}
+		return fmt.Sprintf("constant %s", t.Min)
+	}
+}
+
+// thinkTime returns the pause before a client's next request, falling back to
+// the client class's fixed default when no distribution is configured.
+func (c TestConfig) thinkTime(fixed time.Duration) time.Duration {
+	if c.ThinkTime == nil {
+		return fixed
+	}
+	return c.ThinkTime.Sample()
+}
+
+// Bounds for errorBackoff's pause.
+const (
+	minErrorBackoff = time.Millisecond
+	maxErrorBackoff = 100 * time.Millisecond
+)
+
+// errorBackoff paces a client that has no think time while its requests keep
+// failing. A server that refuses connections fails requests in microseconds,
+// and retrying at that rate would busy-spin the load generator and flood the
+// failure counts. The pause doubles with each consecutive failure and resets
+// after a success.
+type errorBackoff struct {
+	current time.Duration
+}
+
+// pause returns how long to wait before the next request given the think time
+// already chosen and whether the last request succeeded.
+func (b *errorBackoff) pause(thinkTime time.Duration, ok bool) time.Duration {
+	if ok {
+		b.current = 0
+		return thinkTime
+	}
+	if b.current == 0 {
+		b.current = minErrorBackoff
+	} else {
+		b.current = min(2*b.current, maxErrorBackoff)
+	}
+	return max(thinkTime, b.current)
 }
 
 type Stats struct {
 	totalRequests   atomic.Int64
 	successRequests atomic.Int64
 	failedRequests  atomic.Int64
+	timedOut        atomic.Int64 // failures where the client gave up waiting, also in failedRequests
+	failuresMutex   sync.Mutex
+	failureCauses   map[string]int64 // e.g. "HTTP 500", "connection error"
 	fastRequests    atomic.Int64
 	slowRequests    atomic.Int64
+	inFlight        atomic.Int64 // requests sent but not yet fully read
 	latenciesMutex  sync.Mutex
 	fastLatencies   []int64 // fast client latencies in milliseconds
 	slowLatencies   []int64 // slow client latencies in milliseconds
+
+	// Latency breakdown in microseconds: the server-reported handler time
+	// (X-Processing-Time) and the rest of the round trip (network + transfer)
+	fastServerTimes  []int64
+	fastNetworkTimes []int64
+	slowServerTimes  []int64
+	slowNetworkTimes []int64
+
+	// Server-reported time to the allocation decision (X-Decision-Time), in
+	// microseconds
+	fastDecisionTimes []int64
+	slowDecisionTimes []int64
+
+	// Successful latencies in milliseconds, grouped by the windowSize-long
+	// window of the run in which each request completed
+	windowSize      time.Duration
+	windowStart     time.Time
+	windowLatencies [][]int64
+
+	// Slow client transfer accounting: body bytes read across all slow
+	// requests, and for transfers cut off mid-body, how much of the expected
+	// body arrived
+	slowBytesReceived    atomic.Int64
+	partialTransfers     atomic.Int64
+	partialBytesReceived atomic.Int64
+	partialBytesExpected atomic.Int64
+
+	// With -verify-payload, slow client payloads that matched their
+	// X-Payload-SHA256, and those from responses without the header (an older
+	// server) that couldn't be checked
+	verifiedPayloads   atomic.Int64
+	unverifiedPayloads atomic.Int64
+
+	// Per-profile results with -profile-mix, keyed by profile name; the map
+	// is built before the run and not modified during it
+	profiles map[string]*ProfileStats
+}
+
+// recordWindowLatency adds a completed request's latency to its time window.
+// The caller must hold latenciesMutex.
+func (s *Stats) recordWindowLatency(completed time.Time, latency time.Duration) {
+	if s.windowSize <= 0 {
+		return
+	}
+	index := int(completed.Sub(s.windowStart) / s.windowSize)
+	if index < 0 {
+		index = 0
+	}
+	for len(s.windowLatencies) <= index {
+		s.windowLatencies = append(s.windowLatencies, nil)
+	}
+	s.windowLatencies[index] = append(s.windowLatencies[index], latency.Milliseconds())
+}
+
+// recordFailure counts a failed request under its cause.
+func (s *Stats) recordFailure(cause string) {
+	s.failedRequests.Add(1)
+	if cause == causeTimeout {
+		s.timedOut.Add(1)
+	}
+	s.failuresMutex.Lock()
+	if s.failureCauses == nil {
+		s.failureCauses = make(map[string]int64)
+	}
+	s.failureCauses[cause]++
+	s.failuresMutex.Unlock()
+}
+
+// Failure causes that separate how a request failed: before any response, by
+// the client timing out, or partway through the body. A client timeout means
+// the server didn't answer in time, not that it answered with an error
+const (
+	causeConnectionRefused = "connection refused"
+	causeConnectionError   = "connection error"
+	causeTimeout           = "client timeout"
+	causePartialTransfer   = "partial transfer"
+	causeReadError         = "read error"
+)
+
+// Failure causes for a body that arrived in full, as far as HTTP could tell,
+// but failed -verify-payload: too short to parse, or parsed to a payload that
+// doesn't hash to X-Payload-SHA256
+const (
+	causeTruncatedPayload = "truncated payload"
+	causeCorruptedPayload = "corrupted payload"
+)
+
+// isClientTimeout reports whether err is the client giving up: its
+// http.Client timeout or a context deadline expiring, rather than the server
+// refusing, closing or erroring.
+func isClientTimeout(err error) bool {
+	var netErr net.Error
+	return errors.Is(err, context.DeadlineExceeded) || errors.As(err, &netErr) && netErr.Timeout()
+}
+
+// classifyRequestError names the cause of an error from sending a request.
+func classifyRequestError(err error) string {
+	switch {
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return causeConnectionRefused
+	case isClientTimeout(err):
+		return causeTimeout
+	default:
+		return causeConnectionError
+	}
+}
+
+// classifyReadError names the cause of an error while reading a response body
+// after received bytes. A body cut short of its Content-Length (or ended early
+// when the length is unknown) is a partial transfer, as when the server closes
+// a slow connection on a write timeout; a client-side timeout is reported as
+// such even if some bytes arrived.
+func classifyReadError(err error, received, expected int64) string {
+	switch {
+	case isClientTimeout(err):
+		return causeTimeout
+	case expected > 0 && received < expected,
+		expected < 0 && errors.Is(err, io.ErrUnexpectedEOF):
+		return causePartialTransfer
+	default:
+		return causeReadError
+	}
 }
 
 // SlowReader wraps an io.Reader to simulate slow network download speeds with random delays
@@ -43,13 +415,26 @@ type SlowReader struct {
 	rng         *rand.Rand
 }
 
-func NewSlowReader(reader io.Reader, bytesPerSec int) *SlowReader {
+// NewSlowReader creates a SlowReader whose jitter and stalls come from seed, so
+// the same seed always produces the same delay sequence.
+func NewSlowReader(reader io.Reader, bytesPerSec int, seed int64) *SlowReader {
 	return &SlowReader{
 		reader:      reader,
 		bytesPerSec: bytesPerSec,
 		lastRead:    time.Now(),
-		rng:         rand.New(rand.NewSource(time.Now().UnixNano())),
+		rng:         rand.New(rand.NewSource(seed)),
+	}
+}
+
+// slowReadSeed returns the SlowReader seed for a slow client's request. With a
+// base seed, it is base + clientID*RequestsPerClient + request, so the Nth
+// request of each slow client sees the same stall pattern in every run.
+// Without one, each request gets a random seed.
+func (c TestConfig) slowReadSeed(clientID, request int) int64 {
+	if c.Seed == 0 {
+		return time.Now().UnixNano()
 	}
+	return c.Seed + int64(clientID*c.RequestsPerClient+request)
 }
 
 func (sr *SlowReader) Read(p []byte) (n int, err error) {
@@ -94,7 +479,7 @@ func (sr *SlowReader) Read(p []byte) (n int, err error) {
 
 func main() {
 	// Command line flags
-	serverURL := flag.String("url", "http://localhost:3000", "Server URL")
+	serverURL := flag.String("url", "http://localhost:3000", "Server URL, including the server's -base-path if it has one, e.g. http://localhost:3000/loc/v1")
 	fastClients := flag.Int("fast", 10, "Number of fast clients")
 	slowClients := flag.Int("slow", 5, "Number of slow clients")
 	requests := flag.Int("requests", 100, "Requests per client")
@@ -102,7 +487,115 @@ func main() {
 	duration := flag.Duration("duration", 30*time.Second, "Test duration")
 	hogTest := flag.Bool("hog-test", false, "Run connection hogging test (many slow clients, measure fast client impact)")
 	mode := flag.String("mode", "normal", "Test mode: 'normal' (all fast) or 'saturation' (mix of slow/fast)")
+	authToken := flag.String("auth-token", "", "Bearer token to send if the server requires auth")
+	thinkTimeSpec := flag.String("think-time", "", "Pause between requests: constant:50ms, uniform:20ms-200ms, exponential:100ms or 0 for none (default: fixed 50ms fast / 100ms slow)")
+	noThink := flag.Bool("no-think-time", false, "Send each client's requests back-to-back to measure the server's maximum throughput (same as -think-time 0)")
+	replayFile := flag.String("replay-file", "", "Replay recorded requests from a JSON Lines file of {timestamp, userId} records")
+	replaySpeed := flag.Float64("replay-speed", 1.0, "Replay timeline multiplier (2 = twice as fast, 0.5 = half speed)")
+	totalClients := flag.Int("total-clients", 0, "Total number of clients; with -slow-percent, overrides -fast/-slow")
+	slowPercent := flag.Float64("slow-percent", 0, "Percentage of -total-clients that are slow (0-100)")
+	seed := flag.Int64("seed", 0, "Base seed for slow-client network jitter and stalls, for reproducible runs (0 = random)")
+	tui := flag.Bool("tui", false, "Show a live dashboard with rolling charts (falls back to the plain monitor when stdout is not a terminal)")
+	window := flag.Duration("window", 5*time.Second, "Window size for the latency-over-time table (0 disables it)")
+	protocol := flag.String("protocol", "h1", "Protocol for requests: 'h1' (HTTP/1.1) or 'h2c' (cleartext HTTP/2; the server needs -protocol h2c)")
+	fastIdleConns := flag.Int("fast-idle-conns", 0, "Idle connections the fast clients' pool keeps for reuse (0 = one per fast client)")
+	fastMaxConns := flag.Int("fast-max-conns", 0, "Connections the fast clients' pool may open at once (0 = unlimited)")
+	slowIdleConns := flag.Int("slow-idle-conns", 0, "Idle connections the slow clients' pool keeps for reuse (0 = one per slow client)")
+	slowMaxConns := flag.Int("slow-max-conns", 0, "Connections the slow clients' pool may open at once (0 = unlimited)")
+	sloP99 := flag.Duration("slo-p99", 0, "Fail (exit 1) when fast-client p99 latency exceeds this, e.g. 200ms (0 = no latency SLO)")
+	profileMix := flag.String("profile-mix", "", "Split fast clients between behavior profiles by weight, e.g. normal=70,bursty=10,abandoner=10,retrier=10 (profiles: "+strings.Join(profileNames(), ", ")+")")
+	flag.StringVar(&percentileMethod, "percentile-method", percentileNearest, "How percentiles are computed: 'nearest' (nearest-rank) or 'linear' (interpolated, like numpy)")
+	verifyPayload := flag.Bool("verify-payload", false, "Slow clients hash each received payload and check it against the server's X-Payload-SHA256, reporting truncated and corrupted payloads as failures")
+	sloSuccessRate := flag.Float64("slo-success-rate", 0, "Fail (exit 1) when the success rate, in percent, falls below this, e.g. 99.5 (0 = no success rate SLO)")
+	availabilityTarget := flag.Float64("availability-target", 0, "Target availability in percent, e.g. 99.9: report how much of its error budget the run's failures consume (default: -slo-success-rate, 0 = no report)")
+	healthAttempts := flag.Int("health-attempts", health.DefaultAttempts, "Times to try the server's /health before giving up, to wait out a server that is still starting (1 = no retries)")
+	healthInterval := flag.Duration("health-interval", health.DefaultInterval, "Pause between -health-attempts")
 	flag.Parse()
+	// Paths are appended to the URL, so a base path may end in a slash
+	*serverURL = strings.TrimRight(*serverURL, "/")
+
+	// Every flag is checked before the server is contacted, and a bad one
+	// exits 2 like a flag parse error, so a misconfigured CI run never passes
+	if *protocol != "h1" && *protocol != "h2c" {
+		fmt.Printf("❌ -protocol must be 'h1' or 'h2c', got %q\n", *protocol)
+		os.Exit(2)
+	}
+	if percentileMethod != percentileNearest && percentileMethod != percentileLinear {
+		fmt.Printf("❌ -percentile-method must be 'nearest' or 'linear', got %q\n", percentileMethod)
+		os.Exit(2)
+	}
+	if *window < 0 {
+		fmt.Println("❌ -window must not be negative")
+		os.Exit(2)
+	}
+	if *healthAttempts < 1 || *healthInterval < 0 {
+		fmt.Println("❌ -health-attempts must be at least 1 and -health-interval can't be negative")
+		os.Exit(2)
+	}
+
+	var replayRecords []ReplayRecord
+	if *replayFile != "" {
+		if *replaySpeed <= 0 {
+			fmt.Println("❌ -replay-speed must be positive")
+			os.Exit(2)
+		}
+		var err error
+		replayRecords, err = loadReplayFile(*replayFile)
+		if err != nil {
+			fmt.Printf("❌ Failed to load replay file: %v\n", err)
+			os.Exit(2)
+		}
+	}
+
+	var profiles []ProfileShare
+	if *profileMix != "" {
+		if len(replayRecords) > 0 {
+			fmt.Println("❌ -profile-mix can't be used with -replay-file, which has no clients")
+			os.Exit(2)
+		}
+		var err error
+		profiles, err = parseProfileMix(*profileMix)
+		if err != nil {
+			fmt.Printf("❌ %v\n", err)
+			os.Exit(2)
+		}
+	}
+
+	var thinkTime *ThinkTime
+	if *noThink {
+		if *thinkTimeSpec != "" && *thinkTimeSpec != "0" {
+			fmt.Println("❌ -no-think-time and -think-time cannot be used together")
+			os.Exit(2)
+		}
+		thinkTime = noThinkTime
+	} else if *thinkTimeSpec != "" {
+		var err error
+		thinkTime, err = parseThinkTime(*thinkTimeSpec)
+		if err != nil {
+			fmt.Printf("❌ %v\n", err)
+			os.Exit(2)
+		}
+	}
+
+	setFlags := make(map[string]bool)
+	flag.Visit(func(f *flag.Flag) { setFlags[f.Name] = true })
+	if setFlags["total-clients"] != setFlags["slow-percent"] {
+		fmt.Println("❌ -total-clients and -slow-percent must be used together")
+		os.Exit(2)
+	}
+	usePercent := setFlags["total-clients"]
+	var percentFast, percentSlow int
+	if usePercent {
+		var err error
+		percentFast, percentSlow, err = splitClients(*totalClients, *slowPercent)
+		if err != nil {
+			fmt.Printf("❌ %v\n", err)
+			os.Exit(2)
+		}
+		if setFlags["fast"] || setFlags["slow"] {
+			fmt.Println("ℹ️  -total-clients/-slow-percent take precedence over -fast/-slow")
+		}
+	}
 
 	// Apply mode presets
 	if *mode == "saturation" {
@@ -117,10 +610,48 @@ func main() {
 		SlowDownloadSpeed: *slowSpeed,
 		TestDuration:      *duration,
 		ConnectionHogTest: *hogTest,
+		AuthToken:         *authToken,
+		ThinkTime:         thinkTime,
+		ReplayRecords:     replayRecords,
+		ReplaySpeed:       *replaySpeed,
+		Seed:              *seed,
+		Protocol:          *protocol,
+		FastPool:          PoolConfig{MaxIdleConnsPerHost: *fastIdleConns, MaxConnsPerHost: *fastMaxConns},
+		SlowPool:          PoolConfig{MaxIdleConnsPerHost: *slowIdleConns, MaxConnsPerHost: *slowMaxConns},
+		Profiles:          profiles,
+		VerifyPayload:     *verifyPayload,
+	}
+	if *fastIdleConns < 0 || *fastMaxConns < 0 || *slowIdleConns < 0 || *slowMaxConns < 0 {
+		fmt.Println("❌ Connection pool sizes must not be negative")
+		os.Exit(2)
+	}
+	slo := SLO{P99: *sloP99, SuccessRate: *sloSuccessRate}
+	if slo.P99 < 0 || slo.SuccessRate < 0 || slo.SuccessRate > 100 {
+		fmt.Println("❌ -slo-p99 must not be negative and -slo-success-rate must be between 0 and 100")
+		os.Exit(2)
+	}
+	if *availabilityTarget == 0 && slo.SuccessRate < 100 {
+		*availabilityTarget = slo.SuccessRate
+	}
+	if *availabilityTarget < 0 || *availabilityTarget >= 100 {
+		fmt.Println("❌ -availability-target must be between 0 and 100, exclusive: 100% leaves no error budget")
+		os.Exit(2)
 	}
 
 	// Adjust settings for saturation/hogging test
-	if config.ConnectionHogTest {
+	if len(config.ReplayRecords) > 0 {
+		fmt.Println("🎞️  Running Replay Load Test")
+		fmt.Println("━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━")
+		span := config.ReplayRecords[len(config.ReplayRecords)-1].Timestamp.Sub(config.ReplayRecords[0].Timestamp)
+		fmt.Printf("Replaying %d recorded requests from %s\n", len(config.ReplayRecords), *replayFile)
+		fmt.Printf("Recorded span: %s, replayed in ~%s (speed %gx)\n",
+			span.Round(time.Millisecond), time.Duration(float64(span)/config.ReplaySpeed).Round(time.Millisecond), config.ReplaySpeed)
+		fmt.Println("Client counts and think time are ignored; the recording drives arrivals.")
+		fmt.Println()
+		config.ConnectionHogTest = false
+		config.FastClients = 0
+		config.SlowClients = 0
+	} else if config.ConnectionHogTest {
 		fmt.Println("🔥 Running Saturation/Hogging Test")
 		fmt.Println("━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━")
 		fmt.Println("This test demonstrates how slow clients can hog server connections")
@@ -146,63 +677,279 @@ func main() {
 		config.SlowClients = 0 // No slow clients in normal mode by default
 	}
 
+	// A population given as percentages wins over the mode presets
+	if usePercent && len(config.ReplayRecords) == 0 {
+		config.FastClients = percentFast
+		config.SlowClients = percentSlow
+	}
+	if config.Profiles != nil {
+		assignProfileClients(config.Profiles, config.FastClients)
+	}
+
 	fmt.Println("━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━")
 	fmt.Printf("Server URL: %s\n", config.ServerURL)
-	fmt.Printf("Fast Clients: %d\n", config.FastClients)
-	fmt.Printf("Slow Clients: %d (simulating %d bytes/sec network)\n", config.SlowClients, config.SlowDownloadSpeed)
-	fmt.Printf("Requests per Client: %d\n", config.RequestsPerClient)
+	if config.Protocol == "h2c" {
+		fmt.Printf("Protocol: h2c (cleartext HTTP/2)\n")
+	}
+	if len(config.ReplayRecords) == 0 {
+		if usePercent {
+			fmt.Printf("Total Clients: %d (%g%% slow)\n", *totalClients, *slowPercent)
+		}
+		fmt.Printf("Fast Clients: %d\n", config.FastClients)
+		if config.Profiles != nil {
+			mix := make([]string, len(config.Profiles))
+			for i, p := range config.Profiles {
+				mix[i] = fmt.Sprintf("%s %d", p.Name, p.Clients)
+			}
+			fmt.Printf("Client Profiles: %s\n", strings.Join(mix, ", "))
+		}
+		fmt.Printf("Slow Clients: %d (simulating %d bytes/sec network)\n", config.SlowClients, config.SlowDownloadSpeed)
+		fmt.Printf("Requests per Client: %d\n", config.RequestsPerClient)
+	}
 	fmt.Printf("Test Duration: %s\n", config.TestDuration)
+	if len(config.ReplayRecords) > 0 {
+		fmt.Printf("Mode: Replay (%d records, speed %gx)\n", len(config.ReplayRecords), config.ReplaySpeed)
+	} else if config.ThinkTime != nil {
+		fmt.Printf("Think Time: %s\n", config.ThinkTime)
+	} else {
+		fmt.Printf("Think Time: fixed (50ms fast / 100ms slow)\n")
+	}
 	if config.ConnectionHogTest {
 		fmt.Printf("Mode: Connection Hogging Test\n")
 	}
+	if config.Seed != 0 && config.SlowClients > 0 {
+		fmt.Printf("Slow Read Seed: %d\n", config.Seed)
+	}
+	fmt.Printf("Percentiles: %s\n", describePercentileMethod(percentileMethod))
+	if config.VerifyPayload {
+		fmt.Println("Payload Verification: slow clients check X-Payload-SHA256")
+	}
 	fmt.Println("━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━")
 	fmt.Println()
 
-	// Check server health before starting
-	if !checkHealth(config.ServerURL) {
-		fmt.Println("❌ Server health check failed. Is the server running?")
-		return
+	// Warn when a pool is too small for its clients: the queueing would happen
+	// in this tool, not the server. Replay has no fixed client count; its
+	// requests go through the fast pool, sized by the records so no connection
+	// is dropped for want of an idle slot.
+	warnings := append(poolWarnings("fast", config.Protocol, config.FastPool, config.FastClients),
+		poolWarnings("slow", config.Protocol, config.SlowPool, config.SlowClients)...)
+	for _, warning := range warnings {
+		fmt.Printf("⚠️  %s\n", warning)
+	}
+	if len(warnings) > 0 {
+		fmt.Println()
+	}
+	config.fastTransport = newTransport(config.Protocol, config.FastPool, config.FastClients+len(config.ReplayRecords))
+	config.slowTransport = newTransport(config.Protocol, config.SlowPool, config.SlowClients)
+
+	// Check server health before starting. A run that can't start must not
+	// pass a CI gate.
+	if !checkHealth(httpClient(config.fastTransport, 10*time.Second), config.ServerURL, *healthAttempts, *healthInterval) {
+		os.Exit(1)
 	}
 
 	stats := &Stats{
 		fastLatencies: make([]int64, 0, 10000),
 		slowLatencies: make([]int64, 0, 10000),
+		windowSize:    *window,
+		profiles:      newProfileStats(config.Profiles),
 	}
 
 	// Start monitoring
-	stopMonitor := make(chan bool)
-	go monitorProgress(stats, stopMonitor)
+	useTUI := *tui && isTerminal(os.Stdout)
+	if *tui && !useTUI {
+		fmt.Println("ℹ️  stdout is not a terminal, using the plain progress monitor")
+	}
+	var dashboard *Dashboard
+	var monitor *progressMonitor
+	if useTUI {
+		dashboard = startDashboard(stats, config.TestDuration)
+	} else {
+		monitor = startProgressMonitor(stats, 2*time.Second)
+	}
 
 	// Run the load test
 	startTime := time.Now()
-	runLoadTest(config, stats)
+	stats.windowStart = startTime
+	if len(config.ReplayRecords) > 0 {
+		runReplay(config, stats)
+	} else {
+		runLoadTest(config, stats)
+	}
 	endTime := time.Now()
 
 	// Stop monitoring
-	stopMonitor <- true
-	time.Sleep(100 * time.Millisecond)
+	if dashboard != nil {
+		dashboard.Stop()
+	} else {
+		monitor.Stop()
+	}
 
 	// Print results
-	printResults(stats, startTime, endTime, config)
+	summary := printResults(stats, startTime, endTime, config)
+	if *availabilityTarget > 0 {
+		printErrorBudget(*availabilityTarget, summary)
+	}
+	if slo.Enabled() && !slo.Check(summary) {
+		os.Exit(1)
+	}
+}
+
+// SLO is the service level a run must meet with -slo-p99 and
+// -slo-success-rate. A zero field is not checked.
+type SLO struct {
+	P99         time.Duration // maximum fast-client p99 latency
+	SuccessRate float64       // minimum success rate in percent
+}
+
+// Enabled reports whether any SLO is set.
+func (s SLO) Enabled() bool {
+	return s.P99 > 0 || s.SuccessRate > 0
+}
+
+// Check prints each SLO against the run's results, then a parseable
+// "SLO RESULT ... PASS|FAIL" line, and reports whether every SLO was met. A
+// run without successful requests fails the latency SLO, as it has no p99 to
+// meet it with.
+func (s SLO) Check(r ResultSummary) bool {
+	passed := true
+	fmt.Println()
+	fmt.Println("SLO Check:")
+	fields := ""
+	if s.P99 > 0 {
+		label := "Fast client p99"
+		if !r.FastClients {
+			label = "p99"
+		}
+		p99 := time.Duration(r.P99) * time.Millisecond
+		switch {
+		case r.Successful == 0:
+			fmt.Printf("  ❌ %s: no successful requests (SLO %s)\n", label, s.P99)
+			passed = false
+		case p99 > s.P99:
+			fmt.Printf("  ❌ %s: %s exceeds the SLO of %s\n", label, p99, s.P99)
+			passed = false
+		default:
+			fmt.Printf("  ✅ %s: %s within the SLO of %s\n", label, p99, s.P99)
+		}
+		fields += fmt.Sprintf(" p99_ms=%d slo_p99_ms=%d", r.P99, s.P99.Milliseconds())
+	}
+	if s.SuccessRate > 0 {
+		if r.SuccessRate < s.SuccessRate {
+			fmt.Printf("  ❌ Success rate: %.2f%% below the SLO of %g%%\n", r.SuccessRate, s.SuccessRate)
+			passed = false
+		} else {
+			fmt.Printf("  ✅ Success rate: %.2f%% meets the SLO of %g%%\n", r.SuccessRate, s.SuccessRate)
+		}
+		fields += fmt.Sprintf(" success_rate=%.2f slo_success_rate=%g", r.SuccessRate, s.SuccessRate)
+	}
+
+	verdict := "PASS"
+	if !passed {
+		verdict = "FAIL"
+	}
+	fmt.Printf("SLO RESULT%s %s\n", fields, verdict)
+	return passed
+}
+
+// errorBudgetPeriod is the SLO window the burn rate is projected over.
+const errorBudgetPeriod = 30 * 24 * time.Hour
+
+// printErrorBudget reports the run's failures against the error budget of a
+// target availability in percent: the share of requests allowed to fail, how
+// much of it the run used, and how fast a 30-day budget would burn at the
+// run's error rate. It ends with a parseable "ERROR BUDGET ...
+// WITHIN|EXCEEDED" line. It only reports; -slo-success-rate is the gate.
+func printErrorBudget(target float64, r ResultSummary) {
+	fmt.Println()
+	fmt.Printf("Error Budget (%g%% availability):\n", target)
+	if r.Total == 0 {
+		fmt.Println("  No requests were sent, so no budget was spent")
+		return
+	}
+	budget := (100 - target) / 100
+	errorRate := float64(r.Failed) / float64(r.Total)
+	// A burn rate of 1 spends exactly the budget over the SLO window
+	burnRate := errorRate / budget
+	fmt.Printf("  Budget:           %.3f%% of requests may fail (%.1f of %d this run)\n", budget*100, budget*float64(r.Total), r.Total)
+	fmt.Printf("  Observed Errors:  %.3f%% (%d failed)\n", errorRate*100, r.Failed)
+	fmt.Printf("  Budget Consumed:  %.1f%%\n", burnRate*100)
+	if burnRate > 0 {
+		lasts := time.Duration(float64(errorBudgetPeriod) / burnRate)
+		fmt.Printf("  Burn Rate:        %.2fx: a 30-day budget would last %s at this rate\n", burnRate, formatDays(lasts))
+	} else {
+		fmt.Printf("  Burn Rate:        0x: no failures\n")
+	}
+	verdict := "WITHIN"
+	if burnRate > 1 {
+		verdict = "EXCEEDED"
+		fmt.Printf("  ❌ The error rate exceeds the budget\n")
+	} else {
+		fmt.Printf("  ✅ The error rate is within the budget\n")
+	}
+	fmt.Printf("ERROR BUDGET target=%g error_rate=%.4f consumed=%.1f burn_rate=%.2f %s\n",
+		target, errorRate*100, burnRate*100, burnRate, verdict)
+}
+
+// formatDays renders d in days, e.g. "8.6 days", or in hours when it is under
+// a day.
+func formatDays(d time.Duration) string {
+	if d < 24*time.Hour {
+		return fmt.Sprintf("%.1f hours", d.Hours())
+	}
+	return fmt.Sprintf("%.1f days", d.Hours()/24)
+}
+
+// printStatus prints a progress note during the run. The dashboard replaces it
+// so notes appear above the live view instead of corrupting it.
+var printStatus = func(msg string) {
+	fmt.Println(msg)
 }
 
-func checkHealth(serverURL string) bool {
-	resp, err := http.Get(serverURL + "/health")
+// splitClients turns a total client count and slow percentage into fast and
+// slow counts, rounding the slow share to the nearest client.
+func splitClients(total int, slowPercent float64) (fast, slow int, err error) {
+	if total <= 0 {
+		return 0, 0, fmt.Errorf("-total-clients must be positive, got %d", total)
+	}
+	if slowPercent < 0 || slowPercent > 100 {
+		return 0, 0, fmt.Errorf("-slow-percent must be between 0 and 100, got %g", slowPercent)
+	}
+	slow = int(math.Round(float64(total) * slowPercent / 100))
+	return total - slow, slow, nil
+}
+
+// checkHealth waits for the server's /health to answer 200 OK, so a server
+// still loading its payloads is waited out rather than failing the run, and
+// reports how long it waited.
+func checkHealth(client *http.Client, serverURL string, attempts int, interval time.Duration) bool {
+	waited, err := health.Wait(client, serverURL+"/health", attempts, interval, func(attempt int, err error) {
+		fmt.Printf("⏳ Server not ready (%v); retry %d/%d in %s\n", err, attempt, attempts, interval)
+	})
 	if err != nil {
+		fmt.Printf("❌ Server health check failed: %v. Is the server running?\n", err)
 		return false
 	}
-	defer resp.Body.Close()
-	return resp.StatusCode == http.StatusOK
+	if waited > 0 {
+		fmt.Printf("✅ Server became ready after %s\n", waited.Round(time.Millisecond))
+	}
+	return true
 }
 
 func runLoadTest(config TestConfig, stats *Stats) {
 	var wg sync.WaitGroup
 	ctx := make(chan bool)
 
+	// With -profile-mix, each fast client behaves as its profile
+	runFast := runFastClient
+	if config.Profiles != nil {
+		runFast = runProfileClient
+	}
+
 	// In saturation mode, start slow clients FIRST to hog connections
 	// Then start fast clients to see if they are blocked
 	if config.ConnectionHogTest {
-		fmt.Println("   ... Pre-warming with slow clients to saturate connections ...")
+		printStatus("   ... Pre-warming with slow clients to saturate connections ...")
 		// Start slow clients
 		for i := 0; i < config.SlowClients; i++ {
 			wg.Add(1)
@@ -214,14 +961,14 @@ func runLoadTest(config TestConfig, stats *Stats) {
 
 		// Wait a bit to let slow clients establish connections
 		time.Sleep(2 * time.Second)
-		fmt.Println("   ... Starting fast clients now ...")
+		printStatus("   ... Starting fast clients now ...")
 
 		// Start fast clients
 		for i := 0; i < config.FastClients; i++ {
 			wg.Add(1)
 			go func(clientID int) {
 				defer wg.Done()
-				runFastClient(clientID, config, stats, ctx)
+				runFast(clientID, config, stats, ctx)
 			}(i)
 		}
 	} else {
@@ -231,7 +978,7 @@ func runLoadTest(config TestConfig, stats *Stats) {
 			wg.Add(1)
 			go func(clientID int) {
 				defer wg.Done()
-				runFastClient(clientID, config, stats, ctx)
+				runFast(clientID, config, stats, ctx)
 			}(i)
 		}
 
@@ -253,81 +1000,149 @@ func runLoadTest(config TestConfig, stats *Stats) {
 	wg.Wait()
 }
 
-func runFastClient(_ int, config TestConfig, stats *Stats, ctx chan bool) {
-	client := &http.Client{
-		Timeout: 10 * time.Second,
+// runReplay issues one request per recorded record at its recorded offset from
+// the first record, divided by the speed multiplier. Each request runs in its
+// own goroutine (an open workload model), so a slow server builds up
+// concurrency the way it would in production instead of delaying later
+// arrivals. Replay stops early when the test duration elapses.
+func runReplay(config TestConfig, stats *Stats) {
+	var wg sync.WaitGroup
+	client := httpClient(config.fastTransport, 10*time.Second)
+	url := config.ServerURL + "/experiment"
+
+	start := time.Now()
+	deadline := start.Add(config.TestDuration)
+	first := config.ReplayRecords[0].Timestamp
+
+	for _, record := range config.ReplayRecords {
+		offset := time.Duration(float64(record.Timestamp.Sub(first)) / config.ReplaySpeed)
+		sendAt := start.Add(offset)
+		if sendAt.After(deadline) {
+			break
+		}
+		time.Sleep(time.Until(sendAt))
+
+		wg.Add(1)
+		go func(userID string) {
+			defer wg.Done()
+			makeFastRequest(client, url, config.AuthToken, userID, stats)
+			stats.fastRequests.Add(1)
+		}(record.UserID)
 	}
 
+	wg.Wait()
+}
+
+func runFastClient(_ int, config TestConfig, stats *Stats, ctx chan bool) {
+	client := httpClient(config.fastTransport, 10*time.Second)
+
+	var backoff errorBackoff
 	for i := 0; i < config.RequestsPerClient; i++ {
 		select {
 		case <-ctx:
 			return
 		default:
-			makeFastRequest(client, config.ServerURL+"/experiment", stats)
+			userID := fmt.Sprintf("fast-user-%d", time.Now().UnixNano())
+			_, ok := makeFastRequest(client, config.ServerURL+"/experiment", config.AuthToken, userID, stats)
 			stats.fastRequests.Add(1)
-			// Small delay between requests
-			time.Sleep(50 * time.Millisecond)
+			// Think time between requests
+			if pause := backoff.pause(config.thinkTime(50*time.Millisecond), ok); pause > 0 {
+				time.Sleep(pause)
+			}
 		}
 	}
 }
 
-func runSlowClient(_ int, config TestConfig, stats *Stats, ctx chan bool) {
-	client := &http.Client{
-		Timeout: 60 * time.Second, // Longer timeout for slow downloads
-	}
+func runSlowClient(clientID int, config TestConfig, stats *Stats, ctx chan bool) {
+	client := httpClient(config.slowTransport, 60*time.Second) // Longer timeout for slow downloads
 
+	var backoff errorBackoff
 	for i := 0; i < config.RequestsPerClient; i++ {
 		select {
 		case <-ctx:
 			return
 		default:
-			makeSlowRequest(client, config.ServerURL+"/experiment", config.SlowDownloadSpeed, stats)
+			ok := makeSlowRequest(client, config.ServerURL+"/experiment", config.AuthToken, config.SlowDownloadSpeed, config.slowReadSeed(clientID, i), config.VerifyPayload, stats)
 			stats.slowRequests.Add(1)
-			// Small delay between requests
-			time.Sleep(100 * time.Millisecond)
+			// Think time between requests
+			if pause := backoff.pause(config.thinkTime(100*time.Millisecond), ok); pause > 0 {
+				time.Sleep(pause)
+			}
 		}
 	}
 }
 
-func makeFastRequest(client *http.Client, url string, stats *Stats) {
+// postExperiment sends the JSON body to the experiment endpoint, attaching the
+// bearer token when one is configured.
+func postExperiment(client *http.Client, url, authToken string, jsonData []byte) (*http.Response, error) {
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewBuffer(jsonData))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if authToken != "" {
+		req.Header.Set("Authorization", "Bearer "+authToken)
+	}
+	return client.Do(req)
+}
+
+// makeFastRequest sends one request and reads the response at full speed. It
+// reports whether the request succeeded and, if so, its latency.
+func makeFastRequest(client *http.Client, url, authToken, userID string, stats *Stats) (time.Duration, bool) {
 	stats.totalRequests.Add(1)
+	stats.inFlight.Add(1)
+	defer stats.inFlight.Add(-1)
 
-	// Generate a unique userId for each request
-	userID := fmt.Sprintf("fast-user-%d", time.Now().UnixNano())
 	payload := map[string]string{
 		"userId": userID,
 	}
 	jsonData, _ := json.Marshal(payload)
 
 	start := time.Now()
-	resp, err := client.Post(url, "application/json", bytes.NewBuffer(jsonData))
+	resp, err := postExperiment(client, url, authToken, jsonData)
 
 	if err != nil {
-		stats.failedRequests.Add(1)
-		return
+		stats.recordFailure(classifyRequestError(err))
+		return 0, false
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode == http.StatusOK {
 		// Read response body normally (fast)
-		_, err = io.Copy(io.Discard, resp.Body)
-		latency := time.Since(start).Milliseconds()
+		received, err := io.Copy(io.Discard, resp.Body)
+		elapsed := time.Since(start)
 
 		if err == nil {
 			stats.successRequests.Add(1)
+			serverTime, hasServerTime := parseServerTiming(resp, "X-Processing-Time")
+			decisionTime, hasDecisionTime := parseServerTiming(resp, "X-Decision-Time")
 			stats.latenciesMutex.Lock()
-			stats.fastLatencies = append(stats.fastLatencies, latency)
+			stats.fastLatencies = append(stats.fastLatencies, elapsed.Milliseconds())
+			stats.recordWindowLatency(start.Add(elapsed), elapsed)
+			if hasServerTime {
+				stats.fastServerTimes = append(stats.fastServerTimes, serverTime.Microseconds())
+				stats.fastNetworkTimes = append(stats.fastNetworkTimes, (elapsed - serverTime).Microseconds())
+			}
+			if hasDecisionTime {
+				stats.fastDecisionTimes = append(stats.fastDecisionTimes, decisionTime.Microseconds())
+			}
 			stats.latenciesMutex.Unlock()
-		} else {
-			stats.failedRequests.Add(1)
+			return elapsed, true
 		}
-	} else {
-		stats.failedRequests.Add(1)
+		stats.recordFailure(classifyReadError(err, received, resp.ContentLength))
+		return 0, false
 	}
+	stats.recordFailure(fmt.Sprintf("HTTP %d", resp.StatusCode))
+	return 0, false
 }
 
-func makeSlowRequest(client *http.Client, url string, bytesPerSec int, stats *Stats) {
+// makeSlowRequest sends one request and reads the response at bytesPerSec. With
+// verify it keeps the body and checks its payload with verifyPayload. It
+// reports whether the request succeeded.
+func makeSlowRequest(client *http.Client, url, authToken string, bytesPerSec int, seed int64, verify bool, stats *Stats) bool {
 	stats.totalRequests.Add(1)
+	stats.inFlight.Add(1)
+	defer stats.inFlight.Add(-1)
 
 	// Generate a unique userId for each request
 	userID := fmt.Sprintf("slow-user-%d", time.Now().UnixNano())
@@ -337,40 +1152,143 @@ func makeSlowRequest(client *http.Client, url string, bytesPerSec int, stats *St
 	jsonData, _ := json.Marshal(payload)
 
 	start := time.Now()
-	resp, err := client.Post(url, "application/json", bytes.NewBuffer(jsonData))
+	resp, err := postExperiment(client, url, authToken, jsonData)
 
 	if err != nil {
-		stats.failedRequests.Add(1)
-		return
+		stats.recordFailure(classifyRequestError(err))
+		return false
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode == http.StatusOK {
 		// Simulate slow network by reading response body slowly with random delays
-		slowReader := NewSlowReader(resp.Body, bytesPerSec)
-		_, err = io.Copy(io.Discard, slowReader)
-		latency := time.Since(start).Milliseconds()
-
+		slowReader := NewSlowReader(resp.Body, bytesPerSec, seed)
+		var body bytes.Buffer
+		sink := io.Discard
+		if verify {
+			sink = &body
+		}
+		received, err := io.Copy(sink, slowReader)
+		elapsed := time.Since(start)
+		stats.slowBytesReceived.Add(received)
+
+		if err == nil && verify {
+			expected := resp.Header.Get("X-Payload-SHA256")
+			if expected == "" {
+				stats.unverifiedPayloads.Add(1)
+			} else if cause := verifyPayload(body.Bytes(), expected); cause != "" {
+				stats.recordFailure(cause)
+				return false
+			} else {
+				stats.verifiedPayloads.Add(1)
+			}
+		}
 		if err == nil {
 			stats.successRequests.Add(1)
+			serverTime, hasServerTime := parseServerTiming(resp, "X-Processing-Time")
+			decisionTime, hasDecisionTime := parseServerTiming(resp, "X-Decision-Time")
 			stats.latenciesMutex.Lock()
-			stats.slowLatencies = append(stats.slowLatencies, latency)
+			stats.slowLatencies = append(stats.slowLatencies, elapsed.Milliseconds())
+			stats.recordWindowLatency(start.Add(elapsed), elapsed)
+			if hasServerTime {
+				stats.slowServerTimes = append(stats.slowServerTimes, serverTime.Microseconds())
+				stats.slowNetworkTimes = append(stats.slowNetworkTimes, (elapsed - serverTime).Microseconds())
+			}
+			if hasDecisionTime {
+				stats.slowDecisionTimes = append(stats.slowDecisionTimes, decisionTime.Microseconds())
+			}
 			stats.latenciesMutex.Unlock()
-		} else {
-			stats.failedRequests.Add(1)
+			return true
 		}
-	} else {
-		stats.failedRequests.Add(1)
+		cause := classifyReadError(err, received, resp.ContentLength)
+		if cause == causePartialTransfer {
+			stats.partialTransfers.Add(1)
+			if resp.ContentLength > 0 {
+				stats.partialBytesReceived.Add(received)
+				stats.partialBytesExpected.Add(resp.ContentLength)
+			}
+		}
+		stats.recordFailure(cause)
+		return false
+	}
+	stats.recordFailure(fmt.Sprintf("HTTP %d", resp.StatusCode))
+	return false
+}
+
+// verifyPayload checks that the payload in a JSON /experiment body hashes to
+// expected, the response's X-Payload-SHA256. It returns the failure cause, or
+// "" when the payload is intact. Streams (JSON Lines) and protobuf aren't
+// checked, since slow clients never ask for them.
+func verifyPayload(body []byte, expected string) string {
+	var response struct {
+		Payload json.RawMessage `json:"payload"`
+	}
+	// A body cut short fails to parse at its very end; any other body that
+	// doesn't parse, or parses but hashes differently, was altered in transit
+	if err := json.Unmarshal(body, &response); err != nil {
+		var syntaxErr *json.SyntaxError
+		if errors.As(err, &syntaxErr) && syntaxErr.Offset >= int64(len(body)) {
+			return causeTruncatedPayload
+		}
+		return causeCorruptedPayload
+	}
+	if len(response.Payload) == 0 {
+		return causeTruncatedPayload
+	}
+	sum := sha256.Sum256(response.Payload)
+	if !strings.EqualFold(hex.EncodeToString(sum[:]), expected) {
+		return causeCorruptedPayload
+	}
+	return ""
+}
+
+// parseServerTiming reads a server-reported timing header in milliseconds, such
+// as X-Processing-Time (handler time) or X-Decision-Time (time to choose the
+// payload). It returns false when the header is missing or malformed, e.g. when
+// testing an older server build.
+func parseServerTiming(resp *http.Response, header string) (time.Duration, bool) {
+	value := resp.Header.Get(header)
+	if value == "" {
+		return 0, false
+	}
+	ms, err := strconv.ParseFloat(value, 64)
+	if err != nil || ms < 0 {
+		return 0, false
 	}
+	return time.Duration(ms * float64(time.Millisecond)), true
 }
 
-func monitorProgress(stats *Stats, stop chan bool) {
-	ticker := time.NewTicker(2 * time.Second)
+// progressMonitor prints a progress line every interval until stopped. It is
+// the plain-terminal counterpart of Dashboard.
+type progressMonitor struct {
+	stop     chan struct{}
+	stopOnce sync.Once
+	done     chan struct{}
+}
+
+func startProgressMonitor(stats *Stats, interval time.Duration) *progressMonitor {
+	m := &progressMonitor{stop: make(chan struct{}), done: make(chan struct{})}
+	go m.run(stats, interval)
+	return m
+}
+
+// Stop stops the monitor and waits until it has returned, so no progress line
+// can be printed after it, in the middle of the results. It never blocks on
+// a monitor that has already returned, and calling it more than once, from
+// any goroutine, is safe.
+func (m *progressMonitor) Stop() {
+	m.stopOnce.Do(func() { close(m.stop) })
+	<-m.done
+}
+
+func (m *progressMonitor) run(stats *Stats, interval time.Duration) {
+	defer close(m.done)
+	ticker := time.NewTicker(interval)
 	defer ticker.Stop()
 
 	for {
 		select {
-		case <-stop:
+		case <-m.stop:
 			return
 		case <-ticker.C:
 			total := stats.totalRequests.Load()
@@ -385,18 +1303,154 @@ func monitorProgress(stats *Stats, stop chan bool) {
 	}
 }
 
+// Percentile methods, selected with -percentile-method. Tools disagree on
+// this, so compare numbers across tools only when they use the same one.
+const (
+	// percentileNearest picks the nearest-rank sample: the smallest sample
+	// that at least the given fraction of samples are less than or equal to.
+	// It always reports a latency that was actually measured.
+	percentileNearest = "nearest"
+	// percentileLinear interpolates linearly between the two samples around
+	// rank (n-1)*p, the default of numpy.percentile and Excel's
+	// PERCENTILE.INC.
+	percentileLinear = "linear"
+)
+
+// percentileMethod is the method calculatePercentile uses for every
+// percentile the load test reports
+var percentileMethod = percentileNearest
+
+// describePercentileMethod explains a percentile method for the output header.
+func describePercentileMethod(method string) string {
+	if method == percentileLinear {
+		return "linear (interpolated between the closest ranks, like numpy)"
+	}
+	return "nearest (nearest-rank, always a measured sample)"
+}
+
+// calculatePercentile returns the percentile (0-1) of sorted samples with
+// percentileMethod. Linear results are rounded to the samples' unit.
 func calculatePercentile(sortedLatencies []int64, percentile float64) int64 {
-	if len(sortedLatencies) == 0 {
+	n := len(sortedLatencies)
+	if n == 0 {
 		return 0
 	}
-	index := int(float64(len(sortedLatencies)) * percentile)
-	if index >= len(sortedLatencies) {
-		index = len(sortedLatencies) - 1
+	if percentileMethod == percentileLinear {
+		rank := float64(n-1) * percentile
+		lo := int(math.Floor(rank))
+		if lo >= n-1 {
+			return sortedLatencies[n-1]
+		}
+		frac := rank - float64(lo)
+		return int64(math.Round(float64(sortedLatencies[lo]) + frac*float64(sortedLatencies[lo+1]-sortedLatencies[lo])))
+	}
+	// Rank ceil(n*p), counted from 1; the old floor(n*p) index was one past
+	// it whenever n*p was a whole number, so p50 of 1..100 reported 51
+	index := int(math.Ceil(float64(n)*percentile)) - 1
+	if index < 0 {
+		index = 0
+	}
+	if index >= n {
+		index = n - 1
 	}
 	return sortedLatencies[index]
 }
 
-func printResults(stats *Stats, startTime, endTime time.Time, config TestConfig) {
+func sortedCopy(values []int64) []int64 {
+	sorted := make([]int64, len(values))
+	copy(sorted, values)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i] < sorted[j]
+	})
+	return sorted
+}
+
+// printBreakdown prints decision, server and network percentiles for sorted
+// microsecond samples. Decision time is how long the handler took to choose a
+// payload; server time is the whole handler; network time is everything else
+// in the round trip, which for slow clients is dominated by the download.
+func printBreakdown(label string, decisionTimes, serverTimes, networkTimes []int64) {
+	ms := func(micros int64) float64 { return float64(micros) / 1000 }
+	fmt.Printf("  %s:\n", label)
+	if len(decisionTimes) > 0 {
+		fmt.Printf("    Decision p50/p90/p99: %.3f / %.3f / %.3f ms\n",
+			ms(calculatePercentile(decisionTimes, 0.50)),
+			ms(calculatePercentile(decisionTimes, 0.90)),
+			ms(calculatePercentile(decisionTimes, 0.99)))
+	}
+	fmt.Printf("    Server  p50/p90/p99:  %.2f / %.2f / %.2f ms\n",
+		ms(calculatePercentile(serverTimes, 0.50)),
+		ms(calculatePercentile(serverTimes, 0.90)),
+		ms(calculatePercentile(serverTimes, 0.99)))
+	fmt.Printf("    Network p50/p90/p99:  %.2f / %.2f / %.2f ms\n",
+		ms(calculatePercentile(networkTimes, 0.50)),
+		ms(calculatePercentile(networkTimes, 0.90)),
+		ms(calculatePercentile(networkTimes, 0.99)))
+}
+
+// printSlowTransfers prints how many body bytes slow clients received and, for
+// transfers the connection cut short, what share of the body made it. A rising
+// partial count is the sign the server is dropping slow clients mid-download.
+// With verifyPayloads it adds how many payloads matched X-Payload-SHA256 and
+// how many arrived truncated or corrupted.
+func printSlowTransfers(stats *Stats, verifyPayloads bool) {
+	partial := stats.partialTransfers.Load()
+	fmt.Println("Slow Client Transfers:")
+	fmt.Printf("  Bytes Received:   %d\n", stats.slowBytesReceived.Load())
+	fmt.Printf("  Partial:          %d\n", partial)
+	if expected := stats.partialBytesExpected.Load(); expected > 0 {
+		received := stats.partialBytesReceived.Load()
+		fmt.Printf("  Partial Received: %d of %d bytes (%.1f%%)\n",
+			received, expected, float64(received)/float64(expected)*100)
+	}
+	if verifyPayloads {
+		stats.failuresMutex.Lock()
+		truncated, corrupted := stats.failureCauses[causeTruncatedPayload], stats.failureCauses[causeCorruptedPayload]
+		stats.failuresMutex.Unlock()
+		fmt.Printf("  Verified:         %d payloads matched X-Payload-SHA256\n", stats.verifiedPayloads.Load())
+		fmt.Printf("  Truncated:        %d\n", truncated)
+		fmt.Printf("  Corrupted:        %d\n", corrupted)
+		if unverified := stats.unverifiedPayloads.Load(); unverified > 0 {
+			fmt.Printf("  Unverified:       %d responses had no X-Payload-SHA256 (older server?)\n", unverified)
+		}
+	}
+	fmt.Println()
+}
+
+// printWindows prints latency percentiles for each window of the run, so a
+// spike that the end-of-run percentiles average away shows up at the time it
+// happened. Windows with no successful requests are marked as such.
+func printWindows(windowLatencies [][]int64, windowSize time.Duration) {
+	fmt.Printf("Latency Over Time (%s windows):\n", windowSize)
+	fmt.Printf("  %-14s %8s %8s %8s %8s %8s\n", "Window", "Requests", "p50", "p90", "p99", "Max")
+	for i, latencies := range windowLatencies {
+		from := time.Duration(i) * windowSize
+		label := fmt.Sprintf("%s-%s", from, from+windowSize)
+		if len(latencies) == 0 {
+			fmt.Printf("  %-14s %8d %8s %8s %8s %8s\n", label, 0, "-", "-", "-", "-")
+			continue
+		}
+		fmt.Printf("  %-14s %8d %6dms %6dms %6dms %6dms\n", label, len(latencies),
+			calculatePercentile(latencies, 0.50),
+			calculatePercentile(latencies, 0.90),
+			calculatePercentile(latencies, 0.99),
+			latencies[len(latencies)-1])
+	}
+	fmt.Println()
+}
+
+// ResultSummary is what printResults measured that the SLO check gates on
+// and the error budget is reported from.
+type ResultSummary struct {
+	P99         int64 // fast-client p99 in ms, or overall p99 without fast clients
+	FastClients bool  // whether P99 is the fast clients'
+	Successful  int64
+	SuccessRate float64 // percent of requests that succeeded, 0 when none were sent
+	Total       int64
+	Failed      int64
+}
+
+func printResults(stats *Stats, startTime, endTime time.Time, config TestConfig) ResultSummary {
 	totalRequests := stats.totalRequests.Load()
 	successRequests := stats.successRequests.Load()
 	failedRequests := stats.failedRequests.Load()
@@ -411,6 +1465,16 @@ func printResults(stats *Stats, startTime, endTime time.Time, config TestConfig)
 	slowLatencies := make([]int64, len(stats.slowLatencies))
 	copy(fastLatencies, stats.fastLatencies)
 	copy(slowLatencies, stats.slowLatencies)
+	fastServerTimes := sortedCopy(stats.fastServerTimes)
+	fastNetworkTimes := sortedCopy(stats.fastNetworkTimes)
+	slowServerTimes := sortedCopy(stats.slowServerTimes)
+	fastDecisionTimes := sortedCopy(stats.fastDecisionTimes)
+	slowDecisionTimes := sortedCopy(stats.slowDecisionTimes)
+	slowNetworkTimes := sortedCopy(stats.slowNetworkTimes)
+	windowLatencies := make([][]int64, len(stats.windowLatencies))
+	for i, latencies := range stats.windowLatencies {
+		windowLatencies[i] = sortedCopy(latencies)
+	}
 	stats.latenciesMutex.Unlock()
 
 	sort.Slice(fastLatencies, func(i, j int) bool {
@@ -490,17 +1554,39 @@ func printResults(stats *Stats, startTime, endTime time.Time, config TestConfig)
 	fmt.Printf("  Total Requests:   %d\n", totalRequests)
 	fmt.Printf("  Successful:       %d (%.2f%%)\n", successRequests, float64(successRequests)/float64(totalRequests)*100)
 	fmt.Printf("  Failed:           %d (%.2f%%)\n", failedRequests, float64(failedRequests)/float64(totalRequests)*100)
+	stats.failuresMutex.Lock()
+	causes := make([]string, 0, len(stats.failureCauses))
+	for cause := range stats.failureCauses {
+		causes = append(causes, cause)
+	}
+	sort.Strings(causes)
+	for _, cause := range causes {
+		fmt.Printf("    %-20s %d\n", cause+":", stats.failureCauses[cause])
+	}
+	stats.failuresMutex.Unlock()
+	// A client that gave up is the server failing to keep up, not erroring
+	if timedOut := stats.timedOut.Load(); failedRequests > 0 {
+		fmt.Printf("  Timed Out:        %d (%.2f%%) client gave up waiting\n", timedOut, float64(timedOut)/float64(totalRequests)*100)
+		fmt.Printf("  Other Failures:   %d (%.2f%%)\n", failedRequests-timedOut, float64(failedRequests-timedOut)/float64(totalRequests)*100)
+	}
 	fmt.Printf("  Fast Clients:     %d\n", fastRequests)
 	fmt.Printf("  Slow Clients:     %d\n", slowRequests)
 	fmt.Println()
 
+	if slowRequests > 0 {
+		printSlowTransfers(stats, config.VerifyPayload)
+	}
+	if config.Profiles != nil {
+		printProfiles(config.Profiles, stats.profiles)
+	}
+
 	fmt.Println("Overall Latency Statistics:")
 	fmt.Printf("  Minimum:          %d ms\n", minLatency)
 	fmt.Printf("  Average:          %d ms\n", avgLatency)
 	fmt.Printf("  Maximum:          %d ms\n", maxLatency)
 	fmt.Println()
 
-	fmt.Println("Overall Latency Percentiles:")
+	fmt.Printf("Overall Latency Percentiles (%s):\n", percentileMethod)
 	fmt.Printf("  p50 (median):     %d ms\n", p50)
 	fmt.Printf("  p90:              %d ms\n", p90)
 	fmt.Printf("  p99:              %d ms\n", p99)
@@ -530,6 +1616,22 @@ func printResults(stats *Stats, startTime, endTime time.Time, config TestConfig)
 		fmt.Println()
 	}
 
+	// Print server vs network breakdown
+	if len(fastServerTimes) > 0 || len(slowServerTimes) > 0 {
+		fmt.Println("Latency Breakdown (server processing vs network/transfer):")
+		if len(fastServerTimes) > 0 {
+			printBreakdown("Fast Clients", fastDecisionTimes, fastServerTimes, fastNetworkTimes)
+		}
+		if len(slowServerTimes) > 0 {
+			printBreakdown("Slow Clients", slowDecisionTimes, slowServerTimes, slowNetworkTimes)
+		}
+		fmt.Println()
+	}
+
+	if len(windowLatencies) > 1 {
+		printWindows(windowLatencies, stats.windowSize)
+	}
+
 	fmt.Println("Throughput:")
 	rps := float64(successRequests) / duration.Seconds()
 	fastRps := float64(fastRequests) / duration.Seconds()
@@ -543,7 +1645,7 @@ func printResults(stats *Stats, startTime, endTime time.Time, config TestConfig)
 	}
 
 	// Calculate efficiency (actual vs theoretical max)
-	if len(fastLatencies) > 0 && fastAvg > 0 {
+	if len(fastLatencies) > 0 && fastAvg > 0 && config.FastClients > 0 {
 		theoreticalMaxFastRps := 1000.0 / float64(fastAvg) * float64(config.FastClients)
 		actualFastRps := fastRps
 		efficiency := (actualFastRps / theoreticalMaxFastRps) * 100
@@ -589,7 +1691,10 @@ func printResults(stats *Stats, startTime, endTime time.Time, config TestConfig)
 		fmt.Println("  ❌ p99: Poor - over 1s")
 	}
 
-	successRate := float64(successRequests) / float64(totalRequests) * 100
+	successRate := 0.0
+	if totalRequests > 0 {
+		successRate = float64(successRequests) / float64(totalRequests) * 100
+	}
 	if successRate >= 99.9 {
 		fmt.Println("  ✅ Success rate: Excellent - 99.9%+")
 	} else if successRate >= 99 {
@@ -619,4 +1724,12 @@ func printResults(stats *Stats, startTime, endTime time.Time, config TestConfig)
 	}
 
 	fmt.Println("━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━")
+	return ResultSummary{
+		P99:         assessP99,
+		FastClients: len(fastLatencies) > 0,
+		Successful:  successRequests,
+		SuccessRate: successRate,
+		Total:       totalRequests,
+		Failed:      failedRequests,
+	}
 }
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestPostExperimentAuthToken(t *testing.T) {
+	tests := []struct {
+		name      string
+		authToken string
+		want      string
+	}{
+		{name: "no token", authToken: "", want: ""},
+		{name: "token", authToken: "s3cret", want: "Bearer s3cret"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got string
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				got = r.Header.Get("Authorization")
+			}))
+			defer server.Close()
+
+			resp, err := postExperiment(server.Client(), server.URL+"/experiment", tt.authToken, []byte(`{"userId":"u1"}`))
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+			if got != tt.want {
+				t.Errorf("Authorization = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCalculatePercentile(t *testing.T) {
+	oneToHundred := make([]int64, 100)
+	for i := range oneToHundred {
+		oneToHundred[i] = int64(i + 1)
+	}
+	// The worked example of both methods on Wikipedia's Percentile page, and
+	// numpy.percentile's results for it
+	small := []int64{15, 20, 35, 40, 50}
+
+	tests := []struct {
+		name       string
+		method     string
+		samples    []int64
+		percentile float64
+		want       int64
+	}{
+		{name: "nearest p0", method: percentileNearest, samples: small, percentile: 0, want: 15},
+		{name: "nearest p30", method: percentileNearest, samples: small, percentile: 0.30, want: 20},
+		{name: "nearest p40", method: percentileNearest, samples: small, percentile: 0.40, want: 20},
+		{name: "nearest p50", method: percentileNearest, samples: small, percentile: 0.50, want: 35},
+		{name: "nearest p100", method: percentileNearest, samples: small, percentile: 1, want: 50},
+		{name: "nearest p50 of 1..100", method: percentileNearest, samples: oneToHundred, percentile: 0.50, want: 50},
+		{name: "nearest p90 of 1..100", method: percentileNearest, samples: oneToHundred, percentile: 0.90, want: 90},
+		{name: "nearest p99 of 1..100", method: percentileNearest, samples: oneToHundred, percentile: 0.99, want: 99},
+		{name: "linear p0", method: percentileLinear, samples: small, percentile: 0, want: 15},
+		{name: "linear p30", method: percentileLinear, samples: small, percentile: 0.30, want: 23},
+		{name: "linear p40", method: percentileLinear, samples: small, percentile: 0.40, want: 29},
+		{name: "linear p50", method: percentileLinear, samples: small, percentile: 0.50, want: 35},
+		{name: "linear p90", method: percentileLinear, samples: small, percentile: 0.90, want: 46},
+		{name: "linear p100", method: percentileLinear, samples: small, percentile: 1, want: 50},
+		{name: "linear p50 of 1..100, rounded", method: percentileLinear, samples: oneToHundred, percentile: 0.50, want: 51},
+		{name: "linear p90 of 1..100", method: percentileLinear, samples: oneToHundred, percentile: 0.90, want: 90},
+		{name: "linear p99 of 1..100", method: percentileLinear, samples: oneToHundred, percentile: 0.99, want: 99},
+		{name: "nearest of one sample", method: percentileNearest, samples: []int64{7}, percentile: 0.99, want: 7},
+		{name: "linear of one sample", method: percentileLinear, samples: []int64{7}, percentile: 0.99, want: 7},
+		{name: "nearest of none", method: percentileNearest, percentile: 0.5, want: 0},
+		{name: "linear of none", method: percentileLinear, percentile: 0.5, want: 0},
+	}
+	saved := percentileMethod
+	t.Cleanup(func() { percentileMethod = saved })
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			percentileMethod = tt.method
+			if got := calculatePercentile(tt.samples, tt.percentile); got != tt.want {
+				t.Errorf("calculatePercentile(%v, %v) = %d, want %d", tt.samples, tt.percentile, got, tt.want)
+			}
+		})
+	}
+}
+
+// TestProgressMonitorStop starts and stops the progress monitor while
+// requests are being counted and checks that Stop returns promptly and nothing
+// is printed after it. Run it with -race.
+func TestProgressMonitorStop(t *testing.T) {
+	const interval = time.Millisecond
+	const stopMarker = "\n--- stopped ---\n"
+	tests := []struct {
+		name      string
+		runFor    time.Duration
+		stoppers  int // goroutines calling Stop at once
+		stopAgain bool
+		wantLines bool
+	}{
+		{name: "stopped at once", stoppers: 1},
+		{name: "stopped mid-run", runFor: 20 * interval, stoppers: 1, wantLines: true},
+		{name: "stopped twice", runFor: 5 * interval, stoppers: 1, stopAgain: true},
+		{name: "stopped concurrently", runFor: 5 * interval, stoppers: 8},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Capture stdout, where the monitor prints
+			r, w, err := os.Pipe()
+			if err != nil {
+				t.Fatal(err)
+			}
+			var output bytes.Buffer
+			copied := make(chan struct{})
+			go func() {
+				io.Copy(&output, r)
+				close(copied)
+			}()
+			saved := os.Stdout
+			os.Stdout = w
+
+			stats := &Stats{}
+			counting := make(chan struct{})
+			var counters sync.WaitGroup
+			counters.Add(1)
+			go func() {
+				defer counters.Done()
+				for {
+					select {
+					case <-counting:
+						return
+					default:
+						stats.totalRequests.Add(1)
+					}
+				}
+			}()
+
+			monitor := startProgressMonitor(stats, interval)
+			time.Sleep(tt.runFor)
+			stopped := make(chan struct{})
+			var stoppers sync.WaitGroup
+			for i := 0; i < tt.stoppers; i++ {
+				stoppers.Add(1)
+				go func() {
+					defer stoppers.Done()
+					monitor.Stop()
+				}()
+			}
+			go func() {
+				stoppers.Wait()
+				if tt.stopAgain {
+					monitor.Stop()
+				}
+				close(stopped)
+			}()
+			select {
+			case <-stopped:
+			case <-time.After(5 * time.Second):
+				t.Fatal("Stop did not return")
+			}
+
+			// Nothing is printed once Stop has returned, however long the
+			// run goes on: the results would follow this marker
+			io.WriteString(w, stopMarker)
+			time.Sleep(10 * interval)
+			close(counting)
+			counters.Wait()
+			os.Stdout = saved
+			w.Close()
+			<-copied
+			r.Close()
+
+			before, after, _ := strings.Cut(output.String(), stopMarker)
+			if after != "" {
+				t.Errorf("printed %q after Stop returned", after)
+			}
+			if tt.wantLines && !strings.Contains(before, "Progress:") {
+				t.Errorf("no progress line printed in %s", tt.runFor)
+			}
+		})
+	}
+}
@@ -0,0 +1,363 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"math/rand"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ClientBehavior is one way a client uses the server. A profile client calls
+// Session in a loop until the run ends or it has sent -requests requests. To
+// add a profile, implement ClientBehavior and register it in clientBehaviors.
+type ClientBehavior interface {
+	// Timeout is the client's HTTP timeout for each request.
+	Timeout() time.Duration
+	// Session sends one or more requests through c and returns how many it
+	// sent and how long the client pauses before its next session.
+	Session(c *ProfileClient) (sent int, pause time.Duration)
+}
+
+// clientBehaviors are the profiles -profile-mix can name.
+var clientBehaviors = map[string]ClientBehavior{
+	"normal":    normalBehavior{},
+	"bursty":    burstyBehavior{minBurst: 2, maxBurst: 10},
+	"abandoner": abandonerBehavior{minFraction: 0.1, maxFraction: 0.9},
+	"retrier":   retrierBehavior{timeout: 2 * time.Second, maxRetries: 3},
+}
+
+// profileNames returns the registered profile names, sorted.
+func profileNames() []string {
+	names := make([]string, 0, len(clientBehaviors))
+	for name := range clientBehaviors {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// ProfileShare is one profile's weight in -profile-mix and the number of fast
+// clients it gets.
+type ProfileShare struct {
+	Name    string
+	Weight  float64
+	Clients int
+}
+
+// parseProfileMix parses "normal=70,bursty=10,abandoner=10,retrier=10" into
+// shares in the order given. Weights are relative and need not sum to 100.
+func parseProfileMix(spec string) ([]ProfileShare, error) {
+	var shares []ProfileShare
+	seen := make(map[string]bool)
+	var total float64
+	for _, part := range strings.Split(spec, ",") {
+		if part = strings.TrimSpace(part); part == "" {
+			continue
+		}
+		name, weight, ok := strings.Cut(part, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid profile %q: expected <profile>=<weight>", part)
+		}
+		name = strings.TrimSpace(name)
+		if _, ok := clientBehaviors[name]; !ok {
+			return nil, fmt.Errorf("unknown client profile %q (want %s)", name, strings.Join(profileNames(), ", "))
+		}
+		if seen[name] {
+			return nil, fmt.Errorf("client profile %q listed twice", name)
+		}
+		seen[name] = true
+		w, err := strconv.ParseFloat(strings.TrimSpace(weight), 64)
+		if err != nil || w < 0 || math.IsInf(w, 0) {
+			return nil, fmt.Errorf("invalid weight %q for client profile %s", weight, name)
+		}
+		total += w
+		shares = append(shares, ProfileShare{Name: name, Weight: w})
+	}
+	if total == 0 {
+		return nil, fmt.Errorf("-profile-mix needs at least one profile with a positive weight")
+	}
+	return shares, nil
+}
+
+// assignProfileClients splits clients between shares in proportion to their
+// weights, giving clients left over after rounding down to the shares with the
+// largest remainders.
+func assignProfileClients(shares []ProfileShare, clients int) {
+	var total float64
+	for _, s := range shares {
+		total += s.Weight
+	}
+	remainders := make([]float64, len(shares))
+	assigned := 0
+	for i := range shares {
+		exact := float64(clients) * shares[i].Weight / total
+		shares[i].Clients = int(exact)
+		remainders[i] = exact - float64(shares[i].Clients)
+		assigned += shares[i].Clients
+	}
+	order := make([]int, len(shares))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(a, b int) bool { return remainders[order[a]] > remainders[order[b]] })
+	for _, i := range order[:clients-assigned] {
+		shares[i].Clients++
+	}
+}
+
+// profileFor returns the profile of the fast client with the given ID: the
+// first share's clients come first, then the next share's, and so on.
+func (c TestConfig) profileFor(clientID int) string {
+	for _, s := range c.Profiles {
+		if clientID < s.Clients {
+			return s.Name
+		}
+		clientID -= s.Clients
+	}
+	return "normal"
+}
+
+// ProfileStats are one profile's results. Requests that complete count towards
+// the fast-client totals as well; abandoned ones count only here.
+type ProfileStats struct {
+	clients           int
+	requests          atomic.Int64
+	succeeded         atomic.Int64
+	failed            atomic.Int64
+	abandoned         atomic.Int64
+	abandonedReceived atomic.Int64 // body bytes read before abandoning
+	abandonedExpected atomic.Int64 // Content-Length of the abandoned bodies
+	retries           atomic.Int64
+	gaveUp            atomic.Int64 // sessions whose request failed on every retry
+
+	latenciesMutex sync.Mutex
+	latencies      []int64 // successful request latencies in milliseconds
+}
+
+// newProfileStats returns empty stats for every share in the mix.
+func newProfileStats(shares []ProfileShare) map[string]*ProfileStats {
+	stats := make(map[string]*ProfileStats, len(shares))
+	for _, s := range shares {
+		stats[s.Name] = &ProfileStats{clients: s.Clients}
+	}
+	return stats
+}
+
+// ProfileClient is what a ClientBehavior drives: one client's connection pool,
+// random source and stats.
+type ProfileClient struct {
+	ID     int
+	Rand   *rand.Rand
+	config TestConfig
+	client *http.Client
+	stats  *Stats
+	own    *ProfileStats
+}
+
+// ThinkTime returns a normal client's pause between requests.
+func (c *ProfileClient) ThinkTime() time.Duration {
+	return c.config.thinkTime(50 * time.Millisecond)
+}
+
+// Request sends one request and reads the whole response, recording it as a
+// fast-client request. It reports whether the request succeeded.
+func (c *ProfileClient) Request() bool {
+	userID := fmt.Sprintf("profile-user-%d-%d", c.ID, time.Now().UnixNano())
+	latency, ok := makeFastRequest(c.client, c.config.ServerURL+"/experiment", c.config.AuthToken, userID, c.stats)
+	c.stats.fastRequests.Add(1)
+	c.own.requests.Add(1)
+	if !ok {
+		c.own.failed.Add(1)
+		return false
+	}
+	c.own.succeeded.Add(1)
+	c.own.latenciesMutex.Lock()
+	c.own.latencies = append(c.own.latencies, latency.Milliseconds())
+	c.own.latenciesMutex.Unlock()
+	return true
+}
+
+// Abandon sends one request, reads fraction of the response body and closes
+// the connection, as a user leaving mid-download does. The server sees a
+// write error once the unread rest no longer fits in the socket buffers.
+// Abandoned requests are counted only in the profile's stats; a request that
+// fails before its body, or gets a status other than 200, is a fast-client
+// failure like any other. It reports whether the server responded with 200.
+func (c *ProfileClient) Abandon(fraction float64) bool {
+	c.own.requests.Add(1)
+	userID := fmt.Sprintf("profile-user-%d-%d", c.ID, time.Now().UnixNano())
+	resp, err := postExperiment(c.client, c.config.ServerURL+"/experiment", c.config.AuthToken, []byte(`{"userId":"`+userID+`"}`))
+	fail := func(cause string) bool {
+		c.own.failed.Add(1)
+		c.stats.totalRequests.Add(1)
+		c.stats.fastRequests.Add(1)
+		c.stats.recordFailure(cause)
+		return false
+	}
+	if err != nil {
+		return fail(classifyRequestError(err))
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		io.Copy(io.Discard, resp.Body)
+		return fail(fmt.Sprintf("HTTP %d", resp.StatusCode))
+	}
+
+	// Without a Content-Length there's no way to know how much is a
+	// fraction; read one buffer's worth
+	limit := int64(32 * 1024)
+	if resp.ContentLength > 0 {
+		limit = int64(float64(resp.ContentLength) * fraction)
+	}
+	received, _ := io.Copy(io.Discard, io.LimitReader(resp.Body, limit))
+	c.own.abandoned.Add(1)
+	c.own.abandonedReceived.Add(received)
+	if resp.ContentLength > 0 {
+		c.own.abandonedExpected.Add(resp.ContentLength)
+	}
+	return true
+}
+
+// normalBehavior sends one request at a time with the configured think time,
+// like the plain fast clients.
+type normalBehavior struct{}
+
+func (normalBehavior) Timeout() time.Duration { return 10 * time.Second }
+
+func (normalBehavior) Session(c *ProfileClient) (int, time.Duration) {
+	c.Request()
+	return 1, c.ThinkTime()
+}
+
+// burstyBehavior sends a burst of requests at once, as an app does when it
+// opens a screen, then stays idle long enough that its average rate matches a
+// normal client's.
+type burstyBehavior struct {
+	minBurst, maxBurst int
+}
+
+func (burstyBehavior) Timeout() time.Duration { return 10 * time.Second }
+
+func (b burstyBehavior) Session(c *ProfileClient) (int, time.Duration) {
+	burst := b.minBurst + c.Rand.Intn(b.maxBurst-b.minBurst+1)
+	var wg sync.WaitGroup
+	for i := 0; i < burst; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			c.Request()
+		}()
+	}
+	wg.Wait()
+	return burst, time.Duration(burst) * c.ThinkTime()
+}
+
+// abandonerBehavior gives up on each response after reading a random fraction
+// of its body, between minFraction and maxFraction.
+type abandonerBehavior struct {
+	minFraction, maxFraction float64
+}
+
+func (abandonerBehavior) Timeout() time.Duration { return 10 * time.Second }
+
+func (a abandonerBehavior) Session(c *ProfileClient) (int, time.Duration) {
+	fraction := a.minFraction + c.Rand.Float64()*(a.maxFraction-a.minFraction)
+	if !c.Abandon(fraction) {
+		// Pace a client whose requests fail fast, as errorBackoff does
+		// for the others
+		return 1, max(c.ThinkTime(), maxErrorBackoff)
+	}
+	return 1, c.ThinkTime()
+}
+
+// retrierBehavior uses a short timeout and retries a failed request at once,
+// up to maxRetries times, the way an impatient client retry loop does. Each
+// attempt is a request to the server.
+type retrierBehavior struct {
+	timeout    time.Duration
+	maxRetries int
+}
+
+func (r retrierBehavior) Timeout() time.Duration { return r.timeout }
+
+func (r retrierBehavior) Session(c *ProfileClient) (int, time.Duration) {
+	for attempt := 0; ; attempt++ {
+		if c.Request() {
+			return attempt + 1, c.ThinkTime()
+		}
+		if attempt == r.maxRetries {
+			c.own.gaveUp.Add(1)
+			// Only pause between sessions, so a down server isn't
+			// hammered without end
+			return attempt + 1, max(c.ThinkTime(), maxErrorBackoff)
+		}
+		c.own.retries.Add(1)
+	}
+}
+
+// runProfileClient runs a fast client with the behavior of its profile.
+func runProfileClient(clientID int, config TestConfig, stats *Stats, ctx chan bool) {
+	name := config.profileFor(clientID)
+	behavior := clientBehaviors[name]
+	seed := time.Now().UnixNano()
+	if config.Seed != 0 {
+		seed = config.Seed + int64(clientID)
+	}
+	c := &ProfileClient{
+		ID:     clientID,
+		Rand:   rand.New(rand.NewSource(seed)),
+		config: config,
+		client: httpClient(config.fastTransport, behavior.Timeout()),
+		stats:  stats,
+		own:    stats.profiles[name],
+	}
+
+	for sent := 0; sent < config.RequestsPerClient; {
+		select {
+		case <-ctx:
+			return
+		default:
+			n, pause := behavior.Session(c)
+			sent += n
+			if pause > 0 {
+				time.Sleep(pause)
+			}
+		}
+	}
+}
+
+// printProfiles prints each client profile's results: its requests and their
+// outcomes, and the latency of the ones that succeeded.
+func printProfiles(shares []ProfileShare, profiles map[string]*ProfileStats) {
+	fmt.Println("Client Profiles:")
+	fmt.Printf("  %-10s %7s %8s %8s %7s %9s %7s %6s %7s %7s %7s\n",
+		"Profile", "Clients", "Requests", "Success", "Failed", "Abandoned", "Retries", "GaveUp", "p50", "p90", "p99")
+	for _, share := range shares {
+		p := profiles[share.Name]
+		p.latenciesMutex.Lock()
+		latencies := sortedCopy(p.latencies)
+		p.latenciesMutex.Unlock()
+		percentile := func(q float64) string {
+			if len(latencies) == 0 {
+				return "-"
+			}
+			return fmt.Sprintf("%dms", calculatePercentile(latencies, q))
+		}
+		fmt.Printf("  %-10s %7d %8d %8d %7d %9d %7d %6d %7s %7s %7s\n",
+			share.Name, p.clients, p.requests.Load(), p.succeeded.Load(), p.failed.Load(),
+			p.abandoned.Load(), p.retries.Load(), p.gaveUp.Load(),
+			percentile(0.50), percentile(0.90), percentile(0.99))
+		if expected := p.abandonedExpected.Load(); expected > 0 {
+			fmt.Printf("  %-10s abandoned after reading %d of %d bytes (%.1f%%)\n",
+				"", p.abandonedReceived.Load(), expected, float64(p.abandonedReceived.Load())/float64(expected)*100)
+		}
+	}
+	fmt.Println()
+}
@@ -0,0 +1,252 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+const (
+	dashboardTick    = time.Second
+	dashboardHistory = 120 // samples kept for the rolling charts
+	chartLabelWidth  = 28
+)
+
+// sparkBlocks are the bar heights used by the rolling charts, lowest first.
+var sparkBlocks = []rune("▁▂▃▄▅▆▇█")
+
+// isTerminal reports whether f is attached to a terminal rather than a pipe or
+// file, e.g. when the load test runs in CI.
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	if err != nil {
+		return false
+	}
+	return info.Mode()&os.ModeCharDevice != 0
+}
+
+// DashboardSample is one tick of live metrics. Latencies are in milliseconds
+// over the requests that completed during the tick; -1 means none did.
+type DashboardSample struct {
+	RPS         float64
+	FastP50     float64
+	FastP99     float64
+	SlowP50     float64
+	SlowP99     float64
+	SuccessRate float64 // percent of requests finished during the tick
+	InFlight    int64
+}
+
+// sampler turns the cumulative Stats into per-tick deltas.
+type sampler struct {
+	stats       *Stats
+	lastTime    time.Time
+	lastSuccess int64
+	lastFailed  int64
+	lastFast    int
+	lastSlow    int
+}
+
+func (s *sampler) sample(now time.Time) DashboardSample {
+	success := s.stats.successRequests.Load()
+	failed := s.stats.failedRequests.Load()
+
+	s.stats.latenciesMutex.Lock()
+	fast := sortedCopy(s.stats.fastLatencies[s.lastFast:])
+	slow := sortedCopy(s.stats.slowLatencies[s.lastSlow:])
+	s.lastFast = len(s.stats.fastLatencies)
+	s.lastSlow = len(s.stats.slowLatencies)
+	s.stats.latenciesMutex.Unlock()
+
+	finished := (success - s.lastSuccess) + (failed - s.lastFailed)
+	sample := DashboardSample{
+		RPS:         float64(finished) / now.Sub(s.lastTime).Seconds(),
+		FastP50:     windowPercentile(fast, 0.50),
+		FastP99:     windowPercentile(fast, 0.99),
+		SlowP50:     windowPercentile(slow, 0.50),
+		SlowP99:     windowPercentile(slow, 0.99),
+		SuccessRate: -1,
+		InFlight:    s.stats.inFlight.Load(),
+	}
+	if finished > 0 {
+		sample.SuccessRate = float64(success-s.lastSuccess) / float64(finished) * 100
+	}
+
+	s.lastTime = now
+	s.lastSuccess = success
+	s.lastFailed = failed
+	return sample
+}
+
+func windowPercentile(sorted []int64, percentile float64) float64 {
+	if len(sorted) == 0 {
+		return -1
+	}
+	return float64(calculatePercentile(sorted, percentile))
+}
+
+type tickMsg time.Time
+
+type doneMsg struct{}
+
+// dashboardModel is the bubbletea model behind -tui.
+type dashboardModel struct {
+	sampler     *sampler
+	start       time.Time
+	duration    time.Duration
+	history     []DashboardSample
+	width       int
+	interrupted bool
+}
+
+func (m dashboardModel) Init() tea.Cmd {
+	return tick()
+}
+
+func tick() tea.Cmd {
+	return tea.Tick(dashboardTick, func(t time.Time) tea.Msg {
+		return tickMsg(t)
+	})
+}
+
+func (m dashboardModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	switch msg := msg.(type) {
+	case tea.KeyMsg:
+		switch msg.String() {
+		case "ctrl+c", "q":
+			m.interrupted = true
+			return m, tea.Quit
+		}
+	case tea.WindowSizeMsg:
+		m.width = msg.Width
+	case tickMsg:
+		m.history = append(m.history, m.sampler.sample(time.Time(msg)))
+		if len(m.history) > dashboardHistory {
+			m.history = m.history[len(m.history)-dashboardHistory:]
+		}
+		return m, tick()
+	case doneMsg:
+		return m, tea.Quit
+	}
+	return m, nil
+}
+
+func (m dashboardModel) View() string {
+	var b strings.Builder
+
+	elapsed := time.Since(m.start).Round(time.Second)
+	stats := m.sampler.stats
+	fmt.Fprintf(&b, "📊 Live dashboard  %s / %s  (q to quit)\n", elapsed, m.duration)
+	fmt.Fprintf(&b, "   Total: %d | Success: %d | Failed: %d | Fast: %d | Slow: %d\n\n",
+		stats.totalRequests.Load(), stats.successRequests.Load(), stats.failedRequests.Load(),
+		stats.fastRequests.Load(), stats.slowRequests.Load())
+
+	if len(m.history) == 0 {
+		b.WriteString("   waiting for the first sample...\n")
+		return b.String()
+	}
+
+	chartWidth := dashboardHistory
+	if m.width > 0 && m.width-chartLabelWidth < chartWidth {
+		chartWidth = max(m.width-chartLabelWidth, 10)
+	}
+	history := m.history
+	if len(history) > chartWidth {
+		history = history[len(history)-chartWidth:]
+	}
+
+	series := func(value func(DashboardSample) float64) []float64 {
+		values := make([]float64, len(history))
+		for i, s := range history {
+			values[i] = value(s)
+		}
+		return values
+	}
+	row := func(label, unit string, values []float64) {
+		current := values[len(values)-1]
+		text := "-"
+		if current >= 0 {
+			text = fmt.Sprintf("%.0f%s", current, unit)
+		}
+		fmt.Fprintf(&b, "%-14s %10s  %s\n", label, text, sparkline(values))
+	}
+
+	row("RPS", "", series(func(s DashboardSample) float64 { return s.RPS }))
+	row("Fast p50", "ms", series(func(s DashboardSample) float64 { return s.FastP50 }))
+	row("Fast p99", "ms", series(func(s DashboardSample) float64 { return s.FastP99 }))
+	row("Slow p50", "ms", series(func(s DashboardSample) float64 { return s.SlowP50 }))
+	row("Slow p99", "ms", series(func(s DashboardSample) float64 { return s.SlowP99 }))
+	row("Success rate", "%", series(func(s DashboardSample) float64 { return s.SuccessRate }))
+	row("In-flight", "", series(func(s DashboardSample) float64 { return float64(s.InFlight) }))
+	return b.String()
+}
+
+// sparkline renders values scaled to their maximum. Negative values (no data
+// for that tick) render as gaps.
+func sparkline(values []float64) string {
+	peak := 0.0
+	for _, v := range values {
+		peak = max(peak, v)
+	}
+
+	var b strings.Builder
+	for _, v := range values {
+		switch {
+		case v < 0:
+			b.WriteRune(' ')
+		case peak == 0:
+			b.WriteRune(sparkBlocks[0])
+		default:
+			level := int(v / peak * float64(len(sparkBlocks)-1))
+			b.WriteRune(sparkBlocks[level])
+		}
+	}
+	return b.String()
+}
+
+// Dashboard runs the live view while the test is in progress.
+type Dashboard struct {
+	program *tea.Program
+	done    chan struct{}
+}
+
+// startDashboard starts the live view and routes progress notes above it.
+// Quitting the dashboard with q or Ctrl+C aborts the test.
+func startDashboard(stats *Stats, duration time.Duration) *Dashboard {
+	now := time.Now()
+	model := dashboardModel{
+		sampler:  &sampler{stats: stats, lastTime: now},
+		start:    now,
+		duration: duration,
+	}
+	d := &Dashboard{
+		program: tea.NewProgram(model),
+		done:    make(chan struct{}),
+	}
+	printStatus = func(msg string) {
+		d.program.Println(msg)
+	}
+
+	go func() {
+		defer close(d.done)
+		final, err := d.program.Run()
+		if err != nil {
+			fmt.Printf("❌ Dashboard failed: %v\n", err)
+			return
+		}
+		if final.(dashboardModel).interrupted {
+			fmt.Println("⚠️  Interrupted")
+			os.Exit(130)
+		}
+	}()
+	return d
+}
+
+// Stop closes the live view and restores the terminal before results print.
+func (d *Dashboard) Stop() {
+	d.program.Send(doneMsg{})
+	<-d.done
+}
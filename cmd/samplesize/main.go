@@ -0,0 +1,119 @@
+// Command samplesize answers "how many users does this experiment need?"
+// before it runs. Given a baseline conversion rate and the smallest change
+// worth detecting, it computes the users each variant needs for a two-sided
+// two-proportion z-test at the chosen confidence and power:
+//
+//	go run ./cmd/samplesize -baseline 0.05 -mde 0.01
+package main
+
+import (
+	"flag"
+	"fmt"
+	"math"
+	"os"
+)
+
+func main() {
+	baseline := flag.Float64("baseline", 0, "Baseline conversion rate of the control, e.g. 0.05 for 5%")
+	mde := flag.Float64("mde", 0, "Minimum detectable effect: the smallest lift in conversion rate worth detecting, e.g. 0.01 for 1 percentage point")
+	relative := flag.Bool("relative", false, "Read -mde as a fraction of -baseline, e.g. 0.1 for a 10% lift")
+	confidence := flag.Float64("confidence", 0.95, "Confidence level, 1 - alpha (two-sided)")
+	power := flag.Float64("power", 0.8, "Power: the chance of detecting an effect of -mde when there is one")
+	variants := flag.Int("variants", 2, "Variants in the experiment, control included; with more than 2, alpha is split over the comparisons with the control (Bonferroni)")
+	exposure := flag.Float64("exposure", 100, "Server -exposure: percentage of users bucketed into the experiment, to size the traffic needed")
+	flag.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: samplesize -baseline <rate> -mde <effect> [flags]")
+		fmt.Fprintln(os.Stderr)
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if *baseline <= 0 || *baseline >= 1 {
+		fail("-baseline must be between 0 and 1, got %g", *baseline)
+	}
+	if *mde <= 0 {
+		fail("-mde must be positive, got %g", *mde)
+	}
+	effect := *mde
+	if *relative {
+		effect = *mde * *baseline
+	}
+	target := *baseline + effect
+	if target >= 1 {
+		fail("-baseline plus -mde must stay below 1, got %g", target)
+	}
+	if *confidence <= 0 || *confidence >= 1 {
+		fail("-confidence must be between 0 and 1, got %g", *confidence)
+	}
+	if *power <= 0 || *power >= 1 {
+		fail("-power must be between 0 and 1, got %g", *power)
+	}
+	if *variants < 2 {
+		fail("-variants must be at least 2 (a control and a treatment), got %d", *variants)
+	}
+	if *exposure <= 0 || *exposure > 100 {
+		fail("-exposure must be above 0 and at most 100, got %g", *exposure)
+	}
+
+	comparisons := *variants - 1
+	alpha := (1 - *confidence) / float64(comparisons)
+	perVariant := usersPerVariant(*baseline, target, alpha, *power)
+	total := perVariant * int64(*variants)
+
+	fmt.Println("Sample size (two-sided two-proportion z-test):")
+	fmt.Printf("  Baseline:     %.2f%%\n", *baseline*100)
+	fmt.Printf("  Detects:      %.2f%% → %.2f%% (%+.2f points, %+.1f%% relative)\n",
+		*baseline*100, target*100, effect*100, effect / *baseline * 100)
+	if comparisons > 1 {
+		fmt.Printf("  Confidence:   %g%% (alpha %.4g per comparison, Bonferroni over %d comparisons with the control)\n",
+			*confidence*100, alpha, comparisons)
+	} else {
+		fmt.Printf("  Confidence:   %g%% (alpha %.4g)\n", *confidence*100, alpha)
+	}
+	fmt.Printf("  Power:        %g%%\n", *power*100)
+	fmt.Printf("  Variants:     %d, control included\n", *variants)
+	fmt.Println()
+	fmt.Printf("  Per variant:  %s users\n", thousands(perVariant))
+	fmt.Printf("  Total:        %s users\n", thousands(total))
+	if *exposure < 100 {
+		// Only exposed users are bucketed, so the rest of the traffic counts for nothing
+		reaching := int64(math.Ceil(float64(total) * 100 / *exposure))
+		fmt.Printf("  At -exposure %g: %s users reaching the server\n", *exposure, thousands(reaching))
+	}
+	fmt.Println()
+	fmt.Printf("Check the split holds at this size: go run ./cmd/allocationtest -users %d\n", total)
+}
+
+// usersPerVariant returns the users each group needs to tell conversion rate
+// p2 from p1 with a two-sided z-test at significance alpha and the given
+// power, by the standard normal-approximation formula without continuity
+// correction.
+func usersPerVariant(p1, p2, alpha, power float64) int64 {
+	zAlpha := normalQuantile(1 - alpha/2)
+	zBeta := normalQuantile(power)
+	pooled := (p1 + p2) / 2
+	a := zAlpha * math.Sqrt(2*pooled*(1-pooled))
+	b := zBeta * math.Sqrt(p1*(1-p1)+p2*(1-p2))
+	d := p2 - p1
+	return int64(math.Ceil((a + b) * (a + b) / (d * d)))
+}
+
+// normalQuantile returns the z with P(Z ≤ z) = p for a standard normal Z.
+func normalQuantile(p float64) float64 {
+	return math.Sqrt2 * math.Erfinv(2*p-1)
+}
+
+// thousands formats n with comma separators, e.g. 24474 as "24,474".
+func thousands(n int64) string {
+	s := fmt.Sprint(n)
+	for i := len(s) - 3; i > 0; i -= 3 {
+		s = s[:i] + "," + s[i:]
+	}
+	return s
+}
+
+// fail reports a bad flag and exits with status 2, as flag errors do.
+func fail(format string, args ...interface{}) {
+	fmt.Printf("❌ "+format+"\n", args...)
+	os.Exit(2)
+}
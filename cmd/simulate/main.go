@@ -0,0 +1,301 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"math"
+	"math/rand"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/google/uuid"
+
+	"go-localization-large-backend/pkg/allocation"
+)
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(2)
+	}
+
+	switch os.Args[1] {
+	case "bias":
+		runBias(os.Args[2:])
+	case "hashes":
+		runHashes(os.Args[2:])
+	case "adaptive":
+		runAdaptive(os.Args[2:])
+	default:
+		usage()
+		os.Exit(2)
+	}
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "Usage: simulate <command> [flags]")
+	fmt.Fprintln(os.Stderr)
+	fmt.Fprintln(os.Stderr, "Commands:")
+	fmt.Fprintln(os.Stderr, "  bias      Measure bucket frequency deviation (modulo bias) over a synthetic population")
+	fmt.Fprintln(os.Stderr, "  hashes    Test every -hash-algorithm for a uniform split over the same population")
+	fmt.Fprintln(os.Stderr, "  adaptive  Compare small populations' splits with and without -adaptive-balance")
+}
+
+func runBias(args []string) {
+	fs := flag.NewFlagSet("bias", flag.ExitOnError)
+	users := fs.Int("users", 1000000, "Number of synthetic userIds to bucket")
+	buckets := fs.Int("buckets", 100, "Number of buckets (the server uses one bucket per loaded payload)")
+	population := fs.String("population", "uuid", "Synthetic userId shape: 'uuid' or 'sequential'")
+	seed := fs.Int64("seed", 1, "Seed for the synthetic population")
+	alpha := fs.Float64("alpha", 0.01, "Significance level for the chi-square test")
+	fs.Parse(args)
+
+	if *buckets < 2 || *users < *buckets {
+		fmt.Println("❌ -buckets must be at least 2 and -users at least -buckets")
+		os.Exit(2)
+	}
+
+	userID, err := populationFunc(*population, *users, *seed)
+	if err != nil {
+		fmt.Printf("❌ %v\n", err)
+		os.Exit(2)
+	}
+
+	fmt.Println("━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━")
+	fmt.Println("🎲 Allocation Bias Simulation")
+	fmt.Println("━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━")
+	fmt.Printf("Users: %d (%s)\n", *users, *population)
+	fmt.Printf("Buckets: %d\n", *buckets)
+	fmt.Printf("Significance level: %g\n", *alpha)
+	fmt.Println("━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━")
+	fmt.Println()
+
+	theory := allocation.Modulo32Bias(*buckets)
+	fmt.Println("Theoretical modulo bias (32-bit FNV-1a % buckets):")
+	if theory.Overweighted == 0 {
+		fmt.Println("  None - bucket count is a power of two")
+	} else {
+		fmt.Printf("  Overweighted buckets:  %d of %d\n", theory.Overweighted, *buckets)
+		fmt.Printf("  Relative bias:         %.3g (%.7f%%)\n", theory.RelativeBias, theory.RelativeBias*100)
+		fmt.Printf("  Users to detect it:    ~%.3g\n", theory.SamplesToDetect)
+	}
+	fmt.Println()
+
+	current := allocation.MeasureBias(allocation.Index, *buckets, *users, userID)
+	wide := allocation.MeasureBias(allocation.WideIndex, *buckets, *users, userID)
+
+	printBiasReport("Current mapping (FNV-1a 32-bit, modulo)", current, *alpha)
+	printBiasReport("Alternative mapping (64-bit hash, multiply-shift)", wide, *alpha)
+
+	fmt.Println("Assessment:")
+	switch {
+	case current.Detectable(*alpha) && !wide.Detectable(*alpha):
+		fmt.Println("  ❌ Bias detected in the current mapping; the wide mapping removes it.")
+		fmt.Println("     Switching mappings reassigns every user, so plan it between experiments.")
+	case current.Detectable(*alpha):
+		fmt.Println("  ⚠️  Both mappings deviate from uniform - check the population for clustering.")
+	default:
+		fmt.Println("  ✅ No statistically detectable bias in the current mapping.")
+		if theory.Overweighted > 0 && float64(*users) < theory.SamplesToDetect {
+			fmt.Printf("     Modulo bias exists in theory but needs ~%.3g users to show up.\n", theory.SamplesToDetect)
+		}
+	}
+	fmt.Println("━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━")
+}
+
+func runHashes(args []string) {
+	fs := flag.NewFlagSet("hashes", flag.ExitOnError)
+	users := fs.Int("users", 1000000, "Number of synthetic userIds to bucket")
+	buckets := fs.String("buckets", "2,5,20,100", "Comma-separated bucket counts to test")
+	population := fs.String("population", "uuid", "Synthetic userId shape: 'uuid' or 'sequential'")
+	seed := fs.Int64("seed", 1, "Seed for the synthetic population")
+	alpha := fs.Float64("alpha", 0.01, "Significance level for the whole run, split across its chi-square tests")
+	fs.Parse(args)
+
+	var counts []int
+	for _, field := range strings.Split(*buckets, ",") {
+		n, err := strconv.Atoi(strings.TrimSpace(field))
+		if err != nil || n < 2 || *users < n {
+			fmt.Printf("❌ Invalid -buckets %q: each count must be at least 2 and at most -users\n", field)
+			os.Exit(2)
+		}
+		counts = append(counts, n)
+	}
+	userID, err := populationFunc(*population, *users, *seed)
+	if err != nil {
+		fmt.Printf("❌ %v\n", err)
+		os.Exit(2)
+	}
+
+	fmt.Println("━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━")
+	fmt.Println("🎲 Hash Algorithm Distribution")
+	fmt.Println("━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━")
+	fmt.Printf("Users: %d (%s)\n", *users, *population)
+	// Bonferroni: with one test per algorithm and bucket count, testing each
+	// at -alpha would flag a fair hash by chance in most runs
+	names := allocation.HashAlgorithms()
+	perTest := *alpha / float64(len(names)*len(counts))
+	fmt.Printf("Significance level: %g (%.2g per test)\n", *alpha, perTest)
+	fmt.Println("━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━")
+	fmt.Printf("%-10s %8s %14s %10s %8s\n", "Algorithm", "Buckets", "Max deviation", "Chi-square", "p-value")
+
+	failed := 0
+	for _, name := range names {
+		mapper, err := allocation.MapperFor(name)
+		if err != nil {
+			fmt.Printf("❌ %v\n", err)
+			os.Exit(2)
+		}
+		for _, n := range counts {
+			r := allocation.MeasureBias(mapper, n, *users, userID)
+			mark := "✅"
+			if r.Detectable(perTest) {
+				mark = "❌"
+				failed++
+			}
+			fmt.Printf("%-10s %8d %13.2f%% %10.2f %8.4f %s\n", name, n, r.MaxDeviation*100, r.ChiSquare, r.PValue, mark)
+		}
+	}
+	fmt.Println("━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━")
+	if failed > 0 {
+		fmt.Printf("❌ %d split(s) deviate from uniform - a real problem fails again with another -seed\n", failed)
+		os.Exit(1)
+	}
+	fmt.Println("✅ Every algorithm splits the population uniformly")
+}
+
+func runAdaptive(args []string) {
+	fs := flag.NewFlagSet("adaptive", flag.ExitOnError)
+	users := fs.Int("users", 200, "Users per simulated population")
+	buckets := fs.Int("buckets", 5, "Number of buckets (the server uses one bucket per loaded payload)")
+	trials := fs.Int("trials", 200, "Number of populations to simulate, each with its own seed")
+	margin := fs.Float64("margin", 0.1, "Share of users near a bucket edge that may be nudged, as the server's -adaptive-margin")
+	algorithm := fs.String("algorithm", allocation.DefaultHashAlgorithm, "Hash algorithm: "+strings.Join(allocation.HashAlgorithms(), ", "))
+	seed := fs.Int64("seed", 1, "Seed for the first population; trial i uses seed+i")
+	fs.Parse(args)
+
+	if *buckets < 2 || *users < *buckets || *trials < 1 {
+		fmt.Println("❌ -buckets must be at least 2, -users at least -buckets and -trials positive")
+		os.Exit(2)
+	}
+	if _, err := allocation.NewBalancer(*algorithm, *margin, *users, nil, nil); err != nil {
+		fmt.Printf("❌ %v\n", err)
+		os.Exit(2)
+	}
+
+	fmt.Println("━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━")
+	fmt.Println("⚖️  Adaptive Balancing Simulation")
+	fmt.Println("━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━")
+	fmt.Printf("Populations: %d of %d users (random UUIDs)\n", *trials, *users)
+	fmt.Printf("Buckets: %d, algorithm: %s, margin: %g\n", *buckets, *algorithm, *margin)
+	fmt.Println("━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━")
+
+	expected := float64(*users) / float64(*buckets)
+	var strictDev, strictChi, adaptiveDev, adaptiveChi float64
+	moved, unstable, closer := 0, 0, 0
+	for t := 0; t < *trials; t++ {
+		userID, err := populationFunc("uuid", *users, *seed+int64(t))
+		if err != nil {
+			fmt.Printf("❌ %v\n", err)
+			os.Exit(2)
+		}
+		balancer, _ := allocation.NewBalancer(*algorithm, *margin, *users, nil, nil)
+		strict := make([]int, *buckets)
+		adaptive := make([]int, *buckets)
+		first := make([]int, *users)
+		for i := 0; i < *users; i++ {
+			natural, _, _ := allocation.Position(*algorithm, userID(i), *buckets)
+			strict[natural]++
+			bucket, from, _ := balancer.Assign(userID(i), *buckets)
+			adaptive[bucket]++
+			first[i] = bucket
+			if from >= 0 {
+				moved++
+			}
+		}
+		// Every user must keep their first bucket on later requests
+		for i := 0; i < *users; i++ {
+			if bucket, _, _ := balancer.Assign(userID(i), *buckets); bucket != first[i] {
+				unstable++
+			}
+		}
+		sd, sc := splitDeviation(strict, expected)
+		ad, ac := splitDeviation(adaptive, expected)
+		strictDev += sd
+		strictChi += sc
+		adaptiveDev += ad
+		adaptiveChi += ac
+		if ac < sc {
+			closer++
+		}
+	}
+
+	n := float64(*trials)
+	fmt.Printf("%-16s %18s %18s\n", "Assignment", "Mean max deviation", "Mean chi-square")
+	fmt.Printf("%-16s %17.2f%% %18.2f\n", "Strict hashing", strictDev/n*100, strictChi/n)
+	fmt.Printf("%-16s %17.2f%% %18.2f\n", "Adaptive", adaptiveDev/n*100, adaptiveChi/n)
+	fmt.Println()
+	fmt.Printf("Users nudged: %.2f%% (at most the %g%% near a bucket edge)\n", float64(moved)/(n*float64(*users))*100, *margin*100)
+	fmt.Printf("Populations split closer to even with adaptation: %d of %d\n", closer, *trials)
+	fmt.Println("━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━")
+	switch {
+	case unstable > 0:
+		fmt.Printf("❌ %d users got a different bucket on a later request\n", unstable)
+		os.Exit(1)
+	case adaptiveChi >= strictChi:
+		fmt.Println("❌ Adaptation didn't bring the split closer to even")
+		os.Exit(1)
+	}
+	fmt.Println("✅ Adaptation split populations closer to even, and every user kept their first bucket")
+}
+
+// splitDeviation returns how far bucket counts are from expected users each:
+// the largest deviation, relative to expected, and the chi-square statistic.
+func splitDeviation(counts []int, expected float64) (maxDeviation, chiSquare float64) {
+	for _, c := range counts {
+		d := float64(c) - expected
+		maxDeviation = max(maxDeviation, math.Abs(d)/expected)
+		chiSquare += d * d / expected
+	}
+	return maxDeviation, chiSquare
+}
+
+func printBiasReport(title string, r allocation.BiasReport, alpha float64) {
+	fmt.Printf("%s:\n", title)
+	fmt.Printf("  Expected per bucket:   %.1f\n", r.Expected)
+	fmt.Printf("  Min / Max bucket:      %d / %d\n", r.MinCount, r.MaxCount)
+	fmt.Printf("  Max deviation:         %.2f%%\n", r.MaxDeviation*100)
+	fmt.Printf("  Chi-square:            %.2f (df=%d)\n", r.ChiSquare, r.DegreesOfFreedom)
+	fmt.Printf("  p-value:               %.4f\n", r.PValue)
+	if r.Detectable(alpha) {
+		fmt.Println("  ❌ Deviation from uniform is statistically significant")
+	} else {
+		fmt.Println("  ✅ Consistent with a uniform split")
+	}
+	fmt.Println()
+}
+
+// populationFunc returns a generator for synthetic userIds. The IDs are
+// precomputed so both mappings bucket exactly the same population.
+func populationFunc(kind string, n int, seed int64) (func(i int) string, error) {
+	ids := make([]string, n)
+	switch kind {
+	case "uuid":
+		rng := rand.New(rand.NewSource(seed))
+		for i := range ids {
+			id, err := uuid.NewRandomFromReader(rng)
+			if err != nil {
+				return nil, err
+			}
+			ids[i] = id.String()
+		}
+	case "sequential":
+		for i := range ids {
+			ids[i] = fmt.Sprintf("user-%d", i)
+		}
+	default:
+		return nil, fmt.Errorf("unknown population %q (want 'uuid' or 'sequential')", kind)
+	}
+	return func(i int) string { return ids[i] }, nil
+}
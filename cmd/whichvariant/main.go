@@ -0,0 +1,178 @@
+// Command whichvariant explains which payload the server assigns a user,
+// without a running server. It loads the same payloads directory and applies
+// the same gates through pkg/allocation, so given the server's flags it prints
+// exactly what /experiment would serve:
+//
+//	go run ./cmd/whichvariant -exposure 20 -control-payload small_payload.json user-123
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+
+	"go-localization-large-backend/pkg/allocation"
+	"go-localization-large-backend/pkg/store"
+)
+
+// experimentID is the server's experiment, which -prerequisites are for.
+const experimentID = "exp-localization-v1"
+
+func main() {
+	dir := flag.String("dir", "payloads", "Payloads directory the server loads")
+	appVersion := flag.String("app-version", "", "Client app version the user's request sends (empty = not sent)")
+	exposure := flag.Float64("exposure", 100, "Server -exposure: percentage of users bucketed into the experiment")
+	controlPayload := flag.String("control-payload", "", "Server -control-payload: payload served to users outside -exposure")
+	appVersions := flag.String("app-version-range", "", "Server -app-version-range: semver range of app versions that can render the payloads")
+	fallbackPayload := flag.String("fallback-payload", "", "Server -fallback-payload: payload served to clients outside -app-version-range")
+	hashAlgorithm := flag.String("hash-algorithm", allocation.DefaultHashAlgorithm, "Server -hash-algorithm: hash that buckets users")
+	prerequisitesFile := flag.String("prerequisites", "", "Server -prerequisites: JSON file limiting the experiment to users in a variant of another experiment")
+	payloadTypes := flag.String("payload-types", "", "Server -payload-types: non-JSON extensions loaded as payloads, e.g. .html,.bin")
+	rollbackSpec := flag.String("rollbacks", "", "Server rollbacks (-rollbacks or GET /admin/rollbacks): disabled payloads and their fallbacks, <disabled>=<fallback>,...")
+	flag.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: whichvariant [flags] <userId>...")
+		fmt.Fprintln(os.Stderr)
+		fmt.Fprintln(os.Stderr, "Pass the same gating flags the server runs with.")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	// The store logs every file it loads; only the assignment matters here
+	log.SetOutput(io.Discard)
+	payloads := store.NewPayloadStore(*dir, store.Limits{})
+	types, err := store.ParseContentTypes(*payloadTypes)
+	if err != nil {
+		fmt.Printf("❌ Invalid -payload-types: %v\n", err)
+		os.Exit(2)
+	}
+	payloads.SetContentTypes(types)
+	if err := payloads.Load(); err != nil {
+		fmt.Printf("❌ Failed to load payloads: %v\n", err)
+		os.Exit(1)
+	}
+
+	if *exposure < 0 || *exposure > 100 {
+		fmt.Printf("❌ -exposure must be between 0 and 100, got %g\n", *exposure)
+		os.Exit(2)
+	}
+	mapper, err := allocation.MapperFor(*hashAlgorithm)
+	if err != nil {
+		fmt.Printf("❌ Invalid -hash-algorithm: %v\n", err)
+		os.Exit(2)
+	}
+	rules := allocation.Rules{ExposurePercent: *exposure, Mapper: mapper}
+	if *exposure < 100 {
+		if _, ok := payloads.Lookup(*controlPayload); !ok {
+			fmt.Printf("❌ -exposure below 100 needs -control-payload naming a loaded payload, got %q\n", *controlPayload)
+			os.Exit(2)
+		}
+	}
+	if *appVersions != "" {
+		r, err := allocation.ParseVersionRange(*appVersions)
+		if err != nil {
+			fmt.Printf("❌ Invalid -app-version-range: %v\n", err)
+			os.Exit(2)
+		}
+		if _, ok := payloads.Lookup(*fallbackPayload); !ok {
+			fmt.Printf("❌ -app-version-range needs -fallback-payload naming a loaded payload, got %q\n", *fallbackPayload)
+			os.Exit(2)
+		}
+		rules.AppVersions = &r
+	}
+	if *prerequisitesFile != "" {
+		data, err := os.ReadFile(*prerequisitesFile)
+		if err != nil {
+			fmt.Printf("❌ Failed to read -prerequisites: %v\n", err)
+			os.Exit(1)
+		}
+		if rules.Prerequisites, err = allocation.ParsePrerequisites(data, experimentID); err != nil {
+			fmt.Printf("❌ Invalid -prerequisites %s: %v\n", *prerequisitesFile, err)
+			os.Exit(2)
+		}
+		if _, ok := payloads.Lookup(*controlPayload); !ok {
+			fmt.Printf("❌ -prerequisites needs -control-payload naming a loaded payload, got %q\n", *controlPayload)
+			os.Exit(2)
+		}
+	}
+
+	rollbacks, err := allocation.ParseRollbacks(*rollbackSpec)
+	if err != nil {
+		fmt.Printf("❌ Invalid -rollbacks: %v\n", err)
+		os.Exit(2)
+	}
+
+	list := payloads.Payloads()
+	for i, userID := range flag.Args() {
+		if i > 0 {
+			fmt.Println()
+		}
+		decision := rules.Decide(userID, *appVersion, len(list))
+		explain(userID, *appVersion, rules, decision, list, *fallbackPayload, *controlPayload, rollbacks)
+	}
+}
+
+// explain prints each gate the user passed or failed, then the payload served.
+// The bucket is shown even when a gate applies, since it is what the user
+// would get if the gate were lifted.
+func explain(userID, appVersion string, rules allocation.Rules, d allocation.Decision, list []store.Payload, fallback, control string, rollbacks allocation.Rollbacks) {
+	fmt.Printf("User: %s\n", userID)
+
+	served := list[d.Bucket].Name
+	switch {
+	case rules.AppVersions == nil:
+		fmt.Println("  App version:  no -app-version-range, not gated")
+	case appVersion == "":
+		fmt.Printf("  App version:  not sent, outside %s → fallback\n", rules.AppVersions)
+	case !d.VersionSupported:
+		fmt.Printf("  App version:  %s outside %s → fallback\n", appVersion, rules.AppVersions)
+	default:
+		fmt.Printf("  App version:  %s in %s\n", appVersion, rules.AppVersions)
+	}
+
+	switch {
+	case rules.Prerequisites == nil:
+		fmt.Println("  Prerequisite: no -prerequisites, not gated")
+	case !d.VersionSupported:
+		fmt.Println("  Prerequisite: skipped (version gate applied first)")
+	case d.Eligible:
+		fmt.Printf("  Prerequisite: in %s\n", rules.Prerequisites.Requires)
+	default:
+		in := "not eligible for it"
+		if variant, ok := rules.Prerequisites.Variant(userID, rules.Prerequisites.Requires.ExperimentID); ok {
+			in = "in " + variant
+		}
+		fmt.Printf("  Prerequisite: %s, not %s → control\n", in, rules.Prerequisites.Requires)
+		served = control
+	}
+
+	switch {
+	case !d.VersionSupported:
+		fmt.Println("  Exposure:     skipped (version gate applied first)")
+		served = fallback
+	case !d.Eligible:
+		fmt.Println("  Exposure:     skipped (prerequisite gate applied first)")
+	case d.Exposed:
+		fmt.Printf("  Exposure:     exposed (%g%%)\n", rules.ExposurePercent)
+	default:
+		fmt.Printf("  Exposure:     not exposed (%g%%) → control\n", rules.ExposurePercent)
+		served = control
+	}
+
+	fmt.Printf("  Bucket:       %d of %d (%s)\n", d.Bucket, len(list), list[d.Bucket].Name)
+	if rolledBack, ok := rollbacks.Serve(list[d.Bucket].Name); ok {
+		if d.VersionSupported && d.Eligible && d.Exposed {
+			fmt.Printf("  Rollback:     %s disabled → %s\n", list[d.Bucket].Name, rolledBack)
+			served = rolledBack
+		} else {
+			fmt.Printf("  Rollback:     %s disabled, but a gate applied first\n", list[d.Bucket].Name)
+		}
+	}
+	fmt.Printf("  Serves:       %s\n", served)
+}
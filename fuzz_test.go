@@ -0,0 +1,57 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+
+	"go-localization-large-backend/pkg/allocation"
+)
+
+// FuzzExperimentHandler feeds /experiment arbitrary request bodies, and
+// well-formed bodies with arbitrary userIds and appVersions, with the app
+// version gate on. The handler must answer every one with a 200 or a 4xx:
+// a panic, a hang or a 5xx means a malformed request reached code that
+// trusted it. `make fuzz` runs it; each run of the handler is slow for a
+// fuzzer, so it caps how long new inputs are minimized.
+func FuzzExperimentHandler(f *testing.F) {
+	f.Add([]byte(`{"userId":"user-123"}`), "user-123", "2.4.1")
+	f.Add([]byte(`{"userId":"user-123","appVersion":"1.0.0-beta.1+build.5"}`), "", "")
+	f.Add([]byte(`{"userId":null,"appVersion":7}`), "👩‍💻", "v2")
+	f.Add([]byte(`{"userId":"`+strings.Repeat("x", 4096)+`"}`), strings.Repeat("x", 4096), "9999999999999999999999.0.0")
+	f.Add([]byte(`[]`), "\x00", "1.2.3-")
+	f.Add([]byte(`{"userId":`), " ", "01.2.3")
+	f.Add([]byte{}, "user\n123", "1.2.3-alpha..1")
+
+	app := newTestApp(f, testPayloads)
+	versions, err := allocation.ParseVersionRange(">=2.0.0")
+	if err != nil {
+		f.Fatal(err)
+	}
+	savedRange, savedFallback := appVersionRange, fallbackPayload
+	f.Cleanup(func() { appVersionRange, fallbackPayload = savedRange, savedFallback })
+	appVersionRange, fallbackPayload = &versions, "a.json"
+
+	f.Fuzz(func(t *testing.T, body []byte, userID, appVersion string) {
+		wellFormed, err := json.Marshal(map[string]string{"userId": userID, "appVersion": appVersion})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, b := range [][]byte{body, wellFormed} {
+			req := httptest.NewRequest(http.MethodPost, "/experiment", strings.NewReader(string(b)))
+			req.Header.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
+			resp, err := app.Test(req, 2000)
+			if err != nil {
+				t.Fatalf("body %.200q: %v", b, err)
+			}
+			resp.Body.Close()
+			if resp.StatusCode != http.StatusOK && (resp.StatusCode < 400 || resp.StatusCode > 499) {
+				t.Errorf("body %q: status %d, want 200 or a 4xx", b, resp.StatusCode)
+			}
+		}
+	})
+}
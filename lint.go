@@ -0,0 +1,186 @@
+package main
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"go-localization-large-backend/pkg/allocation"
+	"go-localization-large-backend/pkg/store"
+)
+
+// lintLevel is how serious a lint finding is. Errors are configs the server
+// would refuse or that can't do what was meant; warnings are legal but
+// probably unintended.
+type lintLevel string
+
+const (
+	lintError   lintLevel = "ERROR"
+	lintWarning lintLevel = "WARNING"
+)
+
+// lintFinding is one problem found by lintConfig.
+type lintFinding struct {
+	Level   lintLevel `json:"level"`
+	Check   string    `json:"check"` // short stable name, e.g. missing-payload
+	Message string    `json:"message"`
+}
+
+// String formats the finding as one greppable line, e.g.
+//
+//	ERROR experiment=exp-localization-v1 check=missing-payload: -control-payload "control.json" is not a loaded payload
+func (f lintFinding) String() string {
+	return fmt.Sprintf("%s experiment=%s check=%s: %s", f.Level, experimentID, f.Check, f.Message)
+}
+
+// lintOptions are the server flags that shape the experiment, as passed to
+// `validate -strict`.
+type lintOptions struct {
+	Exposure        float64
+	ControlPayload  string
+	AppVersionRange string
+	FallbackPayload string
+	Rollbacks       string
+	SchemaCheck     string
+	Prerequisites   string // file path
+}
+
+// lintConfig checks payloads and the server flags that will be run with them
+// for mistakes that load fine but break or defeat the experiment: flags naming
+// payloads that aren't loaded, gates that exclude everyone, variants users
+// can't tell apart, and experiments left with a single variant.
+func lintConfig(payloads []store.Payload, opts lintOptions) []lintFinding {
+	var findings []lintFinding
+	report := func(level lintLevel, check, format string, args ...interface{}) {
+		findings = append(findings, lintFinding{Level: level, Check: check, Message: fmt.Sprintf(format, args...)})
+	}
+
+	loaded := make(map[string]bool, len(payloads))
+	for _, p := range payloads {
+		loaded[p.Name] = true
+	}
+
+	switch {
+	case opts.Exposure < 0 || opts.Exposure > 100:
+		report(lintError, "exposure-range", "-exposure must be between 0 and 100, got %g", opts.Exposure)
+	case opts.Exposure == 0:
+		report(lintWarning, "zero-exposure", "-exposure is 0, so every user gets -control-payload and no one is in the experiment")
+	}
+	if opts.Exposure < 100 && !loaded[opts.ControlPayload] {
+		report(lintError, "missing-payload", "-control-payload %q is not a loaded payload (needed with -exposure below 100)", opts.ControlPayload)
+	}
+	if opts.Prerequisites != "" {
+		if data, err := os.ReadFile(opts.Prerequisites); err != nil {
+			report(lintError, "prerequisites", "can't read -prerequisites: %v", err)
+		} else if _, err := allocation.ParsePrerequisites(data, experimentID); err != nil {
+			report(lintError, "prerequisites", "invalid -prerequisites %s: %v", opts.Prerequisites, err)
+		}
+		if opts.Exposure >= 100 && !loaded[opts.ControlPayload] {
+			report(lintError, "missing-payload", "-control-payload %q is not a loaded payload (needed with -prerequisites)", opts.ControlPayload)
+		}
+	}
+	if opts.Exposure >= 100 && opts.Prerequisites == "" && opts.ControlPayload != "" {
+		report(lintWarning, "unused-flag", "-control-payload %q is set but never served, as -exposure is 100 and -prerequisites is unset", opts.ControlPayload)
+	}
+
+	if opts.AppVersionRange != "" {
+		if _, err := allocation.ParseVersionRange(opts.AppVersionRange); err != nil {
+			report(lintError, "app-version-range", "invalid -app-version-range: %v", err)
+		}
+		if !loaded[opts.FallbackPayload] {
+			report(lintError, "missing-payload", "-fallback-payload %q is not a loaded payload (needed with -app-version-range)", opts.FallbackPayload)
+		}
+	}
+	switch opts.SchemaCheck {
+	case "", schemaCheckOff, schemaCheckReject:
+	case schemaCheckFallback:
+		if !loaded[opts.FallbackPayload] && opts.AppVersionRange == "" {
+			report(lintError, "missing-payload", "-fallback-payload %q is not a loaded payload (needed with -schema-check fallback)", opts.FallbackPayload)
+		}
+	default:
+		report(lintError, "schema-check", "-schema-check must be 'off', 'reject' or 'fallback', got %q", opts.SchemaCheck)
+	}
+	if opts.FallbackPayload != "" && opts.AppVersionRange == "" && opts.SchemaCheck != schemaCheckFallback {
+		report(lintWarning, "unused-flag", "-fallback-payload %q is set but never served, as neither -app-version-range nor -schema-check fallback is", opts.FallbackPayload)
+	}
+
+	rollbacks, err := allocation.ParseRollbacks(opts.Rollbacks)
+	if err != nil {
+		report(lintError, "rollbacks", "invalid -rollbacks: %v", err)
+	}
+	for _, name := range sortedKeys(rollbacks) {
+		for _, payload := range []string{name, rollbacks[name]} {
+			if !loaded[payload] {
+				report(lintError, "missing-payload", "-rollbacks names %q, which is not a loaded payload", payload)
+			}
+		}
+	}
+
+	// Payloads with the same content split users between variants that are
+	// the same to them, usually a file copied and never edited. Content is
+	// compared minified, so formatting differences don't hide a copy.
+	byContent := make(map[[sha256.Size]byte][]string)
+	var order [][sha256.Size]byte
+	for _, p := range payloads {
+		minified, err := store.Minify([]byte(p.Content))
+		if err != nil {
+			minified = []byte(p.Content)
+		}
+		if string(minified) == "{}" {
+			report(lintWarning, "empty-payload", "payload %s is an empty object", p.Name)
+		}
+		sum := sha256.Sum256(minified)
+		if byContent[sum] == nil {
+			order = append(order, sum)
+		}
+		byContent[sum] = append(byContent[sum], p.Name)
+	}
+	for _, sum := range order {
+		if names := byContent[sum]; len(names) > 1 {
+			report(lintWarning, "duplicate-content", "payloads %s have identical content", strings.Join(names, ", "))
+		}
+	}
+
+	// Clients that need a newer schema than a variant has can't be served it,
+	// so variants on different schemas are compared over different clients
+	versions := make(map[int]bool)
+	for _, p := range payloads {
+		versions[p.SchemaVersion] = true
+	}
+	if len(versions) > 1 {
+		var sorted []int
+		for v := range versions {
+			sorted = append(sorted, v)
+		}
+		sort.Ints(sorted)
+		list := make([]string, len(sorted))
+		for i, v := range sorted {
+			list[i] = fmt.Sprint(v)
+		}
+		report(lintWarning, "schema-versions", "payloads have schema versions %s, so clients sending X-Min-Schema-Version can't get every variant", strings.Join(list, ", "))
+	}
+
+	// Every loaded payload is a variant; rollbacks move users off theirs. An
+	// experiment whose users all end up on one payload compares nothing.
+	served := make(map[string]bool)
+	for _, p := range payloads {
+		name, _ := rollbacks.Serve(p.Name)
+		served[name] = true
+	}
+	if len(served) == 1 && len(payloads) > 0 {
+		report(lintWarning, "single-variant", "every bucketed user gets the same payload, so the experiment compares nothing")
+	}
+
+	return findings
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
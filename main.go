@@ -1,97 +1,553 @@
 package main
 
 import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/csv"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
-	"hash/fnv"
+	"io"
 	"log"
+	"net"
+	"net/http"
 	"os"
-	"path/filepath"
-	"sort"
+	"os/signal"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
 
 	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/middleware/adaptor"
 	"github.com/gofiber/fiber/v2/middleware/logger"
 	"github.com/gofiber/fiber/v2/middleware/recover"
+	"github.com/gofiber/fiber/v2/middleware/requestid"
 
+	"go-localization-large-backend/pkg/allocation"
+	"go-localization-large-backend/pkg/audit"
+	"go-localization-large-backend/pkg/encoding/proto"
+	"go-localization-large-backend/pkg/idempotency"
+	"go-localization-large-backend/pkg/lifecycle"
+	"go-localization-large-backend/pkg/locale"
+	"go-localization-large-backend/pkg/metrics"
+	"go-localization-large-backend/pkg/middleware"
 	"go-localization-large-backend/pkg/model"
+	"go-localization-large-backend/pkg/store"
 )
 
-// Payload holds the name and content of a payload file
-type Payload struct {
-	Name    string
-	Content string
-}
+// payloadDir is where localization payloads are loaded from at startup
+const payloadDir = "payloads"
 
-var payloads []Payload
+// watchDebounce is how long the payloads directory must be quiet before a
+// change triggers a reload when -watch is enabled
+const watchDebounce = 500 * time.Millisecond
 
-func init() {
-	// Load all payload files from the payloads directory
-	payloadDir := "payloads"
-	entries, err := os.ReadDir(payloadDir)
-	if err != nil {
-		log.Fatalf("Failed to read payloads directory: %v", err)
+// experimentID identifies the experiment served by /experiment
+const experimentID = "exp-localization-v1"
+
+// headerConfigHash reports the experiment configuration that produced an
+// /experiment response, so clients comparing runs can tell when it changed
+const headerConfigHash = "X-Config-Hash"
+
+// headerIdempotencyKey lets clients mark retries of the same request, and
+// headerIdempotentReplayed marks a response served from an earlier request
+// with that key
+const (
+	headerIdempotencyKey     = "Idempotency-Key"
+	headerIdempotentReplayed = "Idempotent-Replayed"
+)
+
+// headerExperimentID, headerVariant and headerBucket repeat a bucketed user's
+// assignment from the /experiment body, so CDN and edge layers can route,
+// cache and log by variant without parsing it. headerBucketHash
+// ("<algorithm>:<hash>") and headerBucketCount show how the bucket was
+// computed, so anyone can recompute it.
+const (
+	headerExperimentID = "X-Experiment-Id"
+	headerVariant      = "X-Variant"
+	headerBucket       = "X-Bucket"
+	headerBucketHash   = "X-Bucket-Hash"
+	headerBucketCount  = "X-Bucket-Count"
+	// headerBucketNudgedFrom is the hash bucket -adaptive-balance moved the
+	// user from, sent only when it did
+	headerBucketNudgedFrom = "X-Bucket-Nudged-From"
+)
+
+// headerSchemaVersion reports the schema version of the payload served, and
+// clients send headerMinSchemaVersion with the oldest version they can parse
+const (
+	headerSchemaVersion    = "X-Schema-Version"
+	headerMinSchemaVersion = "X-Min-Schema-Version"
+)
+
+// headerPayloadSHA256 carries the hex SHA-256 of the payload served, as the
+// payload field of a JSON response carries it, so clients can tell a payload
+// cut short or corrupted in transit from a complete one
+const headerPayloadSHA256 = "X-Payload-SHA256"
+
+// headerPayloadName and headerExposed carry what a raw response can't put in
+// its body: the payload served, as in selectedPayloadName, and, when the
+// server reports it, whether the user was exposed
+const (
+	headerPayloadName = "X-Payload-Name"
+	headerExposed     = "X-Exposed"
+)
+
+// headerDegraded marks a response that served degradePayload in place of the
+// user's payload because the server was under load
+const headerDegraded = "X-Degraded"
+
+// Response modes, selected with -response-mode: how /experiment sends the
+// payload it chose
+const (
+	responseModeEnvelope = "envelope" // inside a model.Response, as JSON, JSON Lines or protobuf
+	responseModeRaw      = "raw"      // the payload's bytes as is, with its content type
+)
+
+// Schema checks, selected with -schema-check: what to do when the selected
+// payload's schema is older than a client's X-Min-Schema-Version
+const (
+	schemaCheckOff      = "off"      // serve it anyway
+	schemaCheckReject   = "reject"   // fail the request with 406
+	schemaCheckFallback = "fallback" // serve fallbackPayload if it is new enough, else 406
+)
+
+// mimeNDJSON is the Accept value that selects a JSON Lines /experiment response
+const mimeNDJSON = "application/x-ndjson"
+
+// mimeProtobuf is the Accept value that selects a protobuf /experiment
+// response when the server runs with -protobuf
+const mimeProtobuf = "application/x-protobuf"
+
+// ndjsonFlushEntries is how many payload entries a JSON Lines response writes
+// between flushes to the client
+const ndjsonFlushEntries = 256
+
+// mimeCSV is the Accept value that selects a CSV /admin/allocate-batch
+// response
+const mimeCSV = "text/csv"
+
+// maxAllocateBatch caps the userIds one /admin/allocate-batch request may
+// ask for, so a single request can't tie up a worker for long; 10k UUIDs
+// also fit comfortably within the 1MB body limit
+const maxAllocateBatch = 10000
+
+// allocateBatchFlushRows is how many rows an /admin/allocate-batch response
+// writes between flushes to the client
+const allocateBatchFlushRows = 1000
+
+var payloadStore *store.PayloadStore
+
+// basePath is the prefix every route is mounted under with -base-path, e.g.
+// "/loc/v1"; empty serves them at the root
+var basePath string
+
+// auditLog records every allocation when -audit-log is set; nil otherwise
+var auditLog *audit.Logger
+
+// variantResponses are the payloads served with their own status code and
+// Location, from -variant-responses, for redirect and error page experiments
+var variantResponses allocation.VariantResponses
+
+// serverMetrics tracks open connections and in-flight requests for /metrics
+var serverMetrics = metrics.NewCollector()
+
+// abandonDetector counts responses clients went away from mid-body
+var abandonDetector = middleware.NewAbandonDetector()
+
+// loadShedder rejects /experiment requests while the server is overloaded (nil
+// when shedding is disabled)
+var loadShedder *middleware.LoadShedder
+
+// loadDegrader marks /experiment requests for degradePayload while the server
+// is under load (nil when degradation is disabled)
+var loadDegrader *middleware.LoadDegrader
+
+// degradePayload names the lightweight payload served instead of the selected
+// one to requests loadDegrader marks
+var degradePayload string
+
+// idempotencyCache remembers assignments by Idempotency-Key when
+// -idempotency-ttl is set; nil otherwise
+var idempotencyCache *idempotency.Cache[assignment]
+
+// ipLimiter rejects /experiment requests from remote IPs holding too many
+// connections (nil when the per-IP limit is disabled)
+var ipLimiter *middleware.IPConnLimiter
+
+// exposurePercent is the share of users bucketed into the experiment; the rest
+// get controlPayload. 100 exposes everyone.
+var exposurePercent float64 = 100
+
+// controlPayload names the payload served to unexposed users
+var controlPayload string
+
+// reloadLint holds the gating flags as validate -strict takes them, so
+// POST /reload lints new payloads exactly as validate would. Rollbacks are
+// filled in per reload, as they change at runtime. Nil when payloads are
+// generated, as there is no directory to reload.
+var reloadLint *lintOptions
+
+// prerequisites, when set, limits the experiment to users in a variant of
+// another experiment; ineligible users get controlPayload
+var prerequisites *allocation.Prerequisites
+
+// appVersionRange, when set, is the range of client app versions that can
+// render the experiment's payloads; other clients get fallbackPayload
+var appVersionRange *allocation.VersionRange
+
+// fallbackPayload names the payload served to clients outside appVersionRange
+var fallbackPayload string
+
+// schemaCheck is how clients sending X-Min-Schema-Version are protected from
+// payloads they can't parse (see the schemaCheck constants)
+var schemaCheck = schemaCheckOff
+
+// responseMode is how /experiment sends payloads (see the responseMode
+// constants)
+var responseMode = responseModeEnvelope
+
+// hashAlgorithm names the hash that buckets users, and bucketMapper is its
+// Mapper. Anything but allocation.DefaultHashAlgorithm reshuffles every user.
+var hashAlgorithm = allocation.DefaultHashAlgorithm
+var bucketMapper allocation.Mapper = allocation.Index
+
+// balancer, when -adaptive-balance is set, nudges new users near a bucket edge
+// toward the emptier neighbouring bucket and keeps every user it assigned in
+// their first bucket
+var balancer *allocation.Balancer
+
+// warnBalanceJournal logs the first failure to journal an adaptive assignment,
+// after which the balancer stops adapting
+var warnBalanceJournal sync.Once
+
+// rollbacks are the payloads disabled at runtime with -rollbacks or
+// /admin/rollbacks, each mapped to the payload its users get instead. The
+// admin endpoint swaps in a new value on every change.
+var rollbacks atomic.Pointer[allocation.Rollbacks]
+
+// rollbacksMu serializes changes to rollbacks, so two admin requests can't
+// both start from the same value and lose one change
+var rollbacksMu sync.Mutex
+
+// availableLocales are the locales the server negotiates Accept-Language
+// against for the Content-Language header, in default-first order (empty
+// disables negotiation)
+var availableLocales []string
+
+// responseThrottle caps /experiment response bodies at this many bytes per
+// second when -throttle-bps is set (0 = unthrottled)
+var responseThrottle int64
+
+// cpuWorkRounds is the number of SHA-256 rounds the experiment handler runs per
+// request to simulate CPU-heavy allocation logic (0 = none)
+var cpuWorkRounds int
+
+// serverState is the server's lifecycle phase, reported by /health and
+// /health/deep
+var serverState = lifecycle.New()
+
+func main() {
+	// Subcommands run instead of the server
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "validate":
+			os.Exit(runValidate(os.Args[2:]))
+		case "bench-serving":
+			os.Exit(runBenchServing(os.Args[2:]))
+		}
 	}
 
-	// Collect and sort payload names for deterministic ordering
-	var payloadNames []string
-	for _, entry := range entries {
-		if !entry.IsDir() && strings.HasSuffix(entry.Name(), ".json") {
-			payloadNames = append(payloadNames, entry.Name())
+	authToken := flag.String("auth-token", "", "Bearer token required on /experiment (empty disables auth)")
+	adminUI := flag.Bool("admin-ui", false, "Serve a read-only HTML view of the experiment at /admin, behind HTTP Basic auth with -auth-token as the password")
+	watch := flag.Bool("watch", false, "Reload payloads automatically when files in the payloads directory change")
+	maxPayloadFiles := flag.Int("max-payload-files", 1000, "Maximum number of payload files to load (0 = unlimited)")
+	maxPayloadMB := flag.Int64("max-payload-mb", 512, "Maximum combined size of payload files in MiB, JSON files counted twice with -ndjson (0 = unlimited)")
+	auditLogPath := flag.String("audit-log", "", "Append a JSONL record of every allocation to this file ('-' for stdout, empty disables)")
+	auditBuffer := flag.Int("audit-buffer", 4096, "Audit records queued before new ones are dropped")
+	variantResponsesFile := flag.String("variant-responses", "", "JSON file mapping payloads to the status code and Location they are served with, e.g. a 302 for a redirect variant")
+	flag.IntVar(&cpuWorkRounds, "cpu-work", 0, "SHA-256 rounds per request to simulate CPU-bound allocation work (0 = none)")
+	payloadChecksums := flag.String("payload-checksums", "", "SHA-256 manifest (sha256sum format) that payload files must match on load and reload")
+	loadHeader := flag.Bool("load-header", false, "Report open connections and in-flight requests in an X-Server-Load response header")
+	shedHigh := flag.Int64("shed-high", 0, "Shed /experiment requests with 503 when in-flight requests exceed this (0 disables shedding)")
+	idempotencyTTL := flag.Duration("idempotency-ttl", 0, "Serve retries with the same Idempotency-Key header the original assignment for this long, without counting them again (0 disables)")
+	maxConnsPerIP := flag.Int("max-conns-per-ip", 0, "Reject /experiment requests with 503 from a remote IP holding more open connections than this (0 disables the limit)")
+	shedLow := flag.Int64("shed-low", 0, "Stop shedding once in-flight requests drop below this (default: 3/4 of -shed-high)")
+	degradeHigh := flag.Int64("degrade-high", 0, "Serve -degrade-payload instead of the selected payload when in-flight requests exceed this (0 disables degradation)")
+	degradeLow := flag.Int64("degrade-low", 0, "Stop degrading once in-flight requests drop below this (default: 3/4 of -degrade-high)")
+	flag.StringVar(&degradePayload, "degrade-payload", "", "Lightweight payload served under load with -degrade-high, e.g. small_payload.json")
+	chaosDelay := flag.String("chaos-delay", "", "CHAOS TESTING ONLY: delay /experiment responses by a fixed duration or a random one in a range, e.g. 100ms-2s")
+	chaosFraction := flag.Float64("chaos-fraction", 1.0, "Fraction of /experiment requests affected by -chaos-delay (0-1)")
+	flag.Float64Var(&exposurePercent, "exposure", 100, "Percentage of users bucketed into the experiment; the rest get -control-payload")
+	flag.StringVar(&controlPayload, "control-payload", "", "Payload served to users outside -exposure, e.g. small_payload.json")
+	prerequisitesFile := flag.String("prerequisites", "", "JSON file limiting the experiment to users in a variant of another experiment; the rest get -control-payload")
+	flag.Int64Var(&responseThrottle, "throttle-bps", 0, "CHAOS TESTING ONLY: send each /experiment response body at most this many bytes per second (0 = unthrottled)")
+	errorRate := flag.Float64("error-rate", 0, "CHAOS TESTING ONLY: fraction of /experiment requests to fail with 500 (0-1)")
+	errorMode := flag.String("error-mode", "random", "How -error-rate picks requests: 'random' or 'counter' (exactly every 1/rate-th request)")
+	appVersions := flag.String("app-version-range", "", "Semver range of app versions that can render the payloads, e.g. '>=2.0.0' (others get -fallback-payload)")
+	locales := flag.String("locales", "", "Comma-separated locales to negotiate from Accept-Language into Content-Language, default first, e.g. en-US,fr-FR")
+	rollbackSpec := flag.String("rollbacks", "", "Disable payloads at startup, serving their users another payload: <disabled>=<fallback>,..., e.g. variant_b.json=small_payload.json")
+	flag.StringVar(&fallbackPayload, "fallback-payload", "", "Payload served to clients outside -app-version-range or without an appVersion")
+	flag.StringVar(&schemaCheck, "schema-check", schemaCheckOff, "What to do when the selected payload's schemaVersion is below the client's X-Min-Schema-Version: 'off' (serve it), 'reject' (406) or 'fallback' (serve -fallback-payload if it is new enough, else 406)")
+	flag.StringVar(&hashAlgorithm, "hash-algorithm", allocation.DefaultHashAlgorithm, "Hash that buckets users: "+strings.Join(allocation.HashAlgorithms(), ", ")+" (anything but the default reshuffles every user; needs -reshuffle-users)")
+	reshuffleUsers := flag.Bool("reshuffle-users", false, "Confirm that a non-default -hash-algorithm may move every user to a different payload, or that -adaptive-balance starting without state may move users assigned before it")
+	adaptiveBalance := flag.Bool("adaptive-balance", false, "Nudge new users near a bucket edge into the neighbouring bucket when it has fewer users, so small populations split closer to evenly (needs -adaptive-state)")
+	adaptiveState := flag.String("adaptive-state", "", "JSONL file -adaptive-balance journals every assignment it makes to, and restores them from on startup")
+	adaptiveMargin := flag.Float64("adaptive-margin", 0.1, "Share of users, those nearest a bucket edge, that -adaptive-balance may nudge (0-1]")
+	adaptiveMaxUsers := flag.Int("adaptive-max-users", 10000, "Users -adaptive-balance remembers; later users get their hash bucket")
+	loadConcurrency := flag.Int("load-concurrency", 0, "Payload files loaded, encoded and warmed at once (0 = GOMAXPROCS); lower it to cap the startup CPU spike")
+	warmBackground := flag.Bool("warm-background", false, "Warm payloads in the background after loading them, so the server starts listening without waiting for it")
+	generatePayloads := flag.String("generate-payloads", "", "Serve synthetic payloads instead of the payloads directory: <sizeKB>,<count>, e.g. 1024,5")
+	generateSeed := flag.Int64("generate-seed", 1, "Seed for -generate-payloads content")
+	slowRequestThreshold := flag.Duration("slow-request-threshold", 0, "Log a warning with timing and load details for requests slower than this, including the body transfer, e.g. 500ms (0 disables)")
+	payloadTypes := flag.String("payload-types", "", "Also load files with these extensions as payloads served with their content type, inferred or declared, e.g. .html,.txt,.bin,.ftl=text/plain (needs -response-mode raw)")
+	flag.StringVar(&responseMode, "response-mode", responseModeEnvelope, "How /experiment sends a payload: 'envelope' (inside the JSON response) or 'raw' (its bytes as is, with its content type, and the assignment in headers)")
+	ndjson := flag.Bool("ndjson", false, "Also split payloads into entries and stream them as JSON Lines to clients that send Accept: application/x-ndjson")
+	protobuf := flag.Bool("protobuf", false, "Also encode payloads as protobuf and serve them to clients that send Accept: application/x-protobuf")
+	protocol := flag.String("protocol", "h1", "Protocol to serve: 'h1' (HTTP/1.1 on fasthttp) or 'h2c' (cleartext HTTP/2 and HTTP/1.1 on net/http)")
+	drainDelay := flag.Duration("drain-delay", 0, "On SIGINT or SIGTERM, keep serving this long while /health reports draining, so load balancers stop sending traffic before the listener closes")
+	drainTimeout := flag.Duration("drain-timeout", 10*time.Second, "On shutdown, how long in-flight requests get to finish before the server exits")
+	profileDir := flag.String("profile-dir", os.TempDir(), "Directory SIGUSR1 writes a heap profile and a 30s CPU profile to")
+	flag.StringVar(&basePath, "base-path", "", "Prefix to mount every route under when behind a gateway, e.g. /loc/v1 (empty = serve at the root)")
+	flag.Parse()
+
+	if *protocol != "h1" && *protocol != "h2c" {
+		log.Fatalf("-protocol must be 'h1' or 'h2c', got %q", *protocol)
+	}
+	basePath = strings.TrimRight(basePath, "/")
+	if basePath != "" && (!strings.HasPrefix(basePath, "/") || strings.ContainsAny(basePath, ":*?# ")) {
+		log.Fatalf("-base-path must be a plain path starting with '/', e.g. /loc/v1, got %q", basePath)
+	}
+
+	if cpuWorkRounds > 0 {
+		log.Printf("Simulating CPU work: %d SHA-256 rounds per /experiment request", cpuWorkRounds)
+	}
+
+	// Load all payload files from the payloads directory, failing startup if
+	// the directory is over budget rather than risking an OOM
+	payloadStore = store.NewPayloadStore(payloadDir, store.Limits{
+		MaxFiles: *maxPayloadFiles,
+		MaxBytes: *maxPayloadMB * 1024 * 1024,
+	})
+	if *payloadChecksums != "" {
+		payloadStore.SetChecksumFile(*payloadChecksums)
+		log.Printf("Verifying payloads against %s", *payloadChecksums)
+	}
+	if *loadConcurrency < 0 {
+		log.Fatalf("-load-concurrency must not be negative, got %d", *loadConcurrency)
+	}
+	payloadStore.SetLoadConcurrency(*loadConcurrency)
+	if responseMode != responseModeEnvelope && responseMode != responseModeRaw {
+		log.Fatalf("-response-mode must be 'envelope' or 'raw', got %q", responseMode)
+	}
+	if types, err := store.ParseContentTypes(*payloadTypes); err != nil {
+		log.Fatalf("Invalid -payload-types: %v", err)
+	} else if types != nil {
+		// Only JSON fits in the envelope
+		if responseMode != responseModeRaw {
+			log.Fatalf("-payload-types needs -response-mode raw: only JSON payloads can be embedded in a JSON response")
+		}
+		payloadStore.SetContentTypes(types)
+		log.Printf("Loading non-JSON payloads: %s", store.FormatContentTypes(types))
+	}
+	if responseMode == responseModeRaw {
+		if *protobuf {
+			log.Fatalf("-protobuf encodes the JSON response, which -response-mode raw doesn't send")
 		}
+		if *ndjson {
+			log.Fatalf("-ndjson streams the JSON response, which -response-mode raw doesn't send")
+		}
+		log.Printf("Raw responses: /experiment sends each payload's bytes with its content type, the assignment in %s and the other headers", headerPayloadName)
+	}
+	if *ndjson {
+		payloadStore.SetEntries(true)
+		log.Printf("JSON Lines responses enabled for Accept: %s", mimeNDJSON)
+	}
+	if *protobuf {
+		payloadStore.SetProtobuf(true)
+		log.Printf("Protobuf responses enabled for Accept: %s", mimeProtobuf)
+	}
+	if *generatePayloads != "" {
+		// Synthetic payloads never touch the directory, so there is nothing
+		// to verify or watch
+		if *payloadChecksums != "" || *watch {
+			log.Fatalf("-generate-payloads can't be combined with -payload-checksums or -watch")
+		}
+		spec, err := store.ParseGenerateSpec(*generatePayloads)
+		if err != nil {
+			log.Fatalf("Invalid -generate-payloads: %v", err)
+		}
+		spec.Seed = *generateSeed
+		if err := payloadStore.LoadGenerated(spec); err != nil {
+			log.Fatalf("Failed to generate payloads: %v", err)
+		}
+	} else if err := payloadStore.Load(); err != nil {
+		log.Fatalf("Failed to load payloads: %v", err)
+	}
+	var warmed chan struct{}
+	if *warmBackground {
+		// Every payload is loaded by now, so all of them can be served while
+		// their pages are still being faulted in. The server stays starting
+		// until warming finishes
+		warmed = make(chan struct{})
+		go func() {
+			payloadStore.WarmCache()
+			close(warmed)
+		}()
+	} else {
+		payloadStore.WarmCache()
 	}
-	sort.Strings(payloadNames)
 
-	// Load each payload
-	for _, name := range payloadNames {
-		payloadPath := filepath.Join(payloadDir, name)
-		content, err := os.ReadFile(payloadPath)
+	if exposurePercent < 0 || exposurePercent > 100 {
+		log.Fatalf("-exposure must be between 0 and 100, got %g", exposurePercent)
+	}
+	if exposurePercent < 100 {
+		if _, ok := payloadStore.Lookup(controlPayload); !ok {
+			log.Fatalf("-exposure below 100 needs -control-payload naming a loaded payload, got %q", controlPayload)
+		}
+		log.Printf("Exposure: %g%% of users bucketed into the experiment, the rest get %s", exposurePercent, controlPayload)
+	}
+	if *prerequisitesFile != "" {
+		data, err := os.ReadFile(*prerequisitesFile)
 		if err != nil {
-			log.Printf("Warning: failed to load %s: %v", payloadPath, err)
-			continue
-		}
-
-		// Parse JSON to check structure
-		var parsed map[string]interface{}
-		if err := json.Unmarshal(content, &parsed); err != nil {
-			log.Printf("Warning: %s contains invalid JSON: %v", payloadPath, err)
-			continue
-		}
-
-		// Check if this JSON has a "payloads" array
-		if payloadsArray, ok := parsed["payloads"].([]interface{}); ok {
-			// Extract individual payloads from the array
-			log.Printf("Found payloads array in %s with %d items", name, len(payloadsArray))
-			for i, item := range payloadsArray {
-				itemBytes, err := json.Marshal(item)
-				if err != nil {
-					log.Printf("Warning: failed to marshal payload %d from %s: %v", i, name, err)
-					continue
-				}
-				payloads = append(payloads, Payload{
-					Name:    fmt.Sprintf("%s[%d]", name, i),
-					Content: string(itemBytes),
-				})
+			log.Fatalf("Failed to read -prerequisites: %v", err)
+		}
+		if prerequisites, err = allocation.ParsePrerequisites(data, experimentID); err != nil {
+			log.Fatalf("Invalid -prerequisites %s: %v", *prerequisitesFile, err)
+		}
+		if _, ok := payloadStore.Lookup(controlPayload); !ok {
+			log.Fatalf("-prerequisites needs -control-payload naming a loaded payload, got %q", controlPayload)
+		}
+		log.Printf("Prerequisite: only users in %s are eligible for the experiment, the rest get %s", prerequisites.Requires, controlPayload)
+	}
+	if *generatePayloads == "" {
+		reloadLint = &lintOptions{
+			Exposure:        exposurePercent,
+			ControlPayload:  controlPayload,
+			AppVersionRange: *appVersions,
+			FallbackPayload: fallbackPayload,
+			SchemaCheck:     schemaCheck,
+			Prerequisites:   *prerequisitesFile,
+		}
+	}
+
+	if *locales != "" {
+		for _, l := range strings.Split(*locales, ",") {
+			if l = strings.TrimSpace(l); l != "" {
+				availableLocales = append(availableLocales, l)
 			}
-			log.Printf("Loaded %d payloads from %s", len(payloadsArray), name)
-		} else {
-			// No "payloads" array, use the whole file as one payload
-			payloads = append(payloads, Payload{
-				Name:    name,
-				Content: string(content),
-			})
-			log.Printf("Loaded payload: %s (%d bytes)", name, len(content))
 		}
+		log.Printf("Locale negotiation enabled: %s (default %s)", strings.Join(availableLocales, ", "), availableLocales[0])
 	}
 
-	if len(payloads) == 0 {
-		log.Fatal("No payloads loaded")
+	if *idempotencyTTL < 0 {
+		log.Fatalf("-idempotency-ttl must not be negative, got %s", *idempotencyTTL)
+	}
+	if *idempotencyTTL > 0 {
+		idempotencyCache = idempotency.New[assignment](*idempotencyTTL)
+		log.Printf("Idempotency keys enabled: retries are replayed for %s", *idempotencyTTL)
+	}
+
+	if *appVersions != "" {
+		r, err := allocation.ParseVersionRange(*appVersions)
+		if err != nil {
+			log.Fatalf("Invalid -app-version-range: %v", err)
+		}
+		if _, ok := payloadStore.Lookup(fallbackPayload); !ok {
+			log.Fatalf("-app-version-range needs -fallback-payload naming a loaded payload, got %q", fallbackPayload)
+		}
+		appVersionRange = &r
+		log.Printf("App version gate: %s, other clients get %s", r, fallbackPayload)
+	}
+
+	if *variantResponsesFile != "" {
+		data, err := os.ReadFile(*variantResponsesFile)
+		if err != nil {
+			log.Fatalf("Failed to read -variant-responses: %v", err)
+		}
+		if variantResponses, err = allocation.ParseVariantResponses(data); err != nil {
+			log.Fatalf("Invalid -variant-responses %s: %v", *variantResponsesFile, err)
+		}
+		for _, name := range variantResponses.Payloads() {
+			if _, ok := payloadStore.Lookup(name); !ok {
+				log.Fatalf("-variant-responses names payload %q, which is not loaded", name)
+			}
+			log.Printf("Variant response: %s is served with status %d", name, variantResponses[name].StatusCode)
+		}
+	}
+
+	switch schemaCheck {
+	case schemaCheckOff:
+	case schemaCheckReject:
+		log.Printf("Schema check: clients whose X-Min-Schema-Version the selected payload doesn't meet get 406")
+	case schemaCheckFallback:
+		if _, ok := payloadStore.Lookup(fallbackPayload); !ok {
+			log.Fatalf("-schema-check fallback needs -fallback-payload naming a loaded payload, got %q", fallbackPayload)
+		}
+		log.Printf("Schema check: clients whose X-Min-Schema-Version the selected payload doesn't meet get %s, or 406 if it doesn't meet it either", fallbackPayload)
+	default:
+		log.Fatalf("-schema-check must be 'off', 'reject' or 'fallback', got %q", schemaCheck)
+	}
+
+	mapper, err := allocation.MapperFor(hashAlgorithm)
+	if err != nil {
+		log.Fatalf("Invalid -hash-algorithm: %v", err)
+	}
+	if hashAlgorithm != allocation.DefaultHashAlgorithm {
+		if !*reshuffleUsers {
+			log.Fatalf("-hash-algorithm %s assigns users differently from %s, moving most of them to another payload mid-experiment; pass -reshuffle-users to confirm", hashAlgorithm, allocation.DefaultHashAlgorithm)
+		}
+		bucketMapper = mapper
+		log.Printf("⚠️  HASH ALGORITHM CHANGED: bucketing users with %s instead of %s, so most users get a different payload than before. Start a new experiment rather than comparing against results from before the switch.",
+			hashAlgorithm, allocation.DefaultHashAlgorithm)
+	}
+
+	if *adaptiveBalance {
+		closeJournal, err := openBalancer(*adaptiveState, *adaptiveMargin, *adaptiveMaxUsers, *reshuffleUsers)
+		if err != nil {
+			log.Fatalf("Invalid -adaptive-balance: %v", err)
+		}
+		defer closeJournal()
+	}
+
+	initial, err := allocation.ParseRollbacks(*rollbackSpec)
+	if err != nil {
+		log.Fatalf("Invalid -rollbacks: %v", err)
+	}
+	if err := checkRollbackPayloads(initial); err != nil {
+		log.Fatalf("Invalid -rollbacks: %v", err)
+	}
+	rollbacks.Store(&initial)
+	for disabled, fallback := range initial {
+		log.Printf("Rollback: %s disabled, its users get %s", disabled, fallback)
+	}
+
+	if *watch {
+		stopWatch, err := payloadStore.Watch(watchDebounce, watchReload)
+		if err != nil {
+			log.Fatalf("Failed to watch %s: %v", payloadDir, err)
+		}
+		defer stopWatch()
+		log.Printf("Watching %s for changes", payloadDir)
+	}
+
+	if *auditLogPath != "" {
+		var err error
+		auditLog, err = audit.Open(*auditLogPath, *auditBuffer)
+		if err != nil {
+			log.Fatalf("Failed to open audit log: %v", err)
+		}
+		defer auditLog.Close()
+		log.Printf("Audit logging allocations to %s", *auditLogPath)
 	}
-	log.Printf("Loaded %d payloads total", len(payloads))
-}
 
-func main() {
 	// Create a new Fiber instance with slow client protections
 	app := fiber.New(fiber.Config{
 		AppName:               "Go Localization Backend",
@@ -122,61 +578,1232 @@ func main() {
 		// BodyLimit: Max request body size (1MB). Prevents memory exhaustion from
 		// clients sending huge request bodies.
 		BodyLimit: 1 * 1024 * 1024,
+
+		// Oversized bodies are refused by fasthttp before any handler runs, so
+		// the error handler is the only place to count them.
+		ErrorHandler: errorHandler,
 	})
 
 	// Middleware
 	app.Use(logger.New())
 	app.Use(recover.New())
+	app.Use(requestid.New())
+	app.Use(serverMetrics.Middleware(*loadHeader))
+	app.Use(abandonDetector.Handler())
+	app.Use(middleware.ProcessingTime(observeRequest))
+	if *slowRequestThreshold < 0 {
+		log.Fatalf("-slow-request-threshold must not be negative, got %s", *slowRequestThreshold)
+	}
+	if *slowRequestThreshold > 0 {
+		app.Use(middleware.SlowRequestLog(*slowRequestThreshold, serverMetrics.InFlight))
+		log.Printf("Logging requests slower than %s", *slowRequestThreshold)
+	}
+	// Gzipped request bodies are inflated here, within the same limit as
+	// uncompressed ones, before any handler parses them
+	app.Use(middleware.DecompressBody(app.Config().BodyLimit))
+
+	// Every route lives under -base-path, so a gateway can forward its
+	// prefix unchanged and probe the same /health URL clients see
+	var routes fiber.Router = app
+	if basePath != "" {
+		routes = app.Group(basePath)
+		log.Printf("Serving every route under %s", basePath)
+	}
+
+	// Health check endpoints
+	routes.Get("/health", healthCheck)
+	routes.Get("/health/deep", deepHealthCheck)
+
+	// Server load metrics. Resetting changes shared state, so it needs the
+	// bearer token when one is configured.
+	routes.Get("/metrics", metricsHandler)
+	resetHandlers := []fiber.Handler{metricsResetHandler}
+	if *authToken != "" {
+		resetHandlers = append([]fiber.Handler{middleware.BearerAuth(*authToken)}, resetHandlers...)
+	}
+	routes.Post("/metrics/reset", resetHandlers...)
+	routes.Get("/experiment/:id/stats", experimentStatsHandler)
+
+	// Rolling back a payload changes what users are served, so it needs the
+	// bearer token when one is configured, like resetting metrics
+	adminHandlers := func(h fiber.Handler) []fiber.Handler {
+		if *authToken != "" {
+			return []fiber.Handler{middleware.BearerAuth(*authToken), h}
+		}
+		return []fiber.Handler{h}
+	}
+	routes.Get("/admin/rollbacks", rollbacksHandler)
+	routes.Post("/admin/rollbacks", adminHandlers(disablePayloadHandler)...)
+	routes.Delete("/admin/rollbacks", adminHandlers(enablePayloadHandler)...)
+	routes.Delete("/cache/user/:userId", adminHandlers(evictUserHandler)...)
+	routes.Post("/admin/allocate-batch", adminHandlers(allocateBatchHandler)...)
+	routes.Post("/reload", adminHandlers(reloadHandler)...)
+	// The admin page is for browsers, which can prompt for Basic credentials
+	// but not a bearer token; it is never served without a secret
+	if *adminUI {
+		if *authToken == "" {
+			log.Fatalf("-admin-ui needs -auth-token, whose value is the admin page's password")
+		}
+		routes.Get("/admin", middleware.BasicAuth("admin", *authToken), adminUIHandler)
+		log.Printf("Admin UI enabled at %s/admin", basePath)
+	}
+
+	// Experiment endpoint, optionally behind bearer token auth. /health stays
+	// open so orchestrators can probe the server without credentials.
+	experimentHandlers := []fiber.Handler{experiment}
+	if *authToken != "" {
+		experimentHandlers = append([]fiber.Handler{middleware.BearerAuth(*authToken)}, experimentHandlers...)
+		log.Println("Bearer token auth enabled on /experiment")
+	}
+
+	// Chaos delay runs right before the handler, after auth and shedding
+	if *chaosDelay != "" {
+		delay, err := middleware.ParseDelayRange(*chaosDelay)
+		if err != nil {
+			log.Fatalf("Invalid -chaos-delay: %v", err)
+		}
+		if *chaosFraction <= 0 || *chaosFraction > 1 {
+			log.Fatalf("-chaos-fraction must be in (0, 1], got %g", *chaosFraction)
+		}
+		experimentHandlers = append(experimentHandlers[:len(experimentHandlers)-1],
+			middleware.ChaosDelay(delay, *chaosFraction), experiment)
+		log.Printf("⚠️  CHAOS DELAY ACTIVE: delaying %.0f%% of /experiment requests by %s. Do not run this in production.",
+			*chaosFraction*100, delay)
+	}
+
+	// Error injection also sits right before the handler, so shed or
+	// unauthorized requests are never counted as injected failures
+	if *errorRate > 0 {
+		if *errorRate > 1 {
+			log.Fatalf("-error-rate must be between 0 and 1, got %g", *errorRate)
+		}
+		if *errorMode != "random" && *errorMode != "counter" {
+			log.Fatalf("-error-mode must be 'random' or 'counter', got %q", *errorMode)
+		}
+		experimentHandlers = append(experimentHandlers[:len(experimentHandlers)-1],
+			middleware.ErrorInjection(*errorRate, *errorMode == "counter"), experiment)
+		log.Printf("⚠️  ERROR INJECTION ACTIVE: failing %.1f%% of /experiment requests with 500 (%s). Do not run this in production.",
+			*errorRate*100, *errorMode)
+	}
+
+	// Degradation only marks requests, so it runs after shedding: a shed
+	// request gets nothing to degrade
+	if *degradeHigh < 0 {
+		log.Fatalf("-degrade-high must not be negative, got %d", *degradeHigh)
+	}
+	if *degradeHigh > 0 {
+		low := *degradeLow
+		if low == 0 {
+			low = *degradeHigh * 3 / 4
+		}
+		if low <= 0 || low >= *degradeHigh {
+			log.Fatalf("-degrade-low must be between 1 and -degrade-high (%d), got %d", *degradeHigh, low)
+		}
+		if _, ok := payloadStore.Lookup(degradePayload); !ok {
+			log.Fatalf("-degrade-high needs -degrade-payload naming a loaded payload, got %q", degradePayload)
+		}
+		if *shedHigh > 0 && *degradeHigh >= *shedHigh {
+			log.Fatalf("-degrade-high (%d) must be below -shed-high (%d), or requests are shed before they are degraded", *degradeHigh, *shedHigh)
+		}
+		loadDegrader = middleware.NewLoadDegrader(*degradeHigh, low, serverMetrics.InFlight)
+		experimentHandlers = append([]fiber.Handler{loadDegrader.Handler()}, experimentHandlers...)
+		log.Printf("Load degradation enabled: serve %s above %d in-flight requests, resume below %d", degradePayload, *degradeHigh, low)
+	} else if degradePayload != "" {
+		log.Fatalf("-degrade-payload is only served with -degrade-high")
+	}
+
+	// Load shedding runs first so overloaded requests are rejected before any
+	// other work is done
+	if *shedHigh > 0 {
+		low := *shedLow
+		if low == 0 {
+			low = *shedHigh * 3 / 4
+		}
+		if low <= 0 || low >= *shedHigh {
+			log.Fatalf("-shed-low must be between 1 and -shed-high (%d), got %d", *shedHigh, low)
+		}
+		loadShedder = middleware.NewLoadShedder(*shedHigh, low, serverMetrics.InFlight)
+		experimentHandlers = append([]fiber.Handler{loadShedder.Handler()}, experimentHandlers...)
+		log.Printf("Load shedding enabled: shed above %d in-flight requests, resume below %d", *shedHigh, low)
+	}
 
-	// Health check endpoint
-	app.Get("/health", healthCheck)
+	// The per-IP limit runs before shedding, so one abusive host is turned
+	// away without its requests counting toward server-wide overload
+	if *maxConnsPerIP < 0 {
+		log.Fatalf("-max-conns-per-ip must not be negative, got %d", *maxConnsPerIP)
+	}
+	if *maxConnsPerIP > 0 {
+		ipLimiter = middleware.NewIPConnLimiter(*maxConnsPerIP)
+		experimentHandlers = append([]fiber.Handler{ipLimiter.Handler()}, experimentHandlers...)
+		log.Printf("Per-IP connection limit enabled: %d open connections per remote IP", *maxConnsPerIP)
+	}
+
+	// The throttle goes outermost, so shed and rejected responses are slow
+	// too, as they would be from a bandwidth-limited server
+	if responseThrottle < 0 {
+		log.Fatalf("-throttle-bps must not be negative, got %d", responseThrottle)
+	}
+	if responseThrottle > 0 {
+		experimentHandlers = append([]fiber.Handler{middleware.Throttle(responseThrottle)}, experimentHandlers...)
+		log.Printf("⚠️  RESPONSE THROTTLE ACTIVE: /experiment bodies sent at %d bytes/sec; bodies over %d bytes can't finish within the %s write timeout. Do not run this in production.",
+			responseThrottle, responseThrottle*int64(app.Config().WriteTimeout/time.Second), app.Config().WriteTimeout)
+	}
+	routes.Post("/experiment", experimentHandlers...)
+
+	if err := os.MkdirAll(*profileDir, 0755); err != nil {
+		log.Fatalf("Invalid -profile-dir: %v", err)
+	}
+	watchProfileSignal(*profileDir)
+
+	// Start server on a listener that counts open connections
+	ln, err := net.Listen("tcp", ":3000")
+	if err != nil {
+		log.Fatalf("Failed to listen: %v", err)
+	}
+	if ipLimiter != nil {
+		ln = ipLimiter.Listener(ln)
+	}
+	ln = serverMetrics.Listener(ln)
+	ln = abandonDetector.Listener(ln)
+
+	// Payloads are loaded and every flag is checked by now, so the server is
+	// ready from its first connection unless it is still warming payloads
+	if warmed == nil {
+		markReadyWhenWarm(nil)
+	} else {
+		go markReadyWhenWarm(warmed)
+	}
+	serve, shutdown := app.Listener, app.ShutdownWithTimeout
+	if *protocol == "h2c" {
+		serve, shutdown = serveH2C(app)
+	}
+	served := make(chan error, 1)
+	go func() { served <- serve(ln) }()
+
+	// SIGINT or SIGTERM drains the server: /health turns 503 while requests
+	// are still served for -drain-delay, then the listener closes and
+	// in-flight requests get -drain-timeout to finish
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	select {
+	case err := <-served:
+		log.Fatal(err)
+	case sig := <-signals:
+		serverState.Drain()
+		log.Printf("Received %s, draining for %s", sig, *drainDelay)
+		time.Sleep(*drainDelay)
+		if err := shutdown(*drainTimeout); err != nil {
+			log.Printf("Shutdown: %v", err)
+		}
+		log.Println("Server stopped")
+	}
+}
+
+// serveH2C serves app over cleartext HTTP/2 (and HTTP/1.1 for clients that
+// don't ask for HTTP/2) with net/http, since fasthttp only speaks HTTP/1.1.
+// HTTP/2 multiplexes requests as streams over one connection, so a slow
+// client holds a stream rather than a whole connection. Each request is
+// bridged into the Fiber app, which buffers the response body in full. The
+// timeouts match the Fiber config: net/http applies WriteTimeout per stream.
+// It returns functions to serve on a listener and to shut the server down,
+// like the app's own Listener and ShutdownWithTimeout.
+func serveH2C(app *fiber.App) (serve func(net.Listener) error, shutdown func(time.Duration) error) {
+	var protocols http.Protocols
+	protocols.SetHTTP1(true)
+	protocols.SetUnencryptedHTTP2(true)
+	server := &http.Server{
+		Handler:      http.MaxBytesHandler(adaptor.FiberApp(app), int64(app.Config().BodyLimit)),
+		Protocols:    &protocols,
+		ReadTimeout:  app.Config().ReadTimeout,
+		WriteTimeout: app.Config().WriteTimeout,
+		IdleTimeout:  app.Config().IdleTimeout,
+	}
+	serve = func(ln net.Listener) error {
+		log.Printf("Serving HTTP/1.1 and h2c (cleartext HTTP/2) on %s", ln.Addr())
+		return server.Serve(ln)
+	}
+	shutdown = func(timeout time.Duration) error {
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		defer cancel()
+		return server.Shutdown(ctx)
+	}
+	return serve, shutdown
+}
+
+// observeRequest records a finished request in serverMetrics: the processing
+// time of a served /experiment request, or the reason it was rejected.
+func observeRequest(c *fiber.Ctx, elapsed time.Duration) {
+	if c.Path() == basePath+"/experiment" && c.Response().StatusCode() == fiber.StatusOK {
+		serverMetrics.ObserveProcessing(elapsed)
+	}
+	if reason, ok := middleware.RejectionReason(c); ok {
+		serverMetrics.ObserveRejection(reason)
+	}
+}
 
-	// Experiment endpoint
-	app.Post("/experiment", experiment)
+// errorHandler is fiber's default error handler, counting and logging
+// request bodies over BodyLimit as rejections first.
+func errorHandler(c *fiber.Ctx, err error) error {
+	var e *fiber.Error
+	if errors.As(err, &e) && e.Code == fiber.StatusRequestEntityTooLarge {
+		middleware.LogRejection(c, e.Code, middleware.ReasonBodyTooLarge)
+		serverMetrics.ObserveRejection(middleware.ReasonBodyTooLarge)
+	}
+	return fiber.DefaultErrorHandler(c, err)
+}
 
-	// Start server
-	log.Fatal(app.Listen(":3000"))
+// markReadyWhenWarm moves the server to ready once warmed is closed, or at
+// once if it is nil, and logs how long startup took. A server that started
+// draining meanwhile stays draining.
+func markReadyWhenWarm(warmed <-chan struct{}) {
+	if warmed != nil {
+		<-warmed
+	}
+	if serverState.MarkReady() {
+		log.Printf("Ready after %s", serverState.Snapshot().StartupTime.Round(time.Millisecond))
+	}
 }
 
-// Health check handler
+// Health check handler. It answers 200 only while the server is ready, so
+// load balancers and the test tools don't send traffic to a server that is
+// starting or draining.
 func healthCheck(c *fiber.Ctx) error {
+	if phase := serverState.Phase(); phase != lifecycle.Ready {
+		return c.Status(fiber.StatusServiceUnavailable).JSON(fiber.Map{
+			"status":  phase.String(),
+			"message": "Server is not ready",
+		})
+	}
 	return c.Status(fiber.StatusOK).JSON(fiber.Map{
 		"status":  "ok",
 		"message": "Server is running",
 	})
 }
 
+// deepHealthResponse is the JSON body served by /health/deep
+type deepHealthResponse struct {
+	// Phase is starting, ready or draining
+	Phase      string    `json:"phase"`
+	StartedAt  time.Time `json:"startedAt"`
+	PhaseSince time.Time `json:"phaseSince"`
+	// StartupMs is how long the server took to become ready
+	StartupMs  float64 `json:"startupMs,omitempty"`
+	Payloads   int     `json:"payloads"`
+	ConfigHash string  `json:"configHash,omitempty"`
+}
+
+// Deep health check handler: the lifecycle phase and what the server is
+// serving, with the same status code as /health.
+func deepHealthCheck(c *fiber.Ctx) error {
+	snap := serverState.Snapshot()
+	resp := deepHealthResponse{
+		Phase:      snap.Phase.String(),
+		StartedAt:  snap.Started.UTC(),
+		PhaseSince: snap.Since.UTC(),
+		StartupMs:  float64(snap.StartupTime.Microseconds()) / 1000,
+	}
+	if snap.Phase != lifecycle.Starting {
+		resp.Payloads = len(payloadStore.Payloads())
+		resp.ConfigHash = experimentConfigHash()
+	}
+	status := fiber.StatusOK
+	if snap.Phase != lifecycle.Ready {
+		status = fiber.StatusServiceUnavailable
+	}
+	return c.Status(status).JSON(resp)
+}
+
+// metricsResponse is the JSON body served by /metrics
+type metricsResponse struct {
+	metrics.Snapshot
+	AuditDropped int64 `json:"auditDropped"`
+	// AbandonedResponses counts responses whose client closed the connection
+	// or stopped reading before the body was written
+	AbandonedResponses int64            `json:"abandonedResponses"`
+	LoadShedding       *sheddingInfo    `json:"loadShedding,omitempty"`
+	Degradation        *degradationInfo `json:"degradation,omitempty"`
+	IPLimit            *ipLimitInfo     `json:"ipLimit,omitempty"`
+}
+
+// sheddingInfo reports load shedder state when shedding is enabled
+type sheddingInfo struct {
+	Shedding bool  `json:"shedding"`
+	Shed     int64 `json:"shed"`
+}
+
+// degradationInfo reports load degrader state when degradation is enabled
+type degradationInfo struct {
+	Degrading bool   `json:"degrading"`
+	Degraded  int64  `json:"degraded"`
+	Payload   string `json:"payload"`
+}
+
+// ipLimitInfo reports per-IP connection limit rejections when the limit is
+// enabled, keyed by remote IP
+type ipLimitInfo struct {
+	MaxConnsPerIP int              `json:"maxConnsPerIP"`
+	Rejected      map[string]int64 `json:"rejected"`
+}
+
+// Metrics handler
+func metricsHandler(c *fiber.Ctx) error {
+	return c.JSON(newMetricsResponse(serverMetrics.Snapshot()))
+}
+
+// Metrics reset handler: returns the counters accumulated since the last reset
+// and starts a new window
+func metricsResetHandler(c *fiber.Ctx) error {
+	return c.JSON(newMetricsResponse(serverMetrics.SnapshotAndReset()))
+}
+
+func newMetricsResponse(snapshot metrics.Snapshot) metricsResponse {
+	response := metricsResponse{Snapshot: snapshot, AbandonedResponses: abandonDetector.Abandoned()}
+	if auditLog != nil {
+		response.AuditDropped = auditLog.Dropped()
+	}
+	if loadShedder != nil {
+		response.LoadShedding = &sheddingInfo{
+			Shedding: loadShedder.Shedding(),
+			Shed:     loadShedder.Shed(),
+		}
+	}
+	if loadDegrader != nil {
+		response.Degradation = &degradationInfo{
+			Degrading: loadDegrader.Degrading(),
+			Degraded:  loadDegrader.Degraded(),
+			Payload:   degradePayload,
+		}
+	}
+	if ipLimiter != nil {
+		response.IPLimit = &ipLimitInfo{
+			MaxConnsPerIP: ipLimiter.Limit(),
+			Rejected:      ipLimiter.Rejected(),
+		}
+	}
+	return response
+}
+
+// experimentStatsResponse is the JSON body served by /experiment/:id/stats:
+// the bytes served per variant since the start or the last metrics reset,
+// and their projection over a month at that rate
+type experimentStatsResponse struct {
+	ExperimentID  string                          `json:"experimentId"`
+	ConfigHash    string                          `json:"configHash"`
+	WindowSeconds float64                         `json:"windowSeconds"`
+	Variants      map[string]metrics.VariantBytes `json:"variants"`
+	Total         metrics.VariantBytes            `json:"total"`
+}
+
+// Experiment stats handler: per-variant bandwidth, for allocating CDN costs
+func experimentStatsHandler(c *fiber.Ctx) error {
+	if c.Params("id") != experimentID {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": fmt.Sprintf("unknown experiment %q", c.Params("id"))})
+	}
+	return c.JSON(newExperimentStatsResponse(serverMetrics.Snapshot()))
+}
+
+func newExperimentStatsResponse(snapshot metrics.Snapshot) experimentStatsResponse {
+	response := experimentStatsResponse{
+		ExperimentID:  experimentID,
+		ConfigHash:    experimentConfigHash(),
+		WindowSeconds: snapshot.WindowSeconds,
+		Variants:      snapshot.BytesServed,
+	}
+	for _, v := range snapshot.BytesServed {
+		response.Total.Responses += v.Responses
+		response.Total.Bytes += v.Bytes
+		response.Total.RawBytes += v.RawBytes
+	}
+	window := time.Duration(snapshot.WindowSeconds * float64(time.Second))
+	response.Total.ProjectedMonthlyBytes = metrics.ProjectMonthly(response.Total.Bytes, window)
+	return response
+}
+
+// rollbackRequest is the body of POST /admin/rollbacks
+type rollbackRequest struct {
+	Payload  string `json:"payload"`
+	Fallback string `json:"fallback"`
+}
+
+// rollbacksResponse is the JSON body served by the /admin/rollbacks endpoints:
+// every disabled payload with its fallback, and the config hash they produce
+type rollbacksResponse struct {
+	Rollbacks  allocation.Rollbacks `json:"rollbacks"`
+	ConfigHash string               `json:"configHash"`
+}
+
+// Rollbacks handler: lists the disabled payloads
+func rollbacksHandler(c *fiber.Ctx) error {
+	return c.JSON(rollbacksResponse{Rollbacks: *rollbacks.Load(), ConfigHash: experimentConfigHash()})
+}
+
+// Disable payload handler: serves the fallback to every user bucketed into
+// the payload, from the next request on
+func disablePayloadHandler(c *fiber.Ctx) error {
+	var req rollbackRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid request body"})
+	}
+	rollbacksMu.Lock()
+	defer rollbacksMu.Unlock()
+	updated, err := rollbacks.Load().With(req.Payload, req.Fallback)
+	if err == nil {
+		err = checkRollbackPayloads(allocation.Rollbacks{req.Payload: req.Fallback})
+	}
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	}
+	rollbacks.Store(&updated)
+	log.Printf("Rollback: %s disabled, its users get %s (from %s)", req.Payload, req.Fallback, c.IP())
+	return rollbacksHandler(c)
+}
+
+// Enable payload handler: undoes the rollback of the payload named by the
+// payload query parameter, so its users get it again
+func enablePayloadHandler(c *fiber.Ctx) error {
+	payload := c.Query("payload")
+	rollbacksMu.Lock()
+	defer rollbacksMu.Unlock()
+	current := rollbacks.Load()
+	if _, ok := (*current)[payload]; !ok {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": fmt.Sprintf("%q is not disabled", payload)})
+	}
+	updated := current.Without(payload)
+	rollbacks.Store(&updated)
+	log.Printf("Rollback: %s enabled again (from %s)", payload, c.IP())
+	return rollbacksHandler(c)
+}
+
+// cacheEvictionResponse is the JSON body served by DELETE /cache/user/:userId
+type cacheEvictionResponse struct {
+	UserID  string `json:"userId"`
+	Evicted int    `json:"evicted"`
+}
+
+// Evict user handler: drops every cached assignment for a user, so their next
+// request is allocated afresh. Assignments are a pure function of the userId
+// and the config, so that only changes what they get if the config changed
+// since the assignment was cached. The idempotency cache is the only per-user
+// cache; payload caches are per payload and shared by every user.
+func evictUserHandler(c *fiber.Ctx) error {
+	userID := c.Params("userId")
+	evicted := 0
+	if idempotencyCache != nil {
+		evicted = idempotencyCache.Evict(func(a assignment) bool { return a.userID == userID })
+	}
+	log.Printf("Cache: evicted %d cached assignment(s) for user %s (from %s)", evicted, userID, c.IP())
+	return c.JSON(cacheEvictionResponse{UserID: userID, Evicted: evicted})
+}
+
+// allocateBatchRequest is the body of POST /admin/allocate-batch
+type allocateBatchRequest struct {
+	UserIDs    []string `json:"userIds"`
+	AppVersion string   `json:"appVersion,omitempty"`
+}
+
+// batchAssignment is one row of an /admin/allocate-batch response, in the
+// same shape as cmd/export's output lines. Bucket is -1 when a gate applied.
+type batchAssignment struct {
+	UserID  string `json:"userId"`
+	Variant string `json:"variant"`
+	Bucket  int    `json:"bucket"`
+}
+
+// Allocate batch handler: streams the payload each listed user is assigned
+// under the running config, as JSON Lines or, for Accept: text/csv, CSV. It
+// goes through the same gates and rollbacks as /experiment, but serves
+// nothing, so nothing is audited or cached and -schema-check, which depends on
+// the client, doesn't apply.
+func allocateBatchHandler(c *fiber.Ctx) error {
+	var req allocateBatchRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid request body"})
+	}
+	if len(req.UserIDs) == 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "userIds is required"})
+	}
+	if len(req.UserIDs) > maxAllocateBatch {
+		return c.Status(fiber.StatusRequestEntityTooLarge).JSON(fiber.Map{
+			"error": fmt.Sprintf("at most %d userIds per batch, got %d", maxAllocateBatch, len(req.UserIDs)),
+		})
+	}
+	for i, id := range req.UserIDs {
+		if id == "" {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": fmt.Sprintf("userIds[%d] is empty", i)})
+		}
+	}
+
+	csvOut := c.Accepts(mimeNDJSON, mimeCSV) == mimeCSV
+	c.Vary(fiber.HeaderAccept)
+	c.Set(headerConfigHash, experimentConfigHash())
+	if csvOut {
+		c.Set(fiber.HeaderContentType, mimeCSV)
+	} else {
+		c.Set(fiber.HeaderContentType, mimeNDJSON)
+	}
+	log.Printf("Allocate batch: %d users (from %s)", len(req.UserIDs), c.IP())
+
+	// Rows are computed as they are written, so the response never holds more
+	// than a flush's worth of them
+	c.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+		var write func(batchAssignment) error
+		if csvOut {
+			cw := csv.NewWriter(w)
+			cw.Write([]string{"userId", "variant", "bucket"})
+			write = func(a batchAssignment) error {
+				cw.Write([]string{a.UserID, a.Variant, strconv.Itoa(a.Bucket)})
+				cw.Flush()
+				return cw.Error()
+			}
+		} else {
+			enc := json.NewEncoder(w)
+			enc.SetEscapeHTML(false)
+			write = func(a batchAssignment) error { return enc.Encode(a) }
+		}
+		for i, userID := range req.UserIDs {
+			// A lookup: adaptive balancing doesn't remember or journal these users
+			payload, bucket, _ := getPayloadForUser(model.Request{UserID: userID, AppVersion: req.AppVersion})
+			if err := write(batchAssignment{UserID: userID, Variant: payload.Name, Bucket: bucket}); err != nil {
+				log.Printf("Allocate batch aborted after %d of %d users: %v", i, len(req.UserIDs), err)
+				return
+			}
+			if (i+1)%allocateBatchFlushRows == 0 {
+				if err := w.Flush(); err != nil {
+					// The client went away; the status is already sent
+					return
+				}
+			}
+		}
+		w.Flush()
+	})
+	return nil
+}
+
+// reloadResponse is the JSON body served by POST /reload: whether the payloads
+// directory passed validation, what reloading it changes or would change, and
+// whether it was applied
+type reloadResponse struct {
+	DryRun  bool          `json:"dryRun"`
+	Valid   bool          `json:"valid"`
+	Applied bool          `json:"applied"`
+	Error   string        `json:"error,omitempty"`
+	Lint    []lintFinding `json:"lint"`
+	Diff    *reloadDiff   `json:"diff,omitempty"`
+	// ConfigHash is the config hash served after the request: the new one
+	// when the reload was applied, the current one otherwise
+	ConfigHash string `json:"configHash"`
+}
+
+// reloadDiff compares the payloads a reload loads with the ones serving.
+// Every variant gets an equal share of users, so the weights are 100/buckets
+// percent each.
+type reloadDiff struct {
+	Added          []string `json:"added"`
+	Removed        []string `json:"removed"`
+	Changed        []string `json:"changed"` // same name, different content
+	BucketsBefore  int      `json:"bucketsBefore"`
+	BucketsAfter   int      `json:"bucketsAfter"`
+	WeightBefore   float64  `json:"weightBefore"`
+	WeightAfter    float64  `json:"weightAfter"`
+	ReassignsUsers bool     `json:"reassignsUsers"`
+}
+
+// Reload handler: loads the payloads directory, checks it as validate -strict
+// does with the server's flags, and swaps it in if it passes. With
+// ?dryRun=true it stops before the swap, so an operator can preview a change.
+// A reload that fails validation is not applied and gets a 422.
+func reloadHandler(c *fiber.Ctx) error {
+	if reloadLint == nil {
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": "payloads are generated with -generate-payloads; there is no directory to reload"})
+	}
+	response := reloadPayloads(c.QueryBool("dryRun"))
+	if response.Applied {
+		log.Printf("Reloaded payloads on request (from %s): %d added, %d removed, %d changed",
+			c.IP(), len(response.Diff.Added), len(response.Diff.Removed), len(response.Diff.Changed))
+	}
+	if !response.Valid && !response.DryRun {
+		return c.Status(fiber.StatusUnprocessableEntity).JSON(response)
+	}
+	return c.JSON(response)
+}
+
+// reloadPayloads loads the payloads directory, checks it as validate -strict
+// does with the server's flags, and swaps it in if it passes, unless dryRun.
+// The check and the swap happen under the store's reload lock, so a reload
+// landing in between can't be overwritten by a stale candidate, and the
+// checked candidate is what goes live: reading the directory again could
+// pick up files that changed after they were validated.
+func reloadPayloads(dryRun bool) reloadResponse {
+	response := reloadResponse{DryRun: dryRun, Lint: []lintFinding{}}
+	err := payloadStore.Update(func(candidate []store.Payload) bool {
+		if _, err := checkPayloads(candidate); err != nil {
+			response.Error = err.Error()
+			return false
+		}
+		opts := *reloadLint
+		opts.Rollbacks = rollbacks.Load().String()
+		response.Lint = append(response.Lint, lintConfig(candidate, opts)...)
+		response.Valid = true
+		for _, f := range response.Lint {
+			if f.Level == lintError {
+				response.Valid = false
+			}
+		}
+		response.Diff = diffPayloads(payloadStore.Payloads(), candidate)
+		response.Applied = response.Valid && !dryRun
+		return response.Applied
+	})
+	if err != nil {
+		response.Error = err.Error()
+	}
+	response.ConfigHash = experimentConfigHash()
+	return response
+}
+
+// watchReload reloads the payloads for -watch. A change on disk goes through
+// the same checks as POST /reload, so a file that would fail validation is
+// never served just because it was written rather than requested.
+func watchReload() error {
+	response := reloadPayloads(false)
+	if response.Error != "" {
+		return errors.New(response.Error)
+	}
+	if !response.Applied {
+		var failed []string
+		for _, f := range response.Lint {
+			if f.Level == lintError {
+				failed = append(failed, f.Message)
+			}
+		}
+		return fmt.Errorf("lint failed: %s", strings.Join(failed, "; "))
+	}
+	log.Printf("Reloaded payloads after a change: %d added, %d removed, %d changed",
+		len(response.Diff.Added), len(response.Diff.Removed), len(response.Diff.Changed))
+	return nil
+}
+
+// diffPayloads compares the serving payloads with the ones a reload loads.
+func diffPayloads(current, next []store.Payload) *reloadDiff {
+	diff := &reloadDiff{
+		Added:          []string{},
+		Removed:        []string{},
+		Changed:        []string{},
+		BucketsBefore:  len(current),
+		BucketsAfter:   len(next),
+		ReassignsUsers: store.Fingerprint(current) != store.Fingerprint(next),
+	}
+	if len(current) > 0 {
+		diff.WeightBefore = 100 / float64(len(current))
+	}
+	if len(next) > 0 {
+		diff.WeightAfter = 100 / float64(len(next))
+	}
+	digests := make(map[string]string, len(current))
+	for _, p := range current {
+		digests[p.Name] = p.SHA256
+	}
+	for _, p := range next {
+		digest, ok := digests[p.Name]
+		switch {
+		case !ok:
+			diff.Added = append(diff.Added, p.Name)
+		case digest != p.SHA256:
+			diff.Changed = append(diff.Changed, p.Name)
+		}
+		delete(digests, p.Name)
+	}
+	for _, p := range current {
+		if _, ok := digests[p.Name]; ok {
+			diff.Removed = append(diff.Removed, p.Name)
+		}
+	}
+	return diff
+}
+
+// checkRollbackPayloads reports an error if a rollback names a payload that
+// isn't loaded, so a typo can't silently leave a bad payload enabled
+func checkRollbackPayloads(r allocation.Rollbacks) error {
+	for disabled, fallback := range r {
+		for _, name := range []string{disabled, fallback} {
+			if _, ok := payloadStore.Lookup(name); !ok {
+				return fmt.Errorf("payload %q is not loaded", name)
+			}
+		}
+	}
+	return nil
+}
+
 // Experiment handler
 func experiment(c *fiber.Ctx) error {
 	var req model.Request
 	if err := c.BodyParser(&req); err != nil {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"error": "Invalid request body",
-		})
+		return middleware.Reject(c, fiber.StatusBadRequest, middleware.ReasonBadJSON, "Invalid request body")
 	}
 
 	if req.UserID == "" {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"error": "userId is required",
-		})
+		return middleware.Reject(c, fiber.StatusBadRequest, middleware.ReasonMissingUserID, "userId is required")
+	}
+	middleware.Annotate(c, "user_id", req.UserID)
+	middleware.Annotate(c, "experiment", experimentID)
+	if v := c.Get(headerMinSchemaVersion); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			return middleware.Reject(c, fiber.StatusBadRequest, middleware.ReasonBadSchemaVersion,
+				headerMinSchemaVersion+" must be a positive integer")
+		}
+		req.MinSchemaVersion = n
 	}
 
-	// Deterministically assign a payload based on UserID hash
-	payload := getPayloadForUser(req.UserID)
+	// A retry carrying a known Idempotency-Key gets the original assignment
+	// back without being counted or audited a second time
+	var a assignment
+	replayed := false
+	if key := c.Get(headerIdempotencyKey); key != "" && idempotencyCache != nil {
+		a, replayed = idempotencyCache.Do(key, func() assignment { return assign(c, req) })
+		if replayed && a.userID != req.UserID {
+			return middleware.Reject(c, fiber.StatusUnprocessableEntity, middleware.ReasonIdempotencyConflict,
+				"Idempotency-Key was already used for a different userId")
+		}
+	} else {
+		a = assign(c, req)
+	}
+	if a.unsatisfiable {
+		return middleware.Reject(c, fiber.StatusNotAcceptable, middleware.ReasonSchemaUnsatisfiable,
+			fmt.Sprintf("no payload for this user has schema version %d or newer", req.MinSchemaVersion))
+	}
+	payload, exposed := a.payload, a.exposed
+	// Under load, a marked request gets the lightweight payload instead,
+	// unless the client can't parse its schema. The assignment itself is
+	// unchanged, so the user gets their payload again once load drops.
+	degraded := false
+	if middleware.ShouldDegrade(c) && payload.Name != degradePayload {
+		if lite, ok := payloadStore.Lookup(degradePayload); !ok {
+			warnDegradeMissing.Do(func() {
+				log.Printf("Warning: payload %s is no longer loaded, serving full payloads under load", degradePayload)
+			})
+		} else if lite.SchemaVersion >= req.MinSchemaVersion {
+			payload, degraded = lite, true
+			loadDegrader.CountDegraded()
+			c.Set(headerDegraded, "true")
+			middleware.Annotate(c, "degraded", "true")
+		}
+	}
+	middleware.Annotate(c, "variant", payload.Name)
+	if decision, ok := middleware.MarkDecision(c); ok && !replayed {
+		serverMetrics.ObserveDecision(decision)
+	}
+	if replayed {
+		c.Set(headerIdempotentReplayed, "true")
+	}
+	c.Set(headerConfigHash, experimentConfigHash())
+	c.Set(headerSchemaVersion, strconv.Itoa(payload.SchemaVersion))
+	c.Set(headerPayloadSHA256, payload.SHA256)
+	// Users the version or exposure gate sent to the fallback or control
+	// payload weren't assigned a variant, so they get no variant headers
+	if a.bucket >= 0 {
+		c.Set(headerExperimentID, experimentID)
+		c.Set(headerVariant, payload.Name)
+		c.Set(headerBucket, strconv.Itoa(a.bucket))
+		// The algorithm was validated at startup, so this can't fail
+		hash, _ := allocation.BucketHash(hashAlgorithm, a.userID)
+		c.Set(headerBucketHash, hashAlgorithm+":"+strconv.FormatUint(hash, 10))
+		c.Set(headerBucketCount, strconv.Itoa(a.buckets))
+		if natural := bucketMapper(a.userID, a.buckets); natural != a.bucket {
+			c.Set(headerBucketNudgedFrom, strconv.Itoa(natural))
+		}
+	}
+	if len(availableLocales) > 0 {
+		c.Vary(fiber.HeaderAcceptLanguage)
+		c.Set(fiber.HeaderContentLanguage, negotiateLocale(c.Get(fiber.HeaderAcceptLanguage)))
+	}
+
+	// A redirect or error page variant keeps the headers above but is sent
+	// with its own status, and without the payload if it omits it
+	if vr, ok := variantResponses[payload.Name]; ok {
+		c.Status(vr.StatusCode)
+		if vr.Location != "" {
+			c.Set(fiber.HeaderLocation, vr.Location)
+		}
+		if vr.OmitPayload {
+			return nil
+		}
+	}
+
+	// A raw response is the payload itself, in the one format it has
+	if responseMode == responseModeRaw {
+		c.Set(headerPayloadName, payload.Name)
+		c.Set(headerPayloadSHA256, payload.RawSHA256)
+		if reportsExposure() {
+			c.Set(headerExposed, strconv.FormatBool(exposed))
+		}
+		c.Set(fiber.HeaderContentType, payload.ContentType)
+		err := c.SendString(payload.Content)
+		observeBytesServed(c, payload.Name)
+		return err
+	}
+
+	// Clients that prefer protobuf get it when the server encoded one, and
+	// JSON otherwise
+	c.Vary(fiber.HeaderAccept)
+	if len(payload.Proto) > 0 && c.Accepts(fiber.MIMEApplicationJSON, mimeProtobuf) == mimeProtobuf {
+		response := proto.Response{
+			ExperimentID:        experimentID,
+			SelectedPayloadName: payload.Name,
+			Payload:             payload.Proto,
+			SchemaVersion:       uint32(payload.SchemaVersion),
+			Degraded:            degraded,
+		}
+		if reportsExposure() {
+			response.Exposed = &exposed
+		}
+		c.Set(fiber.HeaderContentType, mimeProtobuf)
+		err := c.Send(response.Marshal())
+		observeBytesServed(c, payload.Name)
+		return err
+	}
+
+	// Clients that prefer JSON Lines get the payload one entry per line so
+	// they can parse a large bundle as it arrives
+	if len(payload.Entries) > 0 && c.Accepts(fiber.MIMEApplicationJSON, mimeNDJSON) == mimeNDJSON {
+		header := model.StreamHeader{
+			ExperimentID:        experimentID,
+			SelectedPayloadName: payload.Name,
+			SchemaVersion:       payload.SchemaVersion,
+			Degraded:            degraded,
+		}
+		if reportsExposure() {
+			header.Exposed = &exposed
+		}
+		return streamEntries(c, header, payload.Entries)
+	}
 
 	response := model.Response{
-		ExperimentID:        "exp-localization-v1",
+		ExperimentID:        experimentID,
 		SelectedPayloadName: payload.Name,
 		Payload:             json.RawMessage(payload.Content),
+		SchemaVersion:       payload.SchemaVersion,
+		Degraded:            degraded,
+	}
+	if reportsExposure() {
+		response.Exposed = &exposed
 	}
 
-	return c.JSON(response)
+	err := c.JSON(response)
+	observeBytesServed(c, payload.Name)
+	return err
+}
+
+// observeBytesServed counts a buffered response body toward the bandwidth of
+// the variant it served. A body with a Content-Encoding counts its decoded
+// length as raw bytes.
+func observeBytesServed(c *fiber.Ctx, variant string) {
+	resp := c.Response()
+	sent := int64(len(resp.Body()))
+	raw := sent
+	if len(resp.Header.ContentEncoding()) > 0 {
+		if body, err := resp.BodyUncompressed(); err == nil {
+			raw = int64(len(body))
+		}
+	}
+	serverMetrics.ObserveBytesServed(variant, sent, raw)
+}
+
+// reportsExposure reports whether /experiment responses say if the user was
+// exposed: when a gate can keep bucketable users out of the experiment
+func reportsExposure() bool {
+	return exposurePercent < 100 || prerequisites != nil
+}
+
+// assignment is the outcome of allocating one request, kept per
+// Idempotency-Key so a retry is served the same payload
+type assignment struct {
+	userID  string
+	payload store.Payload
+	bucket  int // -1 when a gate applied
+	buckets int // payloads the bucket was chosen from
+	exposed bool
+	// unsatisfiable is set when -schema-check found no payload the client
+	// can parse; nothing is served or audited
+	unsatisfiable bool
+}
+
+// assign chooses the request's payload and records the allocation in the
+// audit log. It runs once per request, or once per Idempotency-Key.
+func assign(c *fiber.Ctx, req model.Request) assignment {
+	if cpuWorkRounds > 0 {
+		simulateCPUWork(req.UserID, cpuWorkRounds)
+	}
+
+	// Deterministically assign a payload based on UserID hash
+	payload, bucket, exposed := assignPayloadForUser(req)
+
+	// A client that can't parse the selected payload's schema gets the
+	// fallback payload if it can parse that, or nothing
+	if payload.SchemaVersion < req.MinSchemaVersion && schemaCheck != schemaCheckOff {
+		fallback, ok := payloadStore.Lookup(fallbackPayload)
+		if schemaCheck == schemaCheckReject || !ok || fallback.SchemaVersion < req.MinSchemaVersion {
+			return assignment{userID: req.UserID, unsatisfiable: true}
+		}
+		payload, bucket, exposed = fallback, -1, false
+	}
+
+	if auditLog != nil {
+		requestID, _ := c.Locals("requestid").(string)
+		auditLog.Log(audit.Record{
+			Timestamp:    time.Now().UTC(),
+			RequestID:    requestID,
+			UserID:       req.UserID,
+			ExperimentID: experimentID,
+			Variant:      payload.Name,
+			Bucket:       bucket,
+		})
+	}
+	return assignment{userID: req.UserID, payload: payload, bucket: bucket, buckets: len(payloadStore.Payloads()), exposed: exposed}
+}
+
+// streamEntries writes the response as JSON Lines: the header, then one line
+// per payload entry, flushed in batches of ndjsonFlushEntries while the body
+// is still being written.
+func streamEntries(c *fiber.Ctx, header model.StreamHeader, entries []store.Entry) error {
+	headerLine, err := json.Marshal(header)
+	if err != nil {
+		return err
+	}
+	c.Set(fiber.HeaderContentType, mimeNDJSON)
+	c.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+		var out io.Writer = w
+		if responseThrottle > 0 {
+			// Throttle doesn't see inside streams, so wrap the writer here
+			out = middleware.NewThrottledWriter(w, responseThrottle)
+		}
+		// Streams are sent uncompressed, so sent and raw bytes are the same.
+		// This is the only buffer in front of the connection: each flush goes
+		// through the counter and throttle and out to the client
+		counted := &countingWriter{w: out}
+		w = bufio.NewWriter(counted)
+		defer func() {
+			serverMetrics.ObserveBytesServed(header.SelectedPayloadName, counted.n, counted.n)
+		}()
+		w.Write(headerLine)
+		w.WriteByte('\n')
+		if err := store.WriteEntries(w, entries, ndjsonFlushEntries); err != nil {
+			// The status is already sent; the client sees a truncated stream
+			log.Printf("Streaming %s aborted: %v", header.SelectedPayloadName, err)
+		}
+	})
+	return nil
+}
+
+// countingWriter counts the bytes written through it, for streamed bodies,
+// whose length isn't known until they are sent. It flushes w after every
+// write when w buffers, so flushing the writer in front of it reaches the
+// client.
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (cw *countingWriter) Write(p []byte) (int, error) {
+	n, err := cw.w.Write(p)
+	cw.n += int64(n)
+	if err != nil {
+		return n, err
+	}
+	if f, ok := cw.w.(interface{ Flush() error }); ok {
+		return n, f.Flush()
+	}
+	return n, nil
+}
+
+// cachedConfigHash is a config hash with the payload set fingerprint and
+// rollbacks it was computed from; only a reload or a rollback changes the
+// inputs after startup
+type cachedConfigHash struct {
+	fingerprint string
+	rollbacks   string
+	hash        string
+}
+
+var configHashCache atomic.Pointer[cachedConfigHash]
+
+// experimentConfigHash identifies everything that decides a user's payload:
+// the experiment, the loaded payload names, the exposure gate and its control
+// payload, the app version gate and its fallback payload, any rollbacks, the
+// hash algorithm, the schema check, adaptive balancing and any prerequisites.
+// Two runs with the same hash assign users identically.
+func experimentConfigHash() string {
+	fingerprint := payloadStore.Fingerprint()
+	disabled := rollbacks.Load().String()
+	if cached := configHashCache.Load(); cached != nil && cached.fingerprint == fingerprint && cached.rollbacks == disabled {
+		return cached.hash
+	}
+	versions := ""
+	if appVersionRange != nil {
+		versions = appVersionRange.String()
+	}
+	config := fmt.Sprintf("%s\n%s\n%g\n%s\n%s\n%s",
+		experimentID, fingerprint, exposurePercent, controlPayload, versions, fallbackPayload)
+	if disabled != "" {
+		// Appended only when set, so servers without rollbacks keep the
+		// hash they had before rollbacks existed
+		config += "\n" + disabled
+	}
+	if hashAlgorithm != allocation.DefaultHashAlgorithm {
+		// Likewise for servers on the default hash
+		config += "\nhash=" + hashAlgorithm
+	}
+	if schemaCheck != schemaCheckOff {
+		config += "\nschema-check=" + schemaCheck
+	}
+	if balancer != nil {
+		config += "\nadaptive-balance"
+	}
+	if prerequisites != nil {
+		// The parsed config, so formatting changes to the file don't count
+		encoded, _ := json.Marshal(prerequisites)
+		config += "\nprerequisites=" + string(encoded)
+	}
+	sum := sha256.Sum256([]byte(config))
+	hash := hex.EncodeToString(sum[:8])
+	configHashCache.Store(&cachedConfigHash{fingerprint: fingerprint, rollbacks: disabled, hash: hash})
+	return hash
+}
+
+// negotiateLocale returns the best of availableLocales for an Accept-Language
+// header, or the first (default) locale when none is acceptable.
+func negotiateLocale(acceptLanguage string) string {
+	if best, _, ok := locale.Match(acceptLanguage, availableLocales); ok {
+		return best
+	}
+	return availableLocales[0]
+}
+
+// getPayloadForUser returns the payload a request's user gets, along with the
+// bucket (payload index) the user is in and whether the user is exposed to the
+// experiment. Clients outside the app version range get the fallback payload
+// and unexposed users the control payload, both with bucket -1. If a reload
+// removed one of those payloads, the affected users are bucketed rather than
+// failing requests.
+//
+// It only looks: with adaptive balancing, a user the balancer hasn't assigned
+// gets the bucket it would assign them now, and nothing is journaled. Lookups
+// such as POST /admin/allocate-batch use it; /experiment traffic goes through
+// assignPayloadForUser.
+func getPayloadForUser(req model.Request) (store.Payload, int, bool) {
+	return selectPayload(req, peekBucket)
+}
+
+// assignPayloadForUser is getPayloadForUser for a user being served: with
+// adaptive balancing, a new user's bucket is remembered and journaled.
+func assignPayloadForUser(req model.Request) (store.Payload, int, bool) {
+	return selectPayload(req, assignBucket)
+}
+
+// selectPayload applies the gates and rollbacks around bucketFor, which turns
+// a bucketed user's hash bucket among n into their bucket.
+func selectPayload(req model.Request, bucketFor func(userID string, n, hashBucket int) int) (store.Payload, int, bool) {
+	payloads := payloadStore.Payloads()
+	rules := allocation.Rules{ExposurePercent: exposurePercent, AppVersions: appVersionRange, Prerequisites: prerequisites, Mapper: bucketMapper}
+	decision := rules.Decide(req.UserID, req.AppVersion, len(payloads))
+	if !decision.VersionSupported {
+		if payload, ok := lookupOrWarn(fallbackPayload, &warnFallbackMissing); ok {
+			return payload, -1, false
+		}
+	}
+	if !decision.Eligible || !decision.Exposed {
+		if payload, ok := lookupOrWarn(controlPayload, &warnControlMissing); ok {
+			return payload, -1, false
+		}
+	}
+	bucket := bucketFor(req.UserID, len(payloads), decision.Bucket)
+	payload := payloads[bucket]
+	if fallback, ok := rollbacks.Load().Serve(payload.Name); ok {
+		if served, ok := lookupOrWarn(fallback, &warnRollbackMissing); ok {
+			return served, bucket, true
+		}
+	}
+	return payload, bucket, true
+}
+
+// peekBucket returns a user's bucket without recording it.
+func peekBucket(userID string, n, hashBucket int) int {
+	if balancer == nil {
+		return hashBucket
+	}
+	bucket, _ := balancer.Peek(userID, n)
+	return bucket
+}
+
+// assignBucket returns a user's bucket, remembering and journaling a new
+// user's when adaptive balancing is on.
+func assignBucket(userID string, n, hashBucket int) int {
+	if balancer == nil {
+		return hashBucket
+	}
+	bucket, _, err := balancer.Assign(userID, n)
+	if err != nil {
+		warnBalanceJournal.Do(func() {
+			log.Printf("Warning: adaptive balancing stopped, every new user now gets their hash bucket: %v", err)
+		})
+	}
+	return bucket
 }
 
-// getPayloadForUser returns a deterministic payload for a given user ID
-func getPayloadForUser(userID string) Payload {
-	h := fnv.New32a()
-	h.Write([]byte(userID))
-	index := int(h.Sum32()) % len(payloads)
-	return payloads[index]
+// openBalancer sets up balancer from the journal at statePath, restoring the
+// assignments it holds and appending new ones. Starting without any state
+// could move users hashed before adaptive balancing was enabled, so it needs
+// reshuffle. It returns a func that closes the journal.
+func openBalancer(statePath string, margin float64, maxUsers int, reshuffle bool) (func() error, error) {
+	if statePath == "" {
+		return nil, fmt.Errorf("-adaptive-state is required, so users nudged into another bucket keep it across restarts")
+	}
+	state, err := os.ReadFile(statePath)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	journal, err := os.OpenFile(statePath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	b, err := allocation.NewBalancer(hashAlgorithm, margin, maxUsers, bytes.NewReader(state), journal)
+	if err != nil {
+		journal.Close()
+		return nil, fmt.Errorf("%s: %w", statePath, err)
+	}
+	if b.Remembered() == 0 && !reshuffle {
+		journal.Close()
+		return nil, fmt.Errorf("%s holds no assignments, so users already hashed into a bucket could be nudged out of it; pass -reshuffle-users to confirm this is a new experiment", statePath)
+	}
+	balancer = b
+	log.Printf("Adaptive balancing: nudging up to %g%% of new users toward emptier buckets for the first %d users; %d assignments restored from %s",
+		margin*100, maxUsers, b.Remembered(), statePath)
+	return journal.Close, nil
+}
+
+// lookupOrWarn returns the named payload, logging once via warn if a reload
+// has removed it
+func lookupOrWarn(name string, warn *sync.Once) (store.Payload, bool) {
+	payload, ok := payloadStore.Lookup(name)
+	if !ok {
+		warn.Do(func() {
+			log.Printf("Warning: payload %s is no longer loaded, bucketing users who would get it", name)
+		})
+	}
+	return payload, ok
+}
+
+// warnControlMissing, warnFallbackMissing, warnRollbackMissing and
+// warnDegradeMissing log a missing control, fallback, rollback or degrade
+// payload once rather than per request
+var warnControlMissing, warnFallbackMissing, warnRollbackMissing, warnDegradeMissing sync.Once
+
+// simulateCPUWork chains SHA-256 over the user ID to stand in for expensive
+// allocation logic (targeting rules, many experiments). Each round depends on
+// the previous one, so the work can't be skipped or parallelized.
+func simulateCPUWork(userID string, rounds int) [sha256.Size]byte {
+	sum := sha256.Sum256([]byte(userID))
+	for i := 1; i < rounds; i++ {
+		sum = sha256.Sum256(sum[:])
+	}
+	return sum
 }
@@ -0,0 +1,1110 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+
+	"go-localization-large-backend/pkg/allocation"
+	"go-localization-large-backend/pkg/audit"
+	"go-localization-large-backend/pkg/encoding/proto"
+	"go-localization-large-backend/pkg/idempotency"
+	"go-localization-large-backend/pkg/lifecycle"
+	"go-localization-large-backend/pkg/metrics"
+	"go-localization-large-backend/pkg/middleware"
+	"go-localization-large-backend/pkg/model"
+	"go-localization-large-backend/pkg/store"
+)
+
+// TestMain silences the log, which the store writes a line to for every
+// payload it loads, so test and benchmark output stays readable.
+func TestMain(m *testing.M) {
+	log.SetOutput(io.Discard)
+	os.Exit(m.Run())
+}
+
+// testPayloads are small JSON object payloads the handler tests serve.
+var testPayloads = map[string]string{
+	"a.json": `{"greeting":"hello","farewell":"bye"}`,
+	"b.json": `{"greeting":"hi","farewell":"ciao"}`,
+	"c.json": `{"greeting":"hey","farewell":"later"}`,
+}
+
+// writePayloads writes payloads, by file name, to a temporary directory and
+// returns it.
+func writePayloads(tb testing.TB, payloads map[string]string) string {
+	tb.Helper()
+	dir := tb.TempDir()
+	for name, content := range payloads {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644); err != nil {
+			tb.Fatal(err)
+		}
+	}
+	return dir
+}
+
+// newTestApp loads payloads from a temporary directory and returns an app
+// serving them on /experiment.
+func newTestApp(tb testing.TB, payloads map[string]string) *fiber.App {
+	tb.Helper()
+	s := store.NewPayloadStore(writePayloads(tb, payloads), store.Limits{})
+	if err := s.Load(); err != nil {
+		tb.Fatal(err)
+	}
+	useStore(tb, s)
+	return experimentApp()
+}
+
+// useStore makes s the served payload store, with no rollbacks or variant
+// responses, the way main sets it up with default flags. Package state the
+// test changes is restored when it ends.
+func useStore(tb testing.TB, s *store.PayloadStore) {
+	savedStore, savedRollbacks, savedResponses := payloadStore, rollbacks.Load(), variantResponses
+	tb.Cleanup(func() {
+		payloadStore, variantResponses = savedStore, savedResponses
+		rollbacks.Store(savedRollbacks)
+		configHashCache.Store(nil)
+	})
+	payloadStore = s
+	variantResponses = nil
+	rollbacks.Store(&allocation.Rollbacks{})
+	configHashCache.Store(nil)
+}
+
+// experimentApp returns an app serving /experiment without middleware.
+func experimentApp() *fiber.App {
+	app := fiber.New()
+	app.Post("/experiment", experiment)
+	return app
+}
+
+// postExperiment sends an /experiment request for userID with the given
+// extra headers and returns the response with its body read.
+func postExperiment(t *testing.T, app *fiber.App, userID string, headers map[string]string) (*http.Response, string) {
+	t.Helper()
+	return postBody(t, app, `{"userId":"`+userID+`"}`, headers)
+}
+
+// postBody sends an /experiment request with the given JSON body and extra
+// headers and returns the response with its body read.
+func postBody(t *testing.T, app *fiber.App, body string, headers map[string]string) (*http.Response, string) {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodPost, "/experiment", strings.NewReader(body))
+	req.Header.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := app.Test(req, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	read, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp, string(read)
+}
+
+func TestVariantResponses(t *testing.T) {
+	app := newTestApp(t, testPayloads)
+
+	// Record every user's variant before any variant response is configured
+	users := make([]string, 60)
+	natural := make(map[string]string, len(users))
+	for i := range users {
+		users[i] = fmt.Sprintf("user-%d", i)
+		_, body := postExperiment(t, app, users[i], nil)
+		var got model.Response
+		if err := json.Unmarshal([]byte(body), &got); err != nil {
+			t.Fatal(err)
+		}
+		natural[users[i]] = got.SelectedPayloadName
+	}
+
+	variantResponses = allocation.VariantResponses{
+		"b.json": {StatusCode: http.StatusFound, Location: "https://example.com/new", OmitPayload: true},
+		"c.json": {StatusCode: http.StatusServiceUnavailable},
+	}
+	tests := []struct {
+		variant      string
+		wantStatus   int
+		wantLocation string
+		wantBody     bool
+	}{
+		{variant: "a.json", wantStatus: http.StatusOK, wantBody: true},
+		{variant: "b.json", wantStatus: http.StatusFound, wantLocation: "https://example.com/new"},
+		{variant: "c.json", wantStatus: http.StatusServiceUnavailable, wantBody: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.variant, func(t *testing.T) {
+			served := 0
+			for _, user := range users {
+				if natural[user] != tt.variant {
+					continue
+				}
+				served++
+				if got := payloadStore.Payloads()[allocation.Index(user, len(testPayloads))].Name; got != tt.variant {
+					t.Errorf("user %s moved from %s to %s", user, tt.variant, got)
+				}
+				resp, body := postExperiment(t, app, user, nil)
+				if resp.StatusCode != tt.wantStatus {
+					t.Errorf("user %s: status %d, want %d", user, resp.StatusCode, tt.wantStatus)
+				}
+				if got := resp.Header.Get(fiber.HeaderLocation); got != tt.wantLocation {
+					t.Errorf("user %s: Location %q, want %q", user, got, tt.wantLocation)
+				}
+				if hasBody := strings.Contains(body, `"selectedPayloadName":"`+tt.variant+`"`); hasBody != tt.wantBody {
+					t.Errorf("user %s: body %q, want payload body %v", user, body, tt.wantBody)
+				}
+			}
+			if served == 0 {
+				t.Fatalf("no test user is bucketed into %s", tt.variant)
+			}
+		})
+	}
+}
+
+func TestAppVersionGate(t *testing.T) {
+	app := newTestApp(t, testPayloads)
+	versions, err := allocation.ParseVersionRange(">=2.0.0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	savedRange, savedFallback := appVersionRange, fallbackPayload
+	t.Cleanup(func() { appVersionRange, fallbackPayload = savedRange, savedFallback })
+	appVersionRange, fallbackPayload = &versions, "a.json"
+
+	tests := []struct {
+		name       string
+		appVersion string
+		wantGated  bool
+	}{
+		{name: "in range", appVersion: "2.4.1"},
+		{name: "out of range", appVersion: "1.9.0", wantGated: true},
+		{name: "missing version", appVersion: "", wantGated: true},
+		{name: "unparseable version", appVersion: "latest", wantGated: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for i := 0; i < 20; i++ {
+				userID := fmt.Sprintf("user-%d", i)
+				resp, body := postBody(t, app, fmt.Sprintf(`{"userId":%q,"appVersion":%q}`, userID, tt.appVersion), nil)
+				if resp.StatusCode != http.StatusOK {
+					t.Fatalf("status %d, want 200", resp.StatusCode)
+				}
+				variant, bucket := resp.Header.Get(headerVariant), resp.Header.Get(headerBucket)
+				if tt.wantGated {
+					// Gated users get the fallback and no variant headers
+					if !strings.Contains(body, `"selectedPayloadName":"a.json"`) {
+						t.Errorf("%s: body %s, want the fallback a.json", userID, body)
+					}
+					if variant != "" || bucket != "" {
+						t.Errorf("%s: X-Variant %q, X-Bucket %q, want none", userID, variant, bucket)
+					}
+					continue
+				}
+				want := payloadStore.Payloads()[allocation.Index(userID, len(testPayloads))].Name
+				if variant != want {
+					t.Errorf("%s: X-Variant %q, want %q", userID, variant, want)
+				}
+			}
+		})
+	}
+}
+
+func TestIdempotencyKey(t *testing.T) {
+	app := newTestApp(t, testPayloads)
+	auditPath := filepath.Join(t.TempDir(), "audit.jsonl")
+	logger, err := audit.Open(auditPath, 64)
+	if err != nil {
+		t.Fatal(err)
+	}
+	savedCache, savedAudit := idempotencyCache, auditLog
+	t.Cleanup(func() { idempotencyCache, auditLog = savedCache, savedAudit })
+	idempotencyCache, auditLog = idempotency.New[assignment](time.Minute), logger
+
+	tests := []struct {
+		name         string
+		userID       string
+		key          string
+		wantStatus   int
+		wantReplayed bool
+		wantSameBody bool // as the key's first response
+	}{
+		{name: "first use", userID: "alice", key: "k1", wantStatus: http.StatusOK},
+		{name: "retry", userID: "alice", key: "k1", wantStatus: http.StatusOK, wantReplayed: true, wantSameBody: true},
+		{name: "second retry", userID: "alice", key: "k1", wantStatus: http.StatusOK, wantReplayed: true, wantSameBody: true},
+		{name: "other user reusing the key", userID: "bob", key: "k1", wantStatus: http.StatusUnprocessableEntity},
+		{name: "new key", userID: "alice", key: "k2", wantStatus: http.StatusOK},
+		{name: "no key", userID: "alice", wantStatus: http.StatusOK},
+	}
+	first := map[string]string{}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			headers := map[string]string{}
+			if tt.key != "" {
+				headers[headerIdempotencyKey] = tt.key
+			}
+			resp, body := postExperiment(t, app, tt.userID, headers)
+			if resp.StatusCode != tt.wantStatus {
+				t.Fatalf("status %d, want %d: %s", resp.StatusCode, tt.wantStatus, body)
+			}
+			if replayed := resp.Header.Get(headerIdempotentReplayed) == "true"; replayed != tt.wantReplayed {
+				t.Errorf("%s = %q, want replayed %v", headerIdempotentReplayed, resp.Header.Get(headerIdempotentReplayed), tt.wantReplayed)
+			}
+			if tt.wantSameBody && body != first[tt.key] {
+				t.Errorf("replayed body %s, want the first response %s", body, first[tt.key])
+			}
+			if _, seen := first[tt.key]; !seen && tt.wantStatus == http.StatusOK {
+				first[tt.key] = body
+			}
+		})
+	}
+
+	// Only the first use of each key and the request without one were
+	// assigned, so only they reach the audit log
+	if err := logger.Close(); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(auditPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := strings.Count(string(data), "\n"); n != 3 {
+		t.Errorf("audit log has %d records, want 3:\n%s", n, data)
+	}
+}
+
+func TestRejectionMetrics(t *testing.T) {
+	newTestApp(t, testPayloads)
+	savedMetrics, savedCache, savedSchemaCheck := serverMetrics, idempotencyCache, schemaCheck
+	t.Cleanup(func() { serverMetrics, idempotencyCache, schemaCheck = savedMetrics, savedCache, savedSchemaCheck })
+
+	const bodyLimit = 1024
+	tests := []struct {
+		name       string
+		before     fiber.Handler // runs ahead of the handler, like main's middleware
+		limiter    *middleware.IPConnLimiter
+		setup      func()
+		body       string
+		headers    map[string]string
+		wantStatus int
+		wantReason string // empty for a served request
+	}{
+		{name: "served", body: `{"userId":"alice"}`, wantStatus: http.StatusOK},
+		{name: "bad json", body: `{"userId":`, wantStatus: http.StatusBadRequest, wantReason: middleware.ReasonBadJSON},
+		{name: "missing userId", body: `{}`, wantStatus: http.StatusBadRequest, wantReason: middleware.ReasonMissingUserID},
+		{
+			name: "oversized body", body: `{"userId":"` + strings.Repeat("x", bodyLimit) + `"}`,
+			wantStatus: http.StatusRequestEntityTooLarge, wantReason: middleware.ReasonBodyTooLarge,
+		},
+		{
+			name: "bad schema version", body: `{"userId":"alice"}`, headers: map[string]string{headerMinSchemaVersion: "zero"},
+			wantStatus: http.StatusBadRequest, wantReason: middleware.ReasonBadSchemaVersion,
+		},
+		{
+			name: "schema unsatisfiable", setup: func() { schemaCheck = schemaCheckReject },
+			body: `{"userId":"alice"}`, headers: map[string]string{headerMinSchemaVersion: "99"},
+			wantStatus: http.StatusNotAcceptable, wantReason: middleware.ReasonSchemaUnsatisfiable,
+		},
+		{
+			name: "idempotency conflict",
+			setup: func() {
+				idempotencyCache = idempotency.New[assignment](time.Minute)
+				idempotencyCache.Do("k1", func() assignment { return assignment{userID: "bob"} })
+			},
+			body: `{"userId":"alice"}`, headers: map[string]string{headerIdempotencyKey: "k1"},
+			wantStatus: http.StatusUnprocessableEntity, wantReason: middleware.ReasonIdempotencyConflict,
+		},
+		{
+			name: "unauthorized", before: middleware.BearerAuth("secret"), body: `{"userId":"alice"}`,
+			wantStatus: http.StatusUnauthorized, wantReason: middleware.ReasonUnauthorized,
+		},
+		{
+			name: "overloaded", before: middleware.NewLoadShedder(0, 0, func() int64 { return 1 }).Handler(),
+			body: `{"userId":"alice"}`, wantStatus: http.StatusServiceUnavailable, wantReason: middleware.ReasonOverloaded,
+		},
+		{
+			name: "rate limited", limiter: middleware.NewIPConnLimiter(0), body: `{"userId":"alice"}`,
+			wantStatus: http.StatusServiceUnavailable, wantReason: middleware.ReasonRateLimited,
+		},
+		{
+			name: "unsupported encoding", before: middleware.DecompressBody(bodyLimit), body: `{"userId":"alice"}`,
+			headers:    map[string]string{fiber.HeaderContentEncoding: "br"},
+			wantStatus: http.StatusUnsupportedMediaType, wantReason: middleware.ReasonUnsupportedEncoding,
+		},
+		{
+			name: "bad gzip", before: middleware.DecompressBody(bodyLimit), body: `{"userId":"alice"}`,
+			headers:    map[string]string{fiber.HeaderContentEncoding: "gzip"},
+			wantStatus: http.StatusBadRequest, wantReason: middleware.ReasonBadGzip,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			serverMetrics, idempotencyCache, schemaCheck = metrics.NewCollector(), nil, schemaCheckOff
+			if tt.setup != nil {
+				tt.setup()
+			}
+			app := fiber.New(fiber.Config{BodyLimit: bodyLimit, ErrorHandler: errorHandler, DisableStartupMessage: true})
+			app.Use(middleware.ProcessingTime(observeRequest))
+			if tt.before != nil {
+				app.Use(tt.before)
+			}
+			if tt.limiter != nil {
+				app.Use(tt.limiter.Handler())
+			}
+			app.Post("/experiment", experiment)
+
+			// A real listener rather than app.Test, which fails oversized
+			// bodies before the response, and counts connections per IP
+			ln, err := net.Listen("tcp", "127.0.0.1:0")
+			if err != nil {
+				t.Fatal(err)
+			}
+			url := "http://" + ln.Addr().String() + "/experiment"
+			if tt.limiter != nil {
+				ln = tt.limiter.Listener(ln)
+			}
+			go app.Listener(ln)
+			t.Cleanup(func() { app.Shutdown() })
+
+			req, err := http.NewRequest(http.MethodPost, url, strings.NewReader(tt.body))
+			if err != nil {
+				t.Fatal(err)
+			}
+			req.Header.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
+			for k, v := range tt.headers {
+				req.Header.Set(k, v)
+			}
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			body, _ := io.ReadAll(resp.Body)
+			resp.Body.Close()
+			if resp.StatusCode != tt.wantStatus {
+				t.Fatalf("status %d, want %d: %s", resp.StatusCode, tt.wantStatus, body)
+			}
+			got := serverMetrics.Snapshot().Rejections
+			want := map[string]int64{}
+			if tt.wantReason != "" {
+				want[tt.wantReason] = 1
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("rejections %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestHealthPhases(t *testing.T) {
+	newTestApp(t, testPayloads)
+	savedState := serverState
+	t.Cleanup(func() { serverState = savedState })
+	serverState = lifecycle.New()
+
+	app := fiber.New()
+	app.Get("/health", healthCheck)
+	app.Get("/health/deep", deepHealthCheck)
+
+	tests := []struct {
+		phase        string
+		advance      func()
+		wantStatus   int
+		wantPayloads int
+	}{
+		{phase: "starting", advance: func() {}, wantStatus: http.StatusServiceUnavailable},
+		{phase: "ready", advance: func() { serverState.MarkReady() }, wantStatus: http.StatusOK, wantPayloads: len(testPayloads)},
+		{phase: "draining", advance: func() { serverState.Drain() }, wantStatus: http.StatusServiceUnavailable, wantPayloads: len(testPayloads)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.phase, func(t *testing.T) {
+			tt.advance()
+			for _, path := range []string{"/health", "/health/deep"} {
+				resp, err := app.Test(httptest.NewRequest(http.MethodGet, path, nil), -1)
+				if err != nil {
+					t.Fatal(err)
+				}
+				body, err := io.ReadAll(resp.Body)
+				resp.Body.Close()
+				if err != nil {
+					t.Fatal(err)
+				}
+				if resp.StatusCode != tt.wantStatus {
+					t.Errorf("%s: status %d, want %d: %s", path, resp.StatusCode, tt.wantStatus, body)
+				}
+				if path == "/health" {
+					continue
+				}
+				var got deepHealthResponse
+				if err := json.Unmarshal(body, &got); err != nil {
+					t.Fatal(err)
+				}
+				if got.Phase != tt.phase {
+					t.Errorf("%s: phase %q, want %q", path, got.Phase, tt.phase)
+				}
+				if got.Payloads != tt.wantPayloads {
+					t.Errorf("%s: payloads %d, want %d", path, got.Payloads, tt.wantPayloads)
+				}
+			}
+		})
+	}
+}
+
+func TestRollbacks(t *testing.T) {
+	app := newTestApp(t, testPayloads)
+	app.Post("/admin/rollbacks", disablePayloadHandler)
+	app.Delete("/admin/rollbacks", enablePayloadHandler)
+
+	users := make([]string, 60)
+	natural := make(map[string]string, len(users))
+	for i := range users {
+		users[i] = fmt.Sprintf("user-%d", i)
+		_, body := postExperiment(t, app, users[i], nil)
+		var got model.Response
+		if err := json.Unmarshal([]byte(body), &got); err != nil {
+			t.Fatal(err)
+		}
+		natural[users[i]] = got.SelectedPayloadName
+	}
+
+	tests := []struct {
+		name       string
+		method     string
+		target     string
+		body       string
+		wantStatus int
+		want       map[string]string // payload served instead, by natural payload
+	}{
+		{
+			name: "disable b", method: http.MethodPost, target: "/admin/rollbacks",
+			body: `{"payload":"b.json","fallback":"a.json"}`, wantStatus: http.StatusOK,
+			want: map[string]string{"b.json": "a.json"},
+		},
+		{
+			name: "disable c too", method: http.MethodPost, target: "/admin/rollbacks",
+			body: `{"payload":"c.json","fallback":"a.json"}`, wantStatus: http.StatusOK,
+			want: map[string]string{"b.json": "a.json", "c.json": "a.json"},
+		},
+		{
+			name: "fallback can't be disabled", method: http.MethodPost, target: "/admin/rollbacks",
+			body: `{"payload":"a.json","fallback":"b.json"}`, wantStatus: http.StatusBadRequest,
+			want: map[string]string{"b.json": "a.json", "c.json": "a.json"},
+		},
+		{
+			name: "unknown payload", method: http.MethodPost, target: "/admin/rollbacks",
+			body: `{"payload":"missing.json","fallback":"a.json"}`, wantStatus: http.StatusBadRequest,
+			want: map[string]string{"b.json": "a.json", "c.json": "a.json"},
+		},
+		{
+			name: "enable c", method: http.MethodDelete, target: "/admin/rollbacks?payload=c.json", wantStatus: http.StatusOK,
+			want: map[string]string{"b.json": "a.json"},
+		},
+		{
+			name: "enable c again", method: http.MethodDelete, target: "/admin/rollbacks?payload=c.json", wantStatus: http.StatusNotFound,
+			want: map[string]string{"b.json": "a.json"},
+		},
+		{
+			name: "enable b", method: http.MethodDelete, target: "/admin/rollbacks?payload=b.json", wantStatus: http.StatusOK,
+			want: map[string]string{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.target, strings.NewReader(tt.body))
+			req.Header.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
+			resp, err := app.Test(req, -1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+			if resp.StatusCode != tt.wantStatus {
+				t.Fatalf("%s %s: status %d, want %d", tt.method, tt.target, resp.StatusCode, tt.wantStatus)
+			}
+
+			// Users of a disabled payload get its fallback; everyone else keeps
+			// the payload they had
+			for _, user := range users {
+				want := natural[user]
+				if fallback, ok := tt.want[want]; ok {
+					want = fallback
+				}
+				_, body := postExperiment(t, app, user, nil)
+				if !strings.Contains(body, `"selectedPayloadName":"`+want+`"`) {
+					t.Errorf("user %s (naturally %s): body %s, want %s", user, natural[user], body, want)
+				}
+			}
+		})
+	}
+}
+
+func TestProtobufNegotiation(t *testing.T) {
+	s := store.NewPayloadStore(writePayloads(t, testPayloads), store.Limits{})
+	s.SetProtobuf(true)
+	if err := s.Load(); err != nil {
+		t.Fatal(err)
+	}
+	useStore(t, s)
+	app := experimentApp()
+
+	tests := []struct {
+		name      string
+		accept    string
+		wantProto bool
+	}{
+		{name: "no Accept", accept: ""},
+		{name: "JSON", accept: "application/json"},
+		{name: "anything", accept: "*/*"},
+		{name: "protobuf", accept: mimeProtobuf, wantProto: true},
+		{name: "protobuf preferred", accept: mimeProtobuf + ", application/json;q=0.5", wantProto: true},
+		{name: "JSON preferred", accept: "application/json, " + mimeProtobuf + ";q=0.5"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			headers := map[string]string{}
+			if tt.accept != "" {
+				headers[fiber.HeaderAccept] = tt.accept
+			}
+			resp, body := postExperiment(t, app, "alice", headers)
+			if resp.StatusCode != http.StatusOK {
+				t.Fatalf("status %d, want 200: %s", resp.StatusCode, body)
+			}
+			contentType := resp.Header.Get(fiber.HeaderContentType)
+			if !tt.wantProto {
+				if !strings.HasPrefix(contentType, fiber.MIMEApplicationJSON) {
+					t.Errorf("Content-Type %q, want JSON", contentType)
+				}
+				return
+			}
+			if contentType != mimeProtobuf {
+				t.Fatalf("Content-Type %q, want %s", contentType, mimeProtobuf)
+			}
+
+			// The protobuf decodes to the content of the payload JSON serves
+			response, err := proto.UnmarshalResponse([]byte(body))
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := proto.DecodeValue(response.Payload)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var want interface{}
+			if err := json.Unmarshal([]byte(testPayloads[response.SelectedPayloadName]), &want); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("payload %s decodes to %v, want %v", response.SelectedPayloadName, got, want)
+			}
+			if variant := resp.Header.Get(headerVariant); response.SelectedPayloadName != variant {
+				t.Errorf("selectedPayloadName %s, X-Variant %s", response.SelectedPayloadName, variant)
+			}
+		})
+	}
+}
+
+func TestEvictUser(t *testing.T) {
+	app := newTestApp(t, testPayloads)
+	app.Delete("/cache/user/:userId", middleware.BearerAuth("secret"), evictUserHandler)
+	savedCache := idempotencyCache
+	t.Cleanup(func() { idempotencyCache = savedCache })
+	idempotencyCache = idempotency.New[assignment](time.Minute)
+
+	// alice retries two requests, bob one
+	keys := map[string]string{"k1": "alice", "k2": "alice", "k3": "bob"}
+	first := map[string]string{}
+	for key, user := range keys {
+		_, first[key] = postExperiment(t, app, user, map[string]string{headerIdempotencyKey: key})
+	}
+
+	tests := []struct {
+		name          string
+		userID        string
+		token         string
+		wantStatus    int
+		wantEvicted   int
+		wantRecompute []string // keys whose next retry is assigned afresh
+	}{
+		{name: "no token", userID: "alice", wantStatus: http.StatusUnauthorized},
+		{name: "unknown user", userID: "carol", token: "secret", wantStatus: http.StatusOK},
+		{name: "alice", userID: "alice", token: "secret", wantStatus: http.StatusOK, wantEvicted: 2, wantRecompute: []string{"k1", "k2"}},
+		{name: "bob", userID: "bob", token: "secret", wantStatus: http.StatusOK, wantEvicted: 1, wantRecompute: []string{"k3"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodDelete, "/cache/user/"+tt.userID, nil)
+			if tt.token != "" {
+				req.Header.Set(fiber.HeaderAuthorization, "Bearer "+tt.token)
+			}
+			resp, err := app.Test(req, -1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got cacheEvictionResponse
+			err = json.NewDecoder(resp.Body).Decode(&got)
+			resp.Body.Close()
+			if resp.StatusCode != tt.wantStatus {
+				t.Fatalf("status %d, want %d", resp.StatusCode, tt.wantStatus)
+			}
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.UserID != tt.userID || got.Evicted != tt.wantEvicted {
+				t.Errorf("response %+v, want %s with %d evicted", got, tt.userID, tt.wantEvicted)
+			}
+
+			// An evicted assignment is computed again, and being a pure
+			// function of the userId and config, comes out the same
+			for _, key := range tt.wantRecompute {
+				resp, body := postExperiment(t, app, keys[key], map[string]string{headerIdempotencyKey: key})
+				if replayed := resp.Header.Get(headerIdempotentReplayed); replayed != "" {
+					t.Errorf("retry of %s after eviction: %s %q, want it recomputed", key, headerIdempotentReplayed, replayed)
+				}
+				if body != first[key] {
+					t.Errorf("retry of %s after eviction: body %s, want %s", key, body, first[key])
+				}
+			}
+		})
+	}
+}
+
+func TestVariantHeaders(t *testing.T) {
+	app := newTestApp(t, testPayloads)
+	versions, err := allocation.ParseVersionRange(">=2.0.0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	savedRange, savedFallback := appVersionRange, fallbackPayload
+	savedExposure, savedControl := exposurePercent, controlPayload
+	t.Cleanup(func() {
+		appVersionRange, fallbackPayload = savedRange, savedFallback
+		exposurePercent, controlPayload = savedExposure, savedControl
+	})
+	appVersionRange, fallbackPayload, controlPayload = &versions, "a.json", "a.json"
+
+	tests := []struct {
+		name        string
+		noUserID    bool
+		appVersion  string
+		exposure    float64
+		wantStatus  int
+		wantHeaders bool
+	}{
+		{name: "bucketed", appVersion: "2.1.0", exposure: 100, wantStatus: http.StatusOK, wantHeaders: true},
+		{name: "version fallback", appVersion: "1.0.0", exposure: 100, wantStatus: http.StatusOK},
+		{name: "unexposed control", appVersion: "2.1.0", exposure: 0, wantStatus: http.StatusOK},
+		{name: "missing userId", noUserID: true, appVersion: "2.1.0", exposure: 100, wantStatus: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			exposurePercent = tt.exposure
+			for i := 0; i < 20; i++ {
+				userID := fmt.Sprintf("user-%d", i)
+				sent := userID
+				if tt.noUserID {
+					sent = ""
+				}
+				resp, body := postBody(t, app, fmt.Sprintf(`{"userId":%q,"appVersion":%q}`, sent, tt.appVersion), nil)
+				if resp.StatusCode != tt.wantStatus {
+					t.Fatalf("%s: status %d, want %d: %s", userID, resp.StatusCode, tt.wantStatus, body)
+				}
+				experimentHeader := resp.Header.Get(headerExperimentID)
+				variant, bucket := resp.Header.Get(headerVariant), resp.Header.Get(headerBucket)
+				if !tt.wantHeaders {
+					if experimentHeader != "" || variant != "" || bucket != "" {
+						t.Errorf("%s: X-Experiment-Id %q, X-Variant %q, X-Bucket %q, want none", userID, experimentHeader, variant, bucket)
+					}
+					continue
+				}
+
+				// The headers agree with the body, and the bucket is the index
+				// of the payload served
+				var got model.Response
+				if err := json.Unmarshal([]byte(body), &got); err != nil {
+					t.Fatal(err)
+				}
+				if experimentHeader != got.ExperimentID || variant != got.SelectedPayloadName {
+					t.Errorf("%s: X-Experiment-Id %q, X-Variant %q, body has %q, %q",
+						userID, experimentHeader, variant, got.ExperimentID, got.SelectedPayloadName)
+				}
+				if want := payloadStore.Payloads()[allocation.Index(userID, len(testPayloads))].Name; variant != want {
+					t.Errorf("%s: X-Variant %q, want %q", userID, variant, want)
+				}
+				if want := strconv.Itoa(allocation.Index(userID, len(testPayloads))); bucket != want {
+					t.Errorf("%s: X-Bucket %q, want %s", userID, bucket, want)
+				}
+			}
+		})
+	}
+}
+
+func TestSchemaVersionNegotiation(t *testing.T) {
+	app := newTestApp(t, map[string]string{
+		"a.json": `{"greeting":"hello"}`,
+		"b.json": `{"schemaVersion":2,"greeting":"hi"}`,
+		"c.json": `{"schemaVersion":3,"greeting":"hey"}`,
+	})
+	versions := map[string]int{"a.json": 1, "b.json": 2, "c.json": 3}
+	savedCheck, savedFallback := schemaCheck, fallbackPayload
+	t.Cleanup(func() { schemaCheck, fallbackPayload = savedCheck, savedFallback })
+	fallbackPayload = "c.json"
+
+	tests := []struct {
+		check      string
+		minVersion string // X-Min-Schema-Version, empty for none
+		// want is what a user whose own payload is older than minVersion
+		// gets: a payload name, or "" for a 406
+		want string
+	}{
+		{check: schemaCheckOff, minVersion: "3", want: "own"},
+		{check: schemaCheckReject, minVersion: "", want: "own"},
+		{check: schemaCheckReject, minVersion: "1", want: "own"},
+		{check: schemaCheckReject, minVersion: "2"},
+		{check: schemaCheckReject, minVersion: "3"},
+		{check: schemaCheckFallback, minVersion: "2", want: "c.json"},
+		{check: schemaCheckFallback, minVersion: "3", want: "c.json"},
+		{check: schemaCheckFallback, minVersion: "4"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.check+" min "+tt.minVersion, func(t *testing.T) {
+			schemaCheck = tt.check
+			required, _ := strconv.Atoi(tt.minVersion)
+			headers := map[string]string{}
+			if tt.minVersion != "" {
+				headers[headerMinSchemaVersion] = tt.minVersion
+			}
+			for i := 0; i < 30; i++ {
+				userID := fmt.Sprintf("user-%d", i)
+				own := payloadStore.Payloads()[allocation.Index(userID, len(versions))].Name
+				want := own
+				if versions[own] < required && tt.want != "own" {
+					want = tt.want
+				}
+
+				resp, body := postExperiment(t, app, userID, headers)
+				if want == "" {
+					if resp.StatusCode != http.StatusNotAcceptable {
+						t.Errorf("%s (%s, v%d): status %d, want 406", userID, own, versions[own], resp.StatusCode)
+					}
+					continue
+				}
+				if resp.StatusCode != http.StatusOK {
+					t.Fatalf("%s: status %d, want 200: %s", userID, resp.StatusCode, body)
+				}
+				var got model.Response
+				if err := json.Unmarshal([]byte(body), &got); err != nil {
+					t.Fatal(err)
+				}
+				if got.SelectedPayloadName != want {
+					t.Errorf("%s (%s, v%d): served %s, want %s", userID, own, versions[own], got.SelectedPayloadName, want)
+				}
+				header := resp.Header.Get(headerSchemaVersion)
+				if got.SchemaVersion != versions[want] || header != strconv.Itoa(versions[want]) {
+					t.Errorf("%s: schemaVersion %d, X-Schema-Version %q, want %d", userID, got.SchemaVersion, header, versions[want])
+				}
+			}
+		})
+	}
+}
+
+func TestHealthCheckWarming(t *testing.T) {
+	savedState := serverState
+	t.Cleanup(func() { serverState = savedState })
+	serverState = lifecycle.New()
+	app := fiber.New()
+	app.Get("/health", healthCheck)
+
+	warmed := make(chan struct{})
+	ready := make(chan struct{})
+	go func() {
+		markReadyWhenWarm(warmed)
+		close(ready)
+	}()
+
+	tests := []struct {
+		name       string
+		advance    func()
+		wantStatus int
+		wantBody   string
+	}{
+		{name: "warming", advance: func() {}, wantStatus: http.StatusServiceUnavailable, wantBody: `"status":"starting"`},
+		{name: "warm", advance: func() { close(warmed); <-ready }, wantStatus: http.StatusOK, wantBody: `"status":"ok"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.advance()
+			resp, err := app.Test(httptest.NewRequest(http.MethodGet, "/health", nil), -1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			body, _ := io.ReadAll(resp.Body)
+			resp.Body.Close()
+			if resp.StatusCode != tt.wantStatus || !strings.Contains(string(body), tt.wantBody) {
+				t.Errorf("status %d, body %s, want %d with %s", resp.StatusCode, body, tt.wantStatus, tt.wantBody)
+			}
+		})
+	}
+}
+
+func TestRawResponses(t *testing.T) {
+	payloads := map[string]string{
+		"a.json": `{"greeting":"hello"}`,
+		"b.html": "<p>Bonjour, <b>monde</b></p>\n",
+		"c.bin":  "\x00\x01\xfe\xff binary \x80",
+		"d.ftl":  "greeting = Hallo\n",
+	}
+	types, err := store.ParseContentTypes(".html,.bin,.ftl=text/plain; charset=utf-8")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := store.NewPayloadStore(writePayloads(t, payloads), store.Limits{})
+	s.SetContentTypes(types)
+	if err := s.Load(); err != nil {
+		t.Fatal(err)
+	}
+	useStore(t, s)
+	saved := responseMode
+	t.Cleanup(func() { responseMode = saved })
+	responseMode = responseModeRaw
+	app := experimentApp()
+
+	tests := []struct {
+		payload         string
+		wantContentType string
+	}{
+		{payload: "a.json", wantContentType: "application/json"},
+		{payload: "b.html", wantContentType: "text/html; charset=utf-8"},
+		{payload: "c.bin", wantContentType: "application/octet-stream"},
+		{payload: "d.ftl", wantContentType: "text/plain; charset=utf-8"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.payload, func(t *testing.T) {
+			served := 0
+			for i := 0; i < 40; i++ {
+				userID := fmt.Sprintf("user-%d", i)
+				if payloadStore.Payloads()[allocation.Index(userID, len(payloads))].Name != tt.payload {
+					continue
+				}
+				served++
+				resp, body := postExperiment(t, app, userID, nil)
+				if resp.StatusCode != http.StatusOK {
+					t.Fatalf("%s: status %d, want 200", userID, resp.StatusCode)
+				}
+				// The payload's bytes as is, not wrapped in the envelope
+				if body != payloads[tt.payload] {
+					t.Errorf("%s: body %q, want %q", userID, body, payloads[tt.payload])
+				}
+				if got := resp.Header.Get(fiber.HeaderContentType); got != tt.wantContentType {
+					t.Errorf("%s: Content-Type %q, want %q", userID, got, tt.wantContentType)
+				}
+				if got := resp.Header.Get(headerPayloadName); got != tt.payload {
+					t.Errorf("%s: %s %q, want %q", userID, headerPayloadName, got, tt.payload)
+				}
+				sum := sha256.Sum256([]byte(payloads[tt.payload]))
+				if got := resp.Header.Get(headerPayloadSHA256); got != hex.EncodeToString(sum[:]) {
+					t.Errorf("%s: %s %s, want the SHA-256 of the bytes served", userID, headerPayloadSHA256, got)
+				}
+			}
+			if served == 0 {
+				t.Fatalf("no test user is bucketed into %s", tt.payload)
+			}
+		})
+	}
+}
+
+func TestDegradeUnderLoad(t *testing.T) {
+	newTestApp(t, map[string]string{
+		"a.json":    `{"greeting":"hello","farewell":"bye"}`,
+		"b.json":    `{"greeting":"hi","farewell":"ciao"}`,
+		"lite.json": `{"greeting":"hi"}`,
+	})
+	var inFlight atomic.Int64
+	savedDegrader, savedPayload := loadDegrader, degradePayload
+	t.Cleanup(func() { loadDegrader, degradePayload = savedDegrader, savedPayload })
+	loadDegrader, degradePayload = middleware.NewLoadDegrader(100, 50, inFlight.Load), "lite.json"
+	app := fiber.New()
+	app.Post("/experiment", loadDegrader.Handler(), experiment)
+
+	tests := []struct {
+		name         string
+		inFlight     int64
+		minSchema    string
+		wantDegraded bool
+	}{
+		{name: "idle", inFlight: 0},
+		{name: "over the high watermark", inFlight: 150, wantDegraded: true},
+		{name: "still above the low watermark", inFlight: 60, wantDegraded: true},
+		{name: "client needs a newer schema than lite's", inFlight: 60, minSchema: "2"},
+		{name: "recovered", inFlight: 10},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			inFlight.Store(tt.inFlight)
+			headers := map[string]string{}
+			if tt.minSchema != "" {
+				headers[headerMinSchemaVersion] = tt.minSchema
+			}
+			before := loadDegrader.Degraded()
+			degraded := int64(0)
+			for i := 0; i < 30; i++ {
+				userID := fmt.Sprintf("user-%d", i)
+				own := payloadStore.Payloads()[allocation.Index(userID, 3)].Name
+				resp, body := postExperiment(t, app, userID, headers)
+				if resp.StatusCode != http.StatusOK {
+					t.Fatalf("%s: status %d: %s", userID, resp.StatusCode, body)
+				}
+				var got model.Response
+				if err := json.Unmarshal([]byte(body), &got); err != nil {
+					t.Fatal(err)
+				}
+
+				// Users whose own payload is the lite one get it as usual
+				wantDegraded := tt.wantDegraded && own != degradePayload
+				want := own
+				if wantDegraded {
+					want = degradePayload
+					degraded++
+				}
+				if got.SelectedPayloadName != want || got.Degraded != wantDegraded {
+					t.Errorf("%s (%s): served %s, degraded %v, want %s, %v", userID, own, got.SelectedPayloadName, got.Degraded, want, wantDegraded)
+				}
+				if header := resp.Header.Get(headerDegraded) == "true"; header != wantDegraded {
+					t.Errorf("%s: %s %v, want %v", userID, headerDegraded, header, wantDegraded)
+				}
+			}
+			if got := loadDegrader.Degraded() - before; got != degraded {
+				t.Errorf("degraded counter rose by %d, want %d", got, degraded)
+			}
+		})
+	}
+}
+
+func TestStreamEntries(t *testing.T) {
+	// Enough entries for several flush batches and more than one buffer of
+	// bytes, so a layer that is never flushed loses the tail
+	var content strings.Builder
+	content.WriteString("{")
+	const entries = 3*ndjsonFlushEntries + 7
+	for i := 0; i < entries; i++ {
+		if i > 0 {
+			content.WriteString(",")
+		}
+		fmt.Fprintf(&content, `"key-%04d":"value %d"`, i, i)
+	}
+	content.WriteString("}")
+	s := store.NewPayloadStore(writePayloads(t, map[string]string{"a.json": content.String()}), store.Limits{})
+	s.SetEntries(true)
+	if err := s.Load(); err != nil {
+		t.Fatal(err)
+	}
+	useStore(t, s)
+	saved := responseThrottle
+	t.Cleanup(func() { responseThrottle = saved })
+	app := experimentApp()
+
+	tests := []struct {
+		name     string
+		throttle int64
+	}{
+		{name: "unthrottled", throttle: 0},
+		{name: "throttled", throttle: 1 << 20},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			responseThrottle = tt.throttle
+			resp, body := postExperiment(t, app, "user-1", map[string]string{fiber.HeaderAccept: mimeNDJSON})
+			if resp.StatusCode != http.StatusOK {
+				t.Fatalf("status %d: %s", resp.StatusCode, body)
+			}
+			if got := resp.Header.Get(fiber.HeaderContentType); got != mimeNDJSON {
+				t.Errorf("Content-Type %q, want %q", got, mimeNDJSON)
+			}
+			// The header line, then one line per entry
+			if got := strings.Count(body, "\n"); got != entries+1 {
+				t.Fatalf("%d lines, want %d", got, entries+1)
+			}
+			header, rest, _ := strings.Cut(body, "\n")
+			var got model.StreamHeader
+			if err := json.Unmarshal([]byte(header), &got); err != nil {
+				t.Fatal(err)
+			}
+			if got.SelectedPayloadName != "a.json" {
+				t.Errorf("header names %s, want a.json", got.SelectedPayloadName)
+			}
+			object, err := store.ReadEntries(strings.NewReader(rest))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(object) != entries || object["key-0000"] != "value 0" {
+				t.Errorf("reassembled %d entries, key-0000 = %v, want %d, value 0", len(object), object["key-0000"], entries)
+			}
+		})
+	}
+}
+
+func TestWatchReload(t *testing.T) {
+	dir := writePayloads(t, map[string]string{"a.json": `{"id":"a"}`, "control.json": `{"id":"control"}`})
+	s := store.NewPayloadStore(dir, store.Limits{})
+	if err := s.Load(); err != nil {
+		t.Fatal(err)
+	}
+	useStore(t, s)
+	saved := reloadLint
+	t.Cleanup(func() { reloadLint = saved })
+	reloadLint = &lintOptions{Exposure: 50, ControlPayload: "control.json"}
+
+	tests := []struct {
+		name      string
+		write     string // file to add before the reload
+		remove    string // file to delete before the reload
+		wantErr   bool
+		wantNames []string
+	}{
+		{name: "passes lint", write: "b.json", wantNames: []string{"a.json", "b.json", "control.json"}},
+		{name: "removes the control payload", remove: "control.json", wantErr: true, wantNames: []string{"a.json", "b.json", "control.json"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.write != "" {
+				if err := os.WriteFile(filepath.Join(dir, tt.write), []byte(`{}`), 0o644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if tt.remove != "" {
+				if err := os.Remove(filepath.Join(dir, tt.remove)); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := watchReload(); (err != nil) != tt.wantErr {
+				t.Errorf("watchReload() = %v, want error %v", err, tt.wantErr)
+			}
+			var names []string
+			for _, p := range payloadStore.Payloads() {
+				names = append(names, p.Name)
+			}
+			if !reflect.DeepEqual(names, tt.wantNames) {
+				t.Errorf("serving %v, want %v", names, tt.wantNames)
+			}
+		})
+	}
+}
@@ -0,0 +1,46 @@
+// Package allocation contains the deterministic user-to-payload assignment used
+// by the experiment endpoint, along with diagnostics for checking that the
+// assignment spreads users fairly across payloads.
+package allocation
+
+import (
+	"hash/fnv"
+	"math/bits"
+)
+
+// Mapper maps a user ID onto one of n buckets. Every Mapper must be
+// deterministic: the same user ID and n always produce the same bucket.
+type Mapper func(userID string, n int) int
+
+// Index returns the bucket in [0, n) for userID. It hashes the ID with 32-bit
+// FNV-1a and reduces the hash modulo n. This is the mapping the server uses;
+// changing it would reassign every user.
+func Index(userID string, n int) int {
+	h := fnv.New32a()
+	h.Write([]byte(userID))
+	return int(h.Sum32()) % n
+}
+
+// WideIndex returns the bucket in [0, n) for userID using a 64-bit hash and a
+// multiply-shift reduction instead of a modulo. Its bias is bounded by n/2^64,
+// which is unmeasurable for any realistic bucket count.
+//
+// WideIndex assigns users differently from Index, so switching the server to
+// it reshuffles every user.
+func WideIndex(userID string, n int) int {
+	h := fnv.New64a()
+	h.Write([]byte(userID))
+	hi, _ := bits.Mul64(mix64(h.Sum64()), uint64(n))
+	return int(hi)
+}
+
+// mix64 is the murmur3 64-bit finalizer. FNV-1a's high bits avalanche poorly
+// for short keys, and the multiply-shift reduction only uses the high bits.
+func mix64(x uint64) uint64 {
+	x ^= x >> 33
+	x *= 0xff51afd7ed558ccd
+	x ^= x >> 33
+	x *= 0xc4ceb9fe1a85ec53
+	x ^= x >> 33
+	return x
+}
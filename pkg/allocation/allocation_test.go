@@ -0,0 +1,52 @@
+package allocation
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+// benchUsers is the number of distinct userIds each benchmark cycles through,
+// so results reflect a mix of buckets rather than one hot key.
+const benchUsers = 1024
+
+func benchUserIDs() []string {
+	ids := make([]string, benchUsers)
+	for i := range ids {
+		ids[i] = uuid.NewString()
+	}
+	return ids
+}
+
+// BenchmarkIndex measures the default bucketing at a few variant counts. It
+// should stay at 0 allocs/op.
+func BenchmarkIndex(b *testing.B) {
+	userIDs := benchUserIDs()
+	for _, variants := range []int{2, 5, 20} {
+		b.Run(fmt.Sprintf("variants=%d", variants), func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				Index(userIDs[i%benchUsers], variants)
+			}
+		})
+	}
+}
+
+// BenchmarkHashAlgorithm measures each -hash-algorithm's Mapper at 20
+// variants.
+func BenchmarkHashAlgorithm(b *testing.B) {
+	userIDs := benchUserIDs()
+	for _, name := range HashAlgorithms() {
+		mapper, err := MapperFor(name)
+		if err != nil {
+			b.Fatal(err)
+		}
+		b.Run(name, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				mapper(userIDs[i%benchUsers], 20)
+			}
+		})
+	}
+}
@@ -0,0 +1,189 @@
+package allocation
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// BalanceRecord is one user's first assignment by a Balancer, as it journals
+// them: one JSON object per line.
+type BalanceRecord struct {
+	UserID  string `json:"userId"`
+	Bucket  int    `json:"bucket"`
+	Buckets int    `json:"buckets"`
+}
+
+// Balancer nudges new users near the edge of their bucket into the neighbouring
+// bucket when that one has fewer users, so a small population splits closer to
+// evenly than hashing alone leaves it. Only users within margin/2 of an edge
+// can move, and only one bucket over, so at most margin of users ever get
+// anything but their hash bucket.
+//
+// Adaptive assignment depends on who arrived first, so it can't be recomputed
+// from the userId: a Balancer remembers every user it assigns and gives them
+// the same bucket from then on, and journals each assignment so a restarted
+// server can restore them. Once maxUsers are remembered it stops adapting and
+// assigns every further user their hash bucket, which is sticky on its own;
+// by then hashing has evened out the split anyway. A change in the bucket
+// count, which reshuffles hash buckets too, starts it over.
+//
+// A Balancer is safe for concurrent use.
+type Balancer struct {
+	algorithm string
+	margin    float64
+	maxUsers  int
+
+	mu       sync.Mutex
+	buckets  int            // bucket count the state below is for
+	counts   []int          // users assigned to each bucket
+	assigned map[string]int // every user remembered, to their bucket
+	journal  *json.Encoder  // nil when not journaling
+	stopped  bool           // set after a journal write fails
+}
+
+// NewBalancer creates a Balancer bucketing with algorithm, restoring the
+// assignments in state, a journal an earlier Balancer wrote, and journaling
+// new ones to journal. Either may be nil. Records for a bucket count other
+// than the last one journaled are from before a reshuffle and are skipped.
+func NewBalancer(algorithm string, margin float64, maxUsers int, state io.Reader, journal io.Writer) (*Balancer, error) {
+	if _, err := MapperFor(algorithm); err != nil {
+		return nil, err
+	}
+	if margin <= 0 || margin > 1 {
+		return nil, fmt.Errorf("margin must be above 0 and at most 1, got %g", margin)
+	}
+	if maxUsers < 1 {
+		return nil, fmt.Errorf("max users must be positive, got %d", maxUsers)
+	}
+	b := &Balancer{algorithm: algorithm, margin: margin, maxUsers: maxUsers, assigned: map[string]int{}}
+	if journal != nil {
+		b.journal = json.NewEncoder(journal)
+	}
+	if state == nil {
+		return b, nil
+	}
+
+	var records []BalanceRecord
+	scanner := bufio.NewScanner(state)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var r BalanceRecord
+		if err := json.Unmarshal(scanner.Bytes(), &r); err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		if r.Buckets < 1 || r.Bucket < 0 || r.Bucket >= r.Buckets {
+			return nil, fmt.Errorf("line %d: bucket %d out of range for %d buckets", line, r.Bucket, r.Buckets)
+		}
+		records = append(records, r)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(records) == 0 {
+		return b, nil
+	}
+	b.reset(records[len(records)-1].Buckets)
+	for _, r := range records {
+		if _, seen := b.assigned[r.UserID]; r.Buckets == b.buckets && !seen {
+			b.assigned[r.UserID] = r.Bucket
+			b.counts[r.Bucket]++
+		}
+	}
+	return b, nil
+}
+
+// Remembered returns how many users the Balancer has assigned and will keep
+// in their bucket.
+func (b *Balancer) Remembered() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return len(b.assigned)
+}
+
+// Assign returns userID's bucket among n, and the hash bucket it was nudged
+// from, or -1 if the user is in their hash bucket. A remembered user always
+// gets the bucket they got first. The error is set when journaling a new
+// assignment failed; the user then gets their hash bucket, and the Balancer
+// stops adapting, since users it can't journal can't be restored.
+func (b *Balancer) Assign(userID string, n int) (bucket, nudgedFrom int, err error) {
+	// The algorithm was validated in NewBalancer
+	natural, offset, _ := Position(b.algorithm, userID, n)
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if n != b.buckets {
+		b.reset(n)
+	}
+	bucket, nudgedFrom, remembered := b.choose(userID, n, natural, offset)
+	if remembered || b.stopped || len(b.assigned) >= b.maxUsers {
+		return bucket, nudgedFrom, nil
+	}
+
+	if b.journal != nil {
+		if err := b.journal.Encode(BalanceRecord{UserID: userID, Bucket: bucket, Buckets: n}); err != nil {
+			b.stopped = true
+			return natural, -1, fmt.Errorf("journaling %s: %w", userID, err)
+		}
+	}
+	b.assigned[userID] = bucket
+	b.counts[bucket]++
+	return bucket, nudgedFrom, nil
+}
+
+// Peek returns the bucket Assign would give userID among n right now, and the
+// hash bucket it would be nudged from or -1, without remembering or
+// journaling anything. A remembered user gets their bucket; a new user gets
+// the bucket the current counts put them in, which can change as other users
+// are assigned before they are.
+func (b *Balancer) Peek(userID string, n int) (bucket, nudgedFrom int) {
+	natural, offset, _ := Position(b.algorithm, userID, n)
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if n != b.buckets {
+		// Assign would start over, and an empty Balancer nudges no one
+		return natural, -1
+	}
+	bucket, nudgedFrom, _ = b.choose(userID, n, natural, offset)
+	return bucket, nudgedFrom
+}
+
+// choose picks userID's bucket from the current state without changing it:
+// their remembered bucket, or for a new user their hash bucket natural, or
+// its neighbour when the user's offset in it is within margin/2 of that edge
+// and the neighbour has fewer users. b.mu must be held and b.buckets be n.
+func (b *Balancer) choose(userID string, n, natural int, offset float64) (bucket, nudgedFrom int, remembered bool) {
+	if bucket, ok := b.assigned[userID]; ok {
+		if bucket == natural {
+			return bucket, -1, true
+		}
+		return bucket, natural, true
+	}
+	if b.stopped || len(b.assigned) >= b.maxUsers {
+		return natural, -1, false
+	}
+
+	neighbour := -1
+	switch {
+	case offset < b.margin/2:
+		neighbour = (natural + n - 1) % n
+	case offset >= 1-b.margin/2:
+		neighbour = (natural + 1) % n
+	}
+	if neighbour >= 0 && b.counts[neighbour] < b.counts[natural] {
+		return neighbour, natural, false
+	}
+	return natural, -1, false
+}
+
+func (b *Balancer) reset(n int) {
+	b.buckets = n
+	b.counts = make([]int, n)
+	b.assigned = map[string]int{}
+}
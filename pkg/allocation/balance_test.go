@@ -0,0 +1,264 @@
+package allocation
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+)
+
+// spread returns how far the fullest and emptiest of n buckets are apart.
+func spread(counts []int) int {
+	lo, hi := counts[0], counts[0]
+	for _, c := range counts {
+		lo, hi = min(lo, c), max(hi, c)
+	}
+	return hi - lo
+}
+
+// opaqueID returns the i-th of a population of hex user IDs, spread like
+// random ones rather than counting up.
+func opaqueID(i int) string {
+	return fmt.Sprintf("%08x", uint32(i)*2654435761)
+}
+
+func TestBalancerConvergence(t *testing.T) {
+	tests := []struct {
+		users   int
+		buckets int
+		margin  float64
+	}{
+		{users: 20, buckets: 2, margin: 0.2},
+		{users: 30, buckets: 3, margin: 0.2},
+		{users: 50, buckets: 4, margin: 0.2},
+		{users: 100, buckets: 3, margin: 0.1},
+		{users: 100, buckets: 5, margin: 0.3},
+		{users: 200, buckets: 2, margin: 0.1},
+	}
+	totalHashed, totalAdaptive := 0, 0
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("%d users in %d buckets, margin %g", tt.users, tt.buckets, tt.margin), func(t *testing.T) {
+			b, err := NewBalancer(DefaultHashAlgorithm, tt.margin, tt.users, nil, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			hashed, adaptive := make([]int, tt.buckets), make([]int, tt.buckets)
+			nudged := 0
+			for i := 0; i < tt.users; i++ {
+				userID := opaqueID(i)
+				natural := Index(userID, tt.buckets)
+				bucket, nudgedFrom, err := b.Assign(userID, tt.buckets)
+				if err != nil {
+					t.Fatal(err)
+				}
+				hashed[natural]++
+				adaptive[bucket]++
+				if nudgedFrom < 0 {
+					if bucket != natural {
+						t.Fatalf("%s: bucket %d, not nudged, but hashes to %d", userID, bucket, natural)
+					}
+					continue
+				}
+				// A nudge moves a user one bucket over from their hash bucket
+				nudged++
+				if nudgedFrom != natural || (bucket != (natural+1)%tt.buckets && bucket != (natural+tt.buckets-1)%tt.buckets) {
+					t.Errorf("%s: bucket %d nudged from %d, but hashes to %d", userID, bucket, nudgedFrom, natural)
+				}
+			}
+			if spread(adaptive) > spread(hashed) {
+				t.Errorf("adaptive split %v is further from even than hashing's %v", adaptive, hashed)
+			}
+			totalHashed += spread(hashed)
+			totalAdaptive += spread(adaptive)
+			t.Logf("hashed %v, adaptive %v, %d nudged", hashed, adaptive, nudged)
+		})
+	}
+	if totalAdaptive >= totalHashed {
+		t.Errorf("adaptive splits are %d users from even in all, hashing's %d: no closer", totalAdaptive, totalHashed)
+	}
+}
+
+func TestBalancerSticky(t *testing.T) {
+	var journal bytes.Buffer
+	b, err := NewBalancer(DefaultHashAlgorithm, 0.3, 1000, nil, &journal)
+	if err != nil {
+		t.Fatal(err)
+	}
+	first := map[string]int{}
+	for i := 0; i < 100; i++ {
+		userID := fmt.Sprintf("user-%d", i)
+		first[userID], _, _ = b.Assign(userID, 3)
+	}
+	journaled := journal.Len()
+
+	// A restored Balancer gives everyone their first bucket too
+	restored, err := NewBalancer(DefaultHashAlgorithm, 0.3, 1000, bytes.NewReader(journal.Bytes()), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name     string
+		balancer *Balancer
+	}{
+		{name: "same balancer", balancer: b},
+		{name: "restored from the journal", balancer: restored},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if n := tt.balancer.Remembered(); n != len(first) {
+				t.Errorf("Remembered() = %d, want %d", n, len(first))
+			}
+			for userID, want := range first {
+				if bucket, _ := tt.balancer.Peek(userID, 3); bucket != want {
+					t.Errorf("Peek(%s) = %d, want the first bucket %d", userID, bucket, want)
+				}
+				if bucket, _, err := tt.balancer.Assign(userID, 3); bucket != want || err != nil {
+					t.Errorf("Assign(%s) = %d, %v, want the first bucket %d", userID, bucket, err, want)
+				}
+			}
+		})
+	}
+	if journal.Len() != journaled {
+		t.Errorf("assigning remembered users again journaled %q", journal.String()[journaled:])
+	}
+}
+
+// TestBalancerPeek checks that Peek predicts Assign without remembering or
+// journaling anyone, so lookups can't change who arrived first.
+func TestBalancerPeek(t *testing.T) {
+	var journal bytes.Buffer
+	b, err := NewBalancer(DefaultHashAlgorithm, 0.5, 1000, nil, &journal)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 200; i++ {
+		userID := fmt.Sprintf("user-%d", i)
+		peeked, peekedFrom := b.Peek(userID, 4)
+		again, _ := b.Peek(userID, 4)
+		if b.Remembered() != i || strings.Count(journal.String(), "\n") != i {
+			t.Fatalf("Peek(%s) remembered or journaled the user", userID)
+		}
+		bucket, nudgedFrom, err := b.Assign(userID, 4)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if peeked != again || peeked != bucket || peekedFrom != nudgedFrom {
+			t.Fatalf("Peek(%s) = %d, %d then %d, but Assign = %d, %d", userID, peeked, peekedFrom, again, bucket, nudgedFrom)
+		}
+	}
+	// A bucket count Assign would start over for is peeked at as an empty
+	// Balancer, without resetting this one
+	if bucket, nudgedFrom := b.Peek("user-0", 5); bucket != Index("user-0", 5) || nudgedFrom != -1 {
+		t.Errorf("Peek(user-0, 5) = %d, %d, want the hash bucket", bucket, nudgedFrom)
+	}
+	if n := b.Remembered(); n != 200 {
+		t.Errorf("Remembered() = %d after peeking at another bucket count, want 200", n)
+	}
+}
+
+func TestBalancerLimits(t *testing.T) {
+	tests := []struct {
+		name      string
+		maxUsers  int
+		failAfter int // journal writes that succeed; -1 for no journal
+		wantKept  int
+		wantErrs  int
+	}{
+		{name: "remembers up to maxUsers", maxUsers: 10, failAfter: -1, wantKept: 10},
+		{name: "stops on a journal error", maxUsers: 100, failAfter: 5, wantKept: 5, wantErrs: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var journal io.Writer
+			if tt.failAfter >= 0 {
+				journal = &failingWriter{okWrites: tt.failAfter}
+			}
+			b, err := NewBalancer(DefaultHashAlgorithm, 1, tt.maxUsers, nil, journal)
+			if err != nil {
+				t.Fatal(err)
+			}
+			errs := 0
+			for i := 0; i < 50; i++ {
+				userID := opaqueID(i)
+				bucket, nudgedFrom, err := b.Assign(userID, 3)
+				if err != nil {
+					errs++
+				}
+				// Past the limit, or once journaling failed, everyone new
+				// gets their hash bucket
+				if i >= tt.wantKept && (bucket != Index(userID, 3) || nudgedFrom != -1) {
+					t.Errorf("%s: bucket %d nudged from %d after the Balancer stopped adapting", userID, bucket, nudgedFrom)
+				}
+			}
+			if n := b.Remembered(); n != tt.wantKept {
+				t.Errorf("Remembered() = %d, want %d", n, tt.wantKept)
+			}
+			if errs != tt.wantErrs {
+				t.Errorf("%d Assign errors, want %d", errs, tt.wantErrs)
+			}
+		})
+	}
+}
+
+// failingWriter accepts okWrites writes and fails every one after.
+type failingWriter struct {
+	okWrites int
+}
+
+func (w *failingWriter) Write(p []byte) (int, error) {
+	if w.okWrites == 0 {
+		return 0, errors.New("disk full")
+	}
+	w.okWrites--
+	return len(p), nil
+}
+
+func TestNewBalancerErrors(t *testing.T) {
+	tests := []struct {
+		name      string
+		algorithm string
+		margin    float64
+		maxUsers  int
+		state     string
+		wantErr   string
+	}{
+		{name: "unknown algorithm", algorithm: "md5", margin: 0.2, maxUsers: 10, wantErr: "md5"},
+		{name: "zero margin", algorithm: DefaultHashAlgorithm, margin: 0, maxUsers: 10, wantErr: "margin"},
+		{name: "margin over 1", algorithm: DefaultHashAlgorithm, margin: 1.5, maxUsers: 10, wantErr: "margin"},
+		{name: "no users", algorithm: DefaultHashAlgorithm, margin: 0.2, maxUsers: 0, wantErr: "max users"},
+		{name: "bad journal line", algorithm: DefaultHashAlgorithm, margin: 0.2, maxUsers: 10, state: "{\"userId\":\"a\",\"bucket\":0,\"buckets\":2}\nnot json\n", wantErr: "line 2"},
+		{name: "bucket out of range", algorithm: DefaultHashAlgorithm, margin: 0.2, maxUsers: 10, state: `{"userId":"a","bucket":2,"buckets":2}`, wantErr: "out of range"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewBalancer(tt.algorithm, tt.margin, tt.maxUsers, strings.NewReader(tt.state), nil)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("NewBalancer error = %v, want one containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+// TestNewBalancerSkipsOldBucketCounts restores a journal spanning a change in
+// the bucket count: only the assignments for the last count are kept.
+func TestNewBalancerSkipsOldBucketCounts(t *testing.T) {
+	state := strings.Join([]string{
+		`{"userId":"a","bucket":1,"buckets":2}`,
+		`{"userId":"b","bucket":0,"buckets":2}`,
+		``,
+		`{"userId":"a","bucket":2,"buckets":3}`,
+		`{"userId":"c","bucket":0,"buckets":3}`,
+	}, "\n")
+	b, err := NewBalancer(DefaultHashAlgorithm, 0.2, 10, strings.NewReader(state), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := b.Remembered(); n != 2 {
+		t.Errorf("Remembered() = %d, want 2", n)
+	}
+	if bucket, _ := b.Peek("a", 3); bucket != 2 {
+		t.Errorf("Peek(a, 3) = %d, want the journaled 2", bucket)
+	}
+}
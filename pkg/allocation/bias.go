@@ -0,0 +1,282 @@
+package allocation
+
+import (
+	"math"
+)
+
+// BiasReport describes how far a population's bucket frequencies deviate from
+// a perfectly uniform split.
+type BiasReport struct {
+	Buckets          int
+	Samples          int
+	Counts           []int
+	Expected         float64 // expected users per bucket under a uniform split
+	MinCount         int
+	MaxCount         int
+	MaxDeviation     float64 // largest |count-expected|/expected across buckets
+	ChiSquare        float64
+	DegreesOfFreedom int
+	PValue           float64 // probability of a chi-square this large if the split were uniform
+}
+
+// Detectable reports whether the deviation from uniform is statistically
+// significant at the given significance level (e.g. 0.01).
+func (r BiasReport) Detectable(alpha float64) bool {
+	return r.PValue < alpha
+}
+
+// MeasureBias assigns samples user IDs produced by userID to buckets with mapper
+// and runs a chi-square goodness-of-fit test against a uniform distribution.
+func MeasureBias(mapper Mapper, buckets, samples int, userID func(i int) string) BiasReport {
+	counts := make([]int, buckets)
+	for i := 0; i < samples; i++ {
+		counts[mapper(userID(i), buckets)]++
+	}
+	return MeasureUniformity(counts)
+}
+
+// MeasureUniformity runs a chi-square goodness-of-fit test of observed bucket
+// counts against a uniform distribution, for users bucketed elsewhere, e.g. by
+// a running server. counts must not be empty.
+func MeasureUniformity(counts []int) BiasReport {
+	buckets, samples := len(counts), 0
+	for _, count := range counts {
+		samples += count
+	}
+
+	expected := float64(samples) / float64(buckets)
+	report := BiasReport{
+		Buckets:          buckets,
+		Samples:          samples,
+		Counts:           counts,
+		Expected:         expected,
+		MinCount:         counts[0],
+		MaxCount:         counts[0],
+		DegreesOfFreedom: buckets - 1,
+	}
+
+	for _, count := range counts {
+		diff := float64(count) - expected
+		report.ChiSquare += diff * diff / expected
+		if dev := math.Abs(diff) / expected; dev > report.MaxDeviation {
+			report.MaxDeviation = dev
+		}
+		if count < report.MinCount {
+			report.MinCount = count
+		}
+		if count > report.MaxCount {
+			report.MaxCount = count
+		}
+	}
+	report.PValue = chiSquareSurvival(report.ChiSquare, report.DegreesOfFreedom)
+
+	return report
+}
+
+// IndependenceReport is a chi-square test of independence between two ways of
+// classifying the same users, e.g. their locale and their assigned payload.
+type IndependenceReport struct {
+	Samples          int
+	Columns          int // column groups tested after pooling sparse columns
+	ChiSquare        float64
+	DegreesOfFreedom int
+	PValue           float64 // probability of a chi-square this large if the two were independent
+	MinExpected      float64 // smallest expected cell count under independence
+}
+
+// Reliable reports whether every cell expects at least 5 users, the usual
+// rule of thumb for the chi-square approximation to hold.
+func (r IndependenceReport) Reliable() bool {
+	return r.DegreesOfFreedom > 0 && r.MinExpected >= 5
+}
+
+// Detectable reports whether the two classifications are significantly
+// associated at the given significance level.
+func (r IndependenceReport) Detectable(alpha float64) bool {
+	return r.PValue < alpha
+}
+
+// MeasureIndependence runs a chi-square test of independence on a contingency
+// table of counts, table[row][column]. Rows and columns with no users are
+// ignored. With many sparse columns (thousands of payloads), adjacent columns
+// are pooled until every cell expects at least 5 users; pooling coarsens the
+// test but keeps it valid.
+func MeasureIndependence(table [][]int) IndependenceReport {
+	var rows [][]int
+	var rowTotals []int
+	width := 0
+	for _, row := range table {
+		total := 0
+		for _, count := range row {
+			total += count
+		}
+		if total > 0 {
+			rows = append(rows, row)
+			rowTotals = append(rowTotals, total)
+			width = max(width, len(row))
+		}
+	}
+	report := IndependenceReport{PValue: 1}
+	if len(rows) < 2 {
+		return report
+	}
+
+	samples := 0
+	minRow := rowTotals[0]
+	for _, total := range rowTotals {
+		samples += total
+		minRow = min(minRow, total)
+	}
+	report.Samples = samples
+
+	// Pool columns in order until each group is big enough for the smallest
+	// row to expect 5 users in it. A short final group joins the one before.
+	needed := 5 * float64(samples) / float64(minRow)
+	var groups [][]int // groups[g][row] = observed count
+	var groupTotals []int
+	current := make([]int, len(rows))
+	currentTotal := 0
+	for j := 0; j < width; j++ {
+		for i, row := range rows {
+			if j < len(row) {
+				current[i] += row[j]
+				currentTotal += row[j]
+			}
+		}
+		if float64(currentTotal) >= needed {
+			groups = append(groups, current)
+			groupTotals = append(groupTotals, currentTotal)
+			current = make([]int, len(rows))
+			currentTotal = 0
+		}
+	}
+	if currentTotal > 0 {
+		if len(groups) == 0 {
+			groups = append(groups, current)
+			groupTotals = append(groupTotals, currentTotal)
+		} else {
+			last := len(groups) - 1
+			for i := range current {
+				groups[last][i] += current[i]
+			}
+			groupTotals[last] += currentTotal
+		}
+	}
+	report.Columns = len(groups)
+
+	report.MinExpected = math.Inf(1)
+	for g, group := range groups {
+		for i, observed := range group {
+			expected := float64(rowTotals[i]) * float64(groupTotals[g]) / float64(samples)
+			report.ChiSquare += sq(float64(observed)-expected) / expected
+			report.MinExpected = math.Min(report.MinExpected, expected)
+		}
+	}
+	report.DegreesOfFreedom = (len(rows) - 1) * (len(groups) - 1)
+	report.PValue = chiSquareSurvival(report.ChiSquare, report.DegreesOfFreedom)
+	return report
+}
+
+// ModuloBias describes the bias Index introduces by reducing a 32-bit hash
+// modulo buckets, assuming the hash itself is uniform.
+type ModuloBias struct {
+	// Overweighted is the number of buckets that receive one extra hash value.
+	Overweighted int
+	// RelativeBias is how much more likely an overweighted bucket is than the
+	// others, e.g. 1e-7 means 0.00001% more likely.
+	RelativeBias float64
+	// SamplesToDetect is roughly how many users a chi-square test needs before
+	// the bias becomes detectable at the 1% level with 50% power.
+	SamplesToDetect float64
+}
+
+// Modulo32Bias computes the exact modulo bias of Index for the given number of
+// buckets. It is zero when buckets is a power of two.
+func Modulo32Bias(buckets int) ModuloBias {
+	const space = 1 << 32
+	n := uint64(buckets)
+	q := space / n
+	r := space % n
+	if r == 0 {
+		return ModuloBias{}
+	}
+
+	// Noncentrality of the chi-square statistic per sample: k * sum((p_i - 1/k)^2).
+	pHigh := float64(q+1) / space
+	pLow := float64(q) / space
+	uniform := 1 / float64(n)
+	perSample := float64(n) * (float64(r)*sq(pHigh-uniform) + float64(n-r)*sq(pLow-uniform))
+
+	// A noncentral chi-square exceeds the 1% critical value about half the time
+	// once its noncentrality reaches the gap between that critical value and
+	// the mean. The normal approximation to the critical value is plenty here.
+	df := float64(n - 1)
+	critical := df + 2.326*math.Sqrt(2*df)
+
+	return ModuloBias{
+		Overweighted:    int(r),
+		RelativeBias:    1 / float64(q),
+		SamplesToDetect: (critical - df) / perSample,
+	}
+}
+
+func sq(x float64) float64 { return x * x }
+
+// chiSquareSurvival returns P(X >= x) for a chi-square distribution with df
+// degrees of freedom, i.e. the regularized upper incomplete gamma Q(df/2, x/2).
+func chiSquareSurvival(x float64, df int) float64 {
+	if df <= 0 || x <= 0 {
+		return 1
+	}
+	return upperGammaQ(float64(df)/2, x/2)
+}
+
+// upperGammaQ computes the regularized upper incomplete gamma function using a
+// series expansion below a+1 and a continued fraction above it.
+func upperGammaQ(a, x float64) float64 {
+	const (
+		maxIterations = 1000
+		epsilon       = 1e-14
+		tiny          = 1e-300
+	)
+	lgammaA, _ := math.Lgamma(a)
+	prefix := math.Exp(-x + a*math.Log(x) - lgammaA)
+
+	if x < a+1 {
+		sum := 1 / a
+		term := sum
+		for n := 1; n < maxIterations; n++ {
+			term *= x / (a + float64(n))
+			sum += term
+			if math.Abs(term) < math.Abs(sum)*epsilon {
+				break
+			}
+		}
+		return 1 - sum*prefix
+	}
+
+	// Lentz's method for the continued fraction.
+	b := x + 1 - a
+	c := 1 / tiny
+	d := 1 / b
+	h := d
+	for n := 1; n < maxIterations; n++ {
+		an := -float64(n) * (float64(n) - a)
+		b += 2
+		d = an*d + b
+		if math.Abs(d) < tiny {
+			d = tiny
+		}
+		c = b + an/c
+		if math.Abs(c) < tiny {
+			c = tiny
+		}
+		d = 1 / d
+		delta := d * c
+		h *= delta
+		if math.Abs(delta-1) < epsilon {
+			break
+		}
+	}
+	return prefix * h
+}
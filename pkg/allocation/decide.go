@@ -0,0 +1,64 @@
+package allocation
+
+// Rules are the gates the experiment applies before bucketing a user. The
+// zero value has no version gate and exposes nobody; use ExposurePercent 100
+// to expose everyone.
+type Rules struct {
+	// ExposurePercent is the share of users (0-100) bucketed into the
+	// experiment; the rest get the control payload
+	ExposurePercent float64
+	// AppVersions, when set, is the range of client app versions that can
+	// render the experiment's payloads; other clients get the fallback payload
+	AppVersions *VersionRange
+	// Prerequisites, when set, limits the experiment to users in a variant
+	// of another experiment; other users get the control payload
+	Prerequisites *Prerequisites
+	// Mapper buckets exposed users; nil means Index. Anything else reshuffles
+	// every user (see MapperFor)
+	Mapper Mapper
+}
+
+// Decision records every step of a user's assignment, so callers can both
+// serve the result and explain it. Gates apply in field order: a client with
+// an unsupported version gets the fallback payload, then an ineligible or
+// unexposed user gets the control payload, and everyone else gets payload
+// Bucket.
+type Decision struct {
+	VersionSupported bool // always true without a version gate
+	Eligible         bool // always true without prerequisites
+	Exposed          bool
+	Bucket           int // payload index in [0, buckets), computed even when a gate applies
+}
+
+// Decide runs the rules for a user with the given client app version against
+// a payload set of the given size. It is what the experiment endpoint serves,
+// so tools that explain an assignment must call it rather than repeat it.
+func (r Rules) Decide(userID, appVersion string, buckets int) Decision {
+	return Decision{
+		VersionSupported: r.VersionSupported(appVersion),
+		Eligible:         r.Prerequisites == nil || r.Prerequisites.Eligible(userID),
+		Exposed:          Exposed(userID, r.ExposurePercent),
+		Bucket:           r.bucket(userID, buckets),
+	}
+}
+
+func (r Rules) bucket(userID string, buckets int) int {
+	if r.Mapper == nil {
+		return Index(userID, buckets)
+	}
+	return r.Mapper(userID, buckets)
+}
+
+// VersionSupported reports whether a client's app version passes the version
+// gate. A missing or unparseable version is unsupported: old clients predate
+// the appVersion field, so they are exactly the ones to protect.
+func (r Rules) VersionSupported(appVersion string) bool {
+	if r.AppVersions == nil {
+		return true
+	}
+	if appVersion == "" {
+		return false
+	}
+	v, err := ParseVersion(appVersion)
+	return err == nil && r.AppVersions.Contains(v)
+}
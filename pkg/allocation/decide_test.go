@@ -0,0 +1,40 @@
+package allocation
+
+import "testing"
+
+func TestRulesVersionSupported(t *testing.T) {
+	versions, err := ParseVersionRange(">=2.0.0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	gated := Rules{ExposurePercent: 100, AppVersions: &versions}
+	tests := []struct {
+		name       string
+		rules      Rules
+		appVersion string
+		want       bool
+	}{
+		{name: "in range", rules: gated, appVersion: "2.4.1", want: true},
+		{name: "out of range", rules: gated, appVersion: "1.9.0", want: false},
+		{name: "missing version", rules: gated, appVersion: "", want: false},
+		{name: "unparseable version", rules: gated, appVersion: "latest", want: false},
+		{name: "no gate, missing version", rules: Rules{ExposurePercent: 100}, appVersion: "", want: true},
+		{name: "no gate, old version", rules: Rules{ExposurePercent: 100}, appVersion: "0.1.0", want: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.rules.VersionSupported(tt.appVersion); got != tt.want {
+				t.Errorf("VersionSupported(%q) = %v, want %v", tt.appVersion, got, tt.want)
+			}
+			d := tt.rules.Decide("user-123", tt.appVersion, 5)
+			if d.VersionSupported != tt.want {
+				t.Errorf("Decide(%q).VersionSupported = %v, want %v", tt.appVersion, d.VersionSupported, tt.want)
+			}
+			// The gate doesn't move the user's bucket, so they get the same
+			// payload once they upgrade
+			if want := Index("user-123", 5); d.Bucket != want {
+				t.Errorf("Decide(%q).Bucket = %d, want %d", tt.appVersion, d.Bucket, want)
+			}
+		})
+	}
+}
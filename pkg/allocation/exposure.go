@@ -0,0 +1,28 @@
+package allocation
+
+import "hash/fnv"
+
+// exposureSalt separates the exposure gate's hash from the bucketing hash, so
+// whether a user is exposed tells nothing about which variant they would get.
+const exposureSalt = "exposure:"
+
+// exposureResolution is the number of slots exposure percentages are measured
+// in, giving 0.01% granularity.
+const exposureResolution = 10000
+
+// Exposed reports whether userID falls inside an exposure of percent (0-100)
+// of all users. It is deterministic, and ramping up is monotonic: a user
+// exposed at 1% stays exposed at 5%, so raising exposure only adds users.
+func Exposed(userID string, percent float64) bool {
+	if percent >= 100 {
+		return true
+	}
+	if percent <= 0 {
+		return false
+	}
+	h := fnv.New64a()
+	h.Write([]byte(exposureSalt))
+	h.Write([]byte(userID))
+	slot := mix64(h.Sum64()) % exposureResolution
+	return float64(slot) < percent*exposureResolution/100
+}
@@ -0,0 +1,138 @@
+package allocation
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"math/rand"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+// goldenFile records Index's bucket for every golden user at goldenBuckets.
+const goldenFile = "testdata/golden_buckets.tsv"
+
+// goldenBuckets are the bucket counts recorded for each user in the golden
+// file: small experiment sizes plus the current payload count.
+var goldenBuckets = []int{2, 6, 100, 3005}
+
+// goldenUsers is how many random UUIDs -update records alongside
+// goldenEdgeCases.
+const goldenUsers = 500
+
+// goldenEdgeCases are userIds with unusual shapes that the golden file always
+// covers alongside the random UUIDs.
+var goldenEdgeCases = []string{
+	"user-123",
+	"a",
+	"0",
+	"USER-123",
+	"user-123 ",
+	"usuário-ñ",
+	"用户-42",
+	"👩‍💻",
+	strings.Repeat("x", 1024),
+}
+
+var update = flag.Bool("update", false, "Rewrite "+goldenFile+" from the current mapping (reassigns users if the mapping changed)")
+
+// TestGoldenBuckets re-buckets every user in the golden file and fails if any
+// assignment changed, so a hash or reduction change can't reshuffle users by
+// accident. If a reshuffle is intended, rerun with -update and call it out in
+// review. The bucket counts come from the file's header, so the file stays
+// checkable if goldenBuckets changes.
+func TestGoldenBuckets(t *testing.T) {
+	if *update {
+		writeGolden(t)
+	}
+
+	f, err := os.Open(goldenFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	if !scanner.Scan() {
+		t.Fatalf("%s is empty", goldenFile)
+	}
+	header := strings.Split(scanner.Text(), "\t")
+	if len(header) < 2 || header[0] != "userId" {
+		t.Fatalf("%s header must be \"userId\" followed by bucket counts", goldenFile)
+	}
+	counts := make([]int, len(header)-1)
+	for i, field := range header[1:] {
+		n, err := strconv.Atoi(field)
+		if err != nil || n < 1 {
+			t.Fatalf("invalid bucket count %q in %s header", field, goldenFile)
+		}
+		counts[i] = n
+	}
+
+	checked, changed := 0, 0
+	for line := 2; scanner.Scan(); line++ {
+		fields := strings.Split(scanner.Text(), "\t")
+		if len(fields) != len(header) {
+			t.Fatalf("%s line %d: expected %d fields, got %d", goldenFile, line, len(header), len(fields))
+		}
+		userID := fields[0]
+		for i, n := range counts {
+			want, err := strconv.Atoi(fields[i+1])
+			if err != nil {
+				t.Fatalf("%s line %d: invalid bucket %q", goldenFile, line, fields[i+1])
+			}
+			checked++
+			if got := Index(userID, n); got != want {
+				changed++
+				if changed <= 10 {
+					t.Errorf("Index(%.40q, %d) = %d, golden file has %d", userID, n, got, want)
+				}
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if checked == 0 {
+		t.Fatalf("%s has no users", goldenFile)
+	}
+	if changed > 0 {
+		t.Errorf("%d of %d golden assignments changed: the bucketing function reassigns users to different payloads", changed, checked)
+	}
+}
+
+// writeGolden records Index's bucket for goldenEdgeCases and goldenUsers
+// seeded random UUIDs at every goldenBuckets count, as tab-separated lines
+// under a header naming the counts.
+func writeGolden(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	ids := append([]string{}, goldenEdgeCases...)
+	for i := 0; i < goldenUsers; i++ {
+		id, err := uuid.NewRandomFromReader(rng)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, id.String())
+	}
+
+	var b strings.Builder
+	header := []string{"userId"}
+	for _, n := range goldenBuckets {
+		header = append(header, strconv.Itoa(n))
+	}
+	fmt.Fprintln(&b, strings.Join(header, "\t"))
+	for _, id := range ids {
+		row := []string{id}
+		for _, n := range goldenBuckets {
+			row = append(row, strconv.Itoa(Index(id, n)))
+		}
+		fmt.Fprintln(&b, strings.Join(row, "\t"))
+	}
+	if err := os.WriteFile(goldenFile, []byte(b.String()), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	t.Logf("wrote %d users to %s", len(ids), goldenFile)
+}
@@ -0,0 +1,250 @@
+package allocation
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"hash/fnv"
+	"math"
+	"math/bits"
+	"sort"
+	"strings"
+)
+
+// DefaultHashAlgorithm is the algorithm Index buckets users with. Every
+// assignment the server has made so far used it.
+const DefaultHashAlgorithm = "fnv1a"
+
+// Hasher hashes a user ID to 64 bits for bucketing. Hashers with fewer bits
+// return them in the high bits, where the multiply-shift reduction reads.
+type Hasher func(userID string) uint64
+
+// hashers are the alternative algorithms MapperFor can bucket users with.
+var hashers = map[string]Hasher{
+	"xxhash":  XXHash64,
+	"murmur3": Murmur3,
+	"sha256":  SHA256Truncated,
+}
+
+// HashAlgorithms returns the names MapperFor accepts, the default first and
+// the rest sorted.
+func HashAlgorithms() []string {
+	names := make([]string, 0, len(hashers))
+	for name := range hashers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return append([]string{DefaultHashAlgorithm}, names...)
+}
+
+// MapperFor returns the Mapper for a hash algorithm. DefaultHashAlgorithm is
+// Index, 32-bit FNV-1a modulo n, unchanged so existing users keep their
+// payloads. The others reduce their hash with the multiply-shift of WideIndex,
+// which has no measurable modulo bias. Each algorithm assigns users
+// differently from every other, so switching reshuffles every user.
+func MapperFor(algorithm string) (Mapper, error) {
+	if algorithm == DefaultHashAlgorithm {
+		return Index, nil
+	}
+	hasher, ok := hashers[algorithm]
+	if !ok {
+		return nil, fmt.Errorf("unknown hash algorithm %q (want %s)", algorithm, strings.Join(HashAlgorithms(), ", "))
+	}
+	return func(userID string, n int) int {
+		hi, _ := bits.Mul64(hasher(userID), uint64(n))
+		return int(hi)
+	}, nil
+}
+
+// BucketHash returns the hash the Mapper for algorithm reduces to bucket
+// userID: the 32-bit FNV-1a of DefaultHashAlgorithm, or the Hasher's 64 bits
+// otherwise. With ReduceHash it spells out each step of an assignment, so a
+// report can show them and a reader can recompute them by hand.
+func BucketHash(algorithm, userID string) (uint64, error) {
+	if algorithm == DefaultHashAlgorithm {
+		h := fnv.New32a()
+		h.Write([]byte(userID))
+		return uint64(h.Sum32()), nil
+	}
+	hasher, ok := hashers[algorithm]
+	if !ok {
+		return 0, fmt.Errorf("unknown hash algorithm %q (want %s)", algorithm, strings.Join(HashAlgorithms(), ", "))
+	}
+	return hasher(userID), nil
+}
+
+// ReduceHash maps a BucketHash onto one of n buckets as the algorithm's Mapper
+// does: hash mod n for DefaultHashAlgorithm, and the high 64 bits of hash*n
+// for the others.
+func ReduceHash(algorithm string, hash uint64, n int) int {
+	if algorithm == DefaultHashAlgorithm {
+		return int(hash % uint64(n))
+	}
+	hi, _ := bits.Mul64(hash, uint64(n))
+	return int(hi)
+}
+
+// Position returns the bucket the Mapper for algorithm puts userID in among n,
+// and where the user falls within that bucket's share of hash values, from 0
+// at its lower edge to just under 1 at its upper edge. For DefaultHashAlgorithm
+// the share is every hash with the same remainder, ordered by quotient.
+func Position(algorithm, userID string, n int) (int, float64, error) {
+	hash, err := BucketHash(algorithm, userID)
+	if err != nil {
+		return 0, 0, err
+	}
+	var bucket int
+	var offset float64
+	if algorithm == DefaultHashAlgorithm {
+		bucket = int(hash % uint64(n))
+		offset = float64(hash/uint64(n)) / (float64(1<<32) / float64(n))
+	} else {
+		hi, lo := bits.Mul64(hash, uint64(n))
+		bucket, offset = int(hi), float64(lo)/(1<<64)
+	}
+	// Rounding to float64 can reach 1 at the very top of a bucket
+	return bucket, min(offset, math.Nextafter(1, 0)), nil
+}
+
+// ReductionFormula describes ReduceHash for algorithm, for reports.
+func ReductionFormula(algorithm string) string {
+	if algorithm == DefaultHashAlgorithm {
+		return "hash mod n"
+	}
+	return "(hash × n) >> 64"
+}
+
+// SHA256Truncated returns the first 8 bytes of the SHA-256 of userID. It is
+// the slowest algorithm by far, for teams that want a cryptographic hash's
+// distribution guarantees.
+func SHA256Truncated(userID string) uint64 {
+	sum := sha256.Sum256([]byte(userID))
+	return binary.BigEndian.Uint64(sum[:8])
+}
+
+// XXH64 primes.
+const (
+	xxPrime1 uint64 = 0x9E3779B185EBCA87
+	xxPrime2 uint64 = 0xC2B2AE3D27D4EB4F
+	xxPrime3 uint64 = 0x165667B19E3779F9
+	xxPrime4 uint64 = 0x85EBCA77C2B2AE63
+	xxPrime5 uint64 = 0x27D4EB2F165667C5
+)
+
+// XXHash64 returns the XXH64 hash of userID with seed 0, as computed by the
+// reference implementation and github.com/cespare/xxhash.
+func XXHash64(userID string) uint64 {
+	s := userID
+	n := len(s)
+	var h uint64
+	if n >= 32 {
+		// The reference seeds the lanes with sums that wrap, which Go
+		// constants can't
+		p1, p2 := xxPrime1, xxPrime2
+		v1 := p1 + p2
+		v2 := p2
+		v3 := uint64(0)
+		v4 := -p1
+		for len(s) >= 32 {
+			v1 = xxRound(v1, le64(s[0:8]))
+			v2 = xxRound(v2, le64(s[8:16]))
+			v3 = xxRound(v3, le64(s[16:24]))
+			v4 = xxRound(v4, le64(s[24:32]))
+			s = s[32:]
+		}
+		h = bits.RotateLeft64(v1, 1) + bits.RotateLeft64(v2, 7) + bits.RotateLeft64(v3, 12) + bits.RotateLeft64(v4, 18)
+		h = xxMergeRound(h, v1)
+		h = xxMergeRound(h, v2)
+		h = xxMergeRound(h, v3)
+		h = xxMergeRound(h, v4)
+	} else {
+		h = xxPrime5
+	}
+	h += uint64(n)
+
+	for ; len(s) >= 8; s = s[8:] {
+		h ^= xxRound(0, le64(s[:8]))
+		h = bits.RotateLeft64(h, 27)*xxPrime1 + xxPrime4
+	}
+	if len(s) >= 4 {
+		h ^= uint64(le32(s[:4])) * xxPrime1
+		h = bits.RotateLeft64(h, 23)*xxPrime2 + xxPrime3
+		s = s[4:]
+	}
+	for i := 0; i < len(s); i++ {
+		h ^= uint64(s[i]) * xxPrime5
+		h = bits.RotateLeft64(h, 11) * xxPrime1
+	}
+
+	h ^= h >> 33
+	h *= xxPrime2
+	h ^= h >> 29
+	h *= xxPrime3
+	h ^= h >> 32
+	return h
+}
+
+func xxRound(acc, input uint64) uint64 {
+	acc += input * xxPrime2
+	acc = bits.RotateLeft64(acc, 31)
+	return acc * xxPrime1
+}
+
+func xxMergeRound(acc, val uint64) uint64 {
+	acc ^= xxRound(0, val)
+	return acc*xxPrime1 + xxPrime4
+}
+
+// Murmur3 returns the 32-bit MurmurHash3 (x86_32) of userID with seed 0, in
+// the high 32 bits.
+func Murmur3(userID string) uint64 {
+	const (
+		c1 uint32 = 0xcc9e2d51
+		c2 uint32 = 0x1b873593
+	)
+	s := userID
+	var h uint32
+	for ; len(s) >= 4; s = s[4:] {
+		k := le32(s[:4])
+		k *= c1
+		k = bits.RotateLeft32(k, 15)
+		k *= c2
+		h ^= k
+		h = bits.RotateLeft32(h, 13)
+		h = h*5 + 0xe6546b64
+	}
+	var k uint32
+	switch len(s) {
+	case 3:
+		k ^= uint32(s[2]) << 16
+		fallthrough
+	case 2:
+		k ^= uint32(s[1]) << 8
+		fallthrough
+	case 1:
+		k ^= uint32(s[0])
+		k *= c1
+		k = bits.RotateLeft32(k, 15)
+		k *= c2
+		h ^= k
+	}
+
+	h ^= uint32(len(userID))
+	h ^= h >> 16
+	h *= 0x85ebca6b
+	h ^= h >> 13
+	h *= 0xc2b2ae35
+	h ^= h >> 16
+	return uint64(h) << 32
+}
+
+// le64 and le32 read little-endian integers from a string without copying it
+// to a byte slice.
+func le64(s string) uint64 {
+	return uint64(s[0]) | uint64(s[1])<<8 | uint64(s[2])<<16 | uint64(s[3])<<24 |
+		uint64(s[4])<<32 | uint64(s[5])<<40 | uint64(s[6])<<48 | uint64(s[7])<<56
+}
+
+func le32(s string) uint32 {
+	return uint32(s[0]) | uint32(s[1])<<8 | uint32(s[2])<<16 | uint32(s[3])<<24
+}
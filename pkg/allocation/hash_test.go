@@ -0,0 +1,89 @@
+package allocation
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// TestXXHash64 checks XXHash64 against the reference XXH64 with seed 0, using
+// the vectors github.com/cespare/xxhash tests with. The 63-byte input covers
+// the 32-byte stripes, the 8-byte and 4-byte words and the byte tail.
+func TestXXHash64(t *testing.T) {
+	tests := []struct {
+		input string
+		want  uint64
+	}{
+		{"", 0xef46db3751d8e999},
+		{"a", 0xd24ec4f1a98c6e5b},
+		{"as", 0x1c330fb2d66be179},
+		{"asd", 0x631c37ce72a97393},
+		{"asdf", 0x415872f599cea71e},
+		{"Call me Ishmael. Some years ago--never mind how long precisely-", 0x02a2e85470d6fd96},
+	}
+	for _, tt := range tests {
+		if got := XXHash64(tt.input); got != tt.want {
+			t.Errorf("XXHash64(%q) = %#x, want %#x", tt.input, got, tt.want)
+		}
+	}
+}
+
+// TestMurmur3 checks Murmur3 against the reference MurmurHash3_x86_32 with
+// seed 0, whose 32 bits it returns in the high half. The inputs cover every
+// tail length.
+func TestMurmur3(t *testing.T) {
+	tests := []struct {
+		input string
+		want  uint32
+	}{
+		{"", 0x00000000},
+		{"test", 0xba6bd213},
+		{"hello", 0x248bfa47},
+		{"Hello, world!", 0xc0363e43},
+		{"foo", 0xf6a5c420},
+		{"The quick brown fox jumps over the lazy dog", 0x2e4ff723},
+	}
+	for _, tt := range tests {
+		got := Murmur3(tt.input)
+		if uint32(got) != 0 {
+			t.Errorf("Murmur3(%q) = %#x, want the low 32 bits clear", tt.input, got)
+		}
+		if got>>32 != uint64(tt.want) {
+			t.Errorf("Murmur3(%q) = %#x in the high bits, want %#x", tt.input, got>>32, tt.want)
+		}
+	}
+}
+
+// TestHashAlgorithmsUniform buckets the same sequential population with
+// every algorithm and fails if any split is detectably non-uniform. The
+// population is fixed, so the test is deterministic; the significance level
+// is split across the tests so a fair hash doesn't fail by chance.
+func TestHashAlgorithmsUniform(t *testing.T) {
+	const samples = 200000
+	bucketCounts := []int{2, 5, 20, 100}
+	algorithms := HashAlgorithms()
+	alpha := 0.001 / float64(len(algorithms)*len(bucketCounts))
+	userID := func(i int) string { return fmt.Sprintf("user-%d", i) }
+
+	for _, name := range algorithms {
+		mapper, err := MapperFor(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, n := range bucketCounts {
+			t.Run(fmt.Sprintf("%s/buckets=%d", name, n), func(t *testing.T) {
+				r := MeasureBias(mapper, n, samples, userID)
+				if r.Detectable(alpha) {
+					t.Errorf("split of %d users over %d buckets is non-uniform: %+v", samples, n, r)
+				}
+			})
+		}
+	}
+}
+
+func TestMapperForUnknownAlgorithm(t *testing.T) {
+	_, err := MapperFor("crc32")
+	if err == nil || !strings.Contains(err.Error(), "unknown hash algorithm") {
+		t.Errorf("MapperFor(crc32) error = %v, want unknown hash algorithm", err)
+	}
+}
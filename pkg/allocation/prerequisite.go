@@ -0,0 +1,172 @@
+package allocation
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"math/bits"
+	"strings"
+)
+
+// Prerequisite names the variant of another experiment a user must be in to
+// be eligible for an experiment.
+type Prerequisite struct {
+	ExperimentID string `json:"experimentId"`
+	Variant      string `json:"variant"`
+}
+
+func (p Prerequisite) String() string {
+	return p.ExperimentID + "=" + p.Variant
+}
+
+// Experiment is an experiment a prerequisite can name. Its eligible users are
+// split evenly across Variants by a hash of the experiment ID and userId, the
+// same split for any service that runs the experiment this way.
+type Experiment struct {
+	ID           string        `json:"id"`
+	Variants     []string      `json:"variants"`
+	Prerequisite *Prerequisite `json:"prerequisite,omitempty"`
+}
+
+// Prerequisites limits the served experiment to users in one variant of
+// another experiment, for layered and funnel experiments. Experiments lists
+// every experiment the prerequisite chain goes through, each of which may have
+// a prerequisite of its own. ParsePrerequisites rejects chains that loop
+// back on themselves, so resolving one always ends.
+type Prerequisites struct {
+	Requires    Prerequisite `json:"prerequisite"`
+	Experiments []Experiment `json:"experiments"`
+
+	byID map[string]Experiment
+}
+
+// ParsePrerequisites parses a JSON prerequisites config for the experiment
+// servedID and checks it: experiment IDs are unique, each has distinct
+// variants, every prerequisite names a listed experiment and one of its
+// variants, and no chain of prerequisites is a cycle.
+func ParsePrerequisites(data []byte, servedID string) (*Prerequisites, error) {
+	var p Prerequisites
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&p); err != nil {
+		return nil, err
+	}
+
+	if p.Requires.ExperimentID == "" || p.Requires.Variant == "" {
+		return nil, fmt.Errorf("prerequisite needs both an experimentId and a variant")
+	}
+
+	p.byID = make(map[string]Experiment, len(p.Experiments))
+	for _, e := range p.Experiments {
+		if e.ID == "" {
+			return nil, fmt.Errorf("an experiment has no id")
+		}
+		if e.ID == servedID {
+			return nil, fmt.Errorf("experiment %s is the one served; its prerequisite goes in the top-level prerequisite", e.ID)
+		}
+		if _, dup := p.byID[e.ID]; dup {
+			return nil, fmt.Errorf("experiment %s is listed more than once", e.ID)
+		}
+		if len(e.Variants) == 0 {
+			return nil, fmt.Errorf("experiment %s has no variants", e.ID)
+		}
+		seen := make(map[string]bool, len(e.Variants))
+		for _, v := range e.Variants {
+			if v == "" || seen[v] {
+				return nil, fmt.Errorf("experiment %s has an empty or repeated variant %q", e.ID, v)
+			}
+			seen[v] = true
+		}
+		p.byID[e.ID] = e
+	}
+
+	// Cycles first: one through servedID would otherwise be reported as a
+	// prerequisite naming an unlisted experiment
+	prerequisiteOf := func(id string) *Prerequisite {
+		if id == servedID {
+			return &p.Requires
+		}
+		return p.byID[id].Prerequisite
+	}
+	for _, id := range append([]string{servedID}, p.experimentIDs()...) {
+		path := []string{id}
+		for req := prerequisiteOf(id); req != nil; req = prerequisiteOf(req.ExperimentID) {
+			for i, visited := range path {
+				if visited == req.ExperimentID {
+					return nil, fmt.Errorf("prerequisite cycle: %s", strings.Join(append(path[i:], req.ExperimentID), " → "))
+				}
+			}
+			path = append(path, req.ExperimentID)
+		}
+	}
+
+	check := func(owner string, req Prerequisite) error {
+		e, ok := p.byID[req.ExperimentID]
+		if !ok {
+			return fmt.Errorf("%s requires %s, which isn't listed in experiments", owner, req)
+		}
+		for _, v := range e.Variants {
+			if v == req.Variant {
+				return nil
+			}
+		}
+		return fmt.Errorf("%s requires %s, but %s has no variant %q (want one of %s)",
+			owner, req, req.ExperimentID, req.Variant, strings.Join(e.Variants, ", "))
+	}
+	if err := check(servedID, p.Requires); err != nil {
+		return nil, err
+	}
+	for _, e := range p.Experiments {
+		if e.Prerequisite != nil {
+			if err := check(e.ID, *e.Prerequisite); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return &p, nil
+}
+
+// Eligible reports whether userID is in the required variant of the required
+// experiment, resolving that experiment's own prerequisites first.
+func (p *Prerequisites) Eligible(userID string) bool {
+	return p.meets(userID, p.Requires, 0)
+}
+
+// Variant returns the variant userID is in for a listed experiment, or false
+// if they aren't eligible for it.
+func (p *Prerequisites) Variant(userID, experimentID string) (string, bool) {
+	return p.variant(userID, experimentID, 0)
+}
+
+func (p *Prerequisites) meets(userID string, req Prerequisite, depth int) bool {
+	variant, ok := p.variant(userID, req.ExperimentID, depth+1)
+	return ok && variant == req.Variant
+}
+
+func (p *Prerequisites) variant(userID, experimentID string, depth int) (string, bool) {
+	e, ok := p.byID[experimentID]
+	// ParsePrerequisites rejects cycles, so a chain longer than the experiments listed
+	// means the config was modified after parsing; treat it as unmet
+	if !ok || depth > len(p.byID) {
+		return "", false
+	}
+	if e.Prerequisite != nil && !p.meets(userID, *e.Prerequisite, depth) {
+		return "", false
+	}
+	h := fnv.New64a()
+	h.Write([]byte(experimentID))
+	h.Write([]byte{':'})
+	h.Write([]byte(userID))
+	i, _ := bits.Mul64(mix64(h.Sum64()), uint64(len(e.Variants)))
+	return e.Variants[i], true
+}
+
+// experimentIDs returns the listed experiment IDs in file order.
+func (p *Prerequisites) experimentIDs() []string {
+	ids := make([]string, len(p.Experiments))
+	for i, e := range p.Experiments {
+		ids[i] = e.ID
+	}
+	return ids
+}
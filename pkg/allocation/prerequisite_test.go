@@ -0,0 +1,186 @@
+package allocation
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestParsePrerequisites(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  string
+		wantErr string
+	}{
+		{
+			name:   "one level",
+			config: `{"prerequisite": {"experimentId": "onboarding", "variant": "new"}, "experiments": [{"id": "onboarding", "variants": ["old", "new"]}]}`,
+		},
+		{
+			name: "chain",
+			config: `{"prerequisite": {"experimentId": "checkout", "variant": "b"}, "experiments": [
+				{"id": "checkout", "variants": ["a", "b"], "prerequisite": {"experimentId": "onboarding", "variant": "new"}},
+				{"id": "onboarding", "variants": ["old", "new"]}]}`,
+		},
+		{
+			name:    "direct cycle through the served experiment",
+			config:  `{"prerequisite": {"experimentId": "a", "variant": "x"}, "experiments": [{"id": "a", "variants": ["x"], "prerequisite": {"experimentId": "served", "variant": "y"}}]}`,
+			wantErr: "prerequisite cycle: served → a → served",
+		},
+		{
+			name: "cycle among listed experiments",
+			config: `{"prerequisite": {"experimentId": "a", "variant": "x"}, "experiments": [
+				{"id": "a", "variants": ["x"], "prerequisite": {"experimentId": "b", "variant": "x"}},
+				{"id": "b", "variants": ["x"], "prerequisite": {"experimentId": "c", "variant": "x"}},
+				{"id": "c", "variants": ["x"], "prerequisite": {"experimentId": "b", "variant": "x"}}]}`,
+			wantErr: "prerequisite cycle: b → c → b",
+		},
+		{
+			name:    "self cycle",
+			config:  `{"prerequisite": {"experimentId": "a", "variant": "x"}, "experiments": [{"id": "a", "variants": ["x"], "prerequisite": {"experimentId": "a", "variant": "x"}}]}`,
+			wantErr: "prerequisite cycle",
+		},
+		{
+			name:    "unlisted experiment",
+			config:  `{"prerequisite": {"experimentId": "missing", "variant": "x"}, "experiments": []}`,
+			wantErr: "served requires missing=x, which isn't listed",
+		},
+		{
+			name:    "unknown variant",
+			config:  `{"prerequisite": {"experimentId": "a", "variant": "z"}, "experiments": [{"id": "a", "variants": ["x", "y"]}]}`,
+			wantErr: `a has no variant "z" (want one of x, y)`,
+		},
+		{
+			name:    "incomplete prerequisite",
+			config:  `{"prerequisite": {"experimentId": "a"}, "experiments": [{"id": "a", "variants": ["x"]}]}`,
+			wantErr: "needs both",
+		},
+		{
+			name:    "served experiment listed",
+			config:  `{"prerequisite": {"experimentId": "a", "variant": "x"}, "experiments": [{"id": "a", "variants": ["x"]}, {"id": "served", "variants": ["x"]}]}`,
+			wantErr: "is the one served",
+		},
+		{
+			name:    "duplicate experiment",
+			config:  `{"prerequisite": {"experimentId": "a", "variant": "x"}, "experiments": [{"id": "a", "variants": ["x"]}, {"id": "a", "variants": ["x"]}]}`,
+			wantErr: "listed more than once",
+		},
+		{
+			name:    "no variants",
+			config:  `{"prerequisite": {"experimentId": "a", "variant": "x"}, "experiments": [{"id": "a", "variants": []}]}`,
+			wantErr: "has no variants",
+		},
+		{
+			name:    "repeated variant",
+			config:  `{"prerequisite": {"experimentId": "a", "variant": "x"}, "experiments": [{"id": "a", "variants": ["x", "x"]}]}`,
+			wantErr: `repeated variant "x"`,
+		},
+		{
+			name:    "unknown field",
+			config:  `{"prerequisite": {"experimentId": "a", "variant": "x"}, "experiments": [{"id": "a", "variants": ["x"], "weights": [1]}]}`,
+			wantErr: "unknown field",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParsePrerequisites([]byte(tt.config), "served")
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("ParsePrerequisites error = %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("ParsePrerequisites error = %v, want one containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestPrerequisitesEligible(t *testing.T) {
+	p, err := ParsePrerequisites([]byte(`{"prerequisite": {"experimentId": "checkout", "variant": "b"}, "experiments": [
+		{"id": "checkout", "variants": ["a", "b"], "prerequisite": {"experimentId": "onboarding", "variant": "new"}},
+		{"id": "onboarding", "variants": ["old", "new"]}]}`), "served")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const users = 4000
+	counts := map[string]int{}
+	for i := 0; i < users; i++ {
+		userID := fmt.Sprintf("user-%d", i)
+		onboarding, ok := p.Variant(userID, "onboarding")
+		if !ok {
+			t.Fatalf("%s isn't eligible for onboarding, which has no prerequisite", userID)
+		}
+		checkout, inCheckout := p.Variant(userID, "checkout")
+		eligible := p.Eligible(userID)
+
+		var class string
+		switch {
+		case onboarding != "new":
+			class = "ineligible for checkout"
+		case checkout != "b":
+			class = "eligible for checkout, in a"
+		default:
+			class = "eligible"
+		}
+		counts[class]++
+
+		// checkout only runs for onboarding's new variant, and the served
+		// experiment only for checkout's b
+		if inCheckout != (onboarding == "new") {
+			t.Errorf("%s: in onboarding %s, eligible for checkout %v", userID, onboarding, inCheckout)
+		}
+		if eligible != (class == "eligible") {
+			t.Errorf("%s: %s, but Eligible = %v", userID, class, eligible)
+		}
+		if again := p.Eligible(userID); again != eligible {
+			t.Errorf("%s: Eligible changed from %v to %v", userID, eligible, again)
+		}
+	}
+
+	// Half the users reach checkout, and half of those are in b
+	tests := []struct {
+		class string
+		want  float64
+	}{
+		{class: "ineligible for checkout", want: 0.5},
+		{class: "eligible for checkout, in a", want: 0.25},
+		{class: "eligible", want: 0.25},
+	}
+	for _, tt := range tests {
+		if got := float64(counts[tt.class]) / users; got < tt.want-0.03 || got > tt.want+0.03 {
+			t.Errorf("%s: %.3f of users, want about %.2f", tt.class, got, tt.want)
+		}
+	}
+}
+
+func TestRulesDecidePrerequisites(t *testing.T) {
+	p, err := ParsePrerequisites([]byte(`{"prerequisite": {"experimentId": "onboarding", "variant": "new"}, "experiments": [{"id": "onboarding", "variants": ["old", "new"]}]}`), "served")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name  string
+		rules Rules
+	}{
+		{name: "without prerequisites", rules: Rules{ExposurePercent: 100}},
+		{name: "with prerequisites", rules: Rules{ExposurePercent: 100, Prerequisites: p}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for i := 0; i < 100; i++ {
+				userID := fmt.Sprintf("user-%d", i)
+				want := true
+				if tt.rules.Prerequisites != nil {
+					variant, _ := p.Variant(userID, "onboarding")
+					want = variant == "new"
+				}
+				if d := tt.rules.Decide(userID, "", 3); d.Eligible != want {
+					t.Errorf("Decide(%s).Eligible = %v, want %v", userID, d.Eligible, want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,90 @@
+package allocation
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Rollbacks maps disabled payloads to the payload served in their place. Users
+// keep their bucket, so everyone who hashed into a disabled payload moves to
+// its fallback, and users in every other bucket are unaffected. Rollbacks only
+// apply to bucketed users; the control and fallback payloads of the exposure
+// and version gates are served as configured.
+//
+// A fallback can't itself be disabled, so a rollback is always one step and
+// never a chain. Rollbacks values are not modified once built: With and
+// Without return copies, so a server can swap them atomically.
+type Rollbacks map[string]string
+
+// ParseRollbacks parses comma-separated disabled=fallback pairs, e.g.
+// "variant_b.json=control.json". An empty spec disables nothing.
+func ParseRollbacks(spec string) (Rollbacks, error) {
+	r := Rollbacks{}
+	for _, pair := range strings.Split(spec, ",") {
+		if pair = strings.TrimSpace(pair); pair == "" {
+			continue
+		}
+		disabled, fallback, ok := strings.Cut(pair, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid rollback %q: expected <disabled>=<fallback>", pair)
+		}
+		var err error
+		if r, err = r.With(strings.TrimSpace(disabled), strings.TrimSpace(fallback)); err != nil {
+			return nil, err
+		}
+	}
+	return r, nil
+}
+
+// With returns a copy of r that also disables payload in favour of fallback,
+// replacing any fallback payload already had.
+func (r Rollbacks) With(payload, fallback string) (Rollbacks, error) {
+	switch {
+	case payload == "" || fallback == "":
+		return nil, fmt.Errorf("a rollback needs both a disabled payload and a fallback")
+	case payload == fallback:
+		return nil, fmt.Errorf("%s can't fall back to itself", payload)
+	case r[fallback] != "":
+		return nil, fmt.Errorf("fallback %s is disabled itself (falls back to %s)", fallback, r[fallback])
+	}
+	for disabled, f := range r {
+		if f == payload {
+			return nil, fmt.Errorf("%s is the fallback for disabled %s; roll that back first", payload, disabled)
+		}
+	}
+	out := r.Without(payload)
+	out[payload] = fallback
+	return out, nil
+}
+
+// Without returns a copy of r with payload enabled again.
+func (r Rollbacks) Without(payload string) Rollbacks {
+	out := make(Rollbacks, len(r)+1)
+	for disabled, fallback := range r {
+		if disabled != payload {
+			out[disabled] = fallback
+		}
+	}
+	return out
+}
+
+// Serve returns the payload to serve to a user bucketed into payload: its
+// fallback if it is disabled, with ok true, or payload itself.
+func (r Rollbacks) Serve(payload string) (served string, ok bool) {
+	if fallback, ok := r[payload]; ok {
+		return fallback, true
+	}
+	return payload, false
+}
+
+// String formats r in the form ParseRollbacks reads, sorted by disabled
+// payload so equal rollbacks always format the same.
+func (r Rollbacks) String() string {
+	pairs := make([]string, 0, len(r))
+	for disabled, fallback := range r {
+		pairs = append(pairs, disabled+"="+fallback)
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
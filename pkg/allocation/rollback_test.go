@@ -0,0 +1,80 @@
+package allocation
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseRollbacks(t *testing.T) {
+	tests := []struct {
+		name    string
+		spec    string
+		want    string // the parsed rollbacks, formatted by String
+		wantErr string
+	}{
+		{name: "empty", spec: "", want: ""},
+		{name: "one", spec: "b.json=a.json", want: "b.json=a.json"},
+		{name: "several, sorted", spec: " c.json = a.json , b.json=a.json,", want: "b.json=a.json,c.json=a.json"},
+		{name: "later pair replaces earlier", spec: "b.json=a.json,b.json=c.json", want: "b.json=c.json"},
+		{name: "missing fallback", spec: "b.json", wantErr: "expected <disabled>=<fallback>"},
+		{name: "empty fallback", spec: "b.json=", wantErr: "needs both"},
+		{name: "self", spec: "b.json=b.json", wantErr: "fall back to itself"},
+		{name: "disabled fallback", spec: "b.json=a.json,c.json=b.json", wantErr: "fallback b.json is disabled itself"},
+		{name: "disabling a fallback", spec: "b.json=a.json,a.json=c.json", wantErr: "a.json is the fallback for disabled b.json"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseRollbacks(tt.spec)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("ParseRollbacks(%q) error = %v, want one containing %q", tt.spec, err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParseRollbacks(%q) error = %v", tt.spec, err)
+			}
+			if got.String() != tt.want {
+				t.Errorf("ParseRollbacks(%q) = %s, want %s", tt.spec, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRollbacksServe(t *testing.T) {
+	r := Rollbacks{"b.json": "a.json"}
+	tests := []struct {
+		payload    string
+		wantServed string
+		wantOK     bool
+	}{
+		{payload: "a.json", wantServed: "a.json"},
+		{payload: "b.json", wantServed: "a.json", wantOK: true},
+		{payload: "c.json", wantServed: "c.json"},
+	}
+	for _, tt := range tests {
+		if served, ok := r.Serve(tt.payload); served != tt.wantServed || ok != tt.wantOK {
+			t.Errorf("Serve(%s) = %s, %v, want %s, %v", tt.payload, served, ok, tt.wantServed, tt.wantOK)
+		}
+	}
+}
+
+// TestRollbacksCopy checks With and Without leave the receiver unchanged, so
+// a server can swap rollbacks while requests still read the old value.
+func TestRollbacksCopy(t *testing.T) {
+	r := Rollbacks{"b.json": "a.json"}
+	with, err := r.With("c.json", "a.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	without := with.Without("b.json")
+	if got := r.String(); got != "b.json=a.json" {
+		t.Errorf("original = %s after With and Without, want b.json=a.json", got)
+	}
+	if got := with.String(); got != "b.json=a.json,c.json=a.json" {
+		t.Errorf("With = %s, want b.json=a.json,c.json=a.json", got)
+	}
+	if got := without.String(); got != "c.json=a.json" {
+		t.Errorf("Without = %s, want c.json=a.json", got)
+	}
+}
@@ -0,0 +1,212 @@
+package allocation
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Version is a parsed semantic version such as "2.4.1" or "3.0.0-beta.2".
+// Build metadata ("+build.5") is accepted and ignored.
+type Version struct {
+	Major      int
+	Minor      int
+	Patch      int
+	Prerelease []string
+}
+
+// ParseVersion parses a semantic version. A leading "v" is allowed, and missing
+// minor or patch numbers default to zero ("2.1" is 2.1.0), since mobile apps
+// often report short versions.
+func ParseVersion(s string) (Version, error) {
+	raw := s
+	s = strings.TrimPrefix(strings.TrimSpace(s), "v")
+	s, _, _ = strings.Cut(s, "+")
+
+	var v Version
+	core, pre, hasPre := strings.Cut(s, "-")
+	if hasPre {
+		if pre == "" {
+			return Version{}, fmt.Errorf("invalid version %q: empty prerelease", raw)
+		}
+		v.Prerelease = strings.Split(pre, ".")
+	}
+
+	parts := strings.Split(core, ".")
+	if len(parts) > 3 {
+		return Version{}, fmt.Errorf("invalid version %q: too many components", raw)
+	}
+	numbers := []*int{&v.Major, &v.Minor, &v.Patch}
+	for i, part := range parts {
+		n, err := strconv.Atoi(part)
+		if err != nil || n < 0 {
+			return Version{}, fmt.Errorf("invalid version %q", raw)
+		}
+		*numbers[i] = n
+	}
+	return v, nil
+}
+
+func (v Version) String() string {
+	s := fmt.Sprintf("%d.%d.%d", v.Major, v.Minor, v.Patch)
+	if len(v.Prerelease) > 0 {
+		s += "-" + strings.Join(v.Prerelease, ".")
+	}
+	return s
+}
+
+// Compare returns -1, 0 or 1 as v is lower than, equal to or higher than o,
+// following semver precedence: a prerelease sorts below its release.
+func (v Version) Compare(o Version) int {
+	for _, d := range []int{v.Major - o.Major, v.Minor - o.Minor, v.Patch - o.Patch} {
+		if d != 0 {
+			return sign(d)
+		}
+	}
+
+	switch {
+	case len(v.Prerelease) == 0 && len(o.Prerelease) == 0:
+		return 0
+	case len(v.Prerelease) == 0:
+		return 1
+	case len(o.Prerelease) == 0:
+		return -1
+	}
+	for i := 0; i < len(v.Prerelease) && i < len(o.Prerelease); i++ {
+		if c := comparePrerelease(v.Prerelease[i], o.Prerelease[i]); c != 0 {
+			return c
+		}
+	}
+	return sign(len(v.Prerelease) - len(o.Prerelease))
+}
+
+// comparePrerelease compares one dot-separated prerelease identifier: numeric
+// identifiers compare numerically and sort below alphanumeric ones.
+func comparePrerelease(a, b string) int {
+	an, aErr := strconv.Atoi(a)
+	bn, bErr := strconv.Atoi(b)
+	switch {
+	case aErr == nil && bErr == nil:
+		return sign(an - bn)
+	case aErr == nil:
+		return -1
+	case bErr == nil:
+		return 1
+	}
+	return strings.Compare(a, b)
+}
+
+func sign(n int) int {
+	switch {
+	case n < 0:
+		return -1
+	case n > 0:
+		return 1
+	}
+	return 0
+}
+
+// VersionRange is a set of version constraints, e.g. ">=2.0.0 <3.0.0" or
+// "^1.4 || >=2.1.0". Space-separated comparators must all match; "||"
+// separates alternatives, any of which may match.
+type VersionRange struct {
+	raw  string
+	sets [][]versionComparator
+}
+
+type versionComparator struct {
+	op      string // one of = != > >= < <=
+	version Version
+}
+
+// ParseVersionRange parses a range of comparators. Supported operators are
+// =, !=, >, >=, <, <=, ^ (same major, or same minor for 0.x) and ~ (same
+// minor). A bare version means =.
+func ParseVersionRange(s string) (VersionRange, error) {
+	r := VersionRange{raw: s}
+	for _, alternative := range strings.Split(s, "||") {
+		fields := strings.Fields(alternative)
+		if len(fields) == 0 {
+			return VersionRange{}, fmt.Errorf("invalid version range %q: empty alternative", s)
+		}
+
+		var set []versionComparator
+		for _, field := range fields {
+			comparators, err := parseComparator(field)
+			if err != nil {
+				return VersionRange{}, fmt.Errorf("invalid version range %q: %w", s, err)
+			}
+			set = append(set, comparators...)
+		}
+		r.sets = append(r.sets, set)
+	}
+	return r, nil
+}
+
+func parseComparator(field string) ([]versionComparator, error) {
+	op := ""
+	for _, candidate := range []string{">=", "<=", "!=", ">", "<", "=", "^", "~"} {
+		if strings.HasPrefix(field, candidate) {
+			op = candidate
+			break
+		}
+	}
+	v, err := ParseVersion(field[len(op):])
+	if err != nil {
+		return nil, err
+	}
+
+	switch op {
+	case "":
+		return []versionComparator{{op: "=", version: v}}, nil
+	case "^":
+		upper := Version{Major: v.Major + 1}
+		if v.Major == 0 {
+			upper = Version{Minor: v.Minor + 1}
+		}
+		return []versionComparator{{op: ">=", version: v}, {op: "<", version: upper}}, nil
+	case "~":
+		upper := Version{Major: v.Major, Minor: v.Minor + 1}
+		return []versionComparator{{op: ">=", version: v}, {op: "<", version: upper}}, nil
+	}
+	return []versionComparator{{op: op, version: v}}, nil
+}
+
+// Contains reports whether v satisfies the range.
+func (r VersionRange) Contains(v Version) bool {
+	for _, set := range r.sets {
+		if satisfiesAll(v, set) {
+			return true
+		}
+	}
+	return false
+}
+
+func satisfiesAll(v Version, set []versionComparator) bool {
+	for _, c := range set {
+		cmp := v.Compare(c.version)
+		var ok bool
+		switch c.op {
+		case "=":
+			ok = cmp == 0
+		case "!=":
+			ok = cmp != 0
+		case ">":
+			ok = cmp > 0
+		case ">=":
+			ok = cmp >= 0
+		case "<":
+			ok = cmp < 0
+		case "<=":
+			ok = cmp <= 0
+		}
+		if !ok {
+			return false
+		}
+	}
+	return true
+}
+
+func (r VersionRange) String() string {
+	return r.raw
+}
@@ -0,0 +1,108 @@
+package allocation
+
+import "testing"
+
+func TestParseVersion(t *testing.T) {
+	tests := []struct {
+		input   string
+		want    string
+		wantErr bool
+	}{
+		{input: "2.4.1", want: "2.4.1"},
+		{input: "v2.4.1", want: "2.4.1"},
+		{input: "2.1", want: "2.1.0"},
+		{input: "3", want: "3.0.0"},
+		{input: " 1.0.0 ", want: "1.0.0"},
+		{input: "3.0.0-beta.2", want: "3.0.0-beta.2"},
+		{input: "1.0.0+build.5", want: "1.0.0"},
+		{input: "1.0.0-rc.1+build.5", want: "1.0.0-rc.1"},
+		{input: "", wantErr: true},
+		{input: "1.2.3.4", wantErr: true},
+		{input: "1.x", wantErr: true},
+		{input: "-1.0.0", wantErr: true},
+		{input: "1.0.0-", wantErr: true},
+		{input: "latest", wantErr: true},
+	}
+	for _, tt := range tests {
+		v, err := ParseVersion(tt.input)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("ParseVersion(%q) = %s, want an error", tt.input, v)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("ParseVersion(%q) error = %v", tt.input, err)
+		} else if v.String() != tt.want {
+			t.Errorf("ParseVersion(%q) = %s, want %s", tt.input, v, tt.want)
+		}
+	}
+}
+
+func TestVersionCompare(t *testing.T) {
+	// Each version sorts strictly below the next, as in the semver spec
+	ordered := []string{
+		"1.0.0-alpha", "1.0.0-alpha.1", "1.0.0-alpha.beta", "1.0.0-beta",
+		"1.0.0-beta.2", "1.0.0-beta.11", "1.0.0-rc.1", "1.0.0", "1.0.1", "1.1.0", "2.0.0",
+	}
+	for i := range ordered {
+		for j := range ordered {
+			a, _ := ParseVersion(ordered[i])
+			b, _ := ParseVersion(ordered[j])
+			want := sign(i - j)
+			if got := a.Compare(b); got != want {
+				t.Errorf("%s.Compare(%s) = %d, want %d", ordered[i], ordered[j], got, want)
+			}
+		}
+	}
+}
+
+func TestVersionRangeContains(t *testing.T) {
+	tests := []struct {
+		rng     string
+		version string
+		want    bool
+	}{
+		{">=2.0.0", "2.0.0", true},
+		{">=2.0.0", "1.9.9", false},
+		{">=2.0.0", "2.0.0-beta.1", false},
+		{">=2.0.0 <3.0.0", "2.9.9", true},
+		{">=2.0.0 <3.0.0", "3.0.0", false},
+		{"^1.4", "1.9.0", true},
+		{"^1.4", "2.0.0", false},
+		{"^1.4", "1.3.9", false},
+		{"^0.3.1", "0.3.9", true},
+		{"^0.3.1", "0.4.0", false},
+		{"~1.4.2", "1.4.9", true},
+		{"~1.4.2", "1.5.0", false},
+		{"1.2.3", "1.2.3", true},
+		{"=1.2.3", "1.2.4", false},
+		{"!=1.2.3", "1.2.4", true},
+		{">1.0.0", "1.0.0", false},
+		{"<=1.0.0", "1.0.0", true},
+		{"<1.0.0 || >=2.0.0", "0.9.0", true},
+		{"<1.0.0 || >=2.0.0", "1.5.0", false},
+		{"<1.0.0 || >=2.0.0", "2.1.0", true},
+	}
+	for _, tt := range tests {
+		r, err := ParseVersionRange(tt.rng)
+		if err != nil {
+			t.Fatalf("ParseVersionRange(%q) error = %v", tt.rng, err)
+		}
+		v, err := ParseVersion(tt.version)
+		if err != nil {
+			t.Fatalf("ParseVersion(%q) error = %v", tt.version, err)
+		}
+		if got := r.Contains(v); got != tt.want {
+			t.Errorf("%q contains %s = %v, want %v", tt.rng, tt.version, got, tt.want)
+		}
+	}
+}
+
+func TestParseVersionRangeErrors(t *testing.T) {
+	for _, rng := range []string{"", ">=", ">=2.0.0 ||", "|| <1.0.0", ">=x", "~"} {
+		if _, err := ParseVersionRange(rng); err == nil {
+			t.Errorf("ParseVersionRange(%q) succeeded, want an error", rng)
+		}
+	}
+}
@@ -0,0 +1,85 @@
+package allocation
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+)
+
+// VariantResponse is the HTTP response a variant is served as, for experiments
+// that test a redirect or an error page rather than payload content. It
+// changes only how the payload is sent: users are bucketed into the variant
+// exactly as before.
+type VariantResponse struct {
+	// StatusCode replaces the 200 the variant is normally served with
+	StatusCode int `json:"statusCode"`
+	// Location, for redirects and 201, is sent as the Location header
+	Location string `json:"location,omitempty"`
+	// OmitPayload sends the status code and headers without a body, instead
+	// of the payload's usual response
+	OmitPayload bool `json:"omitPayload,omitempty"`
+}
+
+// VariantResponses maps payload names to the response they are served as.
+// Payloads not listed are served as usual.
+type VariantResponses map[string]VariantResponse
+
+// ParseVariantResponses parses a JSON object mapping payload names to
+// VariantResponses, e.g. {"variant_b.json": {"statusCode": 302, "location":
+// "https://example.com/new", "omitPayload": true}}, and checks each one: the
+// status code is a final 2xx to 5xx status, redirects say where to, only
+// redirects and 201 carry a Location, and statuses that can't have a body
+// omit the payload.
+func ParseVariantResponses(data []byte) (VariantResponses, error) {
+	var r VariantResponses
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&r); err != nil {
+		return nil, err
+	}
+	for _, name := range r.Payloads() {
+		if err := r[name].check(); err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+	}
+	return r, nil
+}
+
+func (v VariantResponse) check() error {
+	if v.StatusCode < 200 || v.StatusCode > 599 {
+		return fmt.Errorf("statusCode must be between 200 and 599, got %d", v.StatusCode)
+	}
+	switch v.StatusCode {
+	case http.StatusMovedPermanently, http.StatusFound, http.StatusSeeOther,
+		http.StatusTemporaryRedirect, http.StatusPermanentRedirect:
+		if v.Location == "" {
+			return fmt.Errorf("statusCode %d redirects, so it needs a location", v.StatusCode)
+		}
+	case http.StatusNoContent, http.StatusNotModified:
+		if !v.OmitPayload {
+			return fmt.Errorf("statusCode %d can't have a body, so it needs omitPayload", v.StatusCode)
+		}
+	}
+	if v.Location != "" {
+		if v.StatusCode != http.StatusCreated && (v.StatusCode < 300 || v.StatusCode > 399) {
+			return fmt.Errorf("location only goes with a 3xx or 201 statusCode, got %d", v.StatusCode)
+		}
+		if _, err := url.Parse(v.Location); err != nil {
+			return fmt.Errorf("invalid location: %w", err)
+		}
+	}
+	return nil
+}
+
+// Payloads returns the names of the payloads r lists, sorted.
+func (r VariantResponses) Payloads() []string {
+	names := make([]string, 0, len(r))
+	for name := range r {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,74 @@
+package allocation
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseVariantResponses(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  string
+		want    VariantResponses
+		wantErr string
+	}{
+		{
+			name:   "redirect",
+			config: `{"b.json": {"statusCode": 302, "location": "https://example.com/new", "omitPayload": true}}`,
+			want:   VariantResponses{"b.json": {StatusCode: 302, Location: "https://example.com/new", OmitPayload: true}},
+		},
+		{
+			name:   "custom status with payload",
+			config: `{"c.json": {"statusCode": 503}}`,
+			want:   VariantResponses{"c.json": {StatusCode: 503}},
+		},
+		{
+			name:   "created with location",
+			config: `{"c.json": {"statusCode": 201, "location": "/things/1"}}`,
+			want:   VariantResponses{"c.json": {StatusCode: 201, Location: "/things/1"}},
+		},
+		{
+			name:   "no content without payload",
+			config: `{"c.json": {"statusCode": 204, "omitPayload": true}}`,
+			want:   VariantResponses{"c.json": {StatusCode: 204, OmitPayload: true}},
+		},
+		{name: "empty", config: `{}`, want: VariantResponses{}},
+		{name: "informational status", config: `{"a.json": {"statusCode": 101}}`, wantErr: "between 200 and 599"},
+		{name: "missing status", config: `{"a.json": {}}`, wantErr: "between 200 and 599"},
+		{name: "redirect without location", config: `{"a.json": {"statusCode": 307}}`, wantErr: "needs a location"},
+		{name: "location on error", config: `{"a.json": {"statusCode": 404, "location": "/x"}}`, wantErr: "only goes with a 3xx or 201"},
+		{name: "no content with payload", config: `{"a.json": {"statusCode": 204}}`, wantErr: "needs omitPayload"},
+		{name: "bad location", config: `{"a.json": {"statusCode": 302, "location": "http://a b/%zz"}}`, wantErr: "invalid location"},
+		{name: "unknown field", config: `{"a.json": {"status": 302}}`, wantErr: "unknown field"},
+		{name: "not an object", config: `[]`, wantErr: "cannot unmarshal"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseVariantResponses([]byte(tt.config))
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("ParseVariantResponses(%s) error = %v, want one containing %q", tt.config, err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParseVariantResponses(%s) error = %v", tt.config, err)
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("ParseVariantResponses(%s) = %v, want %v", tt.config, got, tt.want)
+			}
+			for name, want := range tt.want {
+				if got[name] != want {
+					t.Errorf("ParseVariantResponses(%s)[%s] = %+v, want %+v", tt.config, name, got[name], want)
+				}
+			}
+		})
+	}
+}
+
+func TestVariantResponsesPayloadsSorted(t *testing.T) {
+	r := VariantResponses{"c.json": {}, "a.json": {}, "b.json": {}}
+	if got := strings.Join(r.Payloads(), ","); got != "a.json,b.json,c.json" {
+		t.Errorf("Payloads() = %s, want a.json,b.json,c.json", got)
+	}
+}
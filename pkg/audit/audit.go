@@ -0,0 +1,108 @@
+// Package audit writes an append-only JSON Lines record of every allocation
+// the server makes. Records are written by a background goroutine so the
+// request path never waits on disk I/O.
+package audit
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"log"
+	"os"
+	"sync/atomic"
+	"time"
+)
+
+// Record is one allocation decision.
+type Record struct {
+	Timestamp    time.Time `json:"timestamp"`
+	RequestID    string    `json:"requestId"`
+	UserID       string    `json:"userId"`
+	ExperimentID string    `json:"experimentId"`
+	Variant      string    `json:"variant"`
+	Bucket       int       `json:"bucket"` // -1 when the user was not bucketed (not exposed, or an incompatible app version)
+}
+
+// Logger queues records on a buffered channel and writes them from a single
+// goroutine. When the buffer is full, records are dropped and counted rather
+// than blocking the caller.
+type Logger struct {
+	records chan Record
+	out     io.WriteCloser
+	dropped atomic.Int64
+	done    chan struct{}
+}
+
+// Open creates a Logger appending to path, or writing to stdout if path is "-".
+// bufferSize is the number of records that can be queued before new records
+// are dropped.
+func Open(path string, bufferSize int) (*Logger, error) {
+	var out io.WriteCloser = nopCloser{os.Stdout}
+	if path != "-" {
+		f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			return nil, err
+		}
+		out = f
+	}
+
+	l := &Logger{
+		records: make(chan Record, bufferSize),
+		out:     out,
+		done:    make(chan struct{}),
+	}
+	go l.run()
+	return l, nil
+}
+
+// Log queues a record without blocking. If the queue is full the record is
+// dropped and counted.
+func (l *Logger) Log(r Record) {
+	select {
+	case l.records <- r:
+	default:
+		if l.dropped.Add(1) == 1 {
+			log.Printf("Warning: audit log buffer full, dropping records")
+		}
+	}
+}
+
+// Dropped returns how many records have been dropped due to backpressure.
+func (l *Logger) Dropped() int64 {
+	return l.dropped.Load()
+}
+
+// Close stops accepting records, writes everything still queued and closes the
+// output. Log must not be called after Close.
+func (l *Logger) Close() error {
+	close(l.records)
+	<-l.done
+	if dropped := l.dropped.Load(); dropped > 0 {
+		log.Printf("Audit log closed with %d dropped records", dropped)
+	}
+	return l.out.Close()
+}
+
+func (l *Logger) run() {
+	defer close(l.done)
+
+	w := bufio.NewWriter(l.out)
+	enc := json.NewEncoder(w)
+	for r := range l.records {
+		if err := enc.Encode(r); err != nil {
+			log.Printf("Audit log write failed: %v", err)
+		}
+		// Flush whenever the queue drains so records reach disk promptly
+		// without paying a syscall per record under load
+		if len(l.records) == 0 {
+			if err := w.Flush(); err != nil {
+				log.Printf("Audit log flush failed: %v", err)
+			}
+		}
+	}
+	w.Flush()
+}
+
+type nopCloser struct{ io.Writer }
+
+func (nopCloser) Close() error { return nil }
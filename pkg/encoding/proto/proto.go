@@ -0,0 +1,271 @@
+// Package proto encodes /experiment responses as protobuf for clients that
+// would rather not parse large JSON bundles. The messages are defined in
+// localization.proto; payload content is a Value, wire compatible with
+// google.protobuf.Value. The wire format is written and read by hand, as the
+// messages are small and fixed and the server has no other protobuf use.
+package proto
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+)
+
+// Wire types used by the messages in localization.proto.
+const (
+	wireVarint  = 0
+	wireFixed64 = 1
+	wireBytes   = 2
+	wireFixed32 = 5
+)
+
+// Field numbers of Value's kind oneof.
+const (
+	fieldNull   = 1
+	fieldNumber = 2
+	fieldString = 3
+	fieldBool   = 4
+	fieldStruct = 5
+	fieldList   = 6
+)
+
+// Response is the protobuf form of the JSON /experiment response. Payload
+// holds an encoded Value, as returned by EncodeJSON, so a payload can be
+// encoded once and served many times.
+type Response struct {
+	ExperimentID        string
+	SelectedPayloadName string
+	Payload             []byte
+	Exposed             *bool // nil when the server doesn't gate exposure
+	SchemaVersion       uint32
+	Degraded            bool // the server's lightweight payload, served under load
+}
+
+// Marshal encodes r as a localization.v1.Response message.
+func (r Response) Marshal() []byte {
+	b := make([]byte, 0, len(r.Payload)+len(r.ExperimentID)+len(r.SelectedPayloadName)+16)
+	b = appendString(b, 1, r.ExperimentID)
+	b = appendString(b, 2, r.SelectedPayloadName)
+	b = appendBytes(b, 3, r.Payload)
+	if r.Exposed != nil {
+		b = appendBool(b, 4, *r.Exposed)
+	}
+	if r.SchemaVersion != 0 {
+		b = appendVarintField(b, 5, uint64(r.SchemaVersion))
+	}
+	if r.Degraded {
+		b = appendBool(b, 6, true)
+	}
+	return b
+}
+
+// UnmarshalResponse decodes a localization.v1.Response message. Unknown
+// fields are skipped.
+func UnmarshalResponse(b []byte) (Response, error) {
+	var r Response
+	err := eachField(b, func(field, wire int, varint uint64, data []byte) error {
+		switch {
+		case field == 1 && wire == wireBytes:
+			r.ExperimentID = string(data)
+		case field == 2 && wire == wireBytes:
+			r.SelectedPayloadName = string(data)
+		case field == 3 && wire == wireBytes:
+			r.Payload = data
+		case field == 4 && wire == wireVarint:
+			exposed := varint != 0
+			r.Exposed = &exposed
+		case field == 5 && wire == wireVarint:
+			r.SchemaVersion = uint32(varint)
+		case field == 6 && wire == wireVarint:
+			r.Degraded = varint != 0
+		}
+		return nil
+	})
+	return r, err
+}
+
+// EncodeJSON encodes a JSON document as a Value message. Object keys are
+// written in sorted order, so the same content always encodes the same. As in
+// google.protobuf.Value, numbers are doubles: integers beyond 2^53 lose
+// precision.
+func EncodeJSON(content []byte) ([]byte, error) {
+	var v interface{}
+	if err := json.Unmarshal(content, &v); err != nil {
+		return nil, err
+	}
+	return appendValue(nil, v), nil
+}
+
+// DecodeValue decodes a Value message into the Go value json.Unmarshal would
+// produce for the same content: map[string]interface{}, []interface{},
+// float64, string, bool or nil.
+func DecodeValue(b []byte) (interface{}, error) {
+	var v interface{}
+	err := eachField(b, func(field, wire int, varint uint64, data []byte) error {
+		var err error
+		switch {
+		case field == fieldNull && wire == wireVarint:
+			v = nil
+		case field == fieldNumber && wire == wireFixed64:
+			v = math.Float64frombits(varint)
+		case field == fieldString && wire == wireBytes:
+			v = string(data)
+		case field == fieldBool && wire == wireVarint:
+			v = varint != 0
+		case field == fieldStruct && wire == wireBytes:
+			v, err = decodeStruct(data)
+		case field == fieldList && wire == wireBytes:
+			v, err = decodeList(data)
+		}
+		return err
+	})
+	return v, err
+}
+
+func decodeStruct(b []byte) (map[string]interface{}, error) {
+	object := make(map[string]interface{})
+	err := eachField(b, func(field, wire int, _ uint64, entry []byte) error {
+		if field != 1 || wire != wireBytes {
+			return nil
+		}
+		var key string
+		var value interface{}
+		err := eachField(entry, func(field, wire int, _ uint64, data []byte) error {
+			var err error
+			switch {
+			case field == 1 && wire == wireBytes:
+				key = string(data)
+			case field == 2 && wire == wireBytes:
+				value, err = DecodeValue(data)
+			}
+			return err
+		})
+		object[key] = value
+		return err
+	})
+	return object, err
+}
+
+func decodeList(b []byte) ([]interface{}, error) {
+	list := []interface{}{}
+	err := eachField(b, func(field, wire int, _ uint64, data []byte) error {
+		if field != 1 || wire != wireBytes {
+			return nil
+		}
+		value, err := DecodeValue(data)
+		list = append(list, value)
+		return err
+	})
+	return list, err
+}
+
+// appendValue appends v, a value from json.Unmarshal, as a Value message.
+func appendValue(b []byte, v interface{}) []byte {
+	switch v := v.(type) {
+	case nil:
+		return appendVarintField(b, fieldNull, 0)
+	case float64:
+		b = appendTag(b, fieldNumber, wireFixed64)
+		return binary.LittleEndian.AppendUint64(b, math.Float64bits(v))
+	case string:
+		return appendString(b, fieldString, v)
+	case bool:
+		return appendBool(b, fieldBool, v)
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		var fields []byte
+		for _, k := range keys {
+			entry := appendString(nil, 1, k)
+			entry = appendBytes(entry, 2, appendValue(nil, v[k]))
+			fields = appendBytes(fields, 1, entry)
+		}
+		return appendBytes(b, fieldStruct, fields)
+	case []interface{}:
+		var values []byte
+		for _, item := range v {
+			values = appendBytes(values, 1, appendValue(nil, item))
+		}
+		return appendBytes(b, fieldList, values)
+	}
+	panic(fmt.Sprintf("proto: unexpected JSON value of type %T", v))
+}
+
+func appendTag(b []byte, field, wire int) []byte {
+	return binary.AppendUvarint(b, uint64(field)<<3|uint64(wire))
+}
+
+func appendVarintField(b []byte, field int, v uint64) []byte {
+	return binary.AppendUvarint(appendTag(b, field, wireVarint), v)
+}
+
+func appendBool(b []byte, field int, v bool) []byte {
+	if v {
+		return appendVarintField(b, field, 1)
+	}
+	return appendVarintField(b, field, 0)
+}
+
+func appendBytes(b []byte, field int, data []byte) []byte {
+	b = binary.AppendUvarint(appendTag(b, field, wireBytes), uint64(len(data)))
+	return append(b, data...)
+}
+
+func appendString(b []byte, field int, s string) []byte {
+	b = binary.AppendUvarint(appendTag(b, field, wireBytes), uint64(len(s)))
+	return append(b, s...)
+}
+
+var errTruncated = errors.New("proto: truncated message")
+
+// eachField calls fn for every field in the message b, in wire order. varint
+// holds the value of varint and fixed-width fields, data that of
+// length-delimited ones.
+func eachField(b []byte, fn func(field, wire int, varint uint64, data []byte) error) error {
+	for len(b) > 0 {
+		tag, n := binary.Uvarint(b)
+		if n <= 0 {
+			return errTruncated
+		}
+		b = b[n:]
+		field, wire := int(tag>>3), int(tag&7)
+
+		var varint uint64
+		var data []byte
+		switch wire {
+		case wireVarint:
+			if varint, n = binary.Uvarint(b); n <= 0 {
+				return errTruncated
+			}
+			b = b[n:]
+		case wireFixed64:
+			if len(b) < 8 {
+				return errTruncated
+			}
+			varint, b = binary.LittleEndian.Uint64(b), b[8:]
+		case wireFixed32:
+			if len(b) < 4 {
+				return errTruncated
+			}
+			varint, b = uint64(binary.LittleEndian.Uint32(b)), b[4:]
+		case wireBytes:
+			size, n := binary.Uvarint(b)
+			if n <= 0 || uint64(len(b)-n) < size {
+				return errTruncated
+			}
+			data, b = b[n:n+int(size)], b[n+int(size):]
+		default:
+			return fmt.Errorf("proto: unsupported wire type %d for field %d", wire, field)
+		}
+		if err := fn(field, wire, varint, data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
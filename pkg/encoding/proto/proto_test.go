@@ -0,0 +1,148 @@
+package proto
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestEncodeJSONRoundTrip(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+	}{
+		{name: "null", content: `null`},
+		{name: "bool", content: `false`},
+		{name: "number", content: `-12.5`},
+		{name: "string", content: `"héllo, 世界"`},
+		{name: "empty object", content: `{}`},
+		{name: "empty list", content: `[]`},
+		{name: "flat bundle", content: `{"greeting":"hello","farewell":"bye","count":3}`},
+		{
+			name:    "nested",
+			content: `{"menu":{"items":[{"label":"Open","enabled":true},{"label":"Close","enabled":false}],"shortcut":null},"ratio":0.75}`,
+		},
+		{name: "list of mixed values", content: `[1,"two",[3],{"four":4},null,true]`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			encoded, err := EncodeJSON([]byte(tt.content))
+			if err != nil {
+				t.Fatalf("EncodeJSON(%s) error = %v", tt.content, err)
+			}
+			got, err := DecodeValue(encoded)
+			if err != nil {
+				t.Fatalf("DecodeValue error = %v", err)
+			}
+			var want interface{}
+			if err := json.Unmarshal([]byte(tt.content), &want); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("round trip of %s = %#v, want %#v", tt.content, got, want)
+			}
+		})
+	}
+}
+
+// TestEncodeJSONWireFormat pins the bytes of scalar Values, which a
+// google.protobuf.Value decoder must read the same way.
+func TestEncodeJSONWireFormat(t *testing.T) {
+	tests := []struct {
+		content string
+		want    string // hex
+	}{
+		{content: `null`, want: "0800"},
+		{content: `1`, want: "11000000000000f03f"},
+		{content: `"hi"`, want: "1a026869"},
+		{content: `true`, want: "2001"},
+		{content: `{"a":true}`, want: "2a090a070a016112022001"},
+		{content: `["x"]`, want: "32050a031a0178"},
+	}
+	for _, tt := range tests {
+		got, err := EncodeJSON([]byte(tt.content))
+		if err != nil {
+			t.Fatalf("EncodeJSON(%s) error = %v", tt.content, err)
+		}
+		if hex.EncodeToString(got) != tt.want {
+			t.Errorf("EncodeJSON(%s) = %x, want %s", tt.content, got, tt.want)
+		}
+	}
+}
+
+func TestEncodeJSONDeterministic(t *testing.T) {
+	a, err := EncodeJSON([]byte(`{"b":1,"a":{"y":2,"x":3}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := EncodeJSON([]byte(`{"a":{"x":3,"y":2},"b":1}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(a, b) {
+		t.Errorf("equal objects with keys in different orders encode differently: %x, %x", a, b)
+	}
+}
+
+func TestEncodeJSONInvalid(t *testing.T) {
+	if _, err := EncodeJSON([]byte(`{"a":`)); err == nil {
+		t.Error("EncodeJSON of truncated JSON succeeded, want an error")
+	}
+}
+
+func TestResponseRoundTrip(t *testing.T) {
+	payload, err := EncodeJSON([]byte(`{"greeting":"hello"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	exposed, unexposed := true, false
+	tests := []struct {
+		name     string
+		response Response
+	}{
+		{name: "minimal", response: Response{ExperimentID: "exp", SelectedPayloadName: "a.json", Payload: payload}},
+		{
+			name: "every field",
+			response: Response{
+				ExperimentID: "exp", SelectedPayloadName: "b.json", Payload: payload,
+				Exposed: &exposed, SchemaVersion: 3, Degraded: true,
+			},
+		},
+		{name: "not exposed", response: Response{ExperimentID: "exp", SelectedPayloadName: "control.json", Payload: payload, Exposed: &unexposed}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := UnmarshalResponse(tt.response.Marshal())
+			if err != nil {
+				t.Fatalf("UnmarshalResponse error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.response) {
+				t.Errorf("round trip = %+v, want %+v", got, tt.response)
+			}
+		})
+	}
+}
+
+func TestUnmarshalResponseErrors(t *testing.T) {
+	full := Response{ExperimentID: "exp", SelectedPayloadName: "a.json", Payload: []byte{0x20, 0x01}}.Marshal()
+	tests := []struct {
+		name    string
+		message []byte
+		wantErr bool
+	}{
+		{name: "empty", message: nil},
+		{name: "unknown field skipped", message: append(append([]byte{}, full...), 0x78, 0x05)},
+		{name: "truncated length", message: full[:len(full)-1], wantErr: true},
+		{name: "truncated tag", message: []byte{0x80}, wantErr: true},
+		{name: "truncated fixed64", message: []byte{0x79, 0x01}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := UnmarshalResponse(tt.message); (err != nil) != tt.wantErr {
+				t.Errorf("UnmarshalResponse(%x) error = %v, want error %v", tt.message, err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,134 @@
+// Package hashring implements a consistent-hashing ring used to map user IDs
+// onto content nodes. Adding or removing a node only moves the keys that fall
+// into that node's arcs (roughly 1/N of them), so per-user stickiness survives
+// changes to the set of backing stores.
+package hashring
+
+import (
+	"hash/fnv"
+	"sort"
+	"strconv"
+	"sync"
+)
+
+// DefaultVirtualNodes is the number of points each node gets on the ring when
+// no explicit count is configured. More points give a smoother spread of keys
+// across nodes at the cost of a larger ring.
+const DefaultVirtualNodes = 160
+
+// Ring maps keys to nodes using consistent hashing with virtual nodes.
+// It is safe for concurrent use.
+type Ring struct {
+	mu           sync.RWMutex
+	virtualNodes int
+	points       []uint64          // sorted hash positions on the ring
+	owners       map[uint64]string // hash position -> node
+	nodes        map[string]struct{}
+}
+
+// New creates an empty ring. Each node added to the ring is placed at
+// virtualNodes positions; a value <= 0 uses DefaultVirtualNodes.
+func New(virtualNodes int, nodes ...string) *Ring {
+	if virtualNodes <= 0 {
+		virtualNodes = DefaultVirtualNodes
+	}
+	r := &Ring{
+		virtualNodes: virtualNodes,
+		owners:       make(map[uint64]string),
+		nodes:        make(map[string]struct{}),
+	}
+	for _, node := range nodes {
+		r.Add(node)
+	}
+	return r
+}
+
+// Add places a node on the ring. Adding a node that is already present is a no-op.
+func (r *Ring) Add(node string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.nodes[node]; ok {
+		return
+	}
+	r.nodes[node] = struct{}{}
+
+	for i := 0; i < r.virtualNodes; i++ {
+		point := hashKey(node + "#" + strconv.Itoa(i))
+		// On the (astronomically unlikely) event of a collision, keep the
+		// existing owner so placement doesn't depend on insertion order.
+		if _, taken := r.owners[point]; taken {
+			continue
+		}
+		r.owners[point] = node
+		r.points = append(r.points, point)
+	}
+	sort.Slice(r.points, func(i, j int) bool { return r.points[i] < r.points[j] })
+}
+
+// Remove takes a node off the ring. Keys it owned move to the next node
+// clockwise; all other keys keep their assignment.
+func (r *Ring) Remove(node string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.nodes[node]; !ok {
+		return
+	}
+	delete(r.nodes, node)
+
+	kept := r.points[:0]
+	for _, point := range r.points {
+		if r.owners[point] == node {
+			delete(r.owners, point)
+			continue
+		}
+		kept = append(kept, point)
+	}
+	r.points = kept
+}
+
+// Get returns the node responsible for key, or "" if the ring is empty.
+func (r *Ring) Get(key string) string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	if len(r.points) == 0 {
+		return ""
+	}
+
+	h := hashKey(key)
+	i := sort.Search(len(r.points), func(i int) bool { return r.points[i] >= h })
+	if i == len(r.points) {
+		i = 0 // wrap around the ring
+	}
+	return r.owners[r.points[i]]
+}
+
+// Nodes returns the nodes currently on the ring, sorted by name.
+func (r *Ring) Nodes() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	nodes := make([]string, 0, len(r.nodes))
+	for node := range r.nodes {
+		nodes = append(nodes, node)
+	}
+	sort.Strings(nodes)
+	return nodes
+}
+
+// hashKey hashes key onto the ring. FNV-1a alone clusters short, similar
+// inputs like "node#1", "node#2", so its output is run through the murmur3
+// 64-bit finalizer to spread virtual nodes evenly around the ring.
+func hashKey(key string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(key))
+	x := h.Sum64()
+	x ^= x >> 33
+	x *= 0xff51afd7ed558ccd
+	x ^= x >> 33
+	x *= 0xc4ceb9fe1a85ec53
+	x ^= x >> 33
+	return x
+}
@@ -0,0 +1,134 @@
+package hashring
+
+import (
+	"fmt"
+	"math"
+	"testing"
+)
+
+// testKeys returns n distinct user-like keys.
+func testKeys(n int) []string {
+	keys := make([]string, n)
+	for i := range keys {
+		keys[i] = fmt.Sprintf("user-%d", i)
+	}
+	return keys
+}
+
+func nodeNames(n int) []string {
+	nodes := make([]string, n)
+	for i := range nodes {
+		nodes[i] = fmt.Sprintf("node-%d", i)
+	}
+	return nodes
+}
+
+// TestAddMovesOnlyItsShare adds one node to rings of N nodes and checks that
+// only about 1/(N+1) of keys move, all of them to the new node.
+func TestAddMovesOnlyItsShare(t *testing.T) {
+	keys := testKeys(50000)
+	for _, n := range []int{2, 4, 8, 16} {
+		t.Run(fmt.Sprintf("nodes=%d", n), func(t *testing.T) {
+			r := New(0, nodeNames(n)...)
+			before := make([]string, len(keys))
+			for i, k := range keys {
+				before[i] = r.Get(k)
+			}
+
+			r.Add("node-new")
+			moved := 0
+			for i, k := range keys {
+				after := r.Get(k)
+				if after == before[i] {
+					continue
+				}
+				moved++
+				if after != "node-new" {
+					t.Fatalf("key %s moved from %s to %s, not to the new node", k, before[i], after)
+				}
+			}
+			share := float64(moved) / float64(len(keys))
+			want := 1 / float64(n+1)
+			if math.Abs(share-want) > want*0.3 {
+				t.Errorf("adding a node moved %.3f of keys, want about %.3f", share, want)
+			}
+		})
+	}
+}
+
+// TestRemoveMovesOnlyItsKeys removes a node and checks that exactly its keys
+// move and every other key keeps its node.
+func TestRemoveMovesOnlyItsKeys(t *testing.T) {
+	keys := testKeys(20000)
+	r := New(0, nodeNames(5)...)
+	before := make([]string, len(keys))
+	for i, k := range keys {
+		before[i] = r.Get(k)
+	}
+
+	r.Remove("node-2")
+	for i, k := range keys {
+		after := r.Get(k)
+		switch {
+		case after == "node-2":
+			t.Fatalf("key %s still maps to the removed node", k)
+		case before[i] != "node-2" && after != before[i]:
+			t.Fatalf("key %s moved from %s to %s though its node stayed", k, before[i], after)
+		}
+	}
+}
+
+// TestSpread checks that with the default virtual nodes every node gets
+// close to its fair share of keys.
+func TestSpread(t *testing.T) {
+	keys := testKeys(100000)
+	for _, n := range []int{3, 10} {
+		t.Run(fmt.Sprintf("nodes=%d", n), func(t *testing.T) {
+			r := New(0, nodeNames(n)...)
+			counts := make(map[string]int)
+			for _, k := range keys {
+				counts[r.Get(k)]++
+			}
+			fair := float64(len(keys)) / float64(n)
+			for _, node := range r.Nodes() {
+				if got := float64(counts[node]); math.Abs(got-fair) > fair*0.25 {
+					t.Errorf("%s got %d keys, want within 25%% of %.0f", node, counts[node], fair)
+				}
+			}
+		})
+	}
+}
+
+func TestRing(t *testing.T) {
+	tests := []struct {
+		name      string
+		ring      func() *Ring
+		wantNodes []string
+		wantGet   string // "" for an empty ring, "*" for any node
+	}{
+		{name: "empty", ring: func() *Ring { return New(0) }, wantGet: ""},
+		{name: "one node", ring: func() *Ring { return New(4, "a") }, wantNodes: []string{"a"}, wantGet: "a"},
+		{name: "duplicate add", ring: func() *Ring { r := New(4, "a", "b"); r.Add("a"); return r }, wantNodes: []string{"a", "b"}, wantGet: "*"},
+		{name: "remove unknown", ring: func() *Ring { r := New(4, "a"); r.Remove("z"); return r }, wantNodes: []string{"a"}, wantGet: "a"},
+		{name: "remove last", ring: func() *Ring { r := New(4, "a"); r.Remove("a"); return r }, wantGet: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := tt.ring()
+			if got := fmt.Sprint(r.Nodes()); got != fmt.Sprint(append([]string{}, tt.wantNodes...)) {
+				t.Errorf("Nodes() = %s, want %v", got, tt.wantNodes)
+			}
+			got := r.Get("user-1")
+			if tt.wantGet == "*" {
+				if got == "" {
+					t.Errorf("Get() = \"\", want a node")
+				}
+			} else if got != tt.wantGet {
+				t.Errorf("Get() = %q, want %q", got, tt.wantGet)
+			}
+			if r.Get("user-1") != got {
+				t.Errorf("Get() isn't stable")
+			}
+		})
+	}
+}
@@ -0,0 +1,54 @@
+// Package health waits for a server to report itself healthy, so the test
+// tools can be started alongside a server that is still loading its payloads,
+// as CI pipelines do, rather than failing on their first request.
+package health
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// DefaultAttempts and DefaultInterval wait up to about 30 seconds, long enough
+// for the server to load the largest payload sets in the repo.
+const (
+	DefaultAttempts = 30
+	DefaultInterval = time.Second
+)
+
+// Wait GETs url until it answers 200 OK, trying up to attempts times with
+// interval between tries. It returns how long it waited: zero when the first
+// attempt succeeded. When every attempt fails the error describes the last
+// failure. onRetry, if not nil, is called before each retry with the failure
+// that caused it, so a tool can show it is waiting.
+func Wait(client *http.Client, url string, attempts int, interval time.Duration, onRetry func(attempt int, err error)) (time.Duration, error) {
+	start := time.Now()
+	var err error
+	for attempt := 1; attempt <= attempts; attempt++ {
+		if attempt > 1 {
+			if onRetry != nil {
+				onRetry(attempt, err)
+			}
+			time.Sleep(interval)
+		}
+		if err = probe(client, url); err == nil {
+			if attempt == 1 {
+				return 0, nil
+			}
+			return time.Since(start), nil
+		}
+	}
+	return time.Since(start), fmt.Errorf("not healthy after %d attempts: %w", attempts, err)
+}
+
+func probe(client *http.Client, url string) error {
+	resp, err := client.Get(url)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s returned %d", url, resp.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,104 @@
+// Package idempotency remembers the result computed for an idempotency key for
+// a limited time, so a retried request gets the original result instead of
+// being processed (and counted) again.
+package idempotency
+
+import (
+	"strings"
+	"sync"
+	"time"
+)
+
+// Cache maps idempotency keys to the value first computed for them. Entries
+// expire ttl after they were created. All methods are safe for concurrent use.
+type Cache[V any] struct {
+	ttl time.Duration
+
+	mu        sync.Mutex
+	entries   map[string]*entry[V]
+	lastSweep time.Time
+}
+
+type entry[V any] struct {
+	expires time.Time
+	once    sync.Once
+	value   V
+	ready   bool // value has been computed; guarded by Cache.mu
+}
+
+// New creates a cache whose entries live for ttl.
+func New[V any](ttl time.Duration) *Cache[V] {
+	return &Cache[V]{
+		ttl:       ttl,
+		entries:   make(map[string]*entry[V]),
+		lastSweep: time.Now(),
+	}
+}
+
+// Do returns the value stored for key, calling compute to produce it if the
+// key is new or its entry has expired. cached is false for the call that ran
+// compute. Concurrent calls with the same new key run compute once; the others
+// wait for it and get its value.
+func (c *Cache[V]) Do(key string, compute func() V) (value V, cached bool) {
+	now := time.Now()
+
+	c.mu.Lock()
+	c.sweep(now)
+	e, ok := c.entries[key]
+	if !ok || now.After(e.expires) {
+		e = &entry[V]{expires: now.Add(c.ttl)}
+		// The key may alias a buffer the caller reuses, as fasthttp header
+		// values do; a key that changes in the map can never be found again
+		c.entries[strings.Clone(key)] = e
+	}
+	c.mu.Unlock()
+
+	computed := false
+	e.once.Do(func() {
+		e.value = compute()
+		computed = true
+		c.mu.Lock()
+		e.ready = true
+		c.mu.Unlock()
+	})
+	return e.value, !computed
+}
+
+// Evict removes every entry whose value matches and returns how many it
+// removed, so the next call with one of their keys computes a fresh value.
+// Entries whose value is still being computed are left alone: their value is
+// being computed from the current state already.
+func (c *Cache[V]) Evict(match func(V) bool) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	evicted := 0
+	for key, e := range c.entries {
+		if e.ready && match(e.value) {
+			delete(c.entries, key)
+			evicted++
+		}
+	}
+	return evicted
+}
+
+// Len returns the number of entries, including expired ones not yet swept.
+func (c *Cache[V]) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.entries)
+}
+
+// sweep drops expired entries at most once per ttl, so memory stays bounded by
+// the keys seen in roughly the last two ttl periods without scanning the map
+// on every call. The caller must hold mu.
+func (c *Cache[V]) sweep(now time.Time) {
+	if now.Sub(c.lastSweep) < c.ttl {
+		return
+	}
+	for key, e := range c.entries {
+		if now.After(e.expires) {
+			delete(c.entries, key)
+		}
+	}
+	c.lastSweep = now
+}
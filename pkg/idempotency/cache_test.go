@@ -0,0 +1,125 @@
+package idempotency
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+	"unsafe"
+)
+
+func TestDo(t *testing.T) {
+	tests := []struct {
+		name        string
+		ttl         time.Duration
+		keys        []string
+		pause       time.Duration // between calls
+		wantValues  []int
+		wantCached  []bool
+		wantCompute int
+	}{
+		{
+			name: "repeated key", ttl: time.Minute, keys: []string{"k", "k", "k"},
+			wantValues: []int{1, 1, 1}, wantCached: []bool{false, true, true}, wantCompute: 1,
+		},
+		{
+			name: "distinct keys", ttl: time.Minute, keys: []string{"a", "b", "a"},
+			wantValues: []int{1, 2, 1}, wantCached: []bool{false, false, true}, wantCompute: 2,
+		},
+		{
+			name: "expired key", ttl: time.Millisecond, keys: []string{"k", "k"}, pause: 5 * time.Millisecond,
+			wantValues: []int{1, 2}, wantCached: []bool{false, false}, wantCompute: 2,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := New[int](tt.ttl)
+			computed := 0
+			for i, key := range tt.keys {
+				if i > 0 {
+					time.Sleep(tt.pause)
+				}
+				value, cached := c.Do(key, func() int { computed++; return computed })
+				if value != tt.wantValues[i] || cached != tt.wantCached[i] {
+					t.Errorf("call %d Do(%q) = %d, %v, want %d, %v", i, key, value, cached, tt.wantValues[i], tt.wantCached[i])
+				}
+			}
+			if computed != tt.wantCompute {
+				t.Errorf("computed %d times, want %d", computed, tt.wantCompute)
+			}
+		})
+	}
+}
+
+// TestDoConcurrent retries one new key from many goroutines at once: compute
+// runs once and every caller gets its value.
+func TestDoConcurrent(t *testing.T) {
+	c := New[int](time.Minute)
+	var computed atomic.Int32
+	var wg sync.WaitGroup
+	values := make([]int, 50)
+	for i := range values {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			values[i], _ = c.Do("k", func() int {
+				time.Sleep(time.Millisecond)
+				return int(computed.Add(1))
+			})
+		}(i)
+	}
+	wg.Wait()
+	if n := computed.Load(); n != 1 {
+		t.Fatalf("computed %d times, want 1", n)
+	}
+	for i, v := range values {
+		if v != 1 {
+			t.Errorf("caller %d got %d, want 1", i, v)
+		}
+	}
+}
+
+// TestDoClonesKey passes a key that shares its bytes with a buffer, as
+// fasthttp header values do, reuses the buffer after the call and checks the
+// entry can still be found.
+func TestDoClonesKey(t *testing.T) {
+	c := New[int](time.Minute)
+	buf := []byte("key-1")
+	c.Do(unsafe.String(&buf[0], len(buf)), func() int { return 1 })
+	copy(buf, "xxxxx")
+	if value, cached := c.Do("key-1", func() int { return 2 }); value != 1 || !cached {
+		t.Errorf("Do(key-1) = %d, %v after the caller reused its buffer, want 1, true", value, cached)
+	}
+}
+
+func TestEvict(t *testing.T) {
+	c := New[string](time.Minute)
+	c.Do("k1", func() string { return "alice" })
+	c.Do("k2", func() string { return "bob" })
+	c.Do("k3", func() string { return "alice" })
+
+	if n := c.Evict(func(v string) bool { return v == "alice" }); n != 2 {
+		t.Errorf("Evict(alice) = %d, want 2", n)
+	}
+	if n := c.Len(); n != 1 {
+		t.Errorf("Len() = %d after evicting, want 1", n)
+	}
+	if value, cached := c.Do("k1", func() string { return "carol" }); value != "carol" || cached {
+		t.Errorf("Do(k1) = %q, %v after evicting it, want a fresh value", value, cached)
+	}
+	if value, cached := c.Do("k2", func() string { return "carol" }); value != "bob" || !cached {
+		t.Errorf("Do(k2) = %q, %v, want the kept value", value, cached)
+	}
+}
+
+func TestSweepBoundsEntries(t *testing.T) {
+	c := New[int](time.Millisecond)
+	for i := 0; i < 100; i++ {
+		c.Do(string(rune('a'+i%26))+string(rune('0'+i/26)), func() int { return i })
+	}
+	time.Sleep(5 * time.Millisecond)
+	c.Do("trigger", func() int { return 0 })
+	if n := c.Len(); n != 1 {
+		t.Errorf("Len() = %d after the ttl passed, want only the new entry", n)
+	}
+}
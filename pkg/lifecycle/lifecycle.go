@@ -0,0 +1,107 @@
+// Package lifecycle tracks which phase of its life the server is in, so health
+// checks can tell a server that is ready for traffic from one that is still
+// starting or is shutting down.
+package lifecycle
+
+import (
+	"sync"
+	"time"
+)
+
+// Phase is a stage of the server's life. Phases only move forward:
+// Starting, then Ready, then Draining.
+type Phase int
+
+const (
+	// Starting covers loading and warming payloads and validating the config
+	Starting Phase = iota
+	// Ready means every payload is loaded and the server is serving traffic
+	Ready
+	// Draining means the server is shutting down: it finishes in-flight
+	// requests but should get no new ones
+	Draining
+)
+
+func (p Phase) String() string {
+	switch p {
+	case Starting:
+		return "starting"
+	case Ready:
+		return "ready"
+	case Draining:
+		return "draining"
+	}
+	return "unknown"
+}
+
+// State is the server's current phase and when it entered each one. It is safe
+// for concurrent use.
+type State struct {
+	mu      sync.Mutex
+	phase   Phase
+	started time.Time
+	readyAt time.Time
+	since   time.Time
+}
+
+// New returns a State in the Starting phase, started now.
+func New() *State {
+	now := time.Now()
+	return &State{started: now, since: now}
+}
+
+// Snapshot is a State at one point in time.
+type Snapshot struct {
+	Phase Phase
+	// Started is when the server started, and Since when it entered Phase
+	Started time.Time
+	Since   time.Time
+	// StartupTime is how long the server took to become ready, or 0 if it
+	// never was
+	StartupTime time.Duration
+}
+
+// Snapshot returns the current phase and its timings.
+func (s *State) Snapshot() Snapshot {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	snap := Snapshot{Phase: s.phase, Started: s.started, Since: s.since}
+	if !s.readyAt.IsZero() {
+		snap.StartupTime = s.readyAt.Sub(s.started)
+	}
+	return snap
+}
+
+// Phase returns the current phase.
+func (s *State) Phase() Phase {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.phase
+}
+
+// MarkReady moves a starting server to Ready and reports whether it did. A
+// server that is already ready or draining is left as it is.
+func (s *State) MarkReady() bool {
+	return s.moveTo(Ready)
+}
+
+// Drain moves the server to Draining from any earlier phase and reports
+// whether it did, so only the first of several shutdown signals drains it.
+func (s *State) Drain() bool {
+	return s.moveTo(Draining)
+}
+
+// moveTo enters phase to unless the server is already in it or past it.
+func (s *State) moveTo(to Phase) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.phase >= to {
+		return false
+	}
+	now := time.Now()
+	s.phase, s.since = to, now
+	if to == Ready {
+		s.readyAt = now
+	}
+	return true
+}
@@ -0,0 +1,83 @@
+package lifecycle
+
+import "testing"
+
+func TestTransitions(t *testing.T) {
+	tests := []struct {
+		name      string
+		steps     []func(*State) bool
+		wantMoved []bool
+		want      Phase
+		wantReady bool // StartupTime is set
+	}{
+		{name: "new", want: Starting},
+		{
+			name:      "ready",
+			steps:     []func(*State) bool{(*State).MarkReady},
+			wantMoved: []bool{true},
+			want:      Ready,
+			wantReady: true,
+		},
+		{
+			name:      "ready then draining",
+			steps:     []func(*State) bool{(*State).MarkReady, (*State).Drain},
+			wantMoved: []bool{true, true},
+			want:      Draining,
+			wantReady: true,
+		},
+		{
+			name:      "draining before ready",
+			steps:     []func(*State) bool{(*State).Drain},
+			wantMoved: []bool{true},
+			want:      Draining,
+		},
+		{
+			name:      "no way back from draining",
+			steps:     []func(*State) bool{(*State).Drain, (*State).MarkReady},
+			wantMoved: []bool{true, false},
+			want:      Draining,
+		},
+		{
+			name:      "second signal",
+			steps:     []func(*State) bool{(*State).MarkReady, (*State).Drain, (*State).Drain},
+			wantMoved: []bool{true, true, false},
+			want:      Draining,
+			wantReady: true,
+		},
+		{
+			name:      "ready twice",
+			steps:     []func(*State) bool{(*State).MarkReady, (*State).MarkReady},
+			wantMoved: []bool{true, false},
+			want:      Ready,
+			wantReady: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := New()
+			for i, step := range tt.steps {
+				if moved := step(s); moved != tt.wantMoved[i] {
+					t.Errorf("step %d moved = %v, want %v", i, moved, tt.wantMoved[i])
+				}
+			}
+			snap := s.Snapshot()
+			if snap.Phase != tt.want || s.Phase() != tt.want {
+				t.Errorf("phase %s, want %s", snap.Phase, tt.want)
+			}
+			if (snap.StartupTime > 0) != tt.wantReady {
+				t.Errorf("StartupTime = %s, want set %v", snap.StartupTime, tt.wantReady)
+			}
+			if snap.Since.Before(snap.Started) {
+				t.Errorf("Since %s is before Started %s", snap.Since, snap.Started)
+			}
+		})
+	}
+}
+
+func TestPhaseString(t *testing.T) {
+	for phase, want := range map[Phase]string{Starting: "starting", Ready: "ready", Draining: "draining", Phase(7): "unknown"} {
+		if got := phase.String(); got != want {
+			t.Errorf("Phase(%d).String() = %q, want %q", int(phase), got, want)
+		}
+	}
+}
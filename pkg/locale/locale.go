@@ -0,0 +1,175 @@
+// Package locale negotiates a response locale from an Accept-Language header,
+// honouring quality values and BCP 47 language range matching (RFC 4647)
+// rather than taking the first tag the client lists.
+package locale
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Range is one language range from an Accept-Language header, e.g. "fr-CA"
+// or "*", with its quality value (0-1).
+type Range struct {
+	Tag string
+	Q   float64
+}
+
+// ParseAcceptLanguage parses an Accept-Language header into ranges sorted by
+// quality, highest first; ranges with equal quality keep header order. Ranges
+// without a q parameter have quality 1. Malformed entries are skipped rather
+// than failing the whole header, as browsers send all sorts. Ranges with q=0
+// are kept at the end: they mark a language as not acceptable.
+func ParseAcceptLanguage(header string) []Range {
+	var ranges []Range
+	for _, part := range strings.Split(header, ",") {
+		tag, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		tag = strings.TrimSpace(tag)
+		if !validRange(tag) {
+			continue
+		}
+		q, ok := parseQ(params)
+		if !ok {
+			continue
+		}
+		ranges = append(ranges, Range{Tag: tag, Q: q})
+	}
+	sort.SliceStable(ranges, func(i, j int) bool { return ranges[i].Q > ranges[j].Q })
+	return ranges
+}
+
+// parseQ returns the q parameter from the text after a range's ';', or 1 if
+// there is none. It reports false for a q outside [0, 1] or that isn't a
+// number.
+func parseQ(params string) (float64, bool) {
+	if strings.TrimSpace(params) == "" {
+		return 1, true
+	}
+	for _, param := range strings.Split(params, ";") {
+		name, value, _ := strings.Cut(strings.TrimSpace(param), "=")
+		if !strings.EqualFold(strings.TrimSpace(name), "q") {
+			continue
+		}
+		q, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+		if err != nil || q < 0 || q > 1 {
+			return 0, false
+		}
+		return q, true
+	}
+	return 1, true
+}
+
+// validRange reports whether tag is "*" or alphanumeric subtags of 1-8
+// characters separated by '-', the first one alphabetic.
+func validRange(tag string) bool {
+	if tag == "*" {
+		return true
+	}
+	subtags := strings.Split(tag, "-")
+	for i, subtag := range subtags {
+		if len(subtag) == 0 || len(subtag) > 8 {
+			return false
+		}
+		for _, r := range subtag {
+			alpha := (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z')
+			digit := r >= '0' && r <= '9'
+			if !alpha && !(digit && i > 0) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// Match picks the best of the available locales for an Accept-Language header.
+// It returns the best match and the fallback chain: every acceptable available
+// locale in preference order, best first. ok is false when nothing is
+// acceptable; callers then serve their default.
+//
+// Each range, highest quality first, contributes:
+//   - lookup matches: the range itself, then the range with its last subtag
+//     removed, and so on ("zh-Hant-TW", "zh-Hant", "zh"), per RFC 4647 lookup;
+//   - then filtering matches: available locales the range is a prefix of, so
+//     "en" accepts "en-US" when there is no plain "en";
+//   - for "*", every available locale not yet in the chain, in the order given.
+//
+// Comparison is case-insensitive, and the returned locales are spelled as in
+// available. A range with q=0 excludes the locales it matches by filtering,
+// even from "*".
+func Match(header string, available []string) (best string, chain []string, ok bool) {
+	ranges := ParseAcceptLanguage(header)
+
+	excluded := make(map[string]bool)
+	for _, r := range ranges {
+		if r.Q == 0 && r.Tag != "*" {
+			for _, locale := range available {
+				if prefixOf(r.Tag, locale) {
+					excluded[strings.ToLower(locale)] = true
+				}
+			}
+		}
+	}
+
+	seen := make(map[string]bool)
+	add := func(locale string) {
+		key := strings.ToLower(locale)
+		if !seen[key] && !excluded[key] {
+			seen[key] = true
+			chain = append(chain, locale)
+		}
+	}
+
+	for _, r := range ranges {
+		if r.Q == 0 {
+			continue
+		}
+		if r.Tag == "*" {
+			for _, locale := range available {
+				add(locale)
+			}
+			continue
+		}
+		for _, candidate := range truncations(r.Tag) {
+			for _, locale := range available {
+				if strings.EqualFold(candidate, locale) {
+					add(locale)
+				}
+			}
+		}
+		for _, locale := range available {
+			if prefixOf(r.Tag, locale) {
+				add(locale)
+			}
+		}
+	}
+
+	if len(chain) == 0 {
+		return "", nil, false
+	}
+	return chain[0], chain, true
+}
+
+// truncations returns tag followed by each shorter prefix RFC 4647 lookup
+// falls back to. A single-character subtag (an extension or private use
+// marker) left at the end is removed along with the subtag after it.
+func truncations(tag string) []string {
+	subtags := strings.Split(tag, "-")
+	var out []string
+	for n := len(subtags); n > 0; n-- {
+		if n < len(subtags) && len(subtags[n-1]) == 1 {
+			continue
+		}
+		out = append(out, strings.Join(subtags[:n], "-"))
+	}
+	return out
+}
+
+// prefixOf reports whether locale equals tag or starts with tag followed by a
+// subtag, ignoring case: "en" is a prefix of "en-US" but not of "eng".
+func prefixOf(tag, locale string) bool {
+	if len(locale) < len(tag) || !strings.EqualFold(locale[:len(tag)], tag) {
+		return false
+	}
+	return len(locale) == len(tag) || locale[len(tag)] == '-'
+}
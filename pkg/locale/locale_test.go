@@ -0,0 +1,100 @@
+package locale
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseAcceptLanguage(t *testing.T) {
+	tests := []struct {
+		name   string
+		header string
+		want   []Range
+	}{
+		{name: "empty", header: "", want: nil},
+		{name: "single", header: "fr", want: []Range{{"fr", 1}}},
+		{
+			name:   "sorted by quality",
+			header: "en;q=0.8, fr;q=0.9, de",
+			want:   []Range{{"de", 1}, {"fr", 0.9}, {"en", 0.8}},
+		},
+		{
+			name:   "equal quality keeps header order",
+			header: "es;q=0.5, it;q=0.5, pt;q=0.5",
+			want:   []Range{{"es", 0.5}, {"it", 0.5}, {"pt", 0.5}},
+		},
+		{
+			name:   "wildcard and zero quality",
+			header: "*;q=0.1, de;q=0, en-GB",
+			want:   []Range{{"en-GB", 1}, {"*", 0.1}, {"de", 0}},
+		},
+		{
+			name:   "whitespace and other parameters",
+			header: " fr-CA ; level=1 ; Q=0.7 ,en",
+			want:   []Range{{"en", 1}, {"fr-CA", 0.7}},
+		},
+		{
+			name:   "malformed entries skipped",
+			header: "en;q=2, fr;q=abc, 1en, de-toolongsubtag, , it--x, nl;q=0.3",
+			want:   []Range{{"nl", 0.3}},
+		},
+		{name: "digits after the first subtag", header: "es-419", want: []Range{{"es-419", 1}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ParseAcceptLanguage(tt.header); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseAcceptLanguage(%q) = %v, want %v", tt.header, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMatch(t *testing.T) {
+	available := []string{"en-US", "en-GB", "fr", "fr-CA", "zh-Hant", "de-DE"}
+	tests := []struct {
+		name      string
+		header    string
+		available []string
+		wantChain []string // nil when nothing is acceptable
+	}{
+		{name: "exact", header: "fr-CA", wantChain: []string{"fr-CA", "fr"}},
+		{name: "q-values beat header order", header: "en-GB;q=0.5, fr;q=0.9", wantChain: []string{"fr", "fr-CA", "en-GB"}},
+		{name: "lookup truncates the range", header: "zh-Hant-TW", wantChain: []string{"zh-Hant"}},
+		{name: "filtering finds regional locales", header: "en", wantChain: []string{"en-US", "en-GB"}},
+		{name: "case insensitive", header: "FR-ca", wantChain: []string{"fr-CA", "fr"}},
+		{name: "not a subtag prefix", header: "eng, d", wantChain: nil},
+		{name: "extension marker dropped with its subtag", header: "de-DE-x-foo", wantChain: []string{"de-DE"}},
+		{
+			name:      "wildcard adds the rest in available order",
+			header:    "fr-CA, *;q=0.1",
+			wantChain: []string{"fr-CA", "fr", "en-US", "en-GB", "zh-Hant", "de-DE"},
+		},
+		{
+			name:      "zero quality excludes from the wildcard",
+			header:    "*, en;q=0, fr-CA;q=0",
+			wantChain: []string{"fr", "zh-Hant", "de-DE"},
+		},
+		{name: "zero quality excludes outright", header: "fr;q=0", wantChain: nil},
+		{name: "nothing acceptable", header: "ja, ko;q=0.5", wantChain: nil},
+		{name: "empty header", header: "", wantChain: nil},
+		{name: "nothing available", header: "*", available: []string{}, wantChain: nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			locales := available
+			if tt.available != nil {
+				locales = tt.available
+			}
+			best, chain, ok := Match(tt.header, locales)
+			if !reflect.DeepEqual(chain, tt.wantChain) {
+				t.Errorf("Match(%q) chain = %v, want %v", tt.header, chain, tt.wantChain)
+			}
+			if wantOK := tt.wantChain != nil; ok != wantOK {
+				t.Fatalf("Match(%q) ok = %v, want %v", tt.header, ok, wantOK)
+			}
+			if ok && best != tt.wantChain[0] {
+				t.Errorf("Match(%q) best = %q, want %q", tt.header, best, tt.wantChain[0])
+			}
+		})
+	}
+}
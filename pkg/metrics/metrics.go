@@ -0,0 +1,340 @@
+// Package metrics tracks server-side load: open TCP connections, in-flight
+// requests and handler timings. These give direct evidence of connection
+// hogging, rather than inferring it from client-side latency. It also counts
+// the bytes served per variant, so bandwidth costs can be allocated to them.
+package metrics
+
+import (
+	"fmt"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// HeaderServerLoad reports the server's load at the time a response was
+// produced, e.g. "connections=12; inflight=3".
+const HeaderServerLoad = "X-Server-Load"
+
+// ProjectionPeriod is the month bandwidth projections extrapolate to.
+const ProjectionPeriod = 30 * 24 * time.Hour
+
+// Collector holds the server's load counters. All methods are safe for
+// concurrent use.
+type Collector struct {
+	openConnections  atomic.Int64
+	totalConnections atomic.Int64
+	inFlight         atomic.Int64
+	totalRequests    atomic.Int64
+	decision         timer
+	processing       timer
+	rejections       sync.Map     // reason code -> *atomic.Int64
+	bytesServed      sync.Map     // variant -> *byteCounter
+	windowStart      atomic.Int64 // unix nanoseconds of the start or last reset
+}
+
+// Snapshot is a point-in-time copy of the collector's counters, served as JSON
+// from /metrics.
+type Snapshot struct {
+	Connections ConnectionStats `json:"connections"`
+	Requests    RequestStats    `json:"requests"`
+	Latency     LatencyStats    `json:"latency"`
+	// Rejections counts requests turned away with a 4xx or 5xx, by reason
+	// code (e.g. "bad_json", "overloaded")
+	Rejections map[string]int64 `json:"rejections"`
+	// BytesServed counts /experiment response bodies by the variant served,
+	// over the last WindowSeconds: since the start or the last reset
+	BytesServed   map[string]VariantBytes `json:"bytesServed"`
+	WindowSeconds float64                 `json:"windowSeconds"`
+}
+
+// VariantBytes counts the response bodies served with one variant. Bytes is
+// what was sent, compressed when a Content-Encoding applied; RawBytes is the
+// body before compression. ProjectedMonthlyBytes extrapolates Bytes over
+// ProjectionPeriod at the window's rate.
+type VariantBytes struct {
+	Responses             int64 `json:"responses"`
+	Bytes                 int64 `json:"bytes"`
+	RawBytes              int64 `json:"rawBytes"`
+	ProjectedMonthlyBytes int64 `json:"projectedMonthlyBytes"`
+}
+
+// ConnectionStats counts TCP connections accepted by the server.
+type ConnectionStats struct {
+	Open  int64 `json:"open"`
+	Total int64 `json:"total"`
+}
+
+// RequestStats counts HTTP requests handled by the server.
+type RequestStats struct {
+	InFlight int64 `json:"inFlight"`
+	Total    int64 `json:"total"`
+}
+
+// LatencyStats summarizes /experiment handler timings. Decision is the time
+// from request receipt to choosing a payload; Processing is the whole handler
+// including encoding the response. The difference is the cost of building the
+// body, which grows with payload size rather than allocation logic.
+type LatencyStats struct {
+	Decision   TimingStats `json:"decision"`
+	Processing TimingStats `json:"processing"`
+}
+
+// TimingStats summarizes observed durations in milliseconds.
+type TimingStats struct {
+	Count  int64   `json:"count"`
+	MeanMs float64 `json:"meanMs"`
+	MaxMs  float64 `json:"maxMs"`
+}
+
+// NewCollector creates a collector with all counters at zero.
+func NewCollector() *Collector {
+	m := &Collector{}
+	m.windowStart.Store(time.Now().UnixNano())
+	return m
+}
+
+// Snapshot returns the current counter values.
+func (m *Collector) Snapshot() Snapshot {
+	window := time.Duration(time.Now().UnixNano() - m.windowStart.Load())
+	return Snapshot{
+		Connections: ConnectionStats{
+			Open:  m.openConnections.Load(),
+			Total: m.totalConnections.Load(),
+		},
+		Requests: RequestStats{
+			InFlight: m.inFlight.Load(),
+			Total:    m.totalRequests.Load(),
+		},
+		Latency: LatencyStats{
+			Decision:   m.decision.snapshot(),
+			Processing: m.processing.snapshot(),
+		},
+		Rejections:    m.rejectionCounts(false),
+		BytesServed:   m.bytesServedCounts(window, false),
+		WindowSeconds: window.Seconds(),
+	}
+}
+
+// SnapshotAndReset returns the current values like Snapshot and zeroes the
+// cumulative counters (total connections, total requests, timings and
+// rejections) in the same step, using an atomic swap per counter so no
+// increment that races with the reset is lost: it lands either in this
+// snapshot or in the next one.
+// Gauges (open connections, in-flight requests) describe the present and are
+// not reset. A timer's count, sum and max are swapped one after another, so
+// one observation racing the reset can split across snapshots, as can a
+// variant's byte counters.
+func (m *Collector) SnapshotAndReset() Snapshot {
+	now := time.Now().UnixNano()
+	window := time.Duration(now - m.windowStart.Swap(now))
+	return Snapshot{
+		Connections: ConnectionStats{
+			Open:  m.openConnections.Load(),
+			Total: m.totalConnections.Swap(0),
+		},
+		Requests: RequestStats{
+			InFlight: m.inFlight.Load(),
+			Total:    m.totalRequests.Swap(0),
+		},
+		Latency: LatencyStats{
+			Decision:   m.decision.swap(),
+			Processing: m.processing.swap(),
+		},
+		Rejections:    m.rejectionCounts(true),
+		BytesServed:   m.bytesServedCounts(window, true),
+		WindowSeconds: window.Seconds(),
+	}
+}
+
+// ObserveDecision records how long a request took to reach its allocation
+// decision.
+func (m *Collector) ObserveDecision(d time.Duration) {
+	m.decision.observe(d)
+}
+
+// ObserveProcessing records the total handler time of a request.
+func (m *Collector) ObserveProcessing(d time.Duration) {
+	m.processing.observe(d)
+}
+
+// ObserveRejection counts a request rejected for the given reason code.
+func (m *Collector) ObserveRejection(reason string) {
+	counter, ok := m.rejections.Load(reason)
+	if !ok {
+		counter, _ = m.rejections.LoadOrStore(reason, new(atomic.Int64))
+	}
+	counter.(*atomic.Int64).Add(1)
+}
+
+// ObserveBytesServed counts one response body served with variant: sent bytes
+// as written, and raw bytes before any compression. They are equal when the
+// body was sent uncompressed.
+func (m *Collector) ObserveBytesServed(variant string, sent, raw int64) {
+	counter, ok := m.bytesServed.Load(variant)
+	if !ok {
+		counter, _ = m.bytesServed.LoadOrStore(variant, new(byteCounter))
+	}
+	c := counter.(*byteCounter)
+	c.responses.Add(1)
+	c.sent.Add(sent)
+	c.raw.Add(raw)
+}
+
+// ProjectMonthly extrapolates bytes served over window to ProjectionPeriod.
+func ProjectMonthly(bytes int64, window time.Duration) int64 {
+	if window <= 0 {
+		return 0
+	}
+	return int64(float64(bytes) * float64(ProjectionPeriod) / float64(window))
+}
+
+// bytesServedCounts copies the byte counters with their projections over
+// window, zeroing each one when reset is true. Variants seen before stay in
+// the map at zero after a reset.
+func (m *Collector) bytesServedCounts(window time.Duration, reset bool) map[string]VariantBytes {
+	counts := make(map[string]VariantBytes)
+	m.bytesServed.Range(func(key, value any) bool {
+		c := value.(*byteCounter)
+		var v VariantBytes
+		if reset {
+			v = VariantBytes{Responses: c.responses.Swap(0), Bytes: c.sent.Swap(0), RawBytes: c.raw.Swap(0)}
+		} else {
+			v = VariantBytes{Responses: c.responses.Load(), Bytes: c.sent.Load(), RawBytes: c.raw.Load()}
+		}
+		v.ProjectedMonthlyBytes = ProjectMonthly(v.Bytes, window)
+		counts[key.(string)] = v
+		return true
+	})
+	return counts
+}
+
+// rejectionCounts copies the rejection counters, zeroing each one when reset is
+// true. Reasons seen before stay in the map at zero after a reset.
+func (m *Collector) rejectionCounts(reset bool) map[string]int64 {
+	counts := make(map[string]int64)
+	m.rejections.Range(func(key, value any) bool {
+		counter := value.(*atomic.Int64)
+		if reset {
+			counts[key.(string)] = counter.Swap(0)
+		} else {
+			counts[key.(string)] = counter.Load()
+		}
+		return true
+	})
+	return counts
+}
+
+// InFlight returns the current number of requests being handled.
+func (m *Collector) InFlight() int64 {
+	return m.inFlight.Load()
+}
+
+// OpenConnections returns the current number of open TCP connections.
+func (m *Collector) OpenConnections() int64 {
+	return m.openConnections.Load()
+}
+
+// Listener wraps ln so every accepted connection is counted as open until it
+// is closed. Pass the result to app.Listener.
+func (m *Collector) Listener(ln net.Listener) net.Listener {
+	return &countingListener{Listener: ln, metrics: m}
+}
+
+// Middleware counts requests in flight for the rest of the handler chain. When
+// loadHeader is true it also reports the load in the X-Server-Load header.
+func (m *Collector) Middleware(loadHeader bool) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		m.totalRequests.Add(1)
+		inFlight := m.inFlight.Add(1)
+		defer m.inFlight.Add(-1)
+
+		if loadHeader {
+			c.Set(HeaderServerLoad, fmt.Sprintf("connections=%d; inflight=%d", m.openConnections.Load(), inFlight))
+		}
+		return c.Next()
+	}
+}
+
+// byteCounter accumulates the bodies served with one variant.
+type byteCounter struct {
+	responses atomic.Int64
+	sent      atomic.Int64
+	raw       atomic.Int64
+}
+
+// timer accumulates a count, sum and maximum of durations without locking.
+type timer struct {
+	count atomic.Int64
+	total atomic.Int64 // nanoseconds
+	max   atomic.Int64 // nanoseconds
+}
+
+func (t *timer) observe(d time.Duration) {
+	t.count.Add(1)
+	t.total.Add(int64(d))
+	for {
+		current := t.max.Load()
+		if int64(d) <= current || t.max.CompareAndSwap(current, int64(d)) {
+			return
+		}
+	}
+}
+
+func (t *timer) snapshot() TimingStats {
+	count := t.count.Load()
+	stats := TimingStats{
+		Count: count,
+		MaxMs: float64(t.max.Load()) / float64(time.Millisecond),
+	}
+	if count > 0 {
+		stats.MeanMs = float64(t.total.Load()) / float64(count) / float64(time.Millisecond)
+	}
+	return stats
+}
+
+// swap returns the timer's stats and zeroes it.
+func (t *timer) swap() TimingStats {
+	count := t.count.Swap(0)
+	total := t.total.Swap(0)
+	stats := TimingStats{
+		Count: count,
+		MaxMs: float64(t.max.Swap(0)) / float64(time.Millisecond),
+	}
+	if count > 0 {
+		stats.MeanMs = float64(total) / float64(count) / float64(time.Millisecond)
+	}
+	return stats
+}
+
+type countingListener struct {
+	net.Listener
+	metrics *Collector
+}
+
+func (l *countingListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	l.metrics.openConnections.Add(1)
+	l.metrics.totalConnections.Add(1)
+	return &countingConn{Conn: conn, metrics: l.metrics}, nil
+}
+
+type countingConn struct {
+	net.Conn
+	metrics   *Collector
+	closeOnce sync.Once
+}
+
+// Close decrements the open count exactly once, even if the server closes a
+// connection more than once.
+func (c *countingConn) Close() error {
+	c.closeOnce.Do(func() {
+		c.metrics.openConnections.Add(-1)
+	})
+	return c.Conn.Close()
+}
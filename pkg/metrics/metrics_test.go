@@ -0,0 +1,109 @@
+package metrics
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+// TestSnapshotAndResetLosesNothing increments every cumulative counter from
+// many goroutines while another resets them over and over, and checks that
+// the snapshots add up to every increment. Run it with -race.
+func TestSnapshotAndResetLosesNothing(t *testing.T) {
+	const writers, perWriter = 8, 5000
+	m := NewCollector()
+
+	var totals Snapshot
+	totals.Rejections = map[string]int64{}
+	totals.BytesServed = map[string]VariantBytes{}
+	add := func(s Snapshot) {
+		totals.Requests.Total += s.Requests.Total
+		totals.Latency.Decision.Count += s.Latency.Decision.Count
+		for reason, n := range s.Rejections {
+			totals.Rejections[reason] += n
+		}
+		for variant, b := range s.BytesServed {
+			sum := totals.BytesServed[variant]
+			sum.Responses += b.Responses
+			sum.Bytes += b.Bytes
+			sum.RawBytes += b.RawBytes
+			totals.BytesServed[variant] = sum
+		}
+	}
+
+	var wg sync.WaitGroup
+	for w := 0; w < writers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < perWriter; i++ {
+				m.totalRequests.Add(1)
+				m.ObserveDecision(time.Millisecond)
+				m.ObserveRejection("bad_json")
+				m.ObserveBytesServed("a.json", 10, 30)
+			}
+		}()
+	}
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	resets := 0
+	for running := true; running; resets++ {
+		select {
+		case <-done:
+			running = false
+		default:
+		}
+		add(m.SnapshotAndReset())
+	}
+
+	const want = writers * perWriter
+	tests := []struct {
+		name string
+		got  int64
+		want int64
+	}{
+		{"requests", totals.Requests.Total, want},
+		{"decision timings", totals.Latency.Decision.Count, want},
+		{"rejections", totals.Rejections["bad_json"], want},
+		{"responses", totals.BytesServed["a.json"].Responses, want},
+		{"bytes", totals.BytesServed["a.json"].Bytes, want * 10},
+		{"raw bytes", totals.BytesServed["a.json"].RawBytes, want * 30},
+	}
+	for _, tt := range tests {
+		if tt.got != tt.want {
+			t.Errorf("%s: snapshots over %d resets add up to %d, want %d", tt.name, resets, tt.got, tt.want)
+		}
+	}
+}
+
+func TestSnapshotAndResetZeroes(t *testing.T) {
+	m := NewCollector()
+	m.totalRequests.Add(3)
+	m.inFlight.Add(2)
+	m.ObserveProcessing(4 * time.Millisecond)
+	m.ObserveRejection("overloaded")
+	m.ObserveBytesServed("b.json", 100, 100)
+
+	first := m.SnapshotAndReset()
+	if first.Requests.Total != 3 || first.Latency.Processing.Count != 1 || first.Latency.Processing.MaxMs != 4 ||
+		first.Rejections["overloaded"] != 1 || first.BytesServed["b.json"].Bytes != 100 {
+		t.Fatalf("first snapshot = %+v, want the counts observed", first)
+	}
+
+	second := m.Snapshot()
+	if second.Requests.Total != 0 || second.Latency.Processing.Count != 0 || second.Latency.Processing.MaxMs != 0 {
+		t.Errorf("after reset: requests %d, timings %+v, want zero", second.Requests.Total, second.Latency.Processing)
+	}
+	if n, ok := second.Rejections["overloaded"]; !ok || n != 0 {
+		t.Errorf("after reset: rejections[overloaded] = %d (present %v), want 0 and present", n, ok)
+	}
+	if b, ok := second.BytesServed["b.json"]; !ok || b.Bytes != 0 || b.Responses != 0 {
+		t.Errorf("after reset: bytesServed[b.json] = %+v (present %v), want zero and present", b, ok)
+	}
+	if second.Requests.InFlight != 2 {
+		t.Errorf("after reset: in flight = %d, want 2: gauges aren't reset", second.Requests.InFlight)
+	}
+}
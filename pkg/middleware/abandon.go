@@ -0,0 +1,153 @@
+package middleware
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"sync/atomic"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// abandonLogPerSecond caps the abandoned response lines written per second, so
+// a wave of clients hanging up doesn't flood the logs; the lines dropped are
+// counted in the next one written.
+const abandonLogPerSecond = 10
+
+// AbandonDetector notices responses the client went away from before they
+// were fully written: the client closed the connection mid-body, or stopped
+// reading until the server's write timeout gave up on it. fasthttp writes the
+// response after the handler has returned and doesn't report write errors to
+// it, so they are caught on the connection, which sees every response path:
+// buffered bodies, JSON Lines streams and throttled bodies alike. Each
+// abandoned response is counted and logged with the request it belonged to.
+//
+// A client that hangs up after the kernel has accepted the whole response
+// can't be seen, so responses that fit in the socket buffers are never
+// reported abandoned. HTTP/2 (h2c) streams aren't tracked.
+type AbandonDetector struct {
+	abandoned atomic.Int64
+	limiter   *logLimiter
+}
+
+// NewAbandonDetector creates a detector with its count at zero.
+func NewAbandonDetector() *AbandonDetector {
+	return &AbandonDetector{limiter: &logLimiter{perSecond: abandonLogPerSecond}}
+}
+
+// Abandoned returns the number of responses abandoned so far.
+func (d *AbandonDetector) Abandoned() int64 {
+	return d.abandoned.Load()
+}
+
+// Listener wraps ln so write errors on its connections can be tied to the
+// response being written. It must be the outermost listener wrapper, since
+// Handler finds the connection through the request context. Pass the result
+// to app.Listener.
+func (d *AbandonDetector) Listener(ln net.Listener) net.Listener {
+	return &abandonListener{Listener: ln, detector: d}
+}
+
+// Handler returns the middleware that records each response's request on its
+// connection once the handler chain has built it, so a failed write can be
+// reported with the request's details.
+func (d *AbandonDetector) Handler() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		conn, ok := c.Context().Conn().(*abandonConn)
+		if !ok {
+			return c.Next()
+		}
+		// Until this response is built, a failed write belongs to no request
+		// the handlers saw
+		conn.response = nil
+		err := c.Next()
+
+		// fasthttp sets Content-Length for buffered bodies only when it
+		// writes them
+		resp := c.Response()
+		contentLength := resp.Header.ContentLength()
+		if !resp.IsBodyStream() {
+			contentLength = len(resp.Body())
+		}
+		requestID, _ := c.Locals("requestid").(string)
+		conn.response = &pendingResponse{
+			status:        resp.StatusCode(),
+			contentLength: contentLength,
+			method:        c.Method(),
+			path:          c.Path(),
+			ip:            c.IP(),
+			requestID:     requestID,
+		}
+		return err
+	}
+}
+
+// pendingResponse is the response a connection is writing, as the handler
+// chain left it.
+type pendingResponse struct {
+	status        int
+	contentLength int // -1 for streamed bodies of unknown length
+	method        string
+	path          string
+	ip            string
+	requestID     string
+	written       int64 // bytes written so far, headers included
+}
+
+// abandon counts and logs a response whose write failed with err.
+func (d *AbandonDetector) abandon(r *pendingResponse, err error) {
+	d.abandoned.Add(1)
+	suppressed, ok := d.limiter.allow()
+	if !ok {
+		return
+	}
+
+	cause := "client_closed"
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		cause = "write_timeout"
+	}
+	line := fmt.Sprintf("abandoned_response cause=%s written_bytes=%d content_length=%d status=%d method=%s path=%s ip=%s request_id=%s",
+		cause, r.written, r.contentLength, r.status, r.method, r.path, r.ip, r.requestID)
+	if suppressed > 0 {
+		line += fmt.Sprintf(" suppressed=%d", suppressed)
+	}
+	log.Print(line)
+}
+
+type abandonListener struct {
+	net.Listener
+	detector *AbandonDetector
+}
+
+func (ln *abandonListener) Accept() (net.Conn, error) {
+	conn, err := ln.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return &abandonConn{Conn: conn, detector: ln.detector}, nil
+}
+
+// abandonConn reports the first failed write of each response. fasthttp runs
+// a connection's handlers and writes its responses on one goroutine, so
+// response needs no locking.
+type abandonConn struct {
+	net.Conn
+	detector *AbandonDetector
+	response *pendingResponse
+}
+
+func (c *abandonConn) Write(p []byte) (int, error) {
+	n, err := c.Conn.Write(p)
+	if r := c.response; r != nil {
+		r.written += int64(n)
+		if err != nil {
+			// fasthttp closes the connection after a failed write; report
+			// the response once
+			c.response = nil
+			c.detector.abandon(r, err)
+		}
+	}
+	return n, err
+}
@@ -0,0 +1,136 @@
+package middleware
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// lockedBuffer collects log output written while the test reads it.
+type lockedBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *lockedBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *lockedBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func (b *lockedBuffer) Reset() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.buf.Reset()
+}
+
+func TestAbandonDetector(t *testing.T) {
+	var logged lockedBuffer
+	log.SetOutput(&logged)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+
+	// Bodies much larger than loopback socket buffers, so the server is still
+	// writing when the client stops
+	const large = 32 << 20
+	tests := []struct {
+		name          string
+		size          int
+		stream        bool
+		read          int  // body bytes the client reads before stopping; -1 reads it all
+		hold          bool // the client stops reading but keeps the connection open
+		wantAbandoned int64
+		wantCause     string // logged for an abandoned response
+	}{
+		{name: "read in full", size: large, read: -1},
+		{name: "small body, closed unread", size: 64, read: 0},
+		{name: "closed mid-body", size: large, read: 4096, wantAbandoned: 1, wantCause: "client_closed"},
+		{name: "closed mid-stream", size: large, stream: true, read: 4096, wantAbandoned: 1, wantCause: "client_closed"},
+		{name: "stopped reading", size: large, read: 4096, hold: true, wantAbandoned: 1, wantCause: "write_timeout"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logged.Reset()
+			d := NewAbandonDetector()
+			app := fiber.New(fiber.Config{DisableStartupMessage: true, WriteTimeout: time.Second})
+			app.Use(d.Handler())
+			app.Get("/", func(c *fiber.Ctx) error {
+				if tt.stream {
+					c.Context().SetBodyStream(strings.NewReader(strings.Repeat("x", tt.size)), -1)
+					return nil
+				}
+				return c.SendString(strings.Repeat("x", tt.size))
+			})
+			ln, err := net.Listen("tcp", "127.0.0.1:0")
+			if err != nil {
+				t.Fatal(err)
+			}
+			go app.Listener(d.Listener(ln))
+			t.Cleanup(func() { app.Shutdown() })
+
+			conn, err := net.Dial("tcp", ln.Addr().String())
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer conn.Close()
+			fmt.Fprintf(conn, "GET / HTTP/1.1\r\nHost: test\r\n\r\n")
+			resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.read < 0 {
+				if n, err := io.Copy(io.Discard, resp.Body); err != nil || n != int64(tt.size) {
+					t.Fatalf("read %d bytes, %v, want %d", n, err, tt.size)
+				}
+			} else if _, err := io.CopyN(io.Discard, resp.Body, int64(tt.read)); err != nil {
+				t.Fatal(err)
+			}
+			if !tt.hold {
+				// Reset rather than close gracefully, so the server's next
+				// write fails at once
+				conn.(*net.TCPConn).SetLinger(0)
+				conn.Close()
+			}
+
+			// The failed write happens after the handler returned, so wait
+			// for it to be counted and logged; a response that isn't abandoned is given a moment to
+			// be miscounted
+			deadline := time.Now().Add(5 * time.Second)
+			if tt.wantAbandoned == 0 {
+				deadline = time.Now().Add(100 * time.Millisecond)
+			}
+			for (d.Abandoned() < tt.wantAbandoned || logged.String() == "" && tt.wantCause != "") && time.Now().Before(deadline) {
+				time.Sleep(10 * time.Millisecond)
+			}
+			if tt.wantAbandoned == 0 {
+				time.Sleep(time.Until(deadline))
+			}
+			if got := d.Abandoned(); got != tt.wantAbandoned {
+				t.Errorf("Abandoned() = %d, want %d", got, tt.wantAbandoned)
+			}
+			line := logged.String()
+			if tt.wantCause == "" && line != "" {
+				t.Errorf("logged %q for a response that wasn't abandoned", line)
+			}
+			if tt.wantCause != "" && !strings.Contains(line, "abandoned_response cause="+tt.wantCause+" ") {
+				t.Errorf("logged %q, want an abandoned_response line with cause=%s", line, tt.wantCause)
+			}
+		})
+	}
+}
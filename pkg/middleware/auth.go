@@ -0,0 +1,48 @@
+package middleware
+
+import (
+	"crypto/subtle"
+	"encoding/base64"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// BearerAuth returns a handler that rejects requests whose Authorization header
+// does not carry the expected bearer token. The comparison is constant-time so
+// the token can't be recovered by timing responses.
+func BearerAuth(token string) fiber.Handler {
+	expected := []byte("Bearer " + token)
+
+	return func(c *fiber.Ctx) error {
+		provided := []byte(c.Get(fiber.HeaderAuthorization))
+		if subtle.ConstantTimeCompare(provided, expected) != 1 {
+			c.Set(fiber.HeaderWWWAuthenticate, "Bearer")
+			return Reject(c, fiber.StatusUnauthorized, ReasonUnauthorized, "Missing or invalid bearer token")
+		}
+		return c.Next()
+	}
+}
+
+// BasicAuth returns a handler that rejects requests whose HTTP Basic
+// credentials don't carry password, so a browser can prompt for the same
+// secret BearerAuth checks. Any username is accepted. The comparison is
+// constant-time, as in BearerAuth.
+func BasicAuth(realm, password string) fiber.Handler {
+	expected := []byte(password)
+
+	return func(c *fiber.Ctx) error {
+		var provided []byte
+		if header := c.Get(fiber.HeaderAuthorization); strings.HasPrefix(header, "Basic ") {
+			decoded, err := base64.StdEncoding.DecodeString(header[len("Basic "):])
+			if _, pass, ok := strings.Cut(string(decoded), ":"); err == nil && ok {
+				provided = []byte(pass)
+			}
+		}
+		if subtle.ConstantTimeCompare(provided, expected) != 1 {
+			c.Set(fiber.HeaderWWWAuthenticate, `Basic realm="`+realm+`", charset="UTF-8"`)
+			return Reject(c, fiber.StatusUnauthorized, ReasonUnauthorized, "Missing or invalid credentials")
+		}
+		return c.Next()
+	}
+}
@@ -0,0 +1,97 @@
+package middleware
+
+import (
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// authApp serves /experiment behind auth and /health without it, the way the
+// server wires BearerAuth.
+func authApp(auth fiber.Handler) *fiber.App {
+	app := fiber.New()
+	ok := func(c *fiber.Ctx) error { return c.SendString("ok") }
+	app.Get("/health", ok)
+	app.Post("/experiment", auth, ok)
+	return app
+}
+
+func TestBearerAuth(t *testing.T) {
+	app := authApp(BearerAuth("s3cret"))
+	tests := []struct {
+		name          string
+		path          string
+		authorization string
+		want          int
+	}{
+		{name: "missing token", path: "/experiment", want: http.StatusUnauthorized},
+		{name: "wrong token", path: "/experiment", authorization: "Bearer wrong", want: http.StatusUnauthorized},
+		{name: "token prefix", path: "/experiment", authorization: "Bearer s3cre", want: http.StatusUnauthorized},
+		{name: "wrong scheme", path: "/experiment", authorization: "Basic s3cret", want: http.StatusUnauthorized},
+		{name: "lowercase scheme", path: "/experiment", authorization: "bearer s3cret", want: http.StatusUnauthorized},
+		{name: "correct token", path: "/experiment", authorization: "Bearer s3cret", want: http.StatusOK},
+		{name: "health stays open", path: "/health", want: http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			method := http.MethodPost
+			if tt.path == "/health" {
+				method = http.MethodGet
+			}
+			req := httptest.NewRequest(method, tt.path, nil)
+			if tt.authorization != "" {
+				req.Header.Set(fiber.HeaderAuthorization, tt.authorization)
+			}
+			resp, err := app.Test(req, -1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+			if resp.StatusCode != tt.want {
+				t.Fatalf("status %d, want %d", resp.StatusCode, tt.want)
+			}
+			if tt.want == http.StatusUnauthorized && resp.Header.Get(fiber.HeaderWWWAuthenticate) != "Bearer" {
+				t.Errorf("WWW-Authenticate = %q, want Bearer", resp.Header.Get(fiber.HeaderWWWAuthenticate))
+			}
+		})
+	}
+}
+
+func TestBasicAuth(t *testing.T) {
+	app := authApp(BasicAuth("admin", "s3cret"))
+	basic := func(userPass string) string {
+		return "Basic " + base64.StdEncoding.EncodeToString([]byte(userPass))
+	}
+	tests := []struct {
+		name          string
+		authorization string
+		want          int
+	}{
+		{name: "missing credentials", want: http.StatusUnauthorized},
+		{name: "wrong password", authorization: basic("admin:wrong"), want: http.StatusUnauthorized},
+		{name: "no colon", authorization: basic("s3cret"), want: http.StatusUnauthorized},
+		{name: "not base64", authorization: "Basic !!!", want: http.StatusUnauthorized},
+		{name: "bearer token", authorization: "Bearer s3cret", want: http.StatusUnauthorized},
+		{name: "correct password", authorization: basic("admin:s3cret"), want: http.StatusOK},
+		{name: "any username", authorization: basic("someone:s3cret"), want: http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/experiment", nil)
+			if tt.authorization != "" {
+				req.Header.Set(fiber.HeaderAuthorization, tt.authorization)
+			}
+			resp, err := app.Test(req, -1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+			if resp.StatusCode != tt.want {
+				t.Fatalf("status %d, want %d", resp.StatusCode, tt.want)
+			}
+		})
+	}
+}
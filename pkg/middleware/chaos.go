@@ -0,0 +1,94 @@
+package middleware
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"math/rand"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// HeaderChaosDelay reports the latency injected into a response by ChaosDelay,
+// so a client investigating a slow response can tell it was deliberate.
+const HeaderChaosDelay = "X-Chaos-Delay"
+
+// DelayRange is an inclusive range of injected delays.
+type DelayRange struct {
+	Min time.Duration
+	Max time.Duration
+}
+
+// ParseDelayRange parses a fixed delay ("500ms") or a range ("100ms-2s").
+func ParseDelayRange(spec string) (DelayRange, error) {
+	lo, hi, isRange := strings.Cut(spec, "-")
+	minD, err := time.ParseDuration(lo)
+	if err != nil || minD < 0 {
+		return DelayRange{}, fmt.Errorf("invalid delay %q: expected <duration> or <min>-<max>", spec)
+	}
+	if !isRange {
+		return DelayRange{Min: minD, Max: minD}, nil
+	}
+	maxD, err := time.ParseDuration(hi)
+	if err != nil || maxD < minD {
+		return DelayRange{}, fmt.Errorf("invalid delay %q: expected <min>-<max> with min <= max", spec)
+	}
+	return DelayRange{Min: minD, Max: maxD}, nil
+}
+
+func (r DelayRange) String() string {
+	if r.Min == r.Max {
+		return r.Min.String()
+	}
+	return r.Min.String() + "-" + r.Max.String()
+}
+
+// ChaosDelay returns a handler that holds a fraction of requests for a random
+// delay drawn uniformly from r before continuing. It exists to test how
+// clients handle a slow server and must never be enabled in production.
+func ChaosDelay(r DelayRange, fraction float64) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if rand.Float64() >= fraction {
+			return c.Next()
+		}
+
+		delay := r.Min
+		if r.Max > r.Min {
+			delay += time.Duration(rand.Int63n(int64(r.Max - r.Min + 1)))
+		}
+		time.Sleep(delay)
+		c.Set(HeaderChaosDelay, delay.Round(time.Millisecond).String())
+		return c.Next()
+	}
+}
+
+// ErrorInjection returns a handler that fails a fraction of requests with 500
+// to exercise clients' error handling. With deterministic set, exactly every
+// 1/rate-th request fails (by a shared counter), so runs are repeatable;
+// otherwise each request fails with probability rate. Every injected error is
+// logged. It must never be enabled in production.
+func ErrorInjection(rate float64, deterministic bool) fiber.Handler {
+	var counter atomic.Int64
+
+	return func(c *fiber.Ctx) error {
+		inject := false
+		if deterministic {
+			n := float64(counter.Add(1))
+			inject = math.Floor(n*rate) > math.Floor((n-1)*rate)
+		} else {
+			inject = rand.Float64() < rate
+		}
+		if !inject {
+			return c.Next()
+		}
+
+		requestID, _ := c.Locals("requestid").(string)
+		log.Printf("CHAOS: injected 500 for %s %s (request %s)", c.Method(), c.Path(), requestID)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Injected failure (chaos testing)",
+		})
+	}
+}
@@ -0,0 +1,58 @@
+package middleware
+
+import (
+	"sync/atomic"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// LoadDegrader marks requests for a lighter response while the server is under
+// load, so it keeps answering within its latency budget by sending less rather
+// than by rejecting requests as LoadShedder does. It uses the same high and
+// low watermarks on in-flight requests. What a degraded response is is up to
+// the handler, which checks ShouldDegrade and calls CountDegraded when it
+// served one.
+type LoadDegrader struct {
+	load     watermarks
+	degraded atomic.Int64
+}
+
+// degradeKey is the Locals key under which a LoadDegrader marks a request.
+type degradeKey struct{}
+
+// NewLoadDegrader creates a degrader over the in-flight count reported by
+// inFlight. low must be less than high.
+func NewLoadDegrader(high, low int64, inFlight func() int64) *LoadDegrader {
+	return &LoadDegrader{load: watermarks{high: high, low: low, inFlight: inFlight}}
+}
+
+// Handler returns the middleware that marks requests arriving under load.
+func (d *LoadDegrader) Handler() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if d.load.update() {
+			c.Locals(degradeKey{}, true)
+		}
+		return c.Next()
+	}
+}
+
+// ShouldDegrade reports whether a LoadDegrader marked the request.
+func ShouldDegrade(c *fiber.Ctx) bool {
+	degrade, _ := c.Locals(degradeKey{}).(bool)
+	return degrade
+}
+
+// CountDegraded records one degraded response.
+func (d *LoadDegrader) CountDegraded() {
+	d.degraded.Add(1)
+}
+
+// Degrading reports whether new requests are currently being marked.
+func (d *LoadDegrader) Degrading() bool {
+	return d.load.over.Load()
+}
+
+// Degraded returns the number of degraded responses served so far.
+func (d *LoadDegrader) Degraded() int64 {
+	return d.degraded.Load()
+}
@@ -0,0 +1,78 @@
+package middleware
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync/atomic"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func TestLoadDegrader(t *testing.T) {
+	var inFlight atomic.Int64
+	d := NewLoadDegrader(10, 5, inFlight.Load)
+	app := fiber.New()
+	app.Get("/", d.Handler(), func(c *fiber.Ctx) error {
+		return c.SendString(strconv.FormatBool(ShouldDegrade(c)))
+	})
+	app.Get("/unmarked", func(c *fiber.Ctx) error {
+		return c.SendString(strconv.FormatBool(ShouldDegrade(c)))
+	})
+	marked := func(path string) bool {
+		t.Helper()
+		resp, err := app.Test(httptest.NewRequest(http.MethodGet, path, nil), -1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(body) == "true"
+	}
+
+	// Requests arriving at each in-flight count, in order: marking starts
+	// above the high watermark and stops only below the low one
+	tests := []struct {
+		inFlight int64
+		want     bool
+	}{
+		{inFlight: 0, want: false},
+		{inFlight: 10, want: false},
+		{inFlight: 11, want: true},
+		{inFlight: 8, want: true},
+		{inFlight: 5, want: true},
+		{inFlight: 4, want: false},
+		{inFlight: 9, want: false},
+		{inFlight: 50, want: true},
+		{inFlight: 0, want: false},
+	}
+	for i, tt := range tests {
+		inFlight.Store(tt.inFlight)
+		if got := marked("/"); got != tt.want {
+			t.Errorf("request %d at %d in flight: marked %v, want %v", i, tt.inFlight, got, tt.want)
+		}
+		if d.Degrading() != tt.want {
+			t.Errorf("request %d at %d in flight: Degrading() = %v, want %v", i, tt.inFlight, d.Degrading(), tt.want)
+		}
+	}
+
+	// Routes without the handler are never marked, and marking alone
+	// counts nothing: the handler counts what it actually degraded
+	inFlight.Store(50)
+	if marked("/unmarked") {
+		t.Error("request to a route without the handler was marked")
+	}
+	if got := d.Degraded(); got != 0 {
+		t.Errorf("Degraded() = %d before any CountDegraded, want 0", got)
+	}
+	d.CountDegraded()
+	d.CountDegraded()
+	if got := d.Degraded(); got != 2 {
+		t.Errorf("Degraded() = %d, want 2", got)
+	}
+}
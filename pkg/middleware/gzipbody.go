@@ -0,0 +1,49 @@
+package middleware
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// DecompressBody returns a handler that inflates request bodies sent with
+// Content-Encoding: gzip before later handlers parse them, reading at most
+// limit decompressed bytes. Fiber would otherwise inflate them itself in
+// c.Body() with no limit, so a few kilobytes of gzip could expand to
+// gigabytes in memory. A body that isn't valid gzip gets a 400, one that
+// inflates past limit a 413, and any other content coding a 415 so it can't
+// take the same unbounded path.
+func DecompressBody(limit int) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		encoding := strings.ToLower(strings.TrimSpace(c.Get(fiber.HeaderContentEncoding)))
+		switch encoding {
+		case "", "identity":
+			return c.Next()
+		case "gzip", "x-gzip":
+		default:
+			return Reject(c, fiber.StatusUnsupportedMediaType, ReasonUnsupportedEncoding,
+				"Unsupported Content-Encoding, send gzip or an uncompressed body")
+		}
+
+		zr, err := gzip.NewReader(bytes.NewReader(c.Request().Body()))
+		if err != nil {
+			return Reject(c, fiber.StatusBadRequest, ReasonBadGzip, "Invalid gzip body")
+		}
+		// Read one byte past the limit to tell a body of exactly limit bytes
+		// from a larger one
+		body, err := io.ReadAll(io.LimitReader(zr, int64(limit)+1))
+		if err != nil {
+			return Reject(c, fiber.StatusBadRequest, ReasonBadGzip, "Invalid gzip body")
+		}
+		if len(body) > limit {
+			return Reject(c, fiber.StatusRequestEntityTooLarge, ReasonBodyTooLarge, "Decompressed body too large")
+		}
+
+		c.Request().SetBody(body)
+		c.Request().Header.Del(fiber.HeaderContentEncoding)
+		return c.Next()
+	}
+}
@@ -0,0 +1,93 @@
+package middleware
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func gzipped(t *testing.T, data []byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if _, err := zw.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestDecompressBody(t *testing.T) {
+	const limit = 1024
+	body := []byte(`{"userId":"alice"}`)
+	exact := bytes.Repeat([]byte("a"), limit)
+	bomb := gzipped(t, bytes.Repeat([]byte("0"), 10<<20))
+
+	// The handler echoes the body it sees and the Content-Encoding left on
+	// the request, which must be gone once the body is inflated
+	app := fiber.New()
+	app.Post("/", DecompressBody(limit), func(c *fiber.Ctx) error {
+		c.Set("X-Seen-Encoding", c.Get(fiber.HeaderContentEncoding))
+		return c.Send(c.Body())
+	})
+
+	tests := []struct {
+		name         string
+		encoding     string
+		body         []byte
+		wantStatus   int
+		wantBody     []byte // nil when the request is rejected
+		wantEncoding string
+	}{
+		{name: "uncompressed", body: body, wantStatus: http.StatusOK, wantBody: body},
+		{name: "identity", encoding: "identity", body: body, wantStatus: http.StatusOK, wantBody: body, wantEncoding: "identity"},
+		{name: "gzip", encoding: "gzip", body: gzipped(t, body), wantStatus: http.StatusOK, wantBody: body},
+		{name: "x-gzip, any case", encoding: " X-GZIP ", body: gzipped(t, body), wantStatus: http.StatusOK, wantBody: body},
+		{name: "exactly the limit", encoding: "gzip", body: gzipped(t, exact), wantStatus: http.StatusOK, wantBody: exact},
+		{name: "not gzip", encoding: "gzip", body: body, wantStatus: http.StatusBadRequest},
+		{name: "truncated gzip", encoding: "gzip", body: gzipped(t, body)[:20], wantStatus: http.StatusBadRequest},
+		{name: "one byte over the limit", encoding: "gzip", body: gzipped(t, append(exact, 'a')), wantStatus: http.StatusRequestEntityTooLarge},
+		{name: "bomb", encoding: "gzip", body: bomb, wantStatus: http.StatusRequestEntityTooLarge},
+		{name: "other coding", encoding: "br", body: body, wantStatus: http.StatusUnsupportedMediaType},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(tt.body))
+			if tt.encoding != "" {
+				req.Header.Set(fiber.HeaderContentEncoding, tt.encoding)
+			}
+			resp, err := app.Test(req, -1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := io.ReadAll(resp.Body)
+			resp.Body.Close()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if resp.StatusCode != tt.wantStatus {
+				t.Fatalf("status %d, want %d: %s", resp.StatusCode, tt.wantStatus, got)
+			}
+			if tt.wantBody == nil {
+				if !strings.Contains(string(got), `"error"`) {
+					t.Errorf("body %s, want an error", got)
+				}
+				return
+			}
+			if !bytes.Equal(got, tt.wantBody) {
+				t.Errorf("handler saw %q, want %q", got, tt.wantBody)
+			}
+			if seen := resp.Header.Get("X-Seen-Encoding"); seen != tt.wantEncoding {
+				t.Errorf("handler saw Content-Encoding %q, want %q", seen, tt.wantEncoding)
+			}
+		})
+	}
+}
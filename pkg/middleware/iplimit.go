@@ -0,0 +1,127 @@
+package middleware
+
+import (
+	"net"
+	"sync"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// IPConnLimiter caps the open connections a single remote IP may use, so one
+// misbehaving host can't hog the server with hundreds of slow connections.
+// Connections are counted at the listener, because a slow client holds its
+// connection while downloading, long after the handler has returned. Requests
+// on a connection beyond the limit get a 503 and the connection is closed.
+type IPConnLimiter struct {
+	limit int
+
+	mu       sync.Mutex
+	open     map[string]int
+	rejected map[string]int64
+}
+
+// NewIPConnLimiter creates a limiter allowing limit open connections per IP.
+func NewIPConnLimiter(limit int) *IPConnLimiter {
+	return &IPConnLimiter{
+		limit:    limit,
+		open:     make(map[string]int),
+		rejected: make(map[string]int64),
+	}
+}
+
+// Limit returns the maximum open connections allowed per IP.
+func (l *IPConnLimiter) Limit() int {
+	return l.limit
+}
+
+// Listener wraps ln so every accepted connection is counted against its remote
+// IP until it is closed. Pass the result to app.Listener.
+func (l *IPConnLimiter) Listener(ln net.Listener) net.Listener {
+	return &ipCountingListener{Listener: ln, limiter: l}
+}
+
+// Handler returns the middleware that rejects requests from IPs over the limit.
+func (l *IPConnLimiter) Handler() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		ip := c.Context().RemoteIP().String()
+
+		l.mu.Lock()
+		over := l.open[ip] > l.limit
+		if over {
+			l.rejected[ip]++
+		}
+		l.mu.Unlock()
+
+		if over {
+			// Closing the connection is what brings the IP back under the limit
+			c.Context().SetConnectionClose()
+			c.Set(fiber.HeaderRetryAfter, "1")
+			return Reject(c, fiber.StatusServiceUnavailable, ReasonRateLimited, "Too many connections from this address")
+		}
+		return c.Next()
+	}
+}
+
+// Rejected returns the number of requests rejected so far, by remote IP.
+func (l *IPConnLimiter) Rejected() map[string]int64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	rejected := make(map[string]int64, len(l.rejected))
+	for ip, n := range l.rejected {
+		rejected[ip] = n
+	}
+	return rejected
+}
+
+func (l *IPConnLimiter) add(ip string, delta int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.open[ip] += delta
+	if l.open[ip] <= 0 {
+		delete(l.open, ip)
+	}
+}
+
+type ipCountingListener struct {
+	net.Listener
+	limiter *IPConnLimiter
+}
+
+func (ln *ipCountingListener) Accept() (net.Conn, error) {
+	conn, err := ln.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	ip := remoteIP(conn)
+	ln.limiter.add(ip, 1)
+	return &ipCountingConn{Conn: conn, limiter: ln.limiter, ip: ip}, nil
+}
+
+type ipCountingConn struct {
+	net.Conn
+	limiter   *IPConnLimiter
+	ip        string
+	closeOnce sync.Once
+}
+
+// Close releases the connection's slot exactly once, even if the server
+// closes a connection more than once.
+func (c *ipCountingConn) Close() error {
+	c.closeOnce.Do(func() {
+		c.limiter.add(c.ip, -1)
+	})
+	return c.Conn.Close()
+}
+
+// remoteIP returns the connection's remote IP in the same form as
+// fasthttp.RequestCtx.RemoteIP, so the listener and the handler agree on keys.
+func remoteIP(conn net.Conn) string {
+	if addr, ok := conn.RemoteAddr().(*net.TCPAddr); ok {
+		return addr.IP.String()
+	}
+	host, _, err := net.SplitHostPort(conn.RemoteAddr().String())
+	if err != nil {
+		return conn.RemoteAddr().String()
+	}
+	return host
+}
@@ -0,0 +1,51 @@
+package middleware
+
+import (
+	"log"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Rejection reason codes, reported in /metrics and in the rejection log line.
+const (
+	ReasonBadJSON             = "bad_json"
+	ReasonBadGzip             = "bad_gzip"
+	ReasonUnsupportedEncoding = "unsupported_encoding"
+	ReasonMissingUserID       = "missing_user_id"
+	ReasonBodyTooLarge        = "body_too_large"
+	ReasonUnauthorized        = "unauthorized"
+	ReasonRateLimited         = "rate_limited"
+	ReasonOverloaded          = "overloaded"
+	ReasonIdempotencyConflict = "idempotency_conflict"
+	ReasonBadSchemaVersion    = "bad_schema_version"
+	ReasonSchemaUnsatisfiable = "schema_unsatisfiable"
+)
+
+// rejectionKey is the Locals key under which Reject stores the reason code.
+type rejectionKey struct{}
+
+// Reject ends a request with status and an {"error": message} body. It logs
+// the rejection with its reason code and records the reason for
+// RejectionReason, so the metrics middleware can count it.
+func Reject(c *fiber.Ctx, status int, reason, message string) error {
+	c.Locals(rejectionKey{}, reason)
+	LogRejection(c, status, reason)
+	return c.Status(status).JSON(fiber.Map{
+		"error": message,
+	})
+}
+
+// RejectionReason returns the reason code the request was rejected with, or
+// false if it wasn't rejected through Reject.
+func RejectionReason(c *fiber.Ctx) (string, bool) {
+	reason, ok := c.Locals(rejectionKey{}).(string)
+	return reason, ok
+}
+
+// LogRejection writes one key=value line per rejected request, so rejections
+// can be filtered and counted by reason from the logs.
+func LogRejection(c *fiber.Ctx, status int, reason string) {
+	requestID, _ := c.Locals("requestid").(string)
+	log.Printf("rejected reason=%s status=%d method=%s path=%s ip=%s request_id=%s",
+		reason, status, c.Method(), c.Path(), c.IP(), requestID)
+}
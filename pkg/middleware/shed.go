@@ -0,0 +1,68 @@
+package middleware
+
+import (
+	"sync/atomic"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// LoadShedder rejects requests with 503 while the server is overloaded, so
+// excess load fails fast instead of queueing and raising latency for everyone.
+// It starts shedding when in-flight requests exceed the high watermark and
+// keeps shedding until they drop below the low watermark; the gap keeps it from
+// flapping on every request near the limit.
+type LoadShedder struct {
+	load watermarks
+	shed atomic.Int64
+}
+
+// NewLoadShedder creates a shedder over the in-flight count reported by
+// inFlight. low must be less than high.
+func NewLoadShedder(high, low int64, inFlight func() int64) *LoadShedder {
+	return &LoadShedder{load: watermarks{high: high, low: low, inFlight: inFlight}}
+}
+
+// Handler returns the middleware that admits or sheds each request.
+func (s *LoadShedder) Handler() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if s.load.update() {
+			s.shed.Add(1)
+			c.Set(fiber.HeaderRetryAfter, "1")
+			return Reject(c, fiber.StatusServiceUnavailable, ReasonOverloaded, "Server overloaded, retry later")
+		}
+		return c.Next()
+	}
+}
+
+// Shedding reports whether new requests are currently being rejected.
+func (s *LoadShedder) Shedding() bool {
+	return s.load.over.Load()
+}
+
+// Shed returns the number of requests rejected so far.
+func (s *LoadShedder) Shed() int64 {
+	return s.shed.Load()
+}
+
+// watermarks tracks whether the in-flight count is over a high watermark,
+// staying over until it drops below the low one.
+type watermarks struct {
+	high     int64
+	low      int64
+	inFlight func() int64
+
+	over atomic.Bool
+}
+
+// update checks the current in-flight count and reports whether it is over.
+func (w *watermarks) update() bool {
+	current := w.inFlight()
+	if w.over.Load() {
+		if current < w.low {
+			w.over.Store(false)
+		}
+	} else if current > w.high {
+		w.over.Store(true)
+	}
+	return w.over.Load()
+}
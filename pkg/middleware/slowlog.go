@@ -0,0 +1,182 @@
+package middleware
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// slowLogPerSecond caps the slow request lines written per second. When the
+// whole server slows down every request is an outlier, and a line per request
+// would bury the logs; the lines dropped are counted in the next one written.
+const slowLogPerSecond = 10
+
+// annotationsKey is the Locals key under which Annotate collects fields for
+// the slow request log.
+type annotationsKey struct{}
+
+// Annotate adds a key=value field to the request's slow request log line,
+// e.g. the userId once the handler has parsed it. Values that are empty or
+// contain spaces, quotes or '=' are quoted so the line stays parseable. It
+// does nothing visible for requests that turn out not to be slow.
+func Annotate(c *fiber.Ctx, key, value string) {
+	if value == "" || strings.ContainsAny(value, " \"=") {
+		value = strconv.Quote(value)
+	}
+	fields, _ := c.Locals(annotationsKey{}).([]string)
+	c.Locals(annotationsKey{}, append(fields, key+"="+value))
+}
+
+// SlowRequestLog returns a handler that logs requests taking longer than
+// threshold, from entering the handler to handing the last byte of the body
+// to the connection. Each outlier gets one warn-level key=value line with the
+// request ID, status, body size, the time split into decision (see
+// MarkDecision), handler and transfer time, the requests in flight when it
+// arrived, and any fields added with Annotate. Fast requests log nothing.
+//
+// To see the transfer, a buffered body is handed to fasthttp as a stream that
+// reports when it has been read, which costs a copy of every response body.
+// Bodies a handler already streams can't be wrapped, so their line shows the
+// handler time only, with transfer and bytes "unknown".
+func SlowRequestLog(threshold time.Duration, inFlight func() int64) fiber.Handler {
+	limiter := &logLimiter{perSecond: slowLogPerSecond}
+	return func(c *fiber.Ctx) error {
+		start := time.Now()
+		arrivedInFlight := inFlight()
+		err := c.Next()
+		handled := time.Since(start)
+
+		entry := slowRequest{
+			threshold: threshold,
+			limiter:   limiter,
+			start:     start,
+			handled:   handled,
+			inFlight:  arrivedInFlight,
+			status:    c.Response().StatusCode(),
+			method:    c.Method(),
+			path:      c.Path(),
+		}
+		entry.requestID, _ = c.Locals("requestid").(string)
+		entry.decision, entry.decided = c.Locals(decisionKey{}).(time.Duration)
+		entry.fields, _ = c.Locals(annotationsKey{}).([]string)
+
+		resp := c.Response()
+		if resp.IsBodyStream() {
+			entry.log(-1, false)
+			return err
+		}
+		// Copy the body: it may be the response buffer, which is reset when
+		// the stream is set
+		body := append([]byte(nil), resp.Body()...)
+		resp.SetBodyStream(&timedBody{Reader: bytes.NewReader(body), done: func() {
+			entry.log(len(body), true)
+		}}, len(body))
+		return err
+	}
+}
+
+// slowRequest is what SlowRequestLog knows about a request when its handler
+// returns, kept until the body has been sent.
+type slowRequest struct {
+	threshold time.Duration
+	limiter   *logLimiter
+
+	start     time.Time
+	handled   time.Duration // time in the handler chain
+	decision  time.Duration
+	decided   bool
+	inFlight  int64
+	status    int
+	method    string
+	path      string
+	requestID string
+	fields    []string
+}
+
+// log writes the request's line if it was slow. transferred is false when
+// the body was streamed by the handler and its transfer couldn't be timed.
+func (r *slowRequest) log(bytes int, transferred bool) {
+	total := r.handled
+	if transferred {
+		total = time.Since(r.start)
+	}
+	if total <= r.threshold {
+		return
+	}
+	suppressed, ok := r.limiter.allow()
+	if !ok {
+		return
+	}
+
+	decision, transfer, size := "unknown", "unknown", "unknown"
+	if r.decided {
+		decision = fmt.Sprintf("%.1f", millis(r.decision))
+	}
+	if transferred {
+		transfer = fmt.Sprintf("%.1f", millis(total-r.handled))
+		size = fmt.Sprint(bytes)
+	}
+	line := fmt.Sprintf("slow_request level=warn total_ms=%.1f decision_ms=%s handler_ms=%.1f transfer_ms=%s bytes=%s status=%d method=%s path=%s request_id=%s inflight=%d",
+		millis(total), decision, millis(r.handled), transfer, size, r.status, r.method, r.path, r.requestID, r.inFlight)
+	if len(r.fields) > 0 {
+		line += " " + strings.Join(r.fields, " ")
+	}
+	if suppressed > 0 {
+		line += fmt.Sprintf(" suppressed=%d", suppressed)
+	}
+	log.Print(line)
+}
+
+func millis(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+// timedBody is a response body that calls done once fasthttp has finished
+// with it. fasthttp closes a body stream after writing it, or when the
+// response is discarded.
+type timedBody struct {
+	*bytes.Reader
+	once sync.Once
+	done func()
+}
+
+func (b *timedBody) Close() error {
+	b.once.Do(b.done)
+	return nil
+}
+
+// logLimiter allows perSecond log lines per one-second window and counts the
+// rest.
+type logLimiter struct {
+	perSecond int
+
+	mu          sync.Mutex
+	windowStart time.Time
+	inWindow    int
+	suppressed  int
+}
+
+// allow reports whether a line may be written now and, if so, how many were
+// suppressed since the last line written.
+func (l *logLimiter) allow() (suppressed int, ok bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := time.Now()
+	if now.Sub(l.windowStart) >= time.Second {
+		l.windowStart = now
+		l.inWindow = 0
+	}
+	if l.inWindow >= l.perSecond {
+		l.suppressed++
+		return 0, false
+	}
+	l.inWindow++
+	suppressed, l.suppressed = l.suppressed, 0
+	return suppressed, true
+}
@@ -0,0 +1,116 @@
+package middleware
+
+import (
+	"bufio"
+	"io"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// throttleBurstDivisor sets a throttled writer's bucket size to a tenth of a
+// second's worth of bytes, so output arrives in small steady steps rather
+// than a second's worth at once.
+const throttleBurstDivisor = 10
+
+// ThrottledWriter is an io.Writer that limits the bytes passed to the writer
+// it wraps with a token bucket. Each chunk is flushed as soon as it is
+// written when the wrapped writer buffers (has a Flush method), so the client
+// receives the bytes at the throttled rate rather than in one burst at the
+// end. It is not safe for concurrent use; make one per response.
+type ThrottledWriter struct {
+	w      io.Writer
+	rate   float64 // bytes per second
+	burst  int
+	tokens float64
+	last   time.Time
+}
+
+// NewThrottledWriter wraps w so at most bytesPerSecond bytes per second pass
+// through it. The bucket starts empty, so the first bytes are throttled too.
+func NewThrottledWriter(w io.Writer, bytesPerSecond int64) *ThrottledWriter {
+	burst := int(bytesPerSecond / throttleBurstDivisor)
+	if burst < 1 {
+		burst = 1
+	}
+	return &ThrottledWriter{
+		w:     w,
+		rate:  float64(bytesPerSecond),
+		burst: burst,
+		last:  time.Now(),
+	}
+}
+
+// Write writes p in chunks of at most one bucket, waiting for each chunk's
+// tokens before writing it.
+func (t *ThrottledWriter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		chunk := len(p)
+		if chunk > t.burst {
+			chunk = t.burst
+		}
+		t.wait(chunk)
+
+		n, err := t.w.Write(p[:chunk])
+		written += n
+		if err != nil {
+			return written, err
+		}
+		if f, ok := t.w.(interface{ Flush() error }); ok {
+			if err := f.Flush(); err != nil {
+				return written, err
+			}
+		}
+		p = p[chunk:]
+	}
+	return written, nil
+}
+
+// wait refills the bucket for the time elapsed since the last call, sleeps
+// until it holds n tokens, then spends them.
+func (t *ThrottledWriter) wait(n int) {
+	now := time.Now()
+	t.tokens += now.Sub(t.last).Seconds() * t.rate
+	if t.tokens > float64(t.burst) {
+		t.tokens = float64(t.burst)
+	}
+	t.last = now
+
+	if missing := float64(n) - t.tokens; missing > 0 {
+		time.Sleep(time.Duration(missing / t.rate * float64(time.Second)))
+		t.tokens += missing
+		t.last = time.Now()
+	}
+	t.tokens -= float64(n)
+}
+
+// Throttle returns a handler that sends the rest of the chain's response body
+// through a ThrottledWriter at bytesPerSecond, to simulate a bandwidth-limited
+// or overloaded server. The body is still built in full by the handler, and
+// the Content-Length header is kept, so clients see a normal response that
+// just arrives slowly. Responses the handler already streams with
+// SetBodyStreamWriter are left alone: fasthttp can't wrap a stream after the
+// fact, so streaming handlers wrap their own writer with NewThrottledWriter.
+// It exists to test clients' timeout and retry handling and must never be
+// enabled in production.
+func Throttle(bytesPerSecond int64) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if err := c.Next(); err != nil {
+			return err
+		}
+
+		resp := c.Response()
+		if resp.IsBodyStream() {
+			return nil
+		}
+		// Copy the body: the response buffer is reset when the stream is set
+		body := append([]byte(nil), resp.Body()...)
+		resp.SetBodyStreamWriter(func(w *bufio.Writer) {
+			// The client may hang up mid-body; there is no one left to tell
+			NewThrottledWriter(w, bytesPerSecond).Write(body)
+		})
+		resp.Header.SetContentLength(len(body))
+		return nil
+	}
+}
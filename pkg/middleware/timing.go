@@ -0,0 +1,65 @@
+package middleware
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// HeaderProcessingTime carries the time the server spent handling a request,
+// in milliseconds with microsecond precision (e.g. "0.412"). It excludes the
+// time taken to transfer the response to the client, so comparing it against
+// the client-observed round trip separates server cost from network cost.
+const HeaderProcessingTime = "X-Processing-Time"
+
+// HeaderDecisionTime carries the time from receiving a request to choosing what
+// to serve, in the same format as X-Processing-Time. The gap between the two is
+// the cost of building the response body.
+const HeaderDecisionTime = "X-Decision-Time"
+
+// startTimeKey is the Locals key under which ProcessingTime stores the time a
+// request entered the handler chain.
+type startTimeKey struct{}
+
+// decisionKey is the Locals key under which MarkDecision stores the decision
+// time, for SlowRequestLog.
+type decisionKey struct{}
+
+// ProcessingTime returns a handler that measures the rest of the handler chain
+// and reports the elapsed time in the X-Processing-Time response header. If
+// observe is not nil it is called with the elapsed time after the chain
+// returns.
+func ProcessingTime(observe func(c *fiber.Ctx, elapsed time.Duration)) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		start := time.Now()
+		c.Locals(startTimeKey{}, start)
+		err := c.Next()
+		elapsed := time.Since(start)
+		c.Set(HeaderProcessingTime, formatMillis(elapsed))
+		if observe != nil {
+			observe(c, elapsed)
+		}
+		return err
+	}
+}
+
+// MarkDecision reports the time since the request entered ProcessingTime in
+// the X-Decision-Time header and returns it. Handlers call it once they have
+// decided what to serve, before encoding the response. It returns false when
+// ProcessingTime is not installed.
+func MarkDecision(c *fiber.Ctx) (time.Duration, bool) {
+	start, ok := c.Locals(startTimeKey{}).(time.Time)
+	if !ok {
+		return 0, false
+	}
+	elapsed := time.Since(start)
+	c.Set(HeaderDecisionTime, formatMillis(elapsed))
+	c.Locals(decisionKey{}, elapsed)
+	return elapsed, true
+}
+
+// formatMillis formats d as milliseconds with microsecond precision.
+func formatMillis(d time.Duration) string {
+	return strconv.FormatFloat(float64(d.Microseconds())/1000, 'f', 3, 64)
+}
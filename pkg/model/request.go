@@ -3,4 +3,10 @@ package model
 // Request defines the user request for the experimentation platform
 type Request struct {
 	UserID string `json:"userId"`
+	// AppVersion is the client's semantic version (e.g. "2.4.1"), used to keep
+	// stale clients off payloads they can't render. Optional.
+	AppVersion string `json:"appVersion,omitempty"`
+	// MinSchemaVersion is the oldest payload schema version the client can
+	// parse, from the X-Min-Schema-Version header; 0 when not sent
+	MinSchemaVersion int `json:"-"`
 }
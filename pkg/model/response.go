@@ -7,4 +7,24 @@ type Response struct {
 	ExperimentID        string          `json:"experimentId"`
 	SelectedPayloadName string          `json:"selectedPayloadName"`
 	Payload             json.RawMessage `json:"payload"`
+	// Exposed is set only when the server gates the experiment with
+	// -exposure: false means the user is outside the exposed fraction and
+	// got the control payload
+	Exposed *bool `json:"exposed,omitempty"`
+	// SchemaVersion is the version of the payload's schema, so clients know
+	// how to parse it
+	SchemaVersion int `json:"schemaVersion"`
+	// Degraded is set when the server was under load and served its
+	// lightweight payload instead of the user's
+	Degraded bool `json:"degraded,omitempty"`
+}
+
+// StreamHeader is the first line of an /experiment response streamed as JSON
+// Lines. Each following line is one entry of the payload, see store.Entry.
+type StreamHeader struct {
+	ExperimentID        string `json:"experimentId"`
+	SelectedPayloadName string `json:"selectedPayloadName"`
+	Exposed             *bool  `json:"exposed,omitempty"`
+	SchemaVersion       int    `json:"schemaVersion"`
+	Degraded            bool   `json:"degraded,omitempty"`
 }
@@ -0,0 +1,89 @@
+package store
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// ServedDigest returns the hex SHA-256 of content as the payload field of a
+// JSON response carries it: encoding/json compacts raw JSON and escapes HTML
+// characters in it, so the served bytes can differ from the file's. Content
+// that isn't valid JSON is digested as is.
+func ServedDigest(content string) string {
+	var compact, escaped bytes.Buffer
+	if err := json.Compact(&compact, []byte(content)); err != nil {
+		sum := sha256.Sum256([]byte(content))
+		return hex.EncodeToString(sum[:])
+	}
+	json.HTMLEscape(&escaped, compact.Bytes())
+	sum := sha256.Sum256(escaped.Bytes())
+	return hex.EncodeToString(sum[:])
+}
+
+// ChecksumMismatchError reports a payload file whose SHA-256 doesn't match the
+// checksum manifest, e.g. a corrupted or swapped file.
+type ChecksumMismatchError struct {
+	File     string
+	Expected string
+	Actual   string
+}
+
+func (e *ChecksumMismatchError) Error() string {
+	return fmt.Sprintf("checksum mismatch for %s: expected sha256 %s, got %s", e.File, e.Expected, e.Actual)
+}
+
+// LoadChecksums reads a checksum manifest in the format produced by
+// `sha256sum *.json`: one "<hex digest>  <file name>" entry per line. Blank
+// lines and lines starting with '#' are ignored.
+func LoadChecksums(path string) (map[string]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	checksums := make(map[string]string)
+	scanner := bufio.NewScanner(f)
+	lineNum := 0
+	for scanner.Scan() {
+		lineNum++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("%s:%d: expected \"<sha256>  <file>\"", path, lineNum)
+		}
+		digest := strings.ToLower(fields[0])
+		name := strings.TrimPrefix(fields[1], "*") // sha256sum's binary-mode marker
+		if decoded, err := hex.DecodeString(digest); err != nil || len(decoded) != sha256.Size {
+			return nil, fmt.Errorf("%s:%d: invalid sha256 digest %q", path, lineNum, fields[0])
+		}
+		checksums[name] = digest
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return checksums, nil
+}
+
+// verifyChecksum checks content against the manifest entry for name, if any.
+func verifyChecksum(checksums map[string]string, name string, content []byte) error {
+	expected, ok := checksums[name]
+	if !ok {
+		return nil
+	}
+	sum := sha256.Sum256(content)
+	if actual := hex.EncodeToString(sum[:]); actual != expected {
+		return &ChecksumMismatchError{File: name, Expected: expected, Actual: actual}
+	}
+	return nil
+}
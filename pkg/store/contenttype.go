@@ -0,0 +1,82 @@
+package store
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// ContentTypeJSON is the content type of payloads loaded from .json files,
+// the only kind the store loads unless SetContentTypes adds others.
+const ContentTypeJSON = "application/json"
+
+// knownContentTypes are the content types ParseContentTypes infers from an
+// extension given without one. The table is fixed rather than read from the
+// system's mime.types, so the same flag serves the same types on every host.
+var knownContentTypes = map[string]string{
+	".bin":  "application/octet-stream",
+	".css":  "text/css; charset=utf-8",
+	".gif":  "image/gif",
+	".htm":  "text/html; charset=utf-8",
+	".html": "text/html; charset=utf-8",
+	".jpeg": "image/jpeg",
+	".jpg":  "image/jpeg",
+	".md":   "text/markdown; charset=utf-8",
+	".pdf":  "application/pdf",
+	".png":  "image/png",
+	".svg":  "image/svg+xml",
+	".txt":  "text/plain; charset=utf-8",
+	".webp": "image/webp",
+	".xml":  "application/xml",
+}
+
+// ParseContentTypes parses a comma-separated list of file extensions to load
+// as non-JSON payloads, each with its content type inferred from the
+// extension (".html") or declared after an equals sign
+// (".ftl=text/plain; charset=utf-8"). An empty spec returns nil.
+func ParseContentTypes(spec string) (map[string]string, error) {
+	if strings.TrimSpace(spec) == "" {
+		return nil, nil
+	}
+	types := make(map[string]string)
+	for _, item := range strings.Split(spec, ",") {
+		ext, contentType, declared := strings.Cut(strings.TrimSpace(item), "=")
+		ext = strings.ToLower(strings.TrimSpace(ext))
+		contentType = strings.TrimSpace(contentType)
+		if !strings.HasPrefix(ext, ".") || len(ext) < 2 || strings.ContainsAny(ext[1:], "./") {
+			return nil, fmt.Errorf("%q is not a file extension like .html", ext)
+		}
+		if ext == ".json" {
+			return nil, fmt.Errorf(".json files are always loaded as JSON payloads")
+		}
+		if _, dup := types[ext]; dup {
+			return nil, fmt.Errorf("%s is listed more than once", ext)
+		}
+		if !declared {
+			known, ok := knownContentTypes[ext]
+			if !ok {
+				return nil, fmt.Errorf("no known content type for %s; declare one, e.g. %s=application/octet-stream", ext, ext)
+			}
+			contentType = known
+		}
+		if contentType == "" || !strings.Contains(contentType, "/") {
+			return nil, fmt.Errorf("%s: %q is not a content type like text/html", ext, contentType)
+		}
+		types[ext] = contentType
+	}
+	return types, nil
+}
+
+// FormatContentTypes renders types as ParseContentTypes reads them, sorted by
+// extension, for logs.
+func FormatContentTypes(types map[string]string) string {
+	exts := make([]string, 0, len(types))
+	for ext := range types {
+		exts = append(exts, ext)
+	}
+	sort.Strings(exts)
+	for i, ext := range exts {
+		exts[i] = ext + "=" + types[ext]
+	}
+	return strings.Join(exts, ",")
+}
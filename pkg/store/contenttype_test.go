@@ -0,0 +1,47 @@
+package store
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseContentTypes(t *testing.T) {
+	tests := []struct {
+		name    string
+		spec    string
+		want    map[string]string
+		wantErr string
+	}{
+		{name: "empty", spec: " ", want: nil},
+		{name: "inferred", spec: ".html,.bin", want: map[string]string{".html": "text/html; charset=utf-8", ".bin": "application/octet-stream"}},
+		{name: "declared", spec: ".ftl=text/plain; charset=utf-8", want: map[string]string{".ftl": "text/plain; charset=utf-8"}},
+		{name: "declared overrides known", spec: ".txt=text/x-strings", want: map[string]string{".txt": "text/x-strings"}},
+		{name: "case and spaces", spec: " .HTML , .Png ", want: map[string]string{".html": "text/html; charset=utf-8", ".png": "image/png"}},
+		{name: "no dot", spec: "html", wantErr: "not a file extension"},
+		{name: "just a dot", spec: ".", wantErr: "not a file extension"},
+		{name: "double extension", spec: ".tar.gz=application/gzip", wantErr: "not a file extension"},
+		{name: "json", spec: ".json", wantErr: "always loaded as JSON"},
+		{name: "repeated", spec: ".html,.HTML", wantErr: "listed more than once"},
+		{name: "unknown extension", spec: ".ftl", wantErr: "no known content type for .ftl"},
+		{name: "not a content type", spec: ".ftl=text", wantErr: "is not a content type"},
+		{name: "empty content type", spec: ".ftl=", wantErr: "is not a content type"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseContentTypes(tt.spec)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("ParseContentTypes(%q) error = %v, want one containing %q", tt.spec, err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParseContentTypes(%q) error = %v", tt.spec, err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseContentTypes(%q) = %v, want %v", tt.spec, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,91 @@
+package store
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+// Entry is one top-level key of a payload object, e.g. a single localization
+// string. It is the unit a payload is streamed in as JSON Lines.
+type Entry struct {
+	Key   string          `json:"key"`
+	Value json.RawMessage `json:"value"`
+}
+
+// SplitEntries returns the top-level keys of a JSON object in document order
+// with their compacted values. Content that isn't a JSON object has no entries
+// and returns nil, so the payload can only be served as a single object.
+func SplitEntries(content []byte) ([]Entry, error) {
+	dec := json.NewDecoder(bytes.NewReader(content))
+	tok, err := dec.Token()
+	if err != nil {
+		return nil, err
+	}
+	if delim, ok := tok.(json.Delim); !ok || delim != '{' {
+		return nil, nil
+	}
+
+	var entries []Entry
+	for dec.More() {
+		tok, err := dec.Token()
+		if err != nil {
+			return nil, err
+		}
+		key, ok := tok.(string)
+		if !ok {
+			return nil, fmt.Errorf("unexpected object key %v", tok)
+		}
+		var raw json.RawMessage
+		if err := dec.Decode(&raw); err != nil {
+			return nil, err
+		}
+		var value bytes.Buffer
+		if err := json.Compact(&value, raw); err != nil {
+			return nil, err
+		}
+		entries = append(entries, Entry{Key: key, Value: value.Bytes()})
+	}
+	return entries, nil
+}
+
+// WriteEntries writes entries as JSON Lines, one {"key":...,"value":...}
+// object per line, flushing every flushEvery entries (0 = only at the end) so
+// a client can start parsing before the whole payload is sent.
+func WriteEntries(w *bufio.Writer, entries []Entry, flushEvery int) error {
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false)
+	for i, entry := range entries {
+		if err := enc.Encode(entry); err != nil {
+			return err
+		}
+		if flushEvery > 0 && (i+1)%flushEvery == 0 {
+			if err := w.Flush(); err != nil {
+				return err
+			}
+		}
+	}
+	return w.Flush()
+}
+
+// ReadEntries reassembles JSON Lines written by WriteEntries into the object
+// they were split from. A repeated key keeps its last value, as with
+// json.Unmarshal.
+func ReadEntries(r io.Reader) (map[string]interface{}, error) {
+	object := make(map[string]interface{})
+	dec := json.NewDecoder(r)
+	for dec.More() {
+		var entry Entry
+		if err := dec.Decode(&entry); err != nil {
+			return nil, err
+		}
+		var value interface{}
+		if err := json.Unmarshal(entry.Value, &value); err != nil {
+			return nil, fmt.Errorf("entry %q: %w", entry.Key, err)
+		}
+		object[entry.Key] = value
+	}
+	return object, nil
+}
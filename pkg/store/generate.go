@@ -0,0 +1,116 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"math/rand"
+	"strconv"
+	"strings"
+)
+
+// GenerateSpec describes a set of synthetic payloads: Count payloads of
+// exactly SizeBytes bytes each, with content derived from Seed.
+type GenerateSpec struct {
+	SizeBytes int
+	Count     int
+	Seed      int64
+}
+
+// generatedValueLen is the length of each generated string value, about the
+// size of a short UI sentence.
+const generatedValueLen = 64
+
+// generatedAlphabet is what generated values are drawn from: letters and
+// spaces, so values never need escaping and their encoded size is exact.
+const generatedAlphabet = "abcdefghijklmnopqrstuvwxyz      "
+
+// ParseGenerateSpec parses "<sizeKB>,<count>", e.g. "1024,5" for five 1 MiB
+// payloads. The seed is left at zero for the caller to set.
+func ParseGenerateSpec(spec string) (GenerateSpec, error) {
+	sizeKB, count, ok := strings.Cut(spec, ",")
+	if !ok {
+		return GenerateSpec{}, fmt.Errorf("invalid payload spec %q: expected <sizeKB>,<count>", spec)
+	}
+	size, err1 := strconv.Atoi(strings.TrimSpace(sizeKB))
+	n, err2 := strconv.Atoi(strings.TrimSpace(count))
+	if err1 != nil || err2 != nil || size <= 0 || n <= 0 {
+		return GenerateSpec{}, fmt.Errorf("invalid payload spec %q: size and count must be positive integers", spec)
+	}
+	return GenerateSpec{SizeBytes: size * 1024, Count: n}, nil
+}
+
+// Generate builds the payloads described by spec. Each is a JSON object of
+// string entries ("string_000000": "...") padded with whitespace to exactly
+// spec.SizeBytes, so it can be streamed as JSON Lines like a real bundle.
+// Payload i depends only on the seed and i: the same spec always produces the
+// same payloads, and raising Count only appends new ones.
+func Generate(spec GenerateSpec) ([]Payload, error) {
+	if spec.SizeBytes < 2 || spec.Count <= 0 {
+		return nil, errors.New("generated payloads need a size of at least 2 bytes and a positive count")
+	}
+	payloads := make([]Payload, spec.Count)
+	for i := range payloads {
+		content := generateContent(rand.New(rand.NewSource(spec.Seed+int64(i))), spec.SizeBytes)
+		payloads[i] = Payload{
+			Name:          fmt.Sprintf("generated_%03d.json", i),
+			Content:       string(content),
+			SchemaVersion: DefaultSchemaVersion,
+			ContentType:   ContentTypeJSON,
+		}
+	}
+	return payloads, nil
+}
+
+// generateContent writes string entries until no whole entry fits in size,
+// then fills the rest with spaces before the closing brace.
+func generateContent(rng *rand.Rand, size int) []byte {
+	buf := make([]byte, 0, size)
+	buf = append(buf, '{')
+	remaining := size - 2 // the braces
+	for i := 0; ; i++ {
+		key := fmt.Sprintf("%q:", fmt.Sprintf("string_%06d", i))
+		if i > 0 {
+			key = "," + key
+		}
+		valueLen := min(generatedValueLen, remaining-len(key)-2)
+		if valueLen < 1 {
+			break
+		}
+		buf = append(buf, key...)
+		buf = append(buf, '"')
+		for j := 0; j < valueLen; j++ {
+			buf = append(buf, generatedAlphabet[rng.Intn(len(generatedAlphabet))])
+		}
+		buf = append(buf, '"')
+		remaining -= len(key) + valueLen + 2
+	}
+	for ; remaining > 0; remaining-- {
+		buf = append(buf, ' ')
+	}
+	return append(buf, '}')
+}
+
+// LoadGenerated replaces the current set with synthetic payloads from spec
+// instead of reading the directory, subject to the store's limits. It is for
+// CI and benchmarks that shouldn't depend on checked-in payload files; Reload
+// and Watch still read the directory, so don't combine them with it.
+func (s *PayloadStore) LoadGenerated(spec GenerateSpec) error {
+	budgetBytes := int64(spec.Count) * int64(spec.SizeBytes)
+	if s.splitEntries {
+		budgetBytes *= 2
+	}
+	if err := s.limits.check(spec.Count, budgetBytes); err != nil {
+		return err
+	}
+	payloads, err := Generate(spec)
+	if err != nil {
+		return err
+	}
+
+	s.reloadMu.Lock()
+	defer s.reloadMu.Unlock()
+	s.swap(payloads)
+	log.Printf("Generated %d synthetic payloads (%s each, seed %d)", spec.Count, formatBytes(int64(spec.SizeBytes)), spec.Seed)
+	return nil
+}
@@ -0,0 +1,96 @@
+package store
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// FileReport describes the size of one payload file on disk and on the wire.
+type FileReport struct {
+	Name          string
+	Payloads      int   // payloads the file contributes (array length, or 1)
+	RawBytes      int64 // size on disk
+	MinifiedBytes int64 // size with insignificant whitespace removed
+	GzipBytes     int64 // minified size after gzip at the default level
+}
+
+// Inspect reports the raw, minified and gzipped size of every payload file in
+// dir, sorted by name. It fails on the first file that isn't valid JSON.
+func Inspect(dir string) ([]FileReport, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read payloads directory: %w", err)
+	}
+
+	var reports []FileReport
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".json") {
+			continue
+		}
+
+		content, err := os.ReadFile(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+		minified, err := Minify(content)
+		if err != nil {
+			return nil, fmt.Errorf("%s contains invalid JSON: %w", entry.Name(), err)
+		}
+		gzipped, err := Gzip(minified)
+		if err != nil {
+			return nil, err
+		}
+
+		count := 1
+		var parsed map[string]interface{}
+		if json.Unmarshal(content, &parsed) == nil {
+			if items, ok := parsed["payloads"].([]interface{}); ok {
+				count = len(items)
+			}
+		}
+
+		reports = append(reports, FileReport{
+			Name:          entry.Name(),
+			Payloads:      count,
+			RawBytes:      int64(len(content)),
+			MinifiedBytes: int64(len(minified)),
+			GzipBytes:     int64(len(gzipped)),
+		})
+	}
+
+	sort.Slice(reports, func(i, j int) bool { return reports[i].Name < reports[j].Name })
+	return reports, nil
+}
+
+// Minify removes insignificant whitespace from JSON content.
+func Minify(content []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := json.Compact(&buf, content); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Gzip compresses content at the default compression level.
+func Gzip(content []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	if _, err := w.Write(content); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// FormatBytes renders a byte count with a binary unit, e.g. "1.5 MiB".
+func FormatBytes(n int64) string {
+	return formatBytes(n)
+}
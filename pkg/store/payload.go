@@ -0,0 +1,544 @@
+// Package store loads and holds the localization payloads served by the
+// experiment endpoint.
+package store
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go-localization-large-backend/pkg/encoding/proto"
+)
+
+// Payload holds the name and content of a payload file
+type Payload struct {
+	Name    string
+	Content string
+	// Entries are the payload's top-level keys in document order, for
+	// streaming it as JSON Lines, set only when the store splits entries; nil
+	// otherwise or when the payload isn't a JSON object
+	Entries []Entry
+	// Proto is the content encoded as a protobuf Value (see package proto),
+	// set only when the store encodes protobuf; nil otherwise
+	Proto []byte
+	// SchemaVersion is the version of the payload's schema, as declared by
+	// its schemaVersion key, or DefaultSchemaVersion
+	SchemaVersion int
+	// SHA256 is the hex SHA-256 of the payload as a JSON response serves it
+	// (see ServedDigest), so clients can check they received all of it
+	SHA256 string
+	// ContentType is what the payload is served as on its own, without the
+	// JSON response around it: ContentTypeJSON for .json files, or the type
+	// SetContentTypes gave its extension
+	ContentType string
+	// RawSHA256 is the hex SHA-256 of Content as is, what a raw response
+	// serves. It equals SHA256 for payloads that aren't JSON.
+	RawSHA256 string
+}
+
+// IsJSON reports whether the payload is JSON, so it can be embedded in a JSON
+// response, streamed as JSON Lines and encoded as protobuf.
+func (p Payload) IsJSON() bool {
+	return p.ContentType == ContentTypeJSON
+}
+
+// Limits caps how much the store will load, so an accidental flood of files in
+// the payloads directory fails loudly instead of exhausting memory. Zero means
+// unlimited.
+type Limits struct {
+	MaxFiles int   // maximum number of payload files
+	MaxBytes int64 // maximum combined size of payload files on disk, JSON files counted twice when entries are split
+}
+
+// PayloadStore holds the set of payloads currently being served. The set is
+// replaced atomically on reload, so readers always see either the old or the
+// new set in full, never a partially loaded directory.
+type PayloadStore struct {
+	dir          string
+	limits       Limits
+	checksumFile string
+	encodeProto  bool
+	splitEntries bool
+	concurrency  int               // 0 means GOMAXPROCS
+	contentTypes map[string]string // extension -> content type, beyond .json
+	payloads     atomic.Pointer[payloadSet]
+	reloadMu     sync.Mutex // serializes loads so concurrent reloads can't interleave
+}
+
+// payloadSet is one loaded generation of payloads with a name index, swapped
+// in as a unit so the list and the index always agree.
+type payloadSet struct {
+	list        []Payload
+	byName      map[string]int
+	fingerprint string
+}
+
+// NewPayloadStore creates an empty store for the payload files in dir, bounded
+// by limits. Call Load before serving.
+func NewPayloadStore(dir string, limits Limits) *PayloadStore {
+	return &PayloadStore{dir: dir, limits: limits}
+}
+
+// SetChecksumFile makes every load verify payload files against the SHA-256
+// manifest at path (see LoadChecksums). The manifest is re-read on each load so
+// content and checksums can be updated together. Files not listed in the
+// manifest are loaded unverified.
+func (s *PayloadStore) SetChecksumFile(path string) {
+	s.checksumFile = path
+}
+
+// SetProtobuf makes every load also encode each payload as protobuf, into
+// Payload.Proto. It costs load time and memory for a second copy of every
+// payload, so it is off unless the server offers protobuf responses.
+func (s *PayloadStore) SetProtobuf(enabled bool) {
+	s.encodeProto = enabled
+}
+
+// SetEntries makes every load also split each JSON object payload into its
+// top-level entries, into Payload.Entries, for JSON Lines responses. The
+// entries are a second copy of the payload, so it is off unless the server
+// streams JSON Lines, and a JSON file counts twice against Limits.MaxBytes.
+func (s *PayloadStore) SetEntries(enabled bool) {
+	s.splitEntries = enabled
+}
+
+// SetLoadConcurrency caps how many payload files a load reads and parses at
+// once, and how many goroutines encode and digest the loaded payloads. Zero,
+// the default, uses GOMAXPROCS; lower it to keep a large load from taking
+// every core at startup, or set 1 to load one file at a time.
+func (s *PayloadStore) SetLoadConcurrency(n int) {
+	s.concurrency = n
+}
+
+// SetContentTypes makes loads also read files with the given extensions (see
+// ParseContentTypes), each as one payload of its content type, with its bytes
+// as is. Such payloads aren't parsed, so they have no entries, protobuf
+// encoding or declared schema version. Call it before Load.
+func (s *PayloadStore) SetContentTypes(types map[string]string) {
+	s.contentTypes = types
+}
+
+func (s *PayloadStore) workers() int {
+	if s.concurrency > 0 {
+		return s.concurrency
+	}
+	return runtime.GOMAXPROCS(0)
+}
+
+// Dir returns the directory the store loads payloads from.
+func (s *PayloadStore) Dir() string {
+	return s.dir
+}
+
+// Payloads returns the current payload set in deterministic (sorted by file
+// name) order. The returned slice must not be modified.
+func (s *PayloadStore) Payloads() []Payload {
+	if set := s.payloads.Load(); set != nil {
+		return set.list
+	}
+	return nil
+}
+
+// Fingerprint identifies the current payload set by its payload names in
+// order: a hex SHA-256 that changes whenever a payload is added, removed or
+// renamed, i.e. whenever users may be reassigned. It ignores payload content.
+func (s *PayloadStore) Fingerprint() string {
+	if set := s.payloads.Load(); set != nil {
+		return set.fingerprint
+	}
+	return ""
+}
+
+// Lookup returns the current payload with the given name, e.g.
+// "small_payload.json" or "nested_large.json[3]".
+func (s *PayloadStore) Lookup(name string) (Payload, bool) {
+	set := s.payloads.Load()
+	if set == nil {
+		return Payload{}, false
+	}
+	i, ok := set.byName[name]
+	if !ok {
+		return Payload{}, false
+	}
+	return set.list[i], true
+}
+
+// Load reads all payloads from the directory and makes them the current set.
+// Files that can't be read or parsed are skipped with a warning; Load fails if
+// the directory exceeds the store's limits, a file doesn't match its checksum,
+// or no payloads could be loaded.
+func (s *PayloadStore) Load() error {
+	return s.load(false)
+}
+
+// Reload is like Load but strict: any unreadable or invalid payload file fails
+// the reload and the current set keeps serving. A half-written file during an
+// in-place update must not silently drop a payload and reshuffle users.
+func (s *PayloadStore) Reload() error {
+	return s.load(true)
+}
+
+// Update runs the same strict checks as Reload and passes the payloads it
+// would load, with their digests, to check. They become the current set only
+// if check returns true. The reload lock is held throughout, so no other load
+// lands between the check and the swap, and what goes live is exactly what
+// was checked. check must not keep next after it returns.
+func (s *PayloadStore) Update(check func(next []Payload) bool) error {
+	s.reloadMu.Lock()
+	defer s.reloadMu.Unlock()
+
+	payloads, _, err := s.read(true)
+	if err != nil {
+		return err
+	}
+	forEach(len(payloads), s.workers(), func(i int) {
+		payloads[i].digest()
+	})
+	if !check(payloads) {
+		return nil
+	}
+	s.swap(payloads)
+	log.Printf("Applied %d payloads total (%s in memory)", len(payloads), formatBytes(memoryBytes(payloads)))
+	return nil
+}
+
+// digest sets the payload's SHA256 and RawSHA256.
+func (p *Payload) digest() {
+	raw := sha256.Sum256([]byte(p.Content))
+	p.RawSHA256 = hex.EncodeToString(raw[:])
+	p.SHA256 = p.RawSHA256
+	if p.IsJSON() {
+		p.SHA256 = ServedDigest(p.Content)
+	}
+}
+
+func (s *PayloadStore) load(strict bool) error {
+	s.reloadMu.Lock()
+	defer s.reloadMu.Unlock()
+
+	payloads, _, err := s.read(strict)
+	if err != nil {
+		return err
+	}
+	s.swap(payloads)
+	log.Printf("Loaded %d payloads total (%s in memory)", len(payloads), formatBytes(memoryBytes(payloads)))
+	return nil
+}
+
+// read loads the directory with the store's limits and checksum manifest.
+// The caller holds reloadMu.
+func (s *PayloadStore) read(strict bool) ([]Payload, int64, error) {
+	var checksums map[string]string
+	if s.checksumFile != "" {
+		var err error
+		if checksums, err = LoadChecksums(s.checksumFile); err != nil {
+			return nil, 0, fmt.Errorf("failed to read checksum manifest: %w", err)
+		}
+	}
+	return loadPayloads(s.dir, s.limits, checksums, s.contentTypes, s.splitEntries, strict, s.workers())
+}
+
+// swap indexes payloads, splits them into entries and encodes them as
+// protobuf if enabled, digests them and makes them the current set.
+func (s *PayloadStore) swap(payloads []Payload) {
+	// Splitting, encoding and digesting are per payload, so they share the
+	// load's workers
+	forEach(len(payloads), s.workers(), func(i int) {
+		p := payloads[i]
+		if s.splitEntries && p.IsJSON() && p.Entries == nil {
+			// As with protobuf, content was parsed on load; a payload without
+			// entries is served as a single object
+			entries, err := SplitEntries([]byte(p.Content))
+			if err != nil {
+				log.Printf("Warning: failed to split %s into entries: %v", p.Name, err)
+			}
+			payloads[i].Entries = entries
+		}
+		if s.encodeProto && p.IsJSON() {
+			// Content was parsed on load, so this can't fail in practice;
+			// a payload without Proto is served as JSON
+			encoded, err := proto.EncodeJSON([]byte(p.Content))
+			if err != nil {
+				log.Printf("Warning: failed to encode %s as protobuf: %v", p.Name, err)
+			}
+			payloads[i].Proto = encoded
+		}
+		payloads[i].digest()
+	})
+	byName := make(map[string]int, len(payloads))
+	for i, p := range payloads {
+		byName[p.Name] = i
+	}
+	s.payloads.Store(&payloadSet{list: payloads, byName: byName, fingerprint: Fingerprint(payloads)})
+}
+
+// memoryBytes is the combined size of payloads' content and entries.
+func memoryBytes(payloads []Payload) int64 {
+	var total int64
+	for _, p := range payloads {
+		total += int64(len(p.Content))
+		for _, e := range p.Entries {
+			total += int64(len(e.Key) + len(e.Value))
+		}
+	}
+	return total
+}
+
+// Fingerprint is PayloadStore.Fingerprint for any payload list, such as one
+// passed to an Update check.
+func Fingerprint(payloads []Payload) string {
+	names := sha256.New()
+	for _, p := range payloads {
+		names.Write([]byte(p.Name))
+		names.Write([]byte{'\n'})
+	}
+	return hex.EncodeToString(names.Sum(nil))
+}
+
+// loadPayloads reads every .json file in dir, and every file with an extension
+// in contentTypes, sorted by name for deterministic ordering. A JSON file with
+// a top-level "payloads" array contributes one payload per array element; any
+// other file is a single payload. Files listed in checksums must match their
+// SHA-256. With entries, JSON files count twice against limits, as swap will
+// split them into a second copy. Up to workers files are loaded at once. It
+// also returns the combined size of the loaded payload contents.
+func loadPayloads(dir string, limits Limits, checksums map[string]string, contentTypes map[string]string, entries bool, strict bool, workers int) ([]Payload, int64, error) {
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to read payloads directory: %w", err)
+	}
+
+	// Collect and sort payload names for deterministic ordering, summing file
+	// sizes so the budget is enforced before anything is read into memory
+	var payloadNames []string
+	var budgetBytes int64
+	for _, file := range files {
+		_, raw := contentTypes[strings.ToLower(filepath.Ext(file.Name()))]
+		isJSON := strings.HasSuffix(file.Name(), ".json")
+		if !file.IsDir() && (isJSON || raw) {
+			info, err := file.Info()
+			if err != nil {
+				return nil, 0, fmt.Errorf("failed to stat %s: %w", file.Name(), err)
+			}
+			payloadNames = append(payloadNames, file.Name())
+			budgetBytes += info.Size()
+			if isJSON && entries {
+				budgetBytes += info.Size()
+			}
+		}
+	}
+	sort.Strings(payloadNames)
+
+	if err := limits.check(len(payloadNames), budgetBytes); err != nil {
+		return nil, 0, err
+	}
+
+	// Every file the manifest vouches for must be present
+	for name := range checksums {
+		if i := sort.SearchStrings(payloadNames, name); i == len(payloadNames) || payloadNames[i] != name {
+			return nil, 0, fmt.Errorf("payload %s is listed in the checksum manifest but missing from %s", name, dir)
+		}
+	}
+
+	// Files are read and parsed on up to workers goroutines, each into its
+	// own slot, so the result keeps file order whatever finishes first
+	results := make([][]Payload, len(payloadNames))
+	errs := make([]error, len(payloadNames))
+	forEach(len(payloadNames), workers, func(i int) {
+		if contentType, ok := contentTypes[strings.ToLower(filepath.Ext(payloadNames[i]))]; ok {
+			results[i], errs[i] = loadRawFile(dir, payloadNames[i], contentType, checksums, strict)
+		} else {
+			results[i], errs[i] = loadFile(dir, payloadNames[i], checksums, strict)
+		}
+	})
+	var payloads []Payload
+	for i := range payloadNames {
+		if errs[i] != nil {
+			return nil, 0, errs[i]
+		}
+		payloads = append(payloads, results[i]...)
+	}
+
+	if len(payloads) == 0 {
+		return nil, 0, errors.New("no payloads loaded")
+	}
+
+	var totalBytes int64
+	for _, p := range payloads {
+		totalBytes += int64(len(p.Content))
+	}
+	return payloads, totalBytes, nil
+}
+
+// check returns an error naming the actual totals and the limits when the
+// payload directory is over budget.
+func (l Limits) check(files int, bytes int64) error {
+	if (l.MaxFiles > 0 && files > l.MaxFiles) || (l.MaxBytes > 0 && bytes > l.MaxBytes) {
+		return fmt.Errorf("payload budget exceeded: %d files totaling %s, limit is %s files / %s",
+			files, formatBytes(bytes), formatLimit(int64(l.MaxFiles), strconv.Itoa(l.MaxFiles)), formatLimit(l.MaxBytes, formatBytes(l.MaxBytes)))
+	}
+	return nil
+}
+
+func formatLimit(limit int64, formatted string) string {
+	if limit <= 0 {
+		return "unlimited"
+	}
+	return formatted
+}
+
+// formatBytes renders a byte count with a binary unit, e.g. "1.5 MiB".
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+// loadFile reads and parses one payload file for loadPayloads. A file that
+// can't be loaded is skipped with a warning, returning no payloads, or fails
+// the load when strict. The log line for the file includes how long it took.
+func loadFile(dir, name string, checksums map[string]string, strict bool) ([]Payload, error) {
+	start := time.Now()
+	payloadPath := filepath.Join(dir, name)
+	skip := func(format string, args ...interface{}) error {
+		msg := fmt.Sprintf(format, args...)
+		if strict {
+			return errors.New(msg)
+		}
+		log.Printf("Warning: %s", msg)
+		return nil
+	}
+
+	content, err := os.ReadFile(payloadPath)
+	if err != nil {
+		return nil, skip("failed to load %s: %v", payloadPath, err)
+	}
+
+	// A checksum mismatch always fails the load: serving a corrupted or
+	// swapped bundle is worse than not starting
+	if err := verifyChecksum(checksums, name, content); err != nil {
+		return nil, err
+	}
+
+	// Parse JSON to check structure
+	var parsed map[string]interface{}
+	if err := json.Unmarshal(content, &parsed); err != nil {
+		return nil, skip("%s contains invalid JSON: %v", payloadPath, err)
+	}
+
+	fileVersion, err := schemaVersionOf(parsed, DefaultSchemaVersion)
+	if err != nil {
+		return nil, skip("%s: %v", payloadPath, err)
+	}
+
+	// No "payloads" array, use the whole file as one payload
+	payloadsArray, ok := parsed["payloads"].([]interface{})
+	if !ok {
+		log.Printf("Loaded payload: %s (%d bytes) in %s", name, len(content), time.Since(start).Round(time.Microsecond))
+		return []Payload{{
+			Name:          name,
+			Content:       string(content),
+			SchemaVersion: fileVersion,
+			ContentType:   ContentTypeJSON,
+		}}, nil
+	}
+
+	// Extract individual payloads from the array
+	log.Printf("Found payloads array in %s with %d items", name, len(payloadsArray))
+	var payloads []Payload
+	for i, item := range payloadsArray {
+		version, err := schemaVersionOf(item, fileVersion)
+		if err != nil {
+			if err := skip("payload %d from %s: %v", i, name, err); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		itemBytes, err := json.Marshal(item)
+		if err != nil {
+			if err := skip("failed to marshal payload %d from %s: %v", i, name, err); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		payloads = append(payloads, Payload{
+			Name:          fmt.Sprintf("%s[%d]", name, i),
+			Content:       string(itemBytes),
+			SchemaVersion: version,
+			ContentType:   ContentTypeJSON,
+		})
+	}
+	log.Printf("Loaded %d payloads from %s in %s", len(payloadsArray), name, time.Since(start).Round(time.Microsecond))
+	return payloads, nil
+}
+
+// loadRawFile reads a payload file that isn't JSON as one payload of
+// contentType, its bytes as is. Like loadFile it skips a file it can't read
+// unless strict, and fails on a checksum mismatch.
+func loadRawFile(dir, name, contentType string, checksums map[string]string, strict bool) ([]Payload, error) {
+	start := time.Now()
+	payloadPath := filepath.Join(dir, name)
+	content, err := os.ReadFile(payloadPath)
+	if err != nil {
+		if strict {
+			return nil, fmt.Errorf("failed to load %s: %v", payloadPath, err)
+		}
+		log.Printf("Warning: failed to load %s: %v", payloadPath, err)
+		return nil, nil
+	}
+	if err := verifyChecksum(checksums, name, content); err != nil {
+		return nil, err
+	}
+	log.Printf("Loaded payload: %s (%d bytes, %s) in %s", name, len(content), contentType, time.Since(start).Round(time.Microsecond))
+	return []Payload{{
+		Name:          name,
+		Content:       string(content),
+		SchemaVersion: DefaultSchemaVersion,
+		ContentType:   contentType,
+	}}, nil
+}
+
+// forEach calls fn for every index below n from up to workers goroutines, and
+// returns once every call has.
+func forEach(n, workers int, fn func(i int)) {
+	if workers > n {
+		workers = n
+	}
+	if workers <= 1 {
+		for i := 0; i < n; i++ {
+			fn(i)
+		}
+		return
+	}
+	var next atomic.Int64
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := int(next.Add(1) - 1); i < n; i = int(next.Add(1) - 1) {
+				fn(i)
+			}
+		}()
+	}
+	wg.Wait()
+}
@@ -0,0 +1,214 @@
+package store
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// TestMain silences the log, which the store writes a line to for every
+// payload it loads.
+func TestMain(m *testing.M) {
+	log.SetOutput(io.Discard)
+	os.Exit(m.Run())
+}
+
+// writeSyntheticPayloads writes n payload files to a new directory: JSON
+// objects of varying size and schema version, and every tenth a file with a
+// payloads array of three. It returns the directory.
+func writeSyntheticPayloads(t *testing.T, n int) string {
+	t.Helper()
+	dir := t.TempDir()
+	for i := 0; i < n; i++ {
+		var content string
+		if i%10 == 0 {
+			content = fmt.Sprintf(`{"schemaVersion":2,"payloads":[{"id":"%d-a"},{"id":"%d-b","schemaVersion":3},{"id":"%d-c"}]}`, i, i, i)
+		} else {
+			var b strings.Builder
+			fmt.Fprintf(&b, `{"schemaVersion":%d`, i%3+1)
+			for k := 0; k < i%50+1; k++ {
+				fmt.Fprintf(&b, `,"key_%d":%q`, k, strings.Repeat(string(rune('a'+k%26)), 20*(k+1)))
+			}
+			b.WriteString("}")
+			content = b.String()
+		}
+		if err := os.WriteFile(filepath.Join(dir, fmt.Sprintf("payload_%04d.json", i)), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func TestLoadConcurrency(t *testing.T) {
+	const files = 300
+	dir := writeSyntheticPayloads(t, files)
+	load := func(concurrency int) []Payload {
+		s := NewPayloadStore(dir, Limits{})
+		s.SetEntries(true)
+		s.SetProtobuf(true)
+		s.SetLoadConcurrency(concurrency)
+		if err := s.Load(); err != nil {
+			t.Fatal(err)
+		}
+		return s.Payloads()
+	}
+	want := load(1)
+	if wantCount := files - files/10 + 3*files/10; len(want) != wantCount {
+		t.Fatalf("loaded %d payloads one file at a time, want %d", len(want), wantCount)
+	}
+
+	tests := []struct {
+		name        string
+		concurrency int
+	}{
+		{name: "two workers", concurrency: 2},
+		{name: "eight workers", concurrency: 8},
+		{name: "more workers than files", concurrency: 2 * files},
+		{name: "GOMAXPROCS", concurrency: 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Any number of workers loads the same payloads in the same order
+			got := load(tt.concurrency)
+			if len(got) != len(want) {
+				t.Fatalf("loaded %d payloads, want %d", len(got), len(want))
+			}
+			for i := range want {
+				if !reflect.DeepEqual(got[i], want[i]) {
+					t.Fatalf("payload %d is %s (v%d, %s), want %s (v%d, %s)", i,
+						got[i].Name, got[i].SchemaVersion, got[i].SHA256, want[i].Name, want[i].SchemaVersion, want[i].SHA256)
+				}
+			}
+		})
+	}
+}
+
+func TestWarmCache(t *testing.T) {
+	dir := writeSyntheticPayloads(t, 200)
+	tests := []struct {
+		name        string
+		entries     bool
+		concurrency int
+	}{
+		{name: "one worker", concurrency: 1},
+		{name: "several workers", concurrency: 4},
+		{name: "with entries", entries: true, concurrency: 4},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := NewPayloadStore(dir, Limits{})
+			s.SetEntries(tt.entries)
+			s.SetLoadConcurrency(tt.concurrency)
+			if err := s.Load(); err != nil {
+				t.Fatal(err)
+			}
+			var wantBytes int64
+			for _, p := range s.Payloads() {
+				wantBytes += int64(len(p.Content))
+				for _, e := range p.Entries {
+					wantBytes += int64(len(e.Value))
+				}
+			}
+			payloads, bytes := s.WarmCache()
+			if payloads != len(s.Payloads()) || bytes != wantBytes {
+				t.Errorf("WarmCache() = %d payloads, %d bytes, want %d, %d", payloads, bytes, len(s.Payloads()), wantBytes)
+			}
+		})
+	}
+}
+
+func TestForEach(t *testing.T) {
+	tests := []struct {
+		n, workers int
+		wantPeak   int // most calls running at once, at most
+	}{
+		{n: 0, workers: 4, wantPeak: 0},
+		{n: 1, workers: 4, wantPeak: 1},
+		{n: 100, workers: 1, wantPeak: 1},
+		{n: 100, workers: 0, wantPeak: 1},
+		{n: 100, workers: 4, wantPeak: 4},
+		{n: 3, workers: 8, wantPeak: 3},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("%d on %d workers", tt.n, tt.workers), func(t *testing.T) {
+			calls := make([]atomic.Int32, tt.n)
+			var mu sync.Mutex
+			running, peak := 0, 0
+			forEach(tt.n, tt.workers, func(i int) {
+				mu.Lock()
+				running++
+				peak = max(peak, running)
+				mu.Unlock()
+				calls[i].Add(1)
+				time.Sleep(100 * time.Microsecond)
+				mu.Lock()
+				running--
+				mu.Unlock()
+			})
+			for i := range calls {
+				if n := calls[i].Load(); n != 1 {
+					t.Errorf("fn(%d) called %d times, want once", i, n)
+				}
+			}
+			if peak > tt.wantPeak {
+				t.Errorf("%d calls ran at once, want at most %d", peak, tt.wantPeak)
+			}
+		})
+	}
+}
+
+func TestUpdate(t *testing.T) {
+	tests := []struct {
+		name      string
+		apply     bool
+		wantNames []string
+	}{
+		{name: "rejected", apply: false, wantNames: []string{"a.json"}},
+		{name: "applied", apply: true, wantNames: []string{"a.json", "b.json"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			if err := os.WriteFile(filepath.Join(dir, "a.json"), []byte(`{"id":"a"}`), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			s := NewPayloadStore(dir, Limits{})
+			if err := s.Load(); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(filepath.Join(dir, "b.json"), []byte(`{"id":"b"}`), 0o644); err != nil {
+				t.Fatal(err)
+			}
+
+			err := s.Update(func(next []Payload) bool {
+				// Another reload must not land between the check and the swap
+				if s.reloadMu.TryLock() {
+					s.reloadMu.Unlock()
+					t.Error("check ran without the reload lock held")
+				}
+				if len(next) != 2 || next[1].SHA256 == "" {
+					t.Errorf("check got %d payloads, want both with digests", len(next))
+				}
+				return tt.apply
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			var names []string
+			for _, p := range s.Payloads() {
+				names = append(names, p.Name)
+			}
+			if !reflect.DeepEqual(names, tt.wantNames) {
+				t.Errorf("serving %v, want %v", names, tt.wantNames)
+			}
+		})
+	}
+}
@@ -0,0 +1,34 @@
+package store
+
+import (
+	"fmt"
+	"math"
+)
+
+// DefaultSchemaVersion is the schema version of payloads that don't declare
+// one: every payload written before schema versions existed.
+const DefaultSchemaVersion = 1
+
+// schemaVersionKey is the top-level key a payload declares its schema version
+// under. In a file with a "payloads" array, a version at the top of the file
+// applies to every element that doesn't declare its own.
+const schemaVersionKey = "schemaVersion"
+
+// schemaVersionOf returns the schema version declared in a parsed payload
+// document, or inherited if it declares none. A declared version must be a
+// positive integer.
+func schemaVersionOf(doc interface{}, inherited int) (int, error) {
+	obj, ok := doc.(map[string]interface{})
+	if !ok {
+		return inherited, nil
+	}
+	v, ok := obj[schemaVersionKey]
+	if !ok {
+		return inherited, nil
+	}
+	f, ok := v.(float64)
+	if !ok || f < 1 || f > math.MaxInt32 || f != math.Trunc(f) {
+		return 0, fmt.Errorf("%s must be a positive integer, got %v", schemaVersionKey, v)
+	}
+	return int(f), nil
+}
@@ -0,0 +1,46 @@
+package store
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestSchemaVersionOf(t *testing.T) {
+	tests := []struct {
+		name      string
+		doc       string
+		inherited int
+		want      int
+		wantErr   bool
+	}{
+		{name: "undeclared", doc: `{"greeting":"hello"}`, inherited: DefaultSchemaVersion, want: DefaultSchemaVersion},
+		{name: "inherited", doc: `{"greeting":"hello"}`, inherited: 4, want: 4},
+		{name: "declared", doc: `{"schemaVersion":3,"greeting":"hello"}`, inherited: DefaultSchemaVersion, want: 3},
+		{name: "declared overrides inherited", doc: `{"schemaVersion":2}`, inherited: 4, want: 2},
+		{name: "not an object", doc: `["schemaVersion"]`, inherited: 4, want: 4},
+		{name: "zero", doc: `{"schemaVersion":0}`, wantErr: true},
+		{name: "negative", doc: `{"schemaVersion":-1}`, wantErr: true},
+		{name: "fractional", doc: `{"schemaVersion":1.5}`, wantErr: true},
+		{name: "string", doc: `{"schemaVersion":"2"}`, wantErr: true},
+		{name: "too large", doc: `{"schemaVersion":1e12}`, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var doc interface{}
+			if err := json.Unmarshal([]byte(tt.doc), &doc); err != nil {
+				t.Fatal(err)
+			}
+			got, err := schemaVersionOf(doc, tt.inherited)
+			if tt.wantErr {
+				if err == nil || !strings.Contains(err.Error(), "positive integer") {
+					t.Fatalf("schemaVersionOf(%s) = %d, %v, want a positive integer error", tt.doc, got, err)
+				}
+				return
+			}
+			if err != nil || got != tt.want {
+				t.Errorf("schemaVersionOf(%s, %d) = %d, %v, want %d", tt.doc, tt.inherited, got, err, tt.want)
+			}
+		})
+	}
+}
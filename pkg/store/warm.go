@@ -0,0 +1,54 @@
+package store
+
+import (
+	"log"
+	"time"
+)
+
+// warmPageSize is the stride WarmCache reads at: one byte per memory page is
+// enough to fault the page in.
+const warmPageSize = 4096
+
+// warmSink keeps WarmCache's reads from being optimized away.
+var warmSink byte
+
+// WarmCache reads every byte page of the current payloads and their streaming
+// entries, so the first request for each variant doesn't pay to fault in
+// memory that hasn't been touched since load. Payloads are loaded eagerly, so
+// today this is cheap; it is the place to force a read if payloads are ever
+// loaded lazily. Payloads are warmed on the store's load workers (see
+// SetLoadConcurrency). It logs and returns the number of payloads and bytes
+// warmed.
+func (s *PayloadStore) WarmCache() (payloads int, bytes int64) {
+	start := time.Now()
+	list := s.Payloads()
+	sinks := make([]byte, len(list))
+	sizes := make([]int64, len(list))
+	forEach(len(list), s.workers(), func(i int) {
+		p := list[i]
+		sinks[i] = touch(p.Content)
+		sizes[i] = int64(len(p.Content))
+		for _, e := range p.Entries {
+			sinks[i] ^= touch(e.Value)
+			sizes[i] += int64(len(e.Value))
+		}
+	})
+	var sink byte
+	for i := range list {
+		sink ^= sinks[i]
+		bytes += sizes[i]
+	}
+	payloads = len(list)
+	warmSink = sink
+	log.Printf("Warmed %d payloads (%s) in %s", payloads, FormatBytes(bytes), time.Since(start).Round(time.Microsecond))
+	return payloads, bytes
+}
+
+// touch reads one byte per page of content.
+func touch[T ~string | ~[]byte](content T) byte {
+	var b byte
+	for i := 0; i < len(content); i += warmPageSize {
+		b ^= content[i]
+	}
+	return b
+}
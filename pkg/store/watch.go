@@ -0,0 +1,78 @@
+package store
+
+import (
+	"log"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// Watch calls reload whenever files in the store's directory change, so the
+// caller can check a change the same way it checks any other reload before
+// it is applied. Bursts of events (an editor's write-rename, or a volume
+// swapping many files at once) are debounced so they trigger a single reload
+// once the directory has been quiet for the debounce interval. A failed
+// reload is logged and the current payloads keep serving.
+//
+// The returned function stops the watcher.
+func (s *PayloadStore) Watch(debounce time.Duration, reload func() error) (stop func(), err error) {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+	if err := watcher.Add(s.dir); err != nil {
+		watcher.Close()
+		return nil, err
+	}
+
+	done := make(chan struct{})
+	go func() {
+		var timer *time.Timer
+		var fire <-chan time.Time
+
+		for {
+			select {
+			case <-done:
+				if timer != nil {
+					timer.Stop()
+				}
+				return
+			case event, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				if event.Op == fsnotify.Chmod {
+					continue
+				}
+				if timer == nil {
+					timer = time.NewTimer(debounce)
+				} else {
+					if !timer.Stop() {
+						select {
+						case <-timer.C:
+						default:
+						}
+					}
+					timer.Reset(debounce)
+				}
+				fire = timer.C
+			case <-fire:
+				fire = nil
+				log.Printf("Detected changes in %s, reloading payloads", s.dir)
+				if err := reload(); err != nil {
+					log.Printf("Reload failed, keeping current payloads: %v", err)
+				}
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				log.Printf("Payload watcher error: %v", err)
+			}
+		}
+	}()
+
+	return func() {
+		close(done)
+		watcher.Close()
+	}, nil
+}
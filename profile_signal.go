@@ -0,0 +1,89 @@
+//go:build unix
+
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"runtime"
+	"runtime/pprof"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// signalProfileDuration is how long the CPU profile SIGUSR1 triggers runs:
+// long enough to catch an incident like a saturation test in the act.
+const signalProfileDuration = 30 * time.Second
+
+// watchProfileSignal writes a heap profile and a signalProfileDuration CPU
+// profile to dir each time the process receives SIGUSR1, for profiling a live
+// incident without a restart or a reachable HTTP port. Files are named by
+// kind and UTC timestamp, e.g. cpu-20240102T150405Z.pprof, and open with
+// `go tool pprof` or as a flame graph with `go tool pprof -http`. A signal
+// that arrives while a profile is running is ignored.
+func watchProfileSignal(dir string) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGUSR1)
+	var running atomic.Bool
+	go func() {
+		for range signals {
+			if !running.CompareAndSwap(false, true) {
+				log.Printf("SIGUSR1: a profile is already being captured, ignoring")
+				continue
+			}
+			go func() {
+				defer running.Store(false)
+				if err := captureProfiles(dir, signalProfileDuration); err != nil {
+					log.Printf("SIGUSR1: profiling failed: %v", err)
+				}
+			}()
+		}
+	}()
+	log.Printf("Profiling: send SIGUSR1 (kill -USR1 %d) to write a heap profile and a %s CPU profile to %s",
+		os.Getpid(), signalProfileDuration, dir)
+}
+
+// captureProfiles writes the heap profile as it is now, then profiles the CPU
+// for duration.
+func captureProfiles(dir string, duration time.Duration) error {
+	stamp := time.Now().UTC().Format("20060102T150405Z")
+
+	heapPath := filepath.Join(dir, fmt.Sprintf("heap-%s.pprof", stamp))
+	heap, err := os.Create(heapPath)
+	if err != nil {
+		return err
+	}
+	// Collect first so the profile reflects the live heap, not garbage
+	runtime.GC()
+	err = pprof.WriteHeapProfile(heap)
+	if closeErr := heap.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("heap profile: %w", err)
+	}
+	log.Printf("SIGUSR1: wrote heap profile to %s", heapPath)
+
+	cpuPath := filepath.Join(dir, fmt.Sprintf("cpu-%s.pprof", stamp))
+	cpu, err := os.Create(cpuPath)
+	if err != nil {
+		return err
+	}
+	defer cpu.Close()
+	if err := pprof.StartCPUProfile(cpu); err != nil {
+		os.Remove(cpuPath)
+		return fmt.Errorf("CPU profile: %w", err)
+	}
+	log.Printf("SIGUSR1: capturing a %s CPU profile to %s", duration, cpuPath)
+	time.Sleep(duration)
+	pprof.StopCPUProfile()
+	if err := cpu.Close(); err != nil {
+		return fmt.Errorf("CPU profile: %w", err)
+	}
+	log.Printf("SIGUSR1: wrote CPU profile to %s", cpuPath)
+	return nil
+}
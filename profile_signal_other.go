@@ -0,0 +1,10 @@
+//go:build !unix
+
+package main
+
+import "log"
+
+// watchProfileSignal is a no-op where there is no SIGUSR1.
+func watchProfileSignal(dir string) {
+	log.Printf("Profiling: SIGUSR1 profiles are not supported on this platform")
+}
@@ -0,0 +1,257 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"go-localization-large-backend/pkg/encoding/proto"
+	"go-localization-large-backend/pkg/metrics"
+	"go-localization-large-backend/pkg/store"
+)
+
+// runValidate implements `main validate`: it loads the payloads directory with
+// the same strict rules as a reload and reports each file's raw, minified and
+// gzipped size, so content authors can see what a bundle costs on the wire
+// before deploying it. With -server it also reports what each variant costs a
+// running server per month at its current rate. It returns the process exit
+// code.
+func runValidate(args []string) int {
+	fs := flag.NewFlagSet("validate", flag.ExitOnError)
+	dir := fs.String("dir", payloadDir, "Payloads directory to validate")
+	maxPayloadFiles := fs.Int("max-payload-files", 1000, "Maximum number of payload files (0 = unlimited)")
+	maxPayloadMB := fs.Int64("max-payload-mb", 512, "Maximum combined size of payload files in MiB, JSON files counted twice with -ndjson (0 = unlimited)")
+	payloadChecksums := fs.String("payload-checksums", "", "SHA-256 manifest (sha256sum format) that payload files must match")
+	strict := fs.Bool("strict", false, "Also lint the payloads together with the server flags below for likely mistakes")
+	failOnWarnings := fs.Bool("fail-on-warnings", false, "With -strict, exit non-zero on warnings as well as errors")
+	ndjson := fs.Bool("ndjson", false, "Server -ndjson: count JSON files twice against -max-payload-mb, as the server does")
+	payloadTypes := fs.String("payload-types", "", "Server -payload-types: also load files with these extensions as non-JSON payloads")
+	serverURL := fs.String("server", "", "Running server's URL, e.g. http://localhost:3000: also report its projected monthly bandwidth per variant")
+	var lint lintOptions
+	fs.Float64Var(&lint.Exposure, "exposure", 100, "With -strict: server -exposure")
+	fs.StringVar(&lint.ControlPayload, "control-payload", "", "With -strict: server -control-payload")
+	fs.StringVar(&lint.AppVersionRange, "app-version-range", "", "With -strict: server -app-version-range")
+	fs.StringVar(&lint.FallbackPayload, "fallback-payload", "", "With -strict: server -fallback-payload")
+	fs.StringVar(&lint.Rollbacks, "rollbacks", "", "With -strict: server -rollbacks")
+	fs.StringVar(&lint.SchemaCheck, "schema-check", schemaCheckOff, "With -strict: server -schema-check")
+	fs.StringVar(&lint.Prerequisites, "prerequisites", "", "With -strict: server -prerequisites")
+	fs.Parse(args)
+
+	payloads := store.NewPayloadStore(*dir, store.Limits{
+		MaxFiles: *maxPayloadFiles,
+		MaxBytes: *maxPayloadMB * 1024 * 1024,
+	})
+	if *payloadChecksums != "" {
+		payloads.SetChecksumFile(*payloadChecksums)
+	}
+	types, err := store.ParseContentTypes(*payloadTypes)
+	if err != nil {
+		fmt.Printf("❌ Invalid -payload-types: %v\n", err)
+		return 2
+	}
+	payloads.SetContentTypes(types)
+	payloads.SetEntries(*ndjson)
+	if err := payloads.Reload(); err != nil {
+		fmt.Printf("❌ Validation failed: %v\n", err)
+		return 1
+	}
+
+	streamable, err := checkPayloads(payloads.Payloads())
+	if err != nil {
+		fmt.Printf("❌ Validation failed: %v\n", err)
+		return 1
+	}
+
+	reports, err := store.Inspect(*dir)
+	if err != nil {
+		fmt.Printf("❌ Validation failed: %v\n", err)
+		return 1
+	}
+
+	fmt.Println()
+	fmt.Println("Payload Sizes:")
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(tw, "File\tPayloads\tRaw\tMinified\tGzipped\tMinify Saves\tGzip Ratio\t")
+
+	var total store.FileReport
+	for _, r := range reports {
+		printSizeRow(tw, r.Name, r)
+		total.Payloads += r.Payloads
+		total.RawBytes += r.RawBytes
+		total.MinifiedBytes += r.MinifiedBytes
+		total.GzipBytes += r.GzipBytes
+	}
+	printSizeRow(tw, "TOTAL", total)
+	tw.Flush()
+
+	if *strict {
+		findings := lintConfig(payloads.Payloads(), lint)
+		var errs, warnings int
+		fmt.Println()
+		fmt.Println("Lint:")
+		for _, f := range findings {
+			fmt.Println(f)
+			if f.Level == lintError {
+				errs++
+			} else {
+				warnings++
+			}
+		}
+		fmt.Println()
+		if errs > 0 || (*failOnWarnings && warnings > 0) {
+			fmt.Printf("❌ Lint failed: %d errors, %d warnings\n", errs, warnings)
+			return 1
+		}
+		fmt.Printf("✅ Lint passed: %d errors, %d warnings\n", errs, warnings)
+	}
+
+	if *serverURL != "" {
+		if err := printBandwidth(*serverURL); err != nil {
+			fmt.Printf("❌ Failed to get bandwidth from %s: %v\n", *serverURL, err)
+			return 1
+		}
+	}
+
+	fmt.Println()
+	fmt.Printf("✅ %d files valid (%d payloads, %d streamable as JSON Lines, all round-trip through protobuf)\n", len(reports), len(payloads.Payloads()), streamable)
+	return 0
+}
+
+// checkPayloads checks what loading can't: every payload that can be streamed
+// must reassemble to its single-object form, or JSON Lines clients would see
+// different content, and the same goes for the protobuf encoding served with
+// -protobuf. It returns how many payloads are streamable. POST /reload runs it
+// too, so a reload is checked exactly as validate checks.
+func checkPayloads(payloads []store.Payload) (int, error) {
+	streamable := 0
+	for _, p := range payloads {
+		// Payloads that aren't JSON are only ever served raw
+		if !p.IsJSON() {
+			continue
+		}
+		if err := checkProtobuf(p); err != nil {
+			return 0, fmt.Errorf("%s: %w", p.Name, err)
+		}
+		// Entries are split here, as the server only splits them with
+		// -ndjson and a reload is checked before it is applied
+		entries, err := store.SplitEntries([]byte(p.Content))
+		if err != nil {
+			return 0, fmt.Errorf("%s: can't split into entries: %w", p.Name, err)
+		}
+		if len(entries) == 0 {
+			continue
+		}
+		if err := checkEntries(p, entries); err != nil {
+			return 0, fmt.Errorf("%s: %w", p.Name, err)
+		}
+		streamable++
+	}
+	return streamable, nil
+}
+
+// checkEntries streams a payload's entries as JSON Lines the way the
+// /experiment handler does and checks that reading them back gives the same
+// object as parsing the payload whole.
+func checkEntries(p store.Payload, entries []store.Entry) error {
+	var buf bytes.Buffer
+	if err := store.WriteEntries(bufio.NewWriter(&buf), entries, 0); err != nil {
+		return err
+	}
+	streamed, err := store.ReadEntries(&buf)
+	if err != nil {
+		return fmt.Errorf("streamed entries don't parse: %w", err)
+	}
+	var whole map[string]interface{}
+	if err := json.Unmarshal([]byte(p.Content), &whole); err != nil {
+		return err
+	}
+	if !reflect.DeepEqual(streamed, whole) {
+		return errors.New("streamed entries don't reassemble into the payload")
+	}
+	return nil
+}
+
+// checkProtobuf encodes a payload as protobuf the way the store does for
+// -protobuf and checks that decoding it gives the same value as parsing the
+// payload's JSON.
+func checkProtobuf(p store.Payload) error {
+	encoded, err := proto.EncodeJSON([]byte(p.Content))
+	if err != nil {
+		return fmt.Errorf("can't encode as protobuf: %w", err)
+	}
+	decoded, err := proto.DecodeValue(encoded)
+	if err != nil {
+		return fmt.Errorf("protobuf encoding doesn't decode: %w", err)
+	}
+	var whole interface{}
+	if err := json.Unmarshal([]byte(p.Content), &whole); err != nil {
+		return err
+	}
+	if !reflect.DeepEqual(decoded, whole) {
+		return errors.New("protobuf encoding doesn't decode to the payload")
+	}
+	return nil
+}
+
+// printBandwidth fetches a running server's /experiment/:id/stats and prints
+// the bytes each variant has served, with their projection over a month at
+// the current rate.
+func printBandwidth(serverURL string) error {
+	client := &http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Get(strings.TrimSuffix(serverURL, "/") + "/experiment/" + experimentID + "/stats")
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("stats returned %d", resp.StatusCode)
+	}
+	var stats experimentStatsResponse
+	if err := json.NewDecoder(resp.Body).Decode(&stats); err != nil {
+		return err
+	}
+
+	fmt.Println()
+	fmt.Printf("Projected monthly bandwidth at current rate (over the last %s):\n",
+		time.Duration(stats.WindowSeconds*float64(time.Second)).Round(time.Second))
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(tw, "Variant\tResponses\tSent\tRaw\tProjected/month\t")
+	names := make([]string, 0, len(stats.Variants))
+	for name := range stats.Variants {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		printBandwidthRow(tw, name, stats.Variants[name])
+	}
+	printBandwidthRow(tw, "TOTAL", stats.Total)
+	return tw.Flush()
+}
+
+func printBandwidthRow(w *tabwriter.Writer, name string, v metrics.VariantBytes) {
+	fmt.Fprintf(w, "%s\t%d\t%s\t%s\t%s\t\n", name, v.Responses,
+		store.FormatBytes(v.Bytes), store.FormatBytes(v.RawBytes), store.FormatBytes(v.ProjectedMonthlyBytes))
+}
+
+func printSizeRow(w *tabwriter.Writer, name string, r store.FileReport) {
+	var minifySaves, gzipRatio float64
+	if r.RawBytes > 0 {
+		minifySaves = (1 - float64(r.MinifiedBytes)/float64(r.RawBytes)) * 100
+	}
+	if r.GzipBytes > 0 {
+		gzipRatio = float64(r.MinifiedBytes) / float64(r.GzipBytes)
+	}
+	fmt.Fprintf(w, "%s\t%d\t%s\t%s\t%s\t%.1f%%\t%.1fx\t\n",
+		name, r.Payloads, store.FormatBytes(r.RawBytes), store.FormatBytes(r.MinifiedBytes),
+		store.FormatBytes(r.GzipBytes), minifySaves, gzipRatio)
+}